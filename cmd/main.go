@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"log-engine-sdk/pkg/k3"
@@ -13,6 +14,7 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
@@ -27,29 +29,30 @@ var (
 
 func main() {
 	var (
-		err       error
-		configs   []string
-		configDir string // 配置文件目录
+		err           error
+		configs       []string
+		configDir     string // 配置文件目录
+		flagConfigDir string // 启动参数指定的配置文件目录
 	)
 
-	k3.K3LogInfo("Start with arguments Version: %s, BuildTime: %s, Tag: %s, ConfigPath: %s\n", Version, BuildTime, Tag, ConfigPath)
+	// 0. 启动参数, --reset-to-end 忽略状态文件中记录的offset, 所有文件从当前末尾开始读取
+	flag.BoolVar(&watch.ResetToEnd, "reset-to-end", false, "ignore offsets in state file, read all files from current end")
+	flag.StringVar(&flagConfigDir, "config-dir", "", "config directory, default $"+config.ConfigDirEnv+" or ./configs")
+	flag.StringVar(&config.StateFilePathOverride, "state-file", "", "state file path, overrides watch.state_file_path")
+	flag.Parse()
 
-	// 1. 如果ConfigPath没有设置，则使用当前目录作为配置文件目录
-	if len(ConfigPath) != 0 {
-		configDir = ConfigPath
-	} else {
+	k3.K3LogInfo("Start with arguments Version: %s, BuildTime: %s, Tag: %s, ConfigPath: %s\n", Version, BuildTime, Tag, ConfigPath)
 
-		if currentDir, err := os.Getwd(); err != nil {
-			k3.K3LogError("[main] get current work dir error: %s", err)
-			return
-		} else {
-			configDir = currentDir + "/configs"
-		}
+	// 1. 配置文件目录: -config-dir > K3_CONFIG_DIR > 编译时设置的ConfigPath > 当前目录下的configs
+	if configDir, err = config.ResolveConfigDir(flagConfigDir, ConfigPath); err != nil {
+		k3.K3LogError("[main] resolve config dir error: %s", err)
+		return
 	}
 
 	// 2. 初始化配置文件, 将配置文件的内容全部写入全局变量GlobalConfig
-	if configs, err = k3.FetchDirectory(configDir, -1); err != nil {
-		k3.K3LogError("fetch directory error: %s", err)
+	if configs, err = config.FetchConfigFiles(configDir); err != nil {
+		k3.K3LogError("[main] fetch config files error: %s", err)
+		return
 	}
 	config.MustLoad(configs...)
 
@@ -59,7 +62,7 @@ func main() {
 			k3.K3LogError("[main] get current work dir error: %s", err)
 			return
 		} else {
-			config.GlobalConfig.System.LogPath = currentDir + "/logs"
+			config.GlobalConfig.System.LogPath = filepath.Join(currentDir, "logs")
 		}
 	}
 
@@ -82,7 +85,27 @@ func main() {
 
 	// 5. 根据配置文件设置日志等级和配置文件打印到控制台权限
 	if config.GlobalConfig.System.LogLevel > 0 {
-		k3.CurrentLogLevel = k3.K3LogLevel(config.GlobalConfig.System.LogLevel)
+		k3.SetLogLevel(k3.K3LogLevel(config.GlobalConfig.System.LogLevel))
+	}
+	k3.SetLogFormat(config.GlobalConfig.System.LogFormat)
+
+	// SDK自身日志写入文件, 按照大小轮转
+	if len(config.GlobalConfig.Log.File) > 0 {
+		if logFile, err := k3.NewRotateFile(k3.K3RotateFileConfig{
+			Path:       config.GlobalConfig.Log.File,
+			MaxSizeMB:  config.GlobalConfig.Log.MaxSizeMB,
+			MaxBackups: config.GlobalConfig.Log.MaxBackups,
+			Compress:   config.GlobalConfig.Log.Compress,
+		}); err != nil {
+			k3.K3LogError("[main] open log file error: %s", err)
+			return
+		} else {
+			k3.SetLogOutput(logFile)
+			defer func() {
+				k3.SetLogOutput(os.Stdout)
+				_ = logFile.Close()
+			}()
+		}
 	}
 
 	if config.GlobalConfig.System.PrintEnabled == true {
@@ -94,31 +117,16 @@ func main() {
 		}
 	}
 
-	// 6. 遍历配置文件的监控目录，由于watch碰到子目录是不会主动监控的，所以需要子目录递归添加
-	watchDirectory := make(map[string][]string)
-
-	for indexName, dirs := range config.GlobalConfig.Watch.ReadPath {
-		// 递归目录
-		for _, dir := range dirs {
-			if paths, err := k3.FetchDirectoryPath(dir, -1); err != nil {
-				k3.K3LogError("[main] fetch directory path error: %s", err)
-				continue
-			} else {
-				watchDirectory[indexName] = append(watchDirectory[indexName], paths...)
-			}
-		}
-	}
-
-	// 7. 清理可能重复的目录
-	for indexName, dirs := range watchDirectory {
-		watchDirectory[indexName] = k3.RemoveDuplicateElement(dirs)
-	}
+	// 6. 遍历配置文件的监控目录，由于watch碰到子目录是不会主动监控的，所以需要子目录递归添加, 并清理可能重复的目录
+	watchDirectory := watch.ExpandWatchDirectory(config.GlobalConfig.Watch.ReadPath)
 
 	k3.K3LogDebug("需要监控的目录列表: %v", watchDirectory)
 
 	var (
-		httpClean  func()
-		watchClean func()
+		httpClean    func()
+		watchClean   func()
+		metricsClean func()
+		healthClean  func()
 	)
 
 	// 8. 将需要监控的目录，放入监控器中，跑起来
@@ -127,13 +135,34 @@ func main() {
 		return
 	}
 
+	// 9. 开启热加载时, 监听配置文件的变化
+	if config.GlobalConfig.Watch.HotReload == true {
+		if err = watch.WatchConfigFiles(configs); err != nil {
+			k3.K3LogError("[main] watch config files error: %s", err)
+		}
+	}
+
 	if config.GlobalConfig.Http.Enable == true {
 		// 启动http服务器
 		httpClean, _ = k3.HttpServer(context.Background())
 	}
 
+	// 配置了metrics.listen时, 启动prometheus指标接口
+	if len(config.GlobalConfig.Metrics.Listen) > 0 {
+		if metricsClean, err = k3.MetricsServer(context.Background(), config.GlobalConfig.Metrics.Listen); err != nil {
+			k3.K3LogError("[main] metrics server error: %s", err)
+		}
+	}
+
+	// 配置了health.listen时, 启动存活/就绪检查接口
+	if len(config.GlobalConfig.Health.Listen) > 0 {
+		if healthClean, err = watch.HealthServer(context.Background(), config.GlobalConfig.Health.Listen); err != nil {
+			k3.K3LogError("[main] health server error: %s", err)
+		}
+	}
+
 	pprof()
-	graceExit(watch.WatcherContext, httpClean, watchClean)
+	graceExit(watch.WatcherContext, configs, httpClean, metricsClean, healthClean, watchClean)
 
 }
 
@@ -151,43 +180,32 @@ func pprof() {
 }
 
 // GraceExit 保持进程常驻， 一是收到退出信号要退出， 二是协程异常退出时要退出
-func graceExit(ctx context.Context, cleans ...func()) {
+// 收到SIGHUP时重新加载配置文件, 退出时最多等待shutdown_timeout提交剩余数据, 再次收到退出信号时立即退出
+func graceExit(ctx context.Context, configs []string, cleans ...func()) {
 	var (
-		state      = -1
+		state      int
 		signalChan = make(chan os.Signal, 1)
 	)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
 
-EXIT:
-	select {
-	case sig, ok := <-signalChan:
-		if !ok {
-			k3.K3LogError("[graceExit] signal chan closed")
-			break EXIT
-		}
-		switch sig {
-		case syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT:
-			state = 0
-			break EXIT
-		case syscall.SIGHUP:
-		default:
-			state = 1
-			break EXIT
-		}
-	case <-ctx.Done():
-		k3.K3LogError("[graceExit] context done")
-		break EXIT
-	}
-
-	// 程序退出之前，做一次FileState文件的保存
-	_ = watch.SaveGlobalFileStatesToDiskFile(watch.FileStateFilePath)
+	state = watch.WaitSignal(ctx, signalChan, func() {
+		watch.ReloadConfigFiles(configs)
+	})
 
-	// 清理各种资源
-	for _, cleanFunc := range cleans {
-		if cleanFunc != nil {
-			cleanFunc()
+	go func() {
+		for sig := range signalChan {
+			if sig != syscall.SIGHUP {
+				k3.K3LogWarn("[graceExit] accept signal %s again, force exit", sig)
+				os.Exit(1)
+			}
 		}
+	}()
+
+	// 清理各种资源, 之后做一次FileState文件的保存
+	if !watch.Shutdown(watch.ShutdownGracePeriod(), cleans...) {
+		state = 1
 	}
+
 	time.Sleep(1 * time.Second)
 	os.Exit(state)
 }
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ConfigDirEnv       = "K3_CONFIG_DIR"                     // 配置文件目录的环境变量
+	DefaultConfigDir   = "configs"                           // 没有指定配置文件目录时, 使用当前目录下的configs
+	ConfigFileSuffixes = []string{".yaml", ".json", ".toml"} // 支持的配置文件格式
+
+	StateFilePathOverride string // 启动参数-state-file, 不为空时覆盖watch.state_file_path, 热加载时同样生效
+)
+
+// ResolveConfigDir 配置文件目录, 优先级: 启动参数-config-dir > 环境变量K3_CONFIG_DIR > 编译时设置的buildDir > 当前目录下的configs
+func ResolveConfigDir(flagDir, buildDir string) (string, error) {
+	if len(flagDir) > 0 {
+		return flagDir, nil
+	}
+
+	if envDir := os.Getenv(ConfigDirEnv); len(envDir) > 0 {
+		return envDir, nil
+	}
+
+	if len(buildDir) > 0 {
+		return buildDir, nil
+	}
+
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return "", errors.New("[ResolveConfigDir] get current work dir failed: " + err.Error())
+	}
+
+	return filepath.Join(currentDir, DefaultConfigDir), nil
+}
+
+// FetchConfigFiles 递归获取dir下所有的配置文件, dir不存在、不是目录或者没有配置文件时返回错误
+func FetchConfigFiles(dir string) ([]string, error) {
+	var files []string
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, errors.New("[FetchConfigFiles] config dir " + dir + " is not accessible: " + err.Error())
+	}
+	if !info.IsDir() {
+		return nil, errors.New("[FetchConfigFiles] config dir " + dir + " is not a directory")
+	}
+
+	if err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && isConfigFile(path) {
+			files = append(files, path)
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.New("[FetchConfigFiles] walk config dir " + dir + " failed: " + err.Error())
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("[FetchConfigFiles] no config file (" + strings.Join(ConfigFileSuffixes, ", ") + ") found in " + dir)
+	}
+
+	return files, nil
+}
+
+func isConfigFile(path string) bool {
+	for _, suffix := range ConfigFileSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// overrideLoader 在配置文件和环境变量加载完之后, 使用启动参数覆盖对应的配置
+type overrideLoader struct{}
+
+func (l *overrideLoader) Load(s interface{}) error {
+	if cfg, ok := s.(*Config); ok && len(StateFilePathOverride) > 0 {
+		cfg.Watch.StateFilePath = StateFilePathOverride
+	}
+	return nil
+}
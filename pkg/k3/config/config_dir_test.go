@@ -0,0 +1,91 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveConfigDir(t *testing.T) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(ConfigDirEnv, "")
+	if dir, _ := ResolveConfigDir("", ""); dir != filepath.Join(currentDir, DefaultConfigDir) {
+		t.Errorf("default config dir should be ./configs, got %s", dir)
+	}
+	if dir, _ := ResolveConfigDir("", "/etc/k3/build"); dir != "/etc/k3/build" {
+		t.Errorf("build config dir should be used, got %s", dir)
+	}
+
+	t.Setenv(ConfigDirEnv, "/etc/k3/env")
+	if dir, _ := ResolveConfigDir("", "/etc/k3/build"); dir != "/etc/k3/env" {
+		t.Errorf("env config dir should override build config dir, got %s", dir)
+	}
+	if dir, _ := ResolveConfigDir("/etc/k3/flag", "/etc/k3/build"); dir != "/etc/k3/flag" {
+		t.Errorf("flag config dir should override env config dir, got %s", dir)
+	}
+}
+
+func TestFetchConfigFiles(t *testing.T) {
+	var dir = t.TempDir()
+
+	for _, file := range []string{"watch.yaml", "elk/elk.json", "README.md"} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := FetchConfigFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != filepath.Join(dir, "elk", "elk.json") || files[1] != filepath.Join(dir, "watch.yaml") {
+		t.Errorf("only config files should be returned, got %v", files)
+	}
+
+	for _, c := range []struct {
+		dir     string
+		message string
+	}{
+		{filepath.Join(dir, "missing"), "not accessible"},
+		{filepath.Join(dir, "watch.yaml"), "not a directory"},
+		{t.TempDir(), "no config file"},
+	} {
+		if _, err = FetchConfigFiles(c.dir); err == nil || !strings.Contains(err.Error(), c.message) {
+			t.Errorf("config dir %s should be rejected with %q, got %v", c.dir, c.message, err)
+		}
+	}
+}
+
+func TestStateFilePathOverride(t *testing.T) {
+	var (
+		cfg      = newValidConfig(t)
+		path     = filepath.Join(t.TempDir(), "watch.yaml")
+		override = filepath.Join(t.TempDir(), "override.json")
+	)
+
+	content := "elk :\n  address : [\"http://127.0.0.1:9200\"]\n" +
+		"watch :\n  state_file_path : \"" + cfg.Watch.StateFilePath + "\"\n  read_path :\n    index_nginx : [\"" + cfg.Watch.ReadPath["index_nginx"][0] + "\"]\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	StateFilePathOverride = override
+	defer func() { StateFilePathOverride = "" }()
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Watch.StateFilePath != override {
+		t.Errorf("-state-file should override watch.state_file_path, got %s", loaded.Watch.StateFilePath)
+	}
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var (
+	envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`) // ${VAR} 或者 ${VAR:-default}
+)
+
+// envLoader 在配置文件加载完之后, 替换所有字符串配置中的${VAR}和${VAR:-default}, 避免将密码等敏感信息写到配置文件中
+type envLoader struct{}
+
+func (l *envLoader) Load(s interface{}) error {
+	return expandEnvValue(reflect.ValueOf(s), "")
+}
+
+// ExpandEnv 替换字符串中的${VAR}和${VAR:-default}
+// 环境变量不存在时使用default, 没有default时返回错误; ${VAR:-default}在环境变量为空时同样使用default
+func ExpandEnv(s string) (string, error) {
+	var err error
+
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	s = envPattern.ReplaceAllStringFunc(s, func(token string) string {
+		var (
+			match      = envPattern.FindStringSubmatch(token)
+			value, ok  = os.LookupEnv(match[1])
+			hasDefault = len(match[2]) > 0
+		)
+
+		if hasDefault && len(value) == 0 {
+			return match[3]
+		}
+
+		if !ok && err == nil {
+			err = errors.New("environment variable " + match[1] + " is not set and has no default")
+		}
+
+		return value
+	})
+
+	return s, err
+}
+
+// expandEnvValue 递归替换结构体中的字符串字段, 包括字符串slice和map的值, path用于错误信息中的配置项名称
+func expandEnvValue(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return expandEnvValue(v.Elem(), path)
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if err := expandEnvValue(v.Field(i), joinEnvPath(path, fieldName(field))); err != nil {
+				return err
+			}
+		}
+
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+		value, err := ExpandEnv(v.String())
+		if err != nil {
+			return errors.New("[envLoader] " + path + ": " + err.Error())
+		}
+		v.SetString(value)
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := expandEnvValue(v.Index(i), path); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		// map的值不能直接修改, 复制后替换再写回
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			if err := expandEnvValue(value, joinEnvPath(path, key.String())); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, value)
+		}
+	}
+
+	return nil
+}
+
+// fieldName 优先使用yaml tag作为配置项名称
+func fieldName(field reflect.StructField) string {
+	if name := strings.Split(field.Tag.Get("yaml"), ",")[0]; len(name) > 0 {
+		return name
+	}
+	return field.Name
+}
+
+func joinEnvPath(path, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("K3_TEST_PASSWORD", "secret")
+	t.Setenv("K3_TEST_EMPTY", "")
+
+	var cases = []struct {
+		value    string
+		expected string
+		hasError bool
+	}{
+		{"plain", "plain", false},
+		{"${K3_TEST_PASSWORD}", "secret", false},
+		{"user:${K3_TEST_PASSWORD}@host", "user:secret@host", false},
+		{"${K3_TEST_PASSWORD:-default}", "secret", false},
+		{"${K3_TEST_MISSING:-default}", "default", false},
+		{"${K3_TEST_MISSING:-}", "", false},
+		{"${K3_TEST_EMPTY:-default}", "default", false},
+		{"${K3_TEST_EMPTY}", "", false},
+		{"${K3_TEST_MISSING}", "", true},
+		{"$K3_TEST_PASSWORD", "$K3_TEST_PASSWORD", false}, // 只支持${VAR}格式
+	}
+
+	for _, c := range cases {
+		value, err := ExpandEnv(c.value)
+		if c.hasError {
+			if err == nil || !strings.Contains(err.Error(), "K3_TEST_MISSING") {
+				t.Errorf("%s: missing variable error expected, got %v", c.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", c.value, err)
+		}
+		if value != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.value, c.expected, value)
+		}
+	}
+}
+
+func TestLoadExpandEnv(t *testing.T) {
+	var (
+		cfg  = newValidConfig(t)
+		path = filepath.Join(t.TempDir(), "config.yaml")
+	)
+	t.Setenv("K3_TEST_ELK_PASSWORD", "secret")
+	t.Setenv("K3_TEST_TOKEN", "Bearer token")
+
+	content := "elk :\n" +
+		"  address : [\"${K3_TEST_ELK_ADDRESS:-http://127.0.0.1:9200}\"]\n" +
+		"  password : \"${K3_TEST_ELK_PASSWORD}\"\n" +
+		"sender :\n" +
+		"  http :\n" +
+		"    headers :\n" +
+		"      Authorization : \"${K3_TEST_TOKEN}\"\n" +
+		"watch :\n" +
+		"  state_file_path : \"" + cfg.Watch.StateFilePath + "\"\n" +
+		"  read_path :\n" +
+		"    index_nginx : [\"" + cfg.Watch.ReadPath["index_nginx"][0] + "\"]\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ELK.Password != "secret" {
+		t.Errorf("password should be expanded, got %q", loaded.ELK.Password)
+	}
+	if len(loaded.ELK.Address) != 1 || loaded.ELK.Address[0] != "http://127.0.0.1:9200" {
+		t.Errorf("address should use default, got %v", loaded.ELK.Address)
+	}
+	if loaded.Sender.Http.Headers["Authorization"] != "Bearer token" {
+		t.Errorf("map value should be expanded, got %q", loaded.Sender.Http.Headers["Authorization"])
+	}
+
+	// 没有设置且没有默认值, 错误信息中包含配置项和变量名
+	content = strings.Replace(content, "${K3_TEST_ELK_PASSWORD}", "${K3_TEST_ELK_MISSING}", 1)
+	if err = os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Load(path); err == nil || !strings.Contains(err.Error(), "elk.password") || !strings.Contains(err.Error(), "K3_TEST_ELK_MISSING") {
+		t.Errorf("missing variable should fail with field name, got %v", err)
+	}
+}
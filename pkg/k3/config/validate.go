@@ -0,0 +1,338 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// configValidator 加载配置文件时调用Config.Validate, 配置错误时启动失败
+type configValidator struct{}
+
+func (v *configValidator) Validate(s interface{}) error {
+	if cfg, ok := s.(*Config); ok {
+		return cfg.Validate()
+	}
+	return nil
+}
+
+// Validate 检查配置是否合法, 返回的错误中包含出错的配置项
+// 1. 至少配置一个read_path, 且至少有一个目录存在
+// 2. 不同index_name的目录不能相同, 也不能是另一个目录的子目录
+// 3. 批量提交的大小和时间间隔不能为负数, 0表示使用默认值, async_overflow只能是block或drop
+// 4. 状态文件所在的目录存在且可写, read_from只能是beginning或end, initial_tail_lines不能与read_from: end同时配置, max_read_passes不能为负数, scan_order只能是path或mtime, start_date的格式正确, backpressure_low小于backpressure_high
+// 5. 发送目标的地址不能为空, index.sender引用的目标需要在sender.named中配置, id_strategy只能是none或content_hash
+// 6. log_format只能是text或json, 日志文件轮转的大小和备份数量不能为负数
+func (c *Config) Validate() error {
+	var err error
+
+	if err = validateReadPath(c.Watch.ReadPath); err != nil {
+		return err
+	}
+
+	if err = validateConsumer(c.Consumer); err != nil {
+		return err
+	}
+
+	if err = validateStateFilePath(c.Watch.StateFilePath); err != nil {
+		return err
+	}
+
+	if c.Log.MaxSizeMB < 0 || c.Log.MaxBackups < 0 {
+		return fmt.Errorf("[Validate] log.max_size_mb, log.max_backups: must not be negative, got %d, %d", c.Log.MaxSizeMB, c.Log.MaxBackups)
+	}
+
+	switch c.System.LogFormat {
+	case "", "text", "json":
+	default:
+		return errors.New("[Validate] system.log_format: must be text or json, got " + c.System.LogFormat)
+	}
+
+	switch c.Watch.ReadFrom {
+	case "", "beginning", "end":
+	default:
+		return errors.New("[Validate] watch.read_from: must be beginning or end, got " + c.Watch.ReadFrom)
+	}
+
+	if c.Watch.InitialTailLines < 0 {
+		return fmt.Errorf("[Validate] watch.initial_tail_lines: must not be negative, got %d", c.Watch.InitialTailLines)
+	}
+
+	if c.Watch.InitialTailLines > 0 && c.Watch.ReadFrom == "end" {
+		return errors.New("[Validate] watch.initial_tail_lines: can not be used with watch.read_from: end")
+	}
+
+	if c.Watch.MaxReadPasses < 0 {
+		return fmt.Errorf("[Validate] watch.max_read_passes: must not be negative, got %d", c.Watch.MaxReadPasses)
+	}
+
+	switch c.Watch.ScanOrder {
+	case "", "path", "mtime":
+	default:
+		return errors.New("[Validate] watch.scan_order: must be path or mtime, got " + c.Watch.ScanOrder)
+	}
+
+	if _, err = c.Watch.StartTime(); err != nil {
+		return errors.New("[Validate] watch.start_date: must be 2006-01-02, 2006-01-02 15:04:05 or RFC3339, got " + c.Watch.StartDate)
+	}
+
+	if c.Watch.BackpressureHigh < 0 || c.Watch.BackpressureLow < 0 {
+		return fmt.Errorf("[Validate] watch.backpressure_high, watch.backpressure_low: must not be negative, got %d, %d", c.Watch.BackpressureHigh, c.Watch.BackpressureLow)
+	}
+
+	if c.Watch.BackpressureHigh > 0 && c.Watch.BackpressureLow >= c.Watch.BackpressureHigh {
+		return fmt.Errorf("[Validate] watch.backpressure_low: must be less than watch.backpressure_high, got %d, %d", c.Watch.BackpressureLow, c.Watch.BackpressureHigh)
+	}
+
+	if c.Watch.MaxLineBytes < 0 {
+		return fmt.Errorf("[Validate] watch.max_line_bytes: must not be negative, got %d", c.Watch.MaxLineBytes)
+	}
+
+	if c.Watch.PartialLineTimeout < 0 {
+		return fmt.Errorf("[Validate] watch.partial_line_timeout: must not be negative, got %d", c.Watch.PartialLineTimeout)
+	}
+
+	if err = validateSender(c); err != nil {
+		return err
+	}
+
+	switch c.ELK.IDStrategy {
+	case "", "none", "content_hash":
+	default:
+		return errors.New("[Validate] elk.id_strategy: must be none or content_hash, got " + c.ELK.IDStrategy)
+	}
+
+	return nil
+}
+
+func validateReadPath(readPath map[string][]string) error {
+	var (
+		indexNames = make([]string, 0, len(readPath))
+		exists     bool
+	)
+
+	for indexName := range readPath {
+		indexNames = append(indexNames, indexName)
+	}
+	sort.Strings(indexNames)
+
+	for _, indexName := range indexNames {
+		for _, dir := range readPath[indexName] {
+			if len(strings.TrimSpace(dir)) == 0 {
+				return fmt.Errorf("[Validate] watch.read_path.%s: directory can not be empty", indexName)
+			}
+
+			// 可以是目录, 也可以是单个文件
+			if _, err := os.Stat(dir); err == nil {
+				exists = true
+			}
+
+			// 同一个目录被多个index_name监听时, 文件的index_name不确定
+			for _, otherName := range indexNames {
+				if otherName == indexName {
+					continue
+				}
+				for _, otherDir := range readPath[otherName] {
+					if isSubDirectory(dir, otherDir) {
+						return fmt.Errorf("[Validate] watch.read_path.%s: directory[%s] overlaps with watch.read_path.%s directory[%s]", indexName, dir, otherName, otherDir)
+					}
+				}
+			}
+		}
+	}
+
+	if !exists {
+		return errors.New("[Validate] watch.read_path: at least one existing directory or file is required")
+	}
+
+	return nil
+}
+
+// isSubDirectory dir与parent相同或者是parent的子目录
+func isSubDirectory(dir, parent string) bool {
+	dir, parent = filepath.Clean(dir), filepath.Clean(parent)
+
+	if dir == parent {
+		return true
+	}
+
+	return strings.HasPrefix(dir, strings.TrimSuffix(parent, string(os.PathSeparator))+string(os.PathSeparator))
+}
+
+func validateConsumer(consumer Consumer) error {
+	var fields = []struct {
+		name  string
+		value int
+	}{
+		{"consumer.consumer_log_channel_size", consumer.ConsumerLogChannelSize},
+		{"consumer.consumer_batch_interval", consumer.ConsumerBatchInterval},
+		{"consumer.consumer_batch_size", consumer.ConsumerBatchSize},
+		{"consumer.consumer_batch_capacity", consumer.ConsumerBatchCapacity},
+		{"consumer.consumer_batch_max_age", consumer.ConsumerBatchMaxAge},
+		{"consumer.consumer_batch_max_bytes", consumer.ConsumerBatchMaxBytes},
+		{"consumer.async_channel_size", consumer.AsyncChannelSize},
+	}
+
+	for _, field := range fields {
+		if field.value < 0 {
+			return fmt.Errorf("[Validate] %s: must not be negative, got %d", field.name, field.value)
+		}
+	}
+
+	switch consumer.AsyncOverflow {
+	case "", "block", "drop":
+	default:
+		return errors.New("[Validate] consumer.async_overflow: must be block or drop, got " + consumer.AsyncOverflow)
+	}
+
+	return nil
+}
+
+// validateStateFilePath 状态文件的路径以工作根目录为基准, 启动时不会创建目录
+func validateStateFilePath(stateFilePath string) error {
+	var (
+		path string
+		dir  string
+		fd   *os.File
+		err  error
+	)
+
+	if len(strings.TrimSpace(stateFilePath)) == 0 {
+		return errors.New("[Validate] watch.state_file_path: can not be empty")
+	}
+
+	if path, err = filepath.Abs(stateFilePath); err != nil {
+		return errors.New("[Validate] watch.state_file_path: " + err.Error())
+	}
+	dir = filepath.Dir(path)
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("[Validate] watch.state_file_path: directory[%s] does not exist", dir)
+	}
+
+	if fd, err = os.CreateTemp(dir, ".state_check_*"); err != nil {
+		return fmt.Errorf("[Validate] watch.state_file_path: directory[%s] is not writable: %s", dir, err.Error())
+	}
+	_ = fd.Close()
+	_ = os.Remove(fd.Name())
+
+	if _, err = os.Stat(path); err == nil {
+		if fd, err = os.OpenFile(path, os.O_WRONLY, 0); err != nil {
+			return fmt.Errorf("[Validate] watch.state_file_path: file[%s] is not writable: %s", path, err.Error())
+		}
+		_ = fd.Close()
+	}
+
+	return nil
+}
+
+func validateSender(c *Config) error {
+	if err := validateNamedSenders(c); err != nil {
+		return err
+	}
+
+	if c.Sender.Type != "multi" {
+		return validateSenderTarget(c, "sender", SenderTarget{Type: c.Sender.Type, Kafka: c.Sender.Kafka, Http: c.Sender.Http, Syslog: c.Sender.Syslog, Loki: c.Sender.Loki, ClickHouse: c.Sender.ClickHouse, File: c.Sender.File})
+	}
+
+	if len(c.Sender.Multi) == 0 {
+		return errors.New("[Validate] sender.multi: at least one target is required")
+	}
+
+	for i, target := range c.Sender.Multi {
+		if err := validateSenderTarget(c, fmt.Sprintf("sender.multi[%d]", i), target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateNamedSenders index.sender引用的发送目标必须在sender.named中配置
+func validateNamedSenders(c *Config) error {
+	var names = make([]string, 0, len(c.Sender.Named))
+
+	for name := range c.Sender.Named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := validateSenderTarget(c, "sender.named."+name, c.Sender.Named[name]); err != nil {
+			return err
+		}
+	}
+
+	for indexName, index := range c.Watch.Index {
+		if len(index.Sender) == 0 {
+			continue
+		}
+		if _, ok := c.Sender.Named[index.Sender]; !ok {
+			return fmt.Errorf("[Validate] watch.index.%s.sender: sender[%s] is not configured in sender.named", indexName, index.Sender)
+		}
+	}
+
+	return nil
+}
+
+func validateSenderTarget(c *Config, name string, target SenderTarget) error {
+	switch target.Type {
+	case "", "elk":
+		if len(c.ELK.Address) == 0 {
+			return fmt.Errorf("[Validate] elk.address: at least one address is required by %s", name)
+		}
+		for _, address := range c.ELK.Address {
+			if len(strings.TrimSpace(address)) == 0 {
+				return errors.New("[Validate] elk.address: address can not be empty")
+			}
+		}
+	case "kafka":
+		if len(target.Kafka.Brokers) == 0 {
+			return fmt.Errorf("[Validate] %s.kafka.brokers: at least one broker is required", name)
+		}
+		if len(target.Kafka.Topic) == 0 {
+			return fmt.Errorf("[Validate] %s.kafka.topic: can not be empty", name)
+		}
+	case "http":
+		if len(target.Http.URL) == 0 {
+			return fmt.Errorf("[Validate] %s.http.url: can not be empty", name)
+		}
+	case "file":
+		if len(target.File.Path) == 0 {
+			return fmt.Errorf("[Validate] %s.file.path: can not be empty", name)
+		}
+	case "loki":
+		if len(target.Loki.URL) == 0 {
+			return fmt.Errorf("[Validate] %s.loki.url: can not be empty", name)
+		}
+	case "clickhouse":
+		if len(target.ClickHouse.DSN) == 0 {
+			return fmt.Errorf("[Validate] %s.clickhouse.dsn: can not be empty", name)
+		}
+		if len(target.ClickHouse.Table) == 0 {
+			return fmt.Errorf("[Validate] %s.clickhouse.table: can not be empty", name)
+		}
+		if target.ClickHouse.BufferRows < 0 {
+			return fmt.Errorf("[Validate] %s.clickhouse.buffer_rows: must not be negative, got %d", name, target.ClickHouse.BufferRows)
+		}
+	case "syslog":
+		if len(target.Syslog.Address) == 0 {
+			return fmt.Errorf("[Validate] %s.syslog.address: can not be empty", name)
+		}
+		switch target.Syslog.Network {
+		case "", "tcp", "udp", "tls":
+		default:
+			return fmt.Errorf("[Validate] %s.syslog.network: must be tcp, udp or tls, got %s", name, target.Syslog.Network)
+		}
+		switch target.Syslog.Framing {
+		case "", "octet_counting", "non_transparent":
+		default:
+			return fmt.Errorf("[Validate] %s.syslog.framing: must be octet_counting or non_transparent, got %s", name, target.Syslog.Framing)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newValidConfig 所有检查都可以通过的配置
+func newValidConfig(t *testing.T) *Config {
+	var root = t.TempDir()
+
+	for _, dir := range []string{"nginx", "api", "state"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &Config{
+		ELK: ELK{Address: []string{"http://127.0.0.1:9200"}},
+		Consumer: Consumer{
+			ConsumerBatchInterval: 5,
+			ConsumerBatchSize:     5,
+		},
+		Watch: Watch{
+			ReadPath: map[string][]string{
+				"index_nginx": {filepath.Join(root, "nginx")},
+				"index_api":   {filepath.Join(root, "api")},
+			},
+			StateFilePath: filepath.Join(root, "state", "core.json"),
+		},
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	var cases = []struct {
+		name   string
+		modify func(cfg *Config)
+		field  string // 错误信息中需要包含的配置项, 为空表示合法
+	}{
+		{"valid", func(cfg *Config) {}, ""},
+		{"zero batch uses default", func(cfg *Config) { cfg.Consumer.ConsumerBatchSize = 0 }, ""},
+		{"empty read path", func(cfg *Config) { cfg.Watch.ReadPath = nil }, "watch.read_path"},
+		{"no existing read path", func(cfg *Config) {
+			cfg.Watch.ReadPath = map[string][]string{"index_nginx": {filepath.Join(t.TempDir(), "missing")}}
+		}, "watch.read_path"},
+		{"read path file", func(cfg *Config) {
+			path := filepath.Join(t.TempDir(), "syslog")
+			_ = os.WriteFile(path, nil, 0644)
+			cfg.Watch.ReadPath = map[string][]string{"index_syslog": {path}}
+		}, ""},
+		{"overlapping read path", func(cfg *Config) {
+			cfg.Watch.ReadPath["index_sub"] = []string{filepath.Join(cfg.Watch.ReadPath["index_nginx"][0], "sub")}
+		}, "watch.read_path.index_nginx"},
+		{"same read path", func(cfg *Config) {
+			cfg.Watch.ReadPath["index_copy"] = cfg.Watch.ReadPath["index_api"]
+		}, "watch.read_path.index_api"},
+		{"negative batch size", func(cfg *Config) { cfg.Consumer.ConsumerBatchSize = -1 }, "consumer.consumer_batch_size"},
+		{"negative batch interval", func(cfg *Config) { cfg.Consumer.ConsumerBatchInterval = -5 }, "consumer.consumer_batch_interval"},
+		{"async drop", func(cfg *Config) { cfg.Consumer.AsyncOverflow = "drop" }, ""},
+		{"unknown async overflow", func(cfg *Config) { cfg.Consumer.AsyncOverflow = "ignore" }, "consumer.async_overflow"},
+		{"empty state file path", func(cfg *Config) { cfg.Watch.StateFilePath = "" }, "watch.state_file_path"},
+		{"missing state dir", func(cfg *Config) {
+			cfg.Watch.StateFilePath = filepath.Join(t.TempDir(), "missing", "core.json")
+		}, "watch.state_file_path"},
+		{"read from end", func(cfg *Config) { cfg.Watch.ReadFrom = "end" }, ""},
+		{"unknown read from", func(cfg *Config) { cfg.Watch.ReadFrom = "middle" }, "watch.read_from"},
+		{"initial tail lines", func(cfg *Config) { cfg.Watch.InitialTailLines = 1000 }, ""},
+		{"negative initial tail lines", func(cfg *Config) { cfg.Watch.InitialTailLines = -1 }, "watch.initial_tail_lines"},
+		{"initial tail lines with read from end", func(cfg *Config) { cfg.Watch.InitialTailLines, cfg.Watch.ReadFrom = 1000, "end" }, "watch.initial_tail_lines"},
+		{"max read passes", func(cfg *Config) { cfg.Watch.MaxReadPasses = 5 }, ""},
+		{"negative max read passes", func(cfg *Config) { cfg.Watch.MaxReadPasses = -1 }, "watch.max_read_passes"},
+		{"start date", func(cfg *Config) { cfg.Watch.StartDate = "2024-01-02 15:04:05" }, ""},
+		{"invalid start date", func(cfg *Config) { cfg.Watch.StartDate = "01/02/2024" }, "watch.start_date"},
+		{"backpressure", func(cfg *Config) { cfg.Watch.BackpressureHigh, cfg.Watch.BackpressureLow = 10000, 5000 }, ""},
+		{"negative backpressure", func(cfg *Config) { cfg.Watch.BackpressureHigh = -1 }, "watch.backpressure_high"},
+		{"backpressure low above high", func(cfg *Config) { cfg.Watch.BackpressureHigh, cfg.Watch.BackpressureLow = 100, 100 }, "watch.backpressure_low"},
+		{"max line bytes", func(cfg *Config) { cfg.Watch.MaxLineBytes = 1 << 20 }, ""},
+		{"negative max line bytes", func(cfg *Config) { cfg.Watch.MaxLineBytes = -1 }, "watch.max_line_bytes"},
+		{"negative partial line timeout", func(cfg *Config) { cfg.Watch.PartialLineTimeout = -1 }, "watch.partial_line_timeout"},
+		{"json log format", func(cfg *Config) { cfg.System.LogFormat = "json" }, ""},
+		{"unknown log format", func(cfg *Config) { cfg.System.LogFormat = "xml" }, "system.log_format"},
+		{"negative log max backups", func(cfg *Config) { cfg.Log.MaxBackups = -1 }, "log.max_backups"},
+		{"content hash id", func(cfg *Config) { cfg.ELK.IDStrategy = "content_hash" }, ""},
+		{"unknown id strategy", func(cfg *Config) { cfg.ELK.IDStrategy = "random" }, "elk.id_strategy"},
+		{"missing elk address", func(cfg *Config) { cfg.ELK.Address = nil }, "elk.address"},
+		{"missing elk address in multi", func(cfg *Config) {
+			cfg.ELK.Address = nil
+			cfg.Sender = Sender{Type: "multi", Multi: []SenderTarget{{Type: "stdout"}, {Type: "elk"}}}
+		}, "elk.address"},
+		{"stdout without elk address", func(cfg *Config) {
+			cfg.ELK.Address = nil
+			cfg.Sender.Type = "stdout"
+		}, ""},
+		{"missing kafka topic", func(cfg *Config) {
+			cfg.Sender = Sender{Type: "kafka", Kafka: Kafka{Brokers: []string{"127.0.0.1:9092"}}}
+		}, "sender.kafka.topic"},
+		{"missing http url", func(cfg *Config) { cfg.Sender.Type = "http" }, "sender.http.url"},
+		{"missing file path", func(cfg *Config) { cfg.Sender.Type = "file" }, "sender.file.path"},
+		{"missing loki url", func(cfg *Config) { cfg.Sender.Type = "loki" }, "sender.loki.url"},
+		{"clickhouse", func(cfg *Config) {
+			cfg.Sender.Type = "clickhouse"
+			cfg.Sender.ClickHouse = ClickHouseSender{DSN: "http://127.0.0.1:8123/logs", Table: "k3_logs"}
+		}, ""},
+		{"missing clickhouse table", func(cfg *Config) {
+			cfg.Sender.Type = "clickhouse"
+			cfg.Sender.ClickHouse.DSN = "http://127.0.0.1:8123/logs"
+		}, "sender.clickhouse.table"},
+		{"missing syslog address", func(cfg *Config) { cfg.Sender.Type = "syslog" }, "sender.syslog.address"},
+		{"unknown syslog network", func(cfg *Config) {
+			cfg.Sender.Type = "syslog"
+			cfg.Sender.Syslog = SyslogSender{Address: "127.0.0.1:514", Network: "unix"}
+		}, "sender.syslog.network"},
+		{"unknown syslog framing", func(cfg *Config) {
+			cfg.Sender.Type = "syslog"
+			cfg.Sender.Syslog = SyslogSender{Address: "127.0.0.1:514", Framing: "length"}
+		}, "sender.syslog.framing"},
+		{"index sender", func(cfg *Config) {
+			cfg.Sender.Named = map[string]SenderTarget{"audit": {Type: "file", File: FileSender{Path: "audit.ndjson"}}}
+			cfg.Watch.Index = map[string]Index{"index_api": {Sender: "audit"}}
+		}, ""},
+		{"unknown index sender", func(cfg *Config) {
+			cfg.Watch.Index = map[string]Index{"index_api": {Sender: "audit"}}
+		}, "watch.index.index_api.sender"},
+		{"invalid named sender", func(cfg *Config) {
+			cfg.Sender.Named = map[string]SenderTarget{"audit": {Type: "file"}}
+		}, "sender.named.audit.file.path"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := newValidConfig(t)
+			c.modify(cfg)
+
+			err := cfg.Validate()
+			if len(c.field) == 0 {
+				if err != nil {
+					t.Errorf("config should be valid, got %s", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("config should be invalid")
+			}
+			if !strings.Contains(err.Error(), c.field) {
+				t.Errorf("error should name %s, got %s", c.field, err)
+			}
+		})
+	}
+}
+
+func TestStateFilePathNotWritable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to read only directory")
+	}
+
+	var dir = t.TempDir()
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0755)
+
+	if err := validateStateFilePath(filepath.Join(dir, "core.json")); err == nil || !strings.Contains(err.Error(), "not writable") {
+		t.Errorf("read only state directory should be rejected, got %v", err)
+	}
+}
+
+func TestLoadValidate(t *testing.T) {
+	var (
+		cfg  = newValidConfig(t)
+		path = filepath.Join(t.TempDir(), "watch.yaml")
+	)
+
+	content := "watch :\n  state_file_path : \"" + cfg.Watch.StateFilePath + "\"\n  read_path :\n    index_nginx : [\"" + cfg.Watch.ReadPath["index_nginx"][0] + "\"]\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有配置elk地址, 加载时校验失败
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "elk.address") {
+		t.Errorf("load should validate config, got %v", err)
+	}
+}
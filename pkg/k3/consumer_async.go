@@ -0,0 +1,235 @@
+package k3
+
+import (
+	"errors"
+	"fmt"
+	"log-engine-sdk/pkg/k3/protocol"
+	"sync"
+)
+
+// 队列满时的处理策略
+const (
+	AsyncOverflowBlock = "block" // 等待队列有空位, 不丢数据, 但是会阻塞调用Add的协程
+	AsyncOverflowDrop  = "drop"  // 直接丢弃, 并计数告警
+)
+
+const (
+	DefaultAsyncChannelSize = 10000 // 默认异步队列大小
+	DefaultAsyncDropWarnN   = 1000  // 每丢弃多少条数据告警一次, 第一条丢弃时也告警
+)
+
+var (
+	MetricDroppedEventsTotal = NewMetric("k3_dropped_events_total", "Total number of events dropped by the async consumer because its queue was full.", MetricCounter)
+)
+
+// asyncEvent 队列中的一条数据, flushed不为nil时表示Flush请求, 处理到该位置时flush下层consumer并返回结果, drain为true时drain下层consumer
+type asyncEvent struct {
+	data    protocol.Data
+	flushed chan error
+	drain   bool
+}
+
+// K3AsyncConsumer 将数据写入有界队列后立即返回, 由单独的协程交给下层consumer
+// 下层consumer发送慢时不会阻塞读取文件的协程, 队列满时按照overflow处理
+type K3AsyncConsumer struct {
+	consumer protocol.K3Consumer        // 下层consumer, 如K3BatchConsumer
+	ch       chan asyncEvent            // 有界队列
+	overflow string                     // 队列满时的处理策略
+	dropped  int64                      // 已经丢弃的数据条数
+	dropLock sync.Mutex                 // dropped锁
+	onDrop   func(data []protocol.Data) // 队列满丢弃数据后的回调
+	wg       sync.WaitGroup             // 协程退出等待
+	mutex    *sync.RWMutex              // Add/Flush持有读锁, Close持有写锁, 保证关闭之后不再写入队列
+	sdkClose bool                       // consumer关闭
+}
+
+// Add 写入队列, 队列满时按照overflow阻塞或者丢弃
+func (k *K3AsyncConsumer) Add(data protocol.Data) error {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	if k.sdkClose {
+		return ErrConsumerClosed
+	}
+
+	if k.overflow == AsyncOverflowBlock {
+		k.ch <- asyncEvent{data: data}
+		return nil
+	}
+
+	select {
+	case k.ch <- asyncEvent{data: data}:
+	default:
+		k.drop(data)
+	}
+
+	return nil
+}
+
+// drop 丢弃数据并计数, 避免日志过多每DefaultAsyncDropWarnN条告警一次
+func (k *K3AsyncConsumer) drop(data protocol.Data) {
+	MetricDroppedEventsTotal.Add(1)
+
+	k.dropLock.Lock()
+	k.dropped++
+	dropped := k.dropped
+	k.dropLock.Unlock()
+
+	if dropped == 1 || dropped%DefaultAsyncDropWarnN == 0 {
+		K3LogWarn("[K3AsyncConsumer] queue is full, drop event index_name[%s] uuid[%s], %d events dropped in total", data.IndexName, data.UUID, dropped)
+	}
+
+	if k.onDrop != nil {
+		k.onDrop([]protocol.Data{data})
+	}
+}
+
+// Dropped 已经丢弃的数据条数
+func (k *K3AsyncConsumer) Dropped() int64 {
+	k.dropLock.Lock()
+	defer k.dropLock.Unlock()
+	return k.dropped
+}
+
+// QueueDepth 队列中的数据条数加上下层consumer中还没有发送成功的数据条数
+func (k *K3AsyncConsumer) QueueDepth() int {
+	depth := len(k.ch)
+	if queue, ok := k.consumer.(protocol.K3QueueDepth); ok {
+		depth += queue.QueueDepth()
+	}
+	return depth
+}
+
+// Flush 等待Flush之前写入队列的数据都交给下层consumer, 然后flush下层consumer
+func (k *K3AsyncConsumer) Flush() error {
+	return k.wait(false)
+}
+
+// Drain 等待Drain之前写入队列的数据都交给下层consumer, 然后drain下层consumer, 下层consumer不支持时flush
+func (k *K3AsyncConsumer) Drain() error {
+	return k.wait(true)
+}
+
+// wait 写入一个Flush请求并等待协程处理到该位置
+func (k *K3AsyncConsumer) wait(drain bool) error {
+	var flushed = make(chan error, 1)
+
+	k.mutex.RLock()
+	if k.sdkClose {
+		k.mutex.RUnlock()
+		return ErrConsumerClosed
+	}
+	k.ch <- asyncEvent{flushed: flushed, drain: drain}
+	k.mutex.RUnlock()
+
+	return <-flushed
+}
+
+// Close 停止接收数据, 队列中剩余的数据交给下层consumer后关闭下层consumer
+func (k *K3AsyncConsumer) Close() error {
+	K3LogInfo("Close K3AsyncConsumer")
+
+	k.mutex.Lock()
+	if k.sdkClose {
+		k.mutex.Unlock()
+		return ErrConsumerClosed
+	}
+	k.sdkClose = true
+	close(k.ch)
+	k.mutex.Unlock()
+
+	k.wg.Wait()
+	return k.consumer.Close()
+}
+
+func (k *K3AsyncConsumer) init() {
+	k.wg.Add(1)
+
+	go func() {
+		defer k.wg.Done()
+
+		for event := range k.ch {
+			k.handle(event)
+		}
+	}()
+}
+
+// handle 处理队列中的一条数据或Flush请求, 防止下层consumer的异常导致协程退出, 之后的数据无人处理
+func (k *K3AsyncConsumer) handle(event asyncEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			K3LogError("[K3AsyncConsumer] handle event panic: %v", r)
+
+			// Flush请求必须返回结果, 否则wait一直等待; 数据没有交给下层consumer, 按照丢弃处理
+			if event.flushed != nil {
+				event.flushed <- fmt.Errorf("[K3AsyncConsumer] consumer panic: %v", r)
+			} else if k.onDrop != nil {
+				k.onDrop([]protocol.Data{event.data})
+			}
+		}
+	}()
+
+	if event.flushed != nil && event.drain {
+		event.flushed <- drainConsumer(k.consumer)
+		return
+	}
+	if event.flushed != nil {
+		event.flushed <- k.consumer.Flush()
+		return
+	}
+
+	if err := k.consumer.Add(event.data); err != nil {
+		K3LogError("[K3AsyncConsumer] add event index_name[%s] uuid[%s] to consumer failed: %s", event.data.IndexName, event.data.UUID, err.Error())
+	}
+}
+
+type K3AsyncConsumerConfig struct {
+	Consumer    protocol.K3Consumer        // 下层consumer, 队列中的数据由单独的协程写入
+	ChannelSize int                        // 队列大小, 默认DefaultAsyncChannelSize
+	Overflow    string                     // 队列满时的处理策略, block(默认)或drop
+	OnDrop      func(data []protocol.Data) // drop策略下队列满丢弃数据后回调, 这些数据不会再发送
+}
+
+// NewAsyncConsumer creates a new K3AsyncConsumer which blocks when the queue is full.
+func NewAsyncConsumer(consumer protocol.K3Consumer) (protocol.K3Consumer, error) {
+	return NewAsyncConsumerWithConfig(K3AsyncConsumerConfig{
+		Consumer: consumer,
+	})
+}
+
+func NewAsyncConsumerWithConfig(config K3AsyncConsumerConfig) (protocol.K3Consumer, error) {
+	var (
+		chSize   int
+		overflow string
+	)
+
+	if config.Consumer == nil {
+		return nil, errors.New("async consumer requires a consumer")
+	}
+
+	switch config.Overflow {
+	case "", AsyncOverflowBlock:
+		overflow = AsyncOverflowBlock
+	case AsyncOverflowDrop:
+		overflow = AsyncOverflowDrop
+	default:
+		return nil, errors.New("unknown async overflow policy: " + config.Overflow)
+	}
+
+	if config.ChannelSize > 0 {
+		chSize = config.ChannelSize
+	} else {
+		chSize = DefaultAsyncChannelSize
+	}
+
+	asyncConsumer := &K3AsyncConsumer{
+		consumer: config.Consumer,
+		ch:       make(chan asyncEvent, chSize),
+		overflow: overflow,
+		mutex:    new(sync.RWMutex),
+		onDrop:   config.OnDrop,
+	}
+	asyncConsumer.init()
+
+	return asyncConsumer, nil
+}
@@ -0,0 +1,186 @@
+package k3
+
+import (
+	"fmt"
+	"log-engine-sdk/pkg/k3/protocol"
+	"testing"
+	"time"
+)
+
+// slowSender 测试用sender, 每次Send等待delay, 模拟elk写入缓慢
+type slowSender struct {
+	captureSender
+	delay time.Duration
+}
+
+func (s *slowSender) Send(data []protocol.Data) error {
+	time.Sleep(s.delay)
+	return s.captureSender.Send(data)
+}
+
+// newSlowAsyncConsumer 队列大小为1, 下层每条数据提交一次, 每次提交等待200ms
+func newSlowAsyncConsumer(t *testing.T, overflow string) (*slowSender, *K3AsyncConsumer) {
+	var (
+		sender   = &slowSender{delay: 200 * time.Millisecond}
+		batch    protocol.K3Consumer
+		consumer protocol.K3Consumer
+		err      error
+	)
+
+	if batch, err = NewBatchConsumerWithConfig(K3BatchConsumerConfig{
+		Sender:    sender,
+		BatchSize: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if consumer, err = NewAsyncConsumerWithConfig(K3AsyncConsumerConfig{
+		Consumer:    batch,
+		ChannelSize: 1,
+		Overflow:    overflow,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一条数据被协程取出后阻塞在Send, 第二条数据占满队列
+	_ = consumer.Add(protocol.Data{UUID: "0", IndexName: "1001"})
+	time.Sleep(50 * time.Millisecond)
+	_ = consumer.Add(protocol.Data{UUID: "1", IndexName: "1001"})
+
+	return sender, consumer.(*K3AsyncConsumer)
+}
+
+func TestAsyncConsumerOverflowDrop(t *testing.T) {
+	var (
+		sender, consumer = newSlowAsyncConsumer(t, AsyncOverflowDrop)
+		start            = time.Now()
+		dropped          = MetricDroppedEventsTotal.Value()
+	)
+
+	// 队列满时直接丢弃, 不会阻塞
+	for i := 2; i < 10; i++ {
+		if err := consumer.Add(protocol.Data{UUID: fmt.Sprintf("%d", i), IndexName: "1001"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("add should not block when queue is full, took %s", elapsed)
+	}
+
+	if err := consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if consumer.Dropped() != 8 {
+		t.Errorf("8 events should be dropped, got %d", consumer.Dropped())
+	}
+	if MetricDroppedEventsTotal.Value()-dropped != 8 {
+		t.Errorf("dropped metric should increase by 8, got %d", MetricDroppedEventsTotal.Value()-dropped)
+	}
+	if sender.count() != 2 {
+		t.Errorf("queued events should be sent, got %d", sender.count())
+	}
+}
+
+func TestAsyncConsumerOverflowBlock(t *testing.T) {
+	var (
+		sender, consumer = newSlowAsyncConsumer(t, AsyncOverflowBlock)
+		start            = time.Now()
+	)
+
+	// 队列满时等待协程取出数据, 不丢数据
+	if err := consumer.Add(protocol.Data{UUID: "2", IndexName: "1001"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("add should block until the queue has room, took %s", elapsed)
+	}
+
+	if err := consumer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if sender.count() != 3 {
+		t.Errorf("flush should wait for queued events, got %d", sender.count())
+	}
+
+	if err := consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if consumer.Dropped() != 0 {
+		t.Errorf("block policy should not drop events, got %d", consumer.Dropped())
+	}
+
+	if err := consumer.Add(protocol.Data{UUID: "3", IndexName: "1001"}); err == nil {
+		t.Error("add after close should return error")
+	}
+}
+
+// panicConsumer 测试用consumer, uuid为panic的数据和第一次Flush时panic
+type panicConsumer struct {
+	captureSender
+	flushed bool
+}
+
+func (p *panicConsumer) Add(data protocol.Data) error {
+	if data.UUID == "panic" {
+		panic("add panic")
+	}
+	return p.captureSender.Send([]protocol.Data{data})
+}
+
+func (p *panicConsumer) Flush() error {
+	if !p.flushed {
+		p.flushed = true
+		panic("flush panic")
+	}
+	return nil
+}
+
+func TestAsyncConsumerPanic(t *testing.T) {
+	var (
+		downstream = &panicConsumer{}
+		dropped    []string
+		consumer   protocol.K3Consumer
+		err        error
+	)
+
+	if consumer, err = NewAsyncConsumerWithConfig(K3AsyncConsumerConfig{
+		Consumer: downstream,
+		OnDrop: func(data []protocol.Data) {
+			for _, d := range data {
+				dropped = append(dropped, d.UUID)
+			}
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// panic只影响当前这一条数据, 之后的数据继续处理
+	_ = consumer.Add(protocol.Data{UUID: "panic", IndexName: "1001"})
+	_ = consumer.Add(protocol.Data{UUID: "1", IndexName: "1001"})
+
+	// Flush时panic也需要返回结果
+	done := make(chan error, 1)
+	go func() { done <- consumer.Flush() }()
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Error("flush should return error when consumer panics")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("flush should not hang when consumer panics")
+	}
+
+	if err = consumer.Flush(); err != nil {
+		t.Errorf("flush after panic should succeed, got %v", err)
+	}
+	if downstream.count() != 1 {
+		t.Errorf("events after panic should be handled, got %d", downstream.count())
+	}
+	if len(dropped) != 1 || dropped[0] != "panic" {
+		t.Errorf("drop callback should receive panic, got %v", dropped)
+	}
+	_ = consumer.Close()
+}
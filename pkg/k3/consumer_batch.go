@@ -1,8 +1,12 @@
 package k3
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log-engine-sdk/pkg/k3/protocol"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,6 +17,27 @@ const (
 	DefaultCacheCapacity = 100 // 默认缓存容量
 )
 
+var (
+	MinBatchAgeCheckInterval = 10 * time.Millisecond // max batch age 的最小检查间隔
+)
+
+var (
+	// ErrConsumerClosed consumer关闭之后继续写入数据时返回, 读取协程收到后停止发送
+	ErrConsumerClosed = errors.New("consumer has been closed")
+)
+
+var (
+	lastSendSuccess atomic.Int64 // 最近一次sender确认接收的时间(UnixNano), 0表示还没有发送成功过
+)
+
+// LastSendSuccess 最近一次发送成功的时间, 还没有发送成功过时返回零值
+func LastSendSuccess() time.Time {
+	if last := lastSendSuccess.Load(); last > 0 {
+		return time.Unix(0, last)
+	}
+	return time.Time{}
+}
+
 type K3BatchConsumer struct {
 	bufferMutex *sync.RWMutex // buffer锁，用于Data数据在缓存中读取是否安全
 	cacheMutex  *sync.RWMutex // cache锁
@@ -24,8 +49,21 @@ type K3BatchConsumer struct {
 
 	wg        *sync.WaitGroup // 用于管控自动刷新协程
 	closed    chan struct{}
+	sdkClose  atomic.Bool     // Close之后为true, Add返回ErrConsumerClosed
 	autoFlush bool            // 是否自动上报
 	sender    protocol.Sender // 不同的日志存储类型，用不同的实现即可
+
+	maxBatchAge     time.Duration // buffer中最早的数据最长缓存时间, 超过后强制提交, 0表示不限制
+	bufferStartTime time.Time     // buffer中第一条数据的写入时间
+
+	maxBatchBytes int // 单个批次序列化后的最大字节数, 超过后即使没有达到batchSize也提前提交, 0表示不限制
+	bufferBytes   int // buffer中数据序列化后的字节数
+
+	pending atomic.Int64 // buffer和cacheBuffer中还没有发送成功的数据条数
+
+	onSend func(data []protocol.Data, err error) // 每个批次提交后的回调
+
+	ctx context.Context // 发送使用的ctx, 取消时正在进行的发送立即返回
 }
 
 // fetchBufferLength returns the length of buffer
@@ -42,15 +80,72 @@ func (k *K3BatchConsumer) fetchCacheLength() int {
 	return len(k.cacheBuffer)
 }
 
+// QueueDepth buffer和cacheBuffer中还没有发送成功的数据条数, 不需要获取锁, 发送阻塞时也可以立即返回
+func (k *K3BatchConsumer) QueueDepth() int {
+	return int(k.pending.Load())
+}
+
+// fetchBufferBytes returns the serialized size of buffer
+func (k *K3BatchConsumer) fetchBufferBytes() int {
+	k.bufferMutex.RLock()
+	defer k.bufferMutex.RUnlock()
+	return k.bufferBytes
+}
+
+// isBufferFull buffer达到batchSize或者maxBatchBytes, 调用时需要持有bufferMutex
+func (k *K3BatchConsumer) isBufferFull() bool {
+	return len(k.buffer) >= k.batchSize || (k.maxBatchBytes > 0 && k.bufferBytes >= k.maxBatchBytes)
+}
+
+// cutBuffer 将buffer中的数据作为一个批次写入cacheBuffer, 并清空buffer, 调用时需要持有cacheMutex和bufferMutex
+func (k *K3BatchConsumer) cutBuffer() {
+	k.cacheBuffer = append(k.cacheBuffer, k.buffer)
+	k.buffer = make([]protocol.Data, 0, k.batchSize)
+	k.bufferBytes = 0
+}
+
+// dataBytes 数据序列化为json后的字节数, 用于估算批次提交的请求大小
+func dataBytes(data protocol.Data) int {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return 0
+	}
+	return len(body)
+}
+
 // Add adds data to buffer
 func (k *K3BatchConsumer) Add(data protocol.Data) error {
+	var size int
+
+	if k.maxBatchBytes > 0 {
+		size = dataBytes(data)
+	}
+
+	k.cacheMutex.Lock()
 	k.bufferMutex.Lock()
+	// 持有锁时检查, 保证Close之前写入的数据都会被FlushAll提交
+	if k.sdkClose.Load() {
+		k.bufferMutex.Unlock()
+		k.cacheMutex.Unlock()
+		return ErrConsumerClosed
+	}
+	// 加入后会超过maxBatchBytes时, 先将buffer中已有的数据作为一个批次, 保证单个批次不超过限制(单条数据超过限制时单独作为一个批次)
+	if k.maxBatchBytes > 0 && len(k.buffer) > 0 && k.bufferBytes+size > k.maxBatchBytes {
+		k.cutBuffer()
+	}
+	if len(k.buffer) == 0 {
+		k.bufferStartTime = time.Now()
+	}
 	k.buffer = append(k.buffer, data)
+	k.bufferBytes += size
 	k.bufferMutex.Unlock()
+	k.cacheMutex.Unlock()
+	MetricPendingEvents.Add(1)
+	k.pending.Add(1)
 	// K3LogInfo("Add data to buffer, current buffer length: %d\n", k.fetchBufferLength())
 
-	// 当buffer长度大于等于 batchSize 或者 cacheBuffer的长度大于0，则立即flush, 要么buffer满了，要么cacheBuffer有数据都可以刷新发送
-	if k.fetchBufferLength() >= k.batchSize || k.fetchCacheLength() > 0 {
+	// 当buffer长度大于等于 batchSize, 字节数大于等于maxBatchBytes, 或者 cacheBuffer的长度大于0，则立即flush, 要么buffer满了，要么cacheBuffer有数据都可以刷新发送
+	if k.fetchBufferLength() >= k.batchSize || (k.maxBatchBytes > 0 && k.fetchBufferBytes() >= k.maxBatchBytes) || k.fetchCacheLength() > 0 {
 		return k.Flush()
 	}
 
@@ -74,58 +169,169 @@ func (k *K3BatchConsumer) Flush() error {
 		return nil
 	}
 
-	// 当buffer长度大于等于 batchSize，则将buffer中的数据写入cacheBuffer中，并清空buffer
-	if len(k.buffer) >= k.batchSize {
-		k.cacheBuffer = append(k.cacheBuffer, k.buffer)
-		k.buffer = make([]protocol.Data, 0, k.batchSize)
+	// 当buffer长度大于等于 batchSize 或者字节数大于等于 maxBatchBytes，则将buffer中的数据写入cacheBuffer中，并清空buffer
+	if k.isBufferFull() {
+		k.cutBuffer()
 	}
 
 	// 当cacheBuffer长度大于等于 cacheCapacity，则将cacheBuffer中的数据写入server，并清空cacheBuffer
 	if len(k.cacheBuffer) >= k.cacheCapacity || len(k.cacheBuffer) > 0 {
-		// 减少一个cache buffer , 并上传
-		err = k.sender.Send(k.cacheBuffer[0])
+		// 减少一个cache buffer , 并上传, 发送失败的批次保留在cacheBuffer中, 下次Flush时重新提交, 不丢数据
+		if err = k.send(k.cacheBuffer[0]); err != nil {
+			return err
+		}
 		k.cacheBuffer = k.cacheBuffer[1:]
 	}
 
 	return err
 }
 
+// send 提交一个批次, 并通过onSend通知提交结果, 发送失败时批次是否保留由调用方决定
+func (k *K3BatchConsumer) send(data []protocol.Data) error {
+	err := protocol.SendContext(k.ctx, k.sender, data)
+	if err != nil {
+		MetricSendErrorsTotal.Add(1)
+	} else if len(data) > 0 {
+		MetricPendingEvents.Add(-int64(len(data)))
+		k.pending.Add(-int64(len(data)))
+		MetricBatchesSentTotal.Add(1)
+		lastSendSuccess.Store(time.Now().UnixNano())
+	}
+	if k.onSend != nil && len(data) > 0 {
+		k.onSend(data, err)
+	}
+	return err
+}
+
+// flushExpired buffer中最早的数据缓存时间超过maxBatchAge, 即使buffer没有满也强制提交, 保证单条数据的最大延迟
+func (k *K3BatchConsumer) flushExpired() error {
+	var (
+		expired bool
+		err     error
+	)
+
+	k.cacheMutex.Lock()
+	k.bufferMutex.Lock()
+	if len(k.buffer) > 0 && time.Since(k.bufferStartTime) >= k.maxBatchAge {
+		k.cutBuffer()
+		expired = true
+	}
+	k.bufferMutex.Unlock()
+	k.cacheMutex.Unlock()
+
+	if !expired {
+		return nil
+	}
+
+	// cacheBuffer中的数据都比buffer中的数据更早, 需要全部提交
+	for k.fetchCacheLength() > 0 {
+		if err = k.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (k *K3BatchConsumer) FlushAll() error {
 	var (
 		err error
 	)
 	// 缓存中一直有数据，就需要不断的send， 直到结束
 	for k.fetchCacheLength() > 0 || k.fetchBufferLength() > 0 {
-		k.cacheBuffer = append(k.cacheBuffer, k.buffer)
-		k.buffer = make([]protocol.Data, 0, k.batchSize)
-		if err = k.sender.Send(k.cacheBuffer[0]); err != nil {
+		if err = k.flushFirst(); err != nil {
 			return err
 		}
-		k.cacheBuffer = k.cacheBuffer[1:]
 	}
 
 	return err
 }
 
-// Close closes the consumer
+// flushFirst 将buffer作为一个批次写入cacheBuffer, 并提交cacheBuffer中最早的批次
+func (k *K3BatchConsumer) flushFirst() error {
+	k.cacheMutex.Lock()
+	defer k.cacheMutex.Unlock()
+
+	k.bufferMutex.Lock()
+	defer k.bufferMutex.Unlock()
+
+	// buffer为空时不切分, 否则之前发送失败的批次还在cacheBuffer中时, 每次都会追加一个空批次而无法结束
+	if len(k.buffer) > 0 {
+		k.cutBuffer()
+	}
+	if err := k.send(k.cacheBuffer[0]); err != nil {
+		return err
+	}
+	k.cacheBuffer = k.cacheBuffer[1:]
+
+	return nil
+}
+
+// Drain 同步提交调用时buffer和cacheBuffer中的全部数据, 不关闭consumer
+// 发送失败的批次保留在cacheBuffer中并返回错误; 只提交调用时已有的批次, 并发写入的数据不会使Drain一直无法返回
+func (k *K3BatchConsumer) Drain() error {
+	var batches int
+
+	k.cacheMutex.Lock()
+	k.bufferMutex.Lock()
+	if len(k.buffer) > 0 {
+		k.cutBuffer()
+	}
+	batches = len(k.cacheBuffer)
+	k.bufferMutex.Unlock()
+	k.cacheMutex.Unlock()
+
+	for ; batches > 0; batches-- {
+		if err := k.drainFirst(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// drainFirst 提交cacheBuffer中最早的批次, 发送成功后才移出cacheBuffer
+func (k *K3BatchConsumer) drainFirst() error {
+	k.cacheMutex.Lock()
+	defer k.cacheMutex.Unlock()
+
+	// 期间Flush可能已经提交了剩余的批次
+	if len(k.cacheBuffer) == 0 {
+		return nil
+	}
+	if err := k.send(k.cacheBuffer[0]); err != nil {
+		return err
+	}
+	k.cacheBuffer = k.cacheBuffer[1:]
+
+	return nil
+}
+
+// Close closes the consumer, Add after Close returns ErrConsumerClosed
 func (k *K3BatchConsumer) Close() error {
 	K3LogInfo("Close K3BatchConsumer")
-	if k.autoFlush {
+	if !k.sdkClose.CompareAndSwap(false, true) {
+		return ErrConsumerClosed
+	}
+
+	if k.autoFlush || k.maxBatchAge > 0 {
 		close(k.closed)
 		k.wg.Wait()
 	}
-	if err := k.FlushAll(); err != nil {
-		return err
-	}
-	return k.sender.Close()
+	// 即使最后一次提交失败, 也需要关闭sender
+	return errors.Join(k.FlushAll(), k.sender.Close())
 }
 
 type K3BatchConsumerConfig struct {
-	Sender        protocol.Sender // 日志提交到那个sender
-	BatchSize     int             // 批量提交大小， 单次, []protocol.Data
-	AutoFlush     bool            // 是否自动提交，配合interval使用
-	Interval      int             // 检查提交的时间间隔
-	CacheCapacity int             // 批量日志缓存容量 [][]protocol.Data
+	Sender        protocol.Sender                       // 日志提交到那个sender
+	BatchSize     int                                   // 批量提交大小， 单次, []protocol.Data
+	AutoFlush     bool                                  // 是否自动提交，配合interval使用
+	Interval      int                                   // 检查提交的时间间隔
+	CacheCapacity int                                   // 批量日志缓存容量 [][]protocol.Data
+	MaxBatchAge   time.Duration                         // 单条数据在缓存中的最长时间, 超过后即使批量没有满也强制提交, 0表示不限制
+	MaxBatchBytes int                                   // 单个批次序列化为json后的最大字节数, 超过后即使没有达到BatchSize也提前提交, 0表示不限制
+	OnSend        func(data []protocol.Data, err error) // 每个批次提交给sender后回调, err为nil表示sender已确认接收
+	Context       context.Context                       // 发送使用的ctx, 取消时正在进行的发送立即返回, 之后的发送直接失败, 默认context.Background()
 }
 
 // NewBatchConsumer creates a new K3BatchConsumer with default batch size.
@@ -183,6 +389,14 @@ func initBatchConsumer(config K3BatchConsumerConfig) (protocol.K3Consumer, error
 		closed:        make(chan struct{}),
 		autoFlush:     config.AutoFlush,
 		sender:        config.Sender,
+		maxBatchAge:   config.MaxBatchAge,
+		maxBatchBytes: config.MaxBatchBytes,
+		onSend:        config.OnSend,
+		ctx:           config.Context,
+	}
+
+	if k3BatchConsumer.ctx == nil {
+		k3BatchConsumer.ctx = context.Background()
 	}
 
 	if config.Interval == 0 {
@@ -218,5 +432,40 @@ func initBatchConsumer(config K3BatchConsumerConfig) (protocol.K3Consumer, error
 		}()
 	}
 
+	if k3BatchConsumer.maxBatchAge > 0 {
+		k3BatchConsumer.wg.Add(1)
+
+		go func() {
+			// 检查间隔为maxBatchAge的一半, 保证数据的最大延迟不超过1.5倍maxBatchAge
+			checkInterval := k3BatchConsumer.maxBatchAge / 2
+			if checkInterval < MinBatchAgeCheckInterval {
+				checkInterval = MinBatchAgeCheckInterval
+			}
+
+			t := time.NewTicker(checkInterval)
+			defer func() {
+				if r := recover(); r != nil {
+					K3LogError("Max batch age goroutine panic: %v\n", r)
+				}
+
+				t.Stop()
+				k3BatchConsumer.wg.Done()
+			}()
+
+			for {
+				select {
+				case <-t.C:
+					if err := k3BatchConsumer.flushExpired(); err != nil {
+						K3LogError("Flush expired batch failed: %s", err.Error())
+					}
+				case _, ok := <-k3BatchConsumer.closed:
+					if !ok {
+						return
+					}
+				}
+			}
+		}()
+	}
+
 	return k3BatchConsumer, nil
 }
@@ -1,8 +1,13 @@
 package k3
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log-engine-sdk/pkg/k3/protocol"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -65,3 +70,329 @@ func TestBatchConsumer(t *testing.T) {
 	consumer.Close()
 
 }
+
+// captureSender 测试用sender, 记录每一次Send的批量数据
+type captureSender struct {
+	lock    sync.Mutex
+	batches [][]protocol.Data
+	err     error // Send 返回的错误
+}
+
+func (c *captureSender) Send(data []protocol.Data) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.batches = append(c.batches, data)
+	return c.err
+}
+
+func (c *captureSender) Close() error {
+	return nil
+}
+
+func (c *captureSender) count() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var count int
+	for _, batch := range c.batches {
+		count += len(batch)
+	}
+	return count
+}
+
+func TestBatchConsumerMaxBatchAge(t *testing.T) {
+	var (
+		sender   = &captureSender{}
+		consumer protocol.K3Consumer
+		err      error
+	)
+
+	if consumer, err = NewBatchConsumerWithConfig(K3BatchConsumerConfig{
+		Sender:      sender,
+		BatchSize:   100,
+		MaxBatchAge: 200 * time.Millisecond,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+
+	// 缓慢写入, 批量永远不会满
+	for i := 0; i < 3; i++ {
+		_ = consumer.Add(protocol.Data{UUID: GenerateUUID(), IndexName: "1001"})
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if sender.count() != 0 {
+		t.Fatalf("batch should not be flushed before max batch age, got %d", sender.count())
+	}
+
+	// 最早的数据超过max batch age后, 必须在1.5倍max batch age内提交
+	time.Sleep(250 * time.Millisecond)
+	if sender.count() != 3 {
+		t.Errorf("expired batch should be flushed, got %d", sender.count())
+	}
+}
+
+func TestBatchConsumerOnSend(t *testing.T) {
+	var (
+		sender   = &captureSender{}
+		consumer protocol.K3Consumer
+		lock     sync.Mutex
+		sent     []string
+		failed   []string
+		err      error
+	)
+
+	if consumer, err = NewBatchConsumerWithConfig(K3BatchConsumerConfig{
+		Sender:    sender,
+		BatchSize: 2,
+		OnSend: func(data []protocol.Data, err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			for _, d := range data {
+				if err != nil {
+					failed = append(failed, d.UUID)
+				} else {
+					sent = append(sent, d.UUID)
+				}
+			}
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = consumer.Add(protocol.Data{UUID: "1", IndexName: "1001"})
+	_ = consumer.Add(protocol.Data{UUID: "2", IndexName: "1001"})
+
+	// 发送失败的批次也需要回调
+	sender.lock.Lock()
+	sender.err = errors.New("send failed")
+	sender.lock.Unlock()
+	_ = consumer.Add(protocol.Data{UUID: "3", IndexName: "1001"})
+	_ = consumer.Close()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(sent) != 2 || sent[0] != "1" || sent[1] != "2" {
+		t.Errorf("sent callback should receive 1, 2, got %v", sent)
+	}
+	if len(failed) != 1 || failed[0] != "3" {
+		t.Errorf("failed callback should receive 3, got %v", failed)
+	}
+}
+
+func TestBatchConsumerFlushRetry(t *testing.T) {
+	var (
+		sender   = &captureSender{err: errors.New("send failed")}
+		consumer protocol.K3Consumer
+		err      error
+	)
+
+	if consumer, err = NewBatchConsumerWithConfig(K3BatchConsumerConfig{
+		Sender:    sender,
+		BatchSize: 2,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	batch := consumer.(*K3BatchConsumer)
+
+	// 批次满时Flush发送失败, 批次保留在cacheBuffer中
+	_ = consumer.Add(protocol.Data{UUID: "1", IndexName: "1001"})
+	if err = consumer.Add(protocol.Data{UUID: "2", IndexName: "1001"}); err == nil {
+		t.Fatal("add should return send error")
+	}
+	if batch.fetchCacheLength() != 1 || batch.QueueDepth() != 2 {
+		t.Fatalf("failed batch should be kept, got %d batches, %d events", batch.fetchCacheLength(), batch.QueueDepth())
+	}
+
+	// 恢复后下一次Flush重新提交失败的批次
+	sender.lock.Lock()
+	sender.err = nil
+	sender.batches = nil
+	sender.lock.Unlock()
+	if err = consumer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if sender.count() != 2 || batch.QueueDepth() != 0 {
+		t.Errorf("failed batch should be sent again, sent %d, pending %d", sender.count(), batch.QueueDepth())
+	}
+	_ = consumer.Close()
+}
+
+func TestBatchConsumerMaxBatchBytes(t *testing.T) {
+	var (
+		sender   = &captureSender{}
+		consumer protocol.K3Consumer
+		large    = strings.Repeat("x", 400)
+		maxBytes = 1000
+		err      error
+	)
+
+	if consumer, err = NewBatchConsumerWithConfig(K3BatchConsumerConfig{
+		Sender:        sender,
+		BatchSize:     100,
+		MaxBatchBytes: maxBytes,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 每条约450字节, 远没有达到BatchSize, 第3条加入时超过maxBatchBytes
+	for i := 0; i < 5; i++ {
+		_ = consumer.Add(protocol.Data{UUID: fmt.Sprintf("%d", i), IndexName: "1001", Properties: map[string]interface{}{"content": large}})
+	}
+
+	if sender.count() == 0 {
+		t.Fatal("batch should be flushed by bytes before batch size")
+	}
+	_ = consumer.Close()
+
+	if sender.count() != 5 {
+		t.Fatalf("all data should be sent, got %d", sender.count())
+	}
+
+	for _, batch := range sender.batches {
+		var size int
+		for _, data := range batch {
+			size += dataBytes(data)
+		}
+		if size > maxBytes {
+			t.Errorf("batch of %d events should not exceed %d bytes, got %d", len(batch), maxBytes, size)
+		}
+	}
+}
+
+func TestBatchConsumerAddAfterClose(t *testing.T) {
+	var (
+		sender   = &captureSender{}
+		consumer protocol.K3Consumer
+		wg       sync.WaitGroup
+		accepted atomic.Int64
+		err      error
+	)
+
+	if consumer, err = NewBatchConsumerWithConfig(K3BatchConsumerConfig{
+		Sender:    sender,
+		BatchSize: 10,
+		AutoFlush: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 多个协程持续写入, 期间关闭consumer
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				err := consumer.Add(protocol.Data{UUID: fmt.Sprintf("%d-%d", i, j), IndexName: "1001"})
+				if errors.Is(err, ErrConsumerClosed) {
+					return
+				}
+				if err != nil {
+					t.Errorf("add should only fail with ErrConsumerClosed, got %v", err)
+					return
+				}
+				accepted.Add(1)
+			}
+		}(i)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err = consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	// Close之前写入成功的数据都已经提交
+	if sender.count() != int(accepted.Load()) {
+		t.Errorf("all accepted data should be sent, accepted %d, sent %d", accepted.Load(), sender.count())
+	}
+
+	if err = consumer.Add(protocol.Data{UUID: "closed", IndexName: "1001"}); !errors.Is(err, ErrConsumerClosed) {
+		t.Errorf("add after close should return ErrConsumerClosed, got %v", err)
+	}
+	if err = consumer.Close(); !errors.Is(err, ErrConsumerClosed) {
+		t.Errorf("close twice should return ErrConsumerClosed, got %v", err)
+	}
+}
+
+func TestBatchConsumerFlushAllRetry(t *testing.T) {
+	var (
+		sender   = &captureSender{err: errors.New("send failed")}
+		consumer protocol.K3Consumer
+		err      error
+	)
+
+	if consumer, err = NewBatchConsumerWithConfig(K3BatchConsumerConfig{
+		Sender:    sender,
+		BatchSize: 10,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	batch := consumer.(*K3BatchConsumer)
+
+	_ = consumer.Add(protocol.Data{UUID: "1", IndexName: "1001"})
+	if err = batch.FlushAll(); err == nil {
+		t.Fatal("flush all should return send error")
+	}
+
+	// 发送失败的批次保留, 再次FlushAll时重新提交, buffer为空时不会追加空批次
+	sender.lock.Lock()
+	sender.err = nil
+	sender.lock.Unlock()
+	if err = batch.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	if batch.fetchCacheLength() != 0 {
+		t.Errorf("cache should be empty after flush all, got %d batches", batch.fetchCacheLength())
+	}
+	if sender.count() != 2 {
+		t.Errorf("failed batch should be sent again, got %d events", sender.count())
+	}
+}
+
+// contextSender 测试用sender, 一直阻塞到ctx取消
+type contextSender struct{}
+
+func (contextSender) Send([]protocol.Data) error {
+	return errors.New("SendContext should be used")
+}
+
+func (contextSender) SendContext(ctx context.Context, _ []protocol.Data) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (contextSender) Close() error {
+	return nil
+}
+
+func TestBatchConsumerContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	consumer, err := NewBatchConsumerWithConfig(K3BatchConsumerConfig{Sender: contextSender{}, BatchSize: 10, Context: ctx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = consumer.Add(protocol.Data{UUID: "1", IndexName: "1001"})
+
+	// 发送阻塞时取消ctx, FlushAll立即返回
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	if err = consumer.(*K3BatchConsumer).FlushAll(); !errors.Is(err, context.Canceled) {
+		t.Errorf("flush should return context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("flush should return promptly after context is cancelled, took %v", elapsed)
+	}
+
+	// 没有实现ContextSender的sender, ctx取消后直接失败
+	consumer, err = NewBatchConsumerWithConfig(K3BatchConsumerConfig{Sender: &captureSender{}, BatchSize: 10, Context: ctx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = consumer.Add(protocol.Data{UUID: "2", IndexName: "1001"})
+	if err = consumer.(*K3BatchConsumer).FlushAll(); !errors.Is(err, context.Canceled) {
+		t.Errorf("flush with cancelled context should fail, got %v", err)
+	}
+}
@@ -46,8 +46,8 @@ func (k *K3LogConsumer) Add(data protocol.Data) error {
 	defer k.mutex.Unlock()
 
 	if k.sdkClose {
-		err = errors.New("add event failed, SDK has been closed ")
-		K3LogError(err.Error())
+		err = ErrConsumerClosed
+		K3LogError("add event failed: %s", err.Error())
 	} else {
 		if b, err = json.Marshal(data); err != nil {
 			return err
@@ -83,7 +83,7 @@ func (k *K3LogConsumer) Close() error {
 	defer k.mutex.Unlock()
 
 	if k.sdkClose {
-		err = errors.New("sdk has been closed")
+		err = ErrConsumerClosed
 	} else {
 		close(k.ch) // 关闭channel，初始化管道数据无需再写入数据了
 		k.wg.Wait() // 等待协程退出
@@ -139,7 +139,7 @@ func (k *K3LogConsumer) init() error {
 		}
 	}()
 
-	K3LogInfo("log consumer init success, log path :", k.directory)
+	K3LogInfo("log consumer init success, log path: %s", k.directory)
 	return nil
 }
 
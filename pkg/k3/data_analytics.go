@@ -44,10 +44,20 @@ func (i *DataAnalytics) CleanSuperProperties() {
 }
 
 func (i *DataAnalytics) Track(accountId, appId, ip, indexName string, properties map[string]interface{}) error {
-	return i.track(accountId, appId, indexName, ip, properties)
+	return i.track(accountId, appId, indexName, ip, time.Now(), properties, nil)
 }
 
-func (i *DataAnalytics) track(accountId, appId, indexName, ip string, properties map[string]interface{}) error {
+// TrackWithTime 使用日志中解析出的时间作为日志时间, 用于补发或者延迟的日志
+func (i *DataAnalytics) TrackWithTime(accountId, appId, ip, indexName string, timestamp time.Time, properties map[string]interface{}) error {
+	return i.track(accountId, appId, indexName, ip, timestamp, properties, nil)
+}
+
+// TrackWithAck 与TrackWithTime相同, sender确认接收这条日志后调用ack, 用于确认之后才提交读取位置
+func (i *DataAnalytics) TrackWithAck(accountId, appId, ip, indexName string, timestamp time.Time, properties map[string]interface{}, ack func()) error {
+	return i.track(accountId, appId, indexName, ip, timestamp, properties, ack)
+}
+
+func (i *DataAnalytics) track(accountId, appId, indexName, ip string, timestamp time.Time, properties map[string]interface{}, ack func()) error {
 	var (
 		msg string
 		p   map[string]interface{}
@@ -80,10 +90,10 @@ func (i *DataAnalytics) track(accountId, appId, indexName, ip string, properties
 
 	p = i.GetSuperProperties()
 	MergeProperties(p, properties)
-	return i.add(accountId, appId, indexName, ip, p)
+	return i.add(accountId, appId, indexName, ip, timestamp, p, ack)
 }
 
-func (i *DataAnalytics) add(accountId, appId, indexName, ip string, properties map[string]interface{}) error {
+func (i *DataAnalytics) add(accountId, appId, indexName, ip string, timestamp time.Time, properties map[string]interface{}, ack func()) error {
 	var (
 		uuid string
 		data protocol.Data
@@ -95,13 +105,36 @@ func (i *DataAnalytics) add(accountId, appId, indexName, ip string, properties m
 		AppId:      appId,
 		IndexName:  indexName,
 		Ip:         ip,
-		Timestamp:  time.Now(),
+		Timestamp:  timestamp,
 		UUID:       uuid,
 		Properties: properties,
+		Ack:        ack,
 	}
 	return i.consumer.Add(data)
 }
 
-func (i *DataAnalytics) Close() {
-	i.consumer.Close()
+// QueueDepth consumer中还没有发送成功的数据条数, consumer不支持时返回0
+func (i *DataAnalytics) QueueDepth() int {
+	if queue, ok := i.consumer.(protocol.K3QueueDepth); ok {
+		return queue.QueueDepth()
+	}
+	return 0
+}
+
+// Flush 同步提交consumer中缓存的数据并返回发送错误, 不关闭consumer, 可以与Track并发调用
+// consumer实现protocol.K3Drain时提交全部缓存, 否则调用consumer的Flush
+func (i *DataAnalytics) Flush() error {
+	return drainConsumer(i.consumer)
+}
+
+// drainConsumer consumer支持Drain时drain, 否则flush
+func drainConsumer(consumer protocol.K3Consumer) error {
+	if drain, ok := consumer.(protocol.K3Drain); ok {
+		return drain.Drain()
+	}
+	return consumer.Flush()
+}
+
+func (i *DataAnalytics) Close() error {
+	return i.consumer.Close()
 }
@@ -2,8 +2,10 @@ package k3
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log-engine-sdk/pkg/k3/protocol"
+	"sync"
 	"testing"
 )
 
@@ -53,3 +55,100 @@ func TestDataAnalytics(t *testing.T) {
 	dataAnalytics.Close()
 
 }
+
+func TestDataAnalyticsFlush(t *testing.T) {
+	var (
+		sender = &captureSender{}
+		wg     sync.WaitGroup
+	)
+
+	consumer, err := NewBatchConsumerWithConfig(K3BatchConsumerConfig{Sender: sender, BatchSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataAnalytics := NewDataAnalytics(consumer)
+	defer dataAnalytics.Close()
+
+	for n := 0; n < 3; n++ {
+		if err = dataAnalytics.Track("account_id", "app_id", "ip", "1001", map[string]interface{}{"n": n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 没有达到BatchSize, Flush之后不需要Close也已经发送
+	if err = dataAnalytics.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if count := sender.count(); count != 3 {
+		t.Fatalf("3 events should be sent by Flush, got %d", count)
+	}
+
+	// 发送失败的数据保留, 下次Flush重新发送
+	sender.lock.Lock()
+	sender.err = errors.New("send failed")
+	sender.lock.Unlock()
+	_ = dataAnalytics.Track("account_id", "app_id", "ip", "1001", map[string]interface{}{"n": 3})
+	if err = dataAnalytics.Flush(); err == nil {
+		t.Fatal("Flush should return the send error")
+	}
+	if depth := dataAnalytics.QueueDepth(); depth != 1 {
+		t.Fatalf("failed batch should be kept, queue depth %d", depth)
+	}
+	sender.lock.Lock()
+	sender.err = nil
+	sender.lock.Unlock()
+
+	// 与Track并发调用
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				_ = dataAnalytics.Track("account_id", "app_id", "ip", "1001", map[string]interface{}{"n": n})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_ = dataAnalytics.Flush()
+		}()
+	}
+	wg.Wait()
+
+	if err = dataAnalytics.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if depth := dataAnalytics.QueueDepth(); depth != 0 {
+		t.Errorf("queue should be empty after Flush, got %d", depth)
+	}
+	// 失败的批次记录了一次, 重新发送后又记录了一次
+	if count := sender.count(); count != 3+1+1+200 {
+		t.Errorf("%d events should be sent, got %d", 3+1+1+200, count)
+	}
+}
+
+func TestDataAnalyticsFlushAsync(t *testing.T) {
+	var sender = &captureSender{}
+
+	batch, err := NewBatchConsumerWithConfig(K3BatchConsumerConfig{Sender: sender, BatchSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := NewAsyncConsumer(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataAnalytics := NewDataAnalytics(consumer)
+	defer dataAnalytics.Close()
+
+	for n := 0; n < 3; n++ {
+		_ = dataAnalytics.Track("account_id", "app_id", "ip", "1001", map[string]interface{}{"n": n})
+	}
+
+	// 队列中的数据先交给批量consumer再提交
+	if err = dataAnalytics.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if count := sender.count(); count != 3 {
+		t.Errorf("3 events should be sent by Flush, got %d", count)
+	}
+}
@@ -32,7 +32,7 @@ func HttpServer(ctx context.Context) (func(), error) {
 	go func() {
 
 		if err := server.ListenAndServe(); err != http.ErrServerClosed {
-			K3LogError("http server error: %s", err.Error)
+			K3LogError("http server error: %s", err.Error())
 			panic(err)
 		}
 	}()
@@ -43,7 +43,7 @@ func HttpServer(ctx context.Context) (func(), error) {
 		defer cancel()
 		server.SetKeepAlivesEnabled(false)
 		if err := server.Shutdown(timeoutCTX); err != nil {
-			K3LogError("http server shutdown error: %s", err.Error)
+			K3LogError("http server shutdown error: %s", err.Error())
 		}
 	}, nil
 }
@@ -67,6 +67,8 @@ func FindStatusRouter(w http.ResponseWriter, r *http.Request) {
 	status.WriteToChannelFailedCount = GlobalWriteToChannelFailedCount
 	status.WriteSuccessCount = GlobalWriteSuccessCount
 	status.WriteFailedCount = GlobalWriteFailedCount
+	status.FdCacheSize = int(MetricFdCacheSize.Value())
+	status.FdCacheEvictedCount = int(MetricFdCacheEvictedTotal.Value())
 
 	if b, err = json.Marshal(status); err != nil {
 		_, _ = w.Write([]byte(err.Error()))
@@ -90,4 +92,6 @@ type Status struct {
 	WriteFailedCount          int    `json:"write_failed_count"`            // 写入ELK失败条数
 	WriteSuccessCount         int    `json:"write_success_count"`           // 写入ELK成功条数
 	WriteToChannelFailedCount int    `json:"write_to_channel_failed_count"` // 写入缓存失败条数
+	FdCacheSize               int    `json:"fd_cache_size"`                 // 当前缓存的文件句柄数量
+	FdCacheEvictedCount       int    `json:"fd_cache_evicted_count"`        // 累计淘汰的文件句柄数量
 }
@@ -0,0 +1,58 @@
+package k3
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileIdentity(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		path    = filepath.Join(dir, "app.log")
+		rotated = filepath.Join(dir, "app.log.1")
+	)
+
+	if err := os.WriteFile(path, []byte("line 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dev, inode, err := FileIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 写入后标识不变, 已打开的句柄与路径的标识相同
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fd.WriteString("line 2\n"); err != nil {
+		t.Fatal(err)
+	}
+	if d, i, _ := FileIdentity(path); d != dev || i != inode {
+		t.Errorf("identity should not change after write, expected %d/%d, got %d/%d", dev, inode, d, i)
+	}
+	if d, i, err := FdIdentity(fd); err != nil || d != dev || i != inode {
+		t.Errorf("opened fd should have the same identity, expected %d/%d, got %d/%d, %v", dev, inode, d, i, err)
+	}
+	// windows上打开的文件不能改名
+	_ = fd.Close()
+
+	// 改名后与原文件的标识相同, 同名的新文件标识不同
+	if err = os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if d, i, _ := FileIdentity(rotated); d != dev || i != inode {
+		t.Errorf("renamed file should keep the identity, expected %d/%d, got %d/%d", dev, inode, d, i)
+	}
+	if d, i, _ := FileIdentity(path); d == dev && i == inode {
+		t.Errorf("new file should have a different identity than the rotated one, got %d/%d", d, i)
+	}
+
+	if _, _, err = FileIdentity(filepath.Join(dir, "missing.log")); !os.IsNotExist(err) {
+		t.Errorf("missing file should return not exist error, got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+//go:build !windows
+
+package k3
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// FileIdentity 获取path对应文件所在的设备和inode, 软链接返回指向的文件
+func FileIdentity(path string) (uint64, uint64, error) {
+	var (
+		fileInfo os.FileInfo
+		err      error
+	)
+
+	if fileInfo, err = os.Stat(path); err != nil {
+		return 0, 0, err
+	}
+
+	return fileInfoIdentity(fileInfo)
+}
+
+// FdIdentity 获取已打开的句柄对应文件所在的设备和inode, 文件被改名或删除后依然可以获取
+func FdIdentity(fd *os.File) (uint64, uint64, error) {
+	var (
+		fileInfo os.FileInfo
+		err      error
+	)
+
+	if fileInfo, err = fd.Stat(); err != nil {
+		return 0, 0, err
+	}
+
+	return fileInfoIdentity(fileInfo)
+}
+
+func fileInfoIdentity(fileInfo os.FileInfo) (uint64, uint64, error) {
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, errors.New("[fileInfoIdentity] unsupported file info of " + fileInfo.Name())
+	}
+
+	return uint64(stat.Dev), uint64(stat.Ino), nil
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package k3
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFileIdentityInode(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "app.log")
+
+	if err := os.WriteFile(path, []byte("line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+
+	// 与stat返回的设备和inode一致, 升级前状态文件中记录的inode依然有效
+	if dev, inode, err := FileIdentity(path); err != nil || dev != uint64(stat.Dev) || inode != stat.Ino {
+		t.Errorf("expected dev %d inode %d, got %d %d, %v", stat.Dev, stat.Ino, dev, inode, err)
+	}
+}
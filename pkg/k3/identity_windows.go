@@ -0,0 +1,52 @@
+//go:build windows
+
+package k3
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// FileIdentity 获取path对应文件所在卷的序列号和file index(相当于inode), 需要打开文件获取
+func FileIdentity(path string) (uint64, uint64, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fd.Close()
+
+	return FdIdentity(fd)
+}
+
+// FdIdentity 获取已打开的句柄对应文件所在卷的序列号和file index, 文件被改名后依然可以获取
+// 文件系统不支持file index(如部分网络盘返回0)时, 使用fileInfoIdentity
+func FdIdentity(fd *os.File) (uint64, uint64, error) {
+	var (
+		info     syscall.ByHandleFileInformation
+		fileInfo os.FileInfo
+		err      error
+	)
+
+	if err = syscall.GetFileInformationByHandle(syscall.Handle(fd.Fd()), &info); err == nil && (info.FileIndexHigh != 0 || info.FileIndexLow != 0) {
+		return uint64(info.VolumeSerialNumber), uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow), nil
+	}
+
+	if fileInfo, err = fd.Stat(); err != nil {
+		return 0, 0, err
+	}
+
+	return fileInfoIdentity(fileInfo)
+}
+
+// fileInfoIdentity 没有file index时, 以文件的创建时间作为标识, 设备为0
+// 大小和修改时间每次写入都会变化, 不能作为标识, 清空后重新写入由offset和文件大小判断
+// 删除后15秒内创建的同名文件可能沿用旧文件的创建时间(file system tunneling), 此时无法发现轮转
+func fileInfoIdentity(fileInfo os.FileInfo) (uint64, uint64, error) {
+	data, ok := fileInfo.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return 0, 0, errors.New("[fileInfoIdentity] unsupported file info of " + fileInfo.Name())
+	}
+
+	return 0, uint64(data.CreationTime.Nanoseconds()), nil
+}
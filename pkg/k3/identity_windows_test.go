@@ -0,0 +1,61 @@
+//go:build windows
+
+package k3
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFileIdentitySameFile(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		path  = filepath.Join(dir, "app.log")
+		other = filepath.Join(dir, "other.log")
+	)
+
+	for _, file := range []string{path, other} {
+		if err := os.WriteFile(file, []byte("line\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 与os.SameFile的判断一致
+	pathInfo, _ := os.Stat(path)
+	otherInfo, _ := os.Stat(other)
+	pathDev, pathIndex, _ := FileIdentity(path)
+	otherDev, otherIndex, _ := FileIdentity(other)
+	if same := pathDev == otherDev && pathIndex == otherIndex; same != os.SameFile(pathInfo, otherInfo) {
+		t.Errorf("identity should agree with os.SameFile, got same %v", same)
+	}
+}
+
+func TestFileInfoIdentity(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "app.log")
+
+	if err := os.WriteFile(path, []byte("line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有file index时使用创建时间, 写入后不变
+	dev, inode, err := fileInfoIdentity(info)
+	if err != nil || dev != 0 || inode != uint64(info.Sys().(*syscall.Win32FileAttributeData).CreationTime.Nanoseconds()) {
+		t.Errorf("creation time expected, got %d %d, %v", dev, inode, err)
+	}
+
+	if err = os.WriteFile(path, []byte("line\nline\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, written, _ := fileInfoIdentity(info); written != inode {
+		t.Errorf("identity should not change after write, expected %d, got %d", inode, written)
+	}
+}
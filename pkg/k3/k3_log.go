@@ -1,7 +1,11 @@
 package k3
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"sync"
 	"time"
 )
 
@@ -15,6 +19,12 @@ const (
 	K3LogLevelDEBUG
 )
 
+// 日志输出格式
+const (
+	K3LogFormatText = "text" // [时间][K3SDK] [Level] 日志内容
+	K3LogFormatJSON = "json" // 每行一个json: {"ts", "level", "msg"}
+)
+
 // K3Logger is a logger interface
 type K3Logger interface {
 	Print(message string)
@@ -30,46 +40,106 @@ var (
 	LogInstance K3Logger
 )
 
+var (
+	logLock                = &sync.RWMutex{}
+	logWriteLock           = &sync.Mutex{}   // 同一时间只有一个协程写入logOutput, 避免多行日志交错
+	logOutput    io.Writer = os.Stdout       // 没有设置LogInstance时日志的输出
+	logFormat              = K3LogFormatText // 日志输出格式
+)
+
+// jsonLog json格式的一条日志
+type jsonLog struct {
+	Ts    string `json:"ts"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+}
+
 func InitLogger(logger K3Logger, level K3LogLevel) {
+	logLock.Lock()
+	defer logLock.Unlock()
+
 	if logger != nil {
 		LogInstance = logger
 	}
 	CurrentLogLevel = level
 }
 
+// SetLogLevel 设置日志等级, 低于该等级的日志不输出, 如INFO时不输出DEBUG日志
+func SetLogLevel(level K3LogLevel) {
+	logLock.Lock()
+	CurrentLogLevel = level
+	logLock.Unlock()
+}
+
+// SetLogOutput 设置日志输出, 默认os.Stdout, 设置了LogInstance时输出到LogInstance
+func SetLogOutput(w io.Writer) {
+	logLock.Lock()
+	logOutput = w
+	logLock.Unlock()
+}
+
+// SetLogFormat 设置日志输出格式, text(默认)或json, 其他值使用text
+func SetLogFormat(format string) {
+	logLock.Lock()
+	defer logLock.Unlock()
+
+	if format == K3LogFormatJSON {
+		logFormat = K3LogFormatJSON
+	} else {
+		logFormat = K3LogFormatText
+	}
+}
+
 // K3Log print log
 func K3Log(level K3LogLevel, format string, v ...interface{}) {
+	logLock.RLock()
+	defer logLock.RUnlock()
 
 	if level > CurrentLogLevel {
 		return
 	}
 
-	var baseMessage string
+	var baseMessage, levelName string
 
 	switch level {
 	case K3LogLevelERROR:
-		baseMessage = "[Error] "
-		break
+		baseMessage, levelName = "[Error] ", "error"
 	case K3LogLevelWARN:
-		baseMessage = "[Warn] "
-		break
+		baseMessage, levelName = "[Warn] ", "warn"
 	case K3LogLevelINFO:
-		baseMessage = "[Info] "
-		break
+		baseMessage, levelName = "[Info] ", "info"
 	case K3LogLevelDEBUG:
-		baseMessage = "[Debug] "
-		break
+		baseMessage, levelName = "[Debug] ", "debug"
 	default:
-		baseMessage = "[Info] "
-		break
+		baseMessage, levelName = "[Info] ", "info"
+	}
+
+	if logFormat == K3LogFormatJSON {
+		line, _ := json.Marshal(jsonLog{
+			Ts:    time.Now().Format(time.RFC3339Nano),
+			Level: levelName,
+			Msg:   fmt.Sprintf(format, v...),
+		})
+		writeLog(string(line) + "\n")
+		return
 	}
 
 	if LogInstance != nil {
-		msg := fmt.Sprintf(SDK_LOG_PREFIX+baseMessage+format+"\n", v...)
-		LogInstance.Print(msg)
+		writeLog(fmt.Sprintf(SDK_LOG_PREFIX+baseMessage+format+"\n", v...))
 	} else {
 		logTime := fmt.Sprintf("[%v]", time.Now().Format("2006-01-02 15:04:05"))
-		fmt.Printf(logTime+SDK_LOG_PREFIX+baseMessage+format+"\n", v...)
+		writeLog(fmt.Sprintf(logTime+SDK_LOG_PREFIX+baseMessage+format+"\n", v...))
+	}
+}
+
+// writeLog 输出一行日志, 调用时需要持有logLock的读锁
+func writeLog(msg string) {
+	if LogInstance != nil {
+		LogInstance.Print(msg)
+	} else {
+		logWriteLock.Lock()
+		_, _ = io.WriteString(logOutput, msg)
+		logWriteLock.Unlock()
 	}
 }
 
@@ -0,0 +1,246 @@
+package k3
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultLogMaxSizeMB  = 100 // 默认单个日志文件最大100MB
+	DefaultLogMaxBackups = 7   // 默认最多保留7个备份
+)
+
+// K3RotateFile SDK自身日志的输出文件, 超过maxSize后轮转: <path>重命名为<path>.1, 之前的备份依次后移为<path>.2, <path>.3...
+// 超过maxBackups的备份删除, compress为true时备份压缩为<path>.N.gz. 多个协程同时写入时通过lock保证轮转安全
+type K3RotateFile struct {
+	lock           sync.Mutex
+	path           string
+	maxSize        int64 // 单位字节
+	maxBackups     int
+	compress       bool
+	rotateInterval time.Duration // 文件打开超过该时间后轮转, 0不按时间轮转
+	fd             *os.File
+	size           int64     // 当前文件大小
+	openedAt       time.Time // 当前文件的打开时间
+}
+
+type K3RotateFileConfig struct {
+	Path           string        // 日志文件路径, 目录不存在时创建
+	MaxSizeMB      int           // 单位MB, 默认DefaultLogMaxSizeMB, 超过后轮转
+	MaxBackups     int           // 默认DefaultLogMaxBackups, 最多保留的备份数量
+	Compress       bool          // 备份是否gzip压缩
+	RotateInterval time.Duration // 0不按时间轮转, 文件打开超过该时间后, 下一次写入前轮转
+}
+
+// NewRotateFile 打开日志文件, 已经存在时追加写入, 可以通过SetLogOutput设置为K3Log的输出
+func NewRotateFile(config K3RotateFileConfig) (*K3RotateFile, error) {
+	var (
+		maxSizeMB  = config.MaxSizeMB
+		maxBackups = config.MaxBackups
+		file       *K3RotateFile
+	)
+
+	if len(config.Path) == 0 {
+		return nil, errors.New("[NewRotateFile] path is empty")
+	}
+
+	if maxSizeMB <= 0 {
+		maxSizeMB = DefaultLogMaxSizeMB
+	}
+
+	if maxBackups <= 0 {
+		maxBackups = DefaultLogMaxBackups
+	}
+
+	if err := os.MkdirAll(filepath.Dir(config.Path), os.ModePerm); err != nil {
+		return nil, errors.New("[NewRotateFile] create log dir failed: " + err.Error())
+	}
+
+	file = &K3RotateFile{
+		path:           config.Path,
+		maxSize:        int64(maxSizeMB) * 1024 * 1024,
+		maxBackups:     maxBackups,
+		compress:       config.Compress,
+		rotateInterval: config.RotateInterval,
+	}
+
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// open 追加打开日志文件, 调用时需要持有lock
+func (r *K3RotateFile) open() error {
+	var (
+		fd   *os.File
+		stat os.FileInfo
+		err  error
+	)
+
+	if fd, err = os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return errors.New("[K3RotateFile] open log file failed: " + err.Error())
+	}
+
+	if stat, err = fd.Stat(); err != nil {
+		_ = fd.Close()
+		return errors.New("[K3RotateFile] stat log file failed: " + err.Error())
+	}
+
+	r.fd = fd
+	r.size = stat.Size()
+	r.openedAt = time.Now()
+	return nil
+}
+
+// Write 写入日志, 写入后超过maxSize或者文件打开超过rotateInterval时先轮转再写入, 单条日志超过maxSize时直接写入新文件
+func (r *K3RotateFile) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.fd == nil {
+		return 0, errors.New("[K3RotateFile] log file has been closed")
+	}
+
+	// 轮转失败时继续写入当前文件, 避免丢失日志
+	if r.size > 0 && (r.size+int64(len(p)) > r.maxSize || r.expired()) {
+		if err := r.rotate(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		}
+	}
+
+	n, err := r.fd.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// expired 文件打开的时间超过rotateInterval, 调用时需要持有lock
+func (r *K3RotateFile) expired() bool {
+	return r.rotateInterval > 0 && time.Since(r.openedAt) >= r.rotateInterval
+}
+
+// rotate 轮转日志文件, 调用时需要持有lock
+func (r *K3RotateFile) rotate() (err error) {
+	if err = r.fd.Close(); err != nil {
+		return errors.New("[K3RotateFile] close log file failed: " + err.Error())
+	}
+	r.fd = nil
+
+	// 重新打开日志文件, 轮转失败时追加写入原来的文件
+	defer func() {
+		if openErr := r.open(); openErr != nil {
+			err = errors.Join(err, openErr)
+		}
+	}()
+
+	// 删除最早的备份, 其余备份依次后移
+	for _, suffix := range []string{"", ".gz"} {
+		if err = removeIfExists(r.backupPath(r.maxBackups) + suffix); err != nil {
+			return err
+		}
+	}
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		for _, suffix := range []string{"", ".gz"} {
+			if err = renameIfExists(r.backupPath(i)+suffix, r.backupPath(i+1)+suffix); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = os.Rename(r.path, r.backupPath(1)); err != nil {
+		return errors.New("[K3RotateFile] rename log file failed: " + err.Error())
+	}
+
+	if r.compress {
+		if err = compressFile(r.backupPath(1)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backupPath 第i个备份的路径
+func (r *K3RotateFile) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
+
+// Sync 将写入的数据同步到硬盘
+func (r *K3RotateFile) Sync() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.fd == nil {
+		return errors.New("[K3RotateFile] log file has been closed")
+	}
+	return r.fd.Sync()
+}
+
+// Close 关闭日志文件, 之后的写入返回错误
+func (r *K3RotateFile) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.fd == nil {
+		return nil
+	}
+
+	err := r.fd.Close()
+	r.fd = nil
+	return err
+}
+
+// compressFile 将path压缩为path.gz, 成功后删除path
+func compressFile(path string) error {
+	var (
+		src *os.File
+		dst *os.File
+		gz  *gzip.Writer
+		err error
+	)
+
+	if src, err = os.Open(path); err != nil {
+		return errors.New("[compressFile] open file failed: " + err.Error())
+	}
+	defer src.Close()
+
+	if dst, err = os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
+		return errors.New("[compressFile] create gzip file failed: " + err.Error())
+	}
+
+	gz = gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(path + ".gz")
+		return errors.New("[compressFile] compress file failed: " + err.Error())
+	}
+
+	return os.Remove(path)
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.New("[K3RotateFile] remove backup failed: " + err.Error())
+	}
+	return nil
+}
+
+func renameIfExists(from, to string) error {
+	if err := os.Rename(from, to); err != nil && !os.IsNotExist(err) {
+		return errors.New("[K3RotateFile] rename backup failed: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package k3
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestRotateFile maxSize为100字节的日志文件
+func newTestRotateFile(t *testing.T, maxBackups int, compress bool) *K3RotateFile {
+	file, err := NewRotateFile(K3RotateFileConfig{
+		Path:       filepath.Join(t.TempDir(), "k3sdk.log"),
+		MaxBackups: maxBackups,
+		Compress:   compress,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.maxSize = 100
+	t.Cleanup(func() { _ = file.Close() })
+
+	return file
+}
+
+func TestRotateFile(t *testing.T) {
+	var (
+		file = newTestRotateFile(t, 2, false)
+		line = strings.Repeat("a", 39) + "\n"
+	)
+
+	// 每行40字节, 第3行写入时超过100字节
+	for i := 0; i < 3; i++ {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if content, _ := os.ReadFile(file.path + ".1"); string(content) != line+line {
+		t.Errorf("backup should contain the first 2 lines, got %q", content)
+	}
+	if content, _ := os.ReadFile(file.path); string(content) != line {
+		t.Errorf("active file should be truncated, got %q", content)
+	}
+
+	// 超过max_backups的备份被删除
+	for i := 0; i < 6; i++ {
+		_, _ = file.Write([]byte(line))
+	}
+	if _, err := os.Stat(file.path + ".2"); err != nil {
+		t.Errorf("backup 2 should exist: %s", err)
+	}
+	if _, err := os.Stat(file.path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("backup 3 should be removed, got %v", err)
+	}
+}
+
+func TestRotateFileCompress(t *testing.T) {
+	var file = newTestRotateFile(t, 3, true)
+
+	_, _ = file.Write([]byte(strings.Repeat("b", 98) + "\n"))
+	_, _ = file.Write([]byte("next\n"))
+
+	if _, err := os.Stat(file.path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("uncompressed backup should be removed, got %v", err)
+	}
+
+	fd, err := os.Open(file.path + ".1.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	reader, err := gzip.NewReader(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := io.ReadAll(reader); string(content) != strings.Repeat("b", 98)+"\n" {
+		t.Errorf("compressed backup content mismatch, got %q", content)
+	}
+}
+
+func TestRotateFileConcurrentLog(t *testing.T) {
+	var (
+		file = newTestRotateFile(t, 100, false)
+		wg   sync.WaitGroup
+	)
+
+	setTestLogger(t, K3LogLevelINFO, K3LogFormatText)
+	SetLogOutput(file)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				K3LogInfo("goroutine %d line %d", i, j)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 轮转过程中没有丢失日志
+	var lines int
+	for i := 0; i <= 100; i++ {
+		path := file.path
+		if i > 0 {
+			path = fmt.Sprintf("%s.%d", file.path, i)
+		}
+		if content, err := os.ReadFile(path); err == nil {
+			lines += strings.Count(string(content), "\n")
+		}
+	}
+	if lines != 100 {
+		t.Errorf("all 100 log lines should be written, got %d", lines)
+	}
+}
+
+func TestRotateFileInterval(t *testing.T) {
+	file, err := NewRotateFile(K3RotateFileConfig{
+		Path:           filepath.Join(t.TempDir(), "k3sdk.log"),
+		RotateInterval: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = file.Close() })
+
+	_, _ = file.Write([]byte("line 1\n"))
+	_, _ = file.Write([]byte("line 2\n"))
+
+	// 打开超过rotate_interval后, 下一次写入前轮转
+	time.Sleep(60 * time.Millisecond)
+	_, _ = file.Write([]byte("line 3\n"))
+
+	if content, _ := os.ReadFile(file.path + ".1"); string(content) != "line 1\nline 2\n" {
+		t.Errorf("backup should contain the lines written before the interval, got %q", content)
+	}
+	if content, _ := os.ReadFile(file.path); string(content) != "line 3\n" {
+		t.Errorf("active file should contain the lines written after rotation, got %q", content)
+	}
+}
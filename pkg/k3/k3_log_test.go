@@ -0,0 +1,81 @@
+package k3
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setTestLogger 日志输出到buffer, 测试结束后恢复
+func setTestLogger(t *testing.T, level K3LogLevel, format string) *bytes.Buffer {
+	var (
+		buffer = &bytes.Buffer{}
+		prev   = CurrentLogLevel
+	)
+
+	SetLogLevel(level)
+	SetLogOutput(buffer)
+	SetLogFormat(format)
+	t.Cleanup(func() {
+		SetLogLevel(prev)
+		SetLogOutput(os.Stdout)
+		SetLogFormat(K3LogFormatText)
+	})
+
+	return buffer
+}
+
+func TestK3LogLevel(t *testing.T) {
+	var buffer = setTestLogger(t, K3LogLevelINFO, K3LogFormatText)
+
+	K3LogDebug("debug %d", 1)
+	K3LogInfo("info %d", 2)
+	K3LogError("error %d", 3)
+
+	output := buffer.String()
+	if strings.Contains(output, "debug 1") {
+		t.Errorf("debug log should be suppressed at info level, got %q", output)
+	}
+	for _, expected := range []string{SDK_LOG_PREFIX + "[Info] info 2\n", SDK_LOG_PREFIX + "[Error] error 3\n"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("log should contain %q, got %q", expected, output)
+		}
+	}
+
+	// OFF时不输出任何日志
+	buffer.Reset()
+	SetLogLevel(K3LogLevelOFF)
+	K3LogError("error %d", 4)
+	if buffer.Len() != 0 {
+		t.Errorf("no log should be written when level is off, got %q", buffer.String())
+	}
+}
+
+func TestK3LogJSON(t *testing.T) {
+	var (
+		buffer = setTestLogger(t, K3LogLevelDEBUG, K3LogFormatJSON)
+		lines  []string
+	)
+
+	K3LogWarn("disk %s is %d%% full", "/data", 90)
+	K3LogDebug("debug")
+
+	if lines = strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n"); len(lines) != 2 {
+		t.Fatalf("each log should be one line, got %q", buffer.String())
+	}
+
+	var fields map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fields) != 3 || fields["level"] != "warn" || fields["msg"] != "disk /data is 90% full" {
+		t.Errorf("json log should only contain ts, level and msg, got %v", fields)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, fields["ts"]); err != nil {
+		t.Errorf("ts should be RFC3339, got %q", fields["ts"])
+	}
+}
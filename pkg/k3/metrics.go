@@ -0,0 +1,145 @@
+package k3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 指标类型, 对应prometheus文本格式中的TYPE
+const (
+	MetricCounter = "counter"
+	MetricGauge   = "gauge"
+)
+
+// Metric 一个prometheus指标, counter只增加, gauge可以通过Set设置或者通过SetFunc在抓取时获取
+type Metric struct {
+	name  string
+	help  string
+	typ   string
+	value atomic.Int64
+	lock  sync.RWMutex
+	fn    func() int64
+}
+
+var (
+	MetricFilesWatched     = NewMetric("k3_files_watched", "Number of files tracked in the state file.", MetricGauge)
+	MetricLinesReadTotal   = NewMetric("k3_lines_read_total", "Total number of lines read from watched files.", MetricCounter)
+	MetricBytesReadTotal   = NewMetric("k3_bytes_read_total", "Total number of bytes read from watched files.", MetricCounter)
+	MetricBatchesSentTotal = NewMetric("k3_batches_sent_total", "Total number of batches confirmed by the sender.", MetricCounter)
+	MetricSendErrorsTotal  = NewMetric("k3_send_errors_total", "Total number of batches the sender failed to deliver.", MetricCounter)
+	MetricReaderGoroutines = NewMetric("k3_reader_goroutines", "Number of goroutines currently reading files.", MetricGauge)
+	MetricPendingEvents    = NewMetric("k3_pending_events", "Number of events buffered in the batch consumer.", MetricGauge)
+
+	MetricFdCacheSize         = NewMetric("k3_fd_cache_size", "Number of file descriptors currently cached.", MetricGauge)
+	MetricFdCacheEvictedTotal = NewMetric("k3_fd_cache_evicted_total", "Total number of cached file descriptors evicted because the cache was full.", MetricCounter)
+)
+
+var (
+	metricsLock   = &sync.RWMutex{}
+	GlobalMetrics []*Metric // 所有导出的指标, 按照创建顺序输出
+)
+
+// NewMetric 创建指标并注册到GlobalMetrics
+func NewMetric(name, help, typ string) *Metric {
+	var metric = &Metric{name: name, help: help, typ: typ}
+
+	metricsLock.Lock()
+	GlobalMetrics = append(GlobalMetrics, metric)
+	metricsLock.Unlock()
+
+	return metric
+}
+
+// Add 增加指标的值
+func (m *Metric) Add(delta int64) {
+	m.value.Add(delta)
+}
+
+// Set 设置gauge的值
+func (m *Metric) Set(value int64) {
+	m.value.Store(value)
+}
+
+// SetFunc 抓取时通过fn获取gauge的值, 适用于其他包中已经维护的状态(如当前读取协程数量)
+func (m *Metric) SetFunc(fn func() int64) {
+	m.lock.Lock()
+	m.fn = fn
+	m.lock.Unlock()
+}
+
+// Value 获取指标当前的值
+func (m *Metric) Value() int64 {
+	m.lock.RLock()
+	fn := m.fn
+	m.lock.RUnlock()
+
+	if fn != nil {
+		return fn()
+	}
+	return m.value.Load()
+}
+
+// WriteMetrics 按照prometheus文本格式输出所有指标
+func WriteMetrics(w *strings.Builder) {
+	metricsLock.RLock()
+	defer metricsLock.RUnlock()
+
+	for _, metric := range GlobalMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", metric.name, metric.typ)
+		fmt.Fprintf(w, "%s %d\n", metric.name, metric.Value())
+	}
+}
+
+// MetricsRouter prometheus抓取指标的接口
+func MetricsRouter(w http.ResponseWriter, r *http.Request) {
+	var builder strings.Builder
+
+	WriteMetrics(&builder)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(builder.String()))
+}
+
+// MetricsServer 在listen地址上开启/metrics接口, 返回关闭函数
+// 先同步监听端口, 端口被占用等错误直接返回, 不影响主程序
+func MetricsServer(ctx context.Context, listen string) (func(), error) {
+	var (
+		listener net.Listener
+		mux      = http.NewServeMux()
+		err      error
+	)
+
+	if listener, err = net.Listen("tcp", listen); err != nil {
+		return nil, errors.New("[MetricsServer] listen failed: " + err.Error())
+	}
+
+	mux.HandleFunc("/metrics", MetricsRouter)
+	server := &http.Server{
+		Handler:     mux,
+		ReadTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			K3LogError("[MetricsServer] metrics server error: %s", err.Error())
+		}
+	}()
+
+	K3LogInfo("[MetricsServer] metrics server listen on %s", listener.Addr().String())
+
+	return func() {
+		timeoutCTX, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(timeoutCTX); err != nil {
+			K3LogError("[MetricsServer] metrics server shutdown error: %s", err.Error())
+		}
+	}, nil
+}
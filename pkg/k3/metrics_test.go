@@ -0,0 +1,51 @@
+package k3
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetricsServer(t *testing.T) {
+	var metric = NewMetric("k3_test_metric_total", "Test metric.", MetricCounter)
+
+	// 获取一个空闲端口
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	clean, err := MetricsServer(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+
+	metric.Add(3)
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	for _, expected := range []string{
+		"# TYPE k3_test_metric_total counter\nk3_test_metric_total 3\n",
+		"# TYPE k3_files_watched gauge\n",
+		"k3_send_errors_total ",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("metrics should contain %q, got:\n%s", expected, body)
+		}
+	}
+
+	// 端口被占用时返回错误
+	if _, err = MetricsServer(context.Background(), addr); err == nil {
+		t.Errorf("listen on used address should fail")
+	}
+}
@@ -22,6 +22,7 @@ type ElasticSearchData struct {
 	EventName  string     `json:"event_name"`  // 日志事件名称(每种日志唯一)
 	Timestamp  time.Time  `json:"@timestamp"`  // 日志产生时间 "2024-10-01 12:00:00 " √
 	Path       string     `json:"@path"`       // 日志内容来源的文件地址
+	Offset     int64      `json:"@offset"`     // 日志在文件中的开始位置, 同一个@path中按照读取顺序递增, 时间相同时用于排序
 	ExtendData ExtendData `json:"extend_data"` // 扩展字段
 }
 
@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
@@ -14,6 +15,7 @@ type Data struct {
 	Timestamp  time.Time              `json:"Timestamp"`            // 日志时间
 	IndexName  string                 `json:"index_name,omitempty"` // 所读文件的索引标识
 	Properties map[string]interface{} `json:"properties"`           // 日志具体内容
+	Ack        func()                 `json:"-"`                    // sender确认接收后调用, 不落盘(wal重放的数据为nil)
 }
 
 func (d *Data) String() string {
@@ -26,7 +28,34 @@ type K3Consumer interface {
 	Close() error
 }
 
+// K3QueueDepth consumer中还没有发送成功的数据条数, 读取文件时用于背压
+type K3QueueDepth interface {
+	QueueDepth() int
+}
+
+// K3Drain 同步提交consumer中缓存的全部数据, 返回发送错误, 提交之后consumer可以继续使用
+type K3Drain interface {
+	Drain() error
+}
+
 type Sender interface {
 	Send(data []Data) error
 	Close() error
 }
+
+// ContextSender 支持取消的Sender, ctx取消时正在进行的发送立即返回错误, 如退出时elk请求没有响应
+type ContextSender interface {
+	SendContext(ctx context.Context, data []Data) error
+}
+
+// SendContext sender实现ContextSender时使用ctx发送, 否则ctx已经取消时直接返回, 没有取消时调用Send
+func SendContext(ctx context.Context, sender Sender, data []Data) error {
+	if s, ok := sender.(ContextSender); ok {
+		return s.SendContext(ctx, data)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return sender.Send(data)
+}
@@ -0,0 +1,62 @@
+package sender
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"net/http"
+	"os"
+)
+
+// newElasticsearchHeader api_key认证的请求头, api_key优先于用户名密码
+func newElasticsearchHeader(elasticsearchConfig config.ELK) http.Header {
+	var header = http.Header{}
+
+	if len(elasticsearchConfig.APIKey) == 0 {
+		return header
+	}
+
+	if len(elasticsearchConfig.Username) > 0 || len(elasticsearchConfig.Password) > 0 {
+		k3.K3LogWarn("[newElasticsearchHeader] both api_key and username/password are configured, use api_key.")
+	}
+
+	header.Set("Authorization", "ApiKey "+elasticsearchConfig.APIKey)
+
+	return header
+}
+
+// newElasticsearchTransport 配置了ca_cert_path或者insecure_skip_verify时使用自定义的tls配置, 否则返回nil使用默认的transport
+func newElasticsearchTransport(elasticsearchConfig config.ELK) (*http.Transport, error) {
+	var (
+		transport *http.Transport
+		tlsConfig = &tls.Config{InsecureSkipVerify: elasticsearchConfig.InsecureSkipVerify}
+		ca        []byte
+		err       error
+	)
+
+	if len(elasticsearchConfig.CACertPath) == 0 && !elasticsearchConfig.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	if len(elasticsearchConfig.CACertPath) > 0 {
+		if ca, err = os.ReadFile(elasticsearchConfig.CACertPath); err != nil {
+			return nil, errors.New("[newElasticsearchTransport] read ca cert failed: " + err.Error())
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("[newElasticsearchTransport] invalid ca cert " + elasticsearchConfig.CACertPath)
+		}
+	}
+
+	if elasticsearchConfig.InsecureSkipVerify {
+		k3.K3LogWarn("[newElasticsearchTransport] insecure_skip_verify is enabled, elasticsearch certificate is not verified.")
+	}
+
+	transport = http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
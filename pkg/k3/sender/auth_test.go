@@ -0,0 +1,101 @@
+package sender
+
+import (
+	"encoding/pem"
+	"log-engine-sdk/pkg/k3/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newBulkHandler 记录请求的Authorization, 所有文档写入成功
+func newBulkHandler(authorization *string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*authorization = r.Header.Get("Authorization")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}
+}
+
+func TestElasticSearchAPIKey(t *testing.T) {
+	var (
+		authorization string
+		server        = httptest.NewServer(newBulkHandler(&authorization))
+	)
+	defer server.Close()
+
+	// 同时配置了用户名密码, 使用api_key
+	client, err := NewElasticsearchWithConfig(config.ELK{
+		Address:  []string{server.URL},
+		Username: "log_user",
+		Password: "secret",
+		APIKey:   "a2V5OnNlY3JldA==",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = client.Send(bulkTestData("1")); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "ApiKey a2V5OnNlY3JldA==" {
+		t.Errorf("api key header expected, got %q", authorization)
+	}
+
+	// 没有api_key时使用用户名密码
+	if client, err = NewElasticsearchWithConfig(config.ELK{Address: []string{server.URL}, Username: "log_user", Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Send(bulkTestData("1")); err != nil {
+		t.Fatal(err)
+	}
+	if user, password, ok := (&http.Request{Header: http.Header{"Authorization": {authorization}}}).BasicAuth(); !ok || user != "log_user" || password != "secret" {
+		t.Errorf("basic auth expected, got %q", authorization)
+	}
+}
+
+func TestElasticSearchCustomCA(t *testing.T) {
+	var (
+		authorization string
+		server        = httptest.NewTLSServer(newBulkHandler(&authorization))
+		caPath        = filepath.Join(t.TempDir(), "ca.pem")
+	)
+	defer server.Close()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caPath, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	transport, err := newElasticsearchTransport(config.ELK{CACertPath: caPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport == nil || transport.TLSClientConfig == nil || transport.TLSClientConfig.RootCAs == nil {
+		t.Fatalf("custom ca pool should be wired into the transport")
+	}
+
+	// 使用自定义ca可以校验证书
+	client, err := NewElasticsearchWithConfig(config.ELK{Address: []string{server.URL}, CACertPath: caPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Send(bulkTestData("1")); err != nil {
+		t.Errorf("send with custom ca failed: %s", err)
+	}
+
+	// 没有配置ca时证书校验失败
+	if client, err = NewElasticsearchWithConfig(config.ELK{Address: []string{server.URL}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Send(bulkTestData("1")); err == nil {
+		t.Errorf("send without custom ca should fail certificate verification")
+	}
+
+	if _, err = newElasticsearchTransport(config.ELK{CACertPath: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Errorf("missing ca file should return error")
+	}
+}
@@ -0,0 +1,133 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"log-engine-sdk/pkg/k3"
+	"sync"
+	"time"
+)
+
+var (
+	DefaultBreakerThreshold  = 5  // 连续连接失败多少次后断开
+	DefaultBreakerBackoff    = 1  // 秒, 第一次断开的时间, 之后每次探测失败翻倍
+	DefaultBreakerMaxBackoff = 60 // 秒, 断开时间的上限
+)
+
+var (
+	MetricELKBreakerOpen        = k3.NewMetric("k3_elk_breaker_open", "Whether the elasticsearch sender circuit breaker is open (1) or closed (0).", k3.MetricGauge)
+	MetricELKBreakerTransitions = k3.NewMetric("k3_elk_breaker_transitions_total", "Total number of times the elasticsearch sender circuit breaker opened or closed.", k3.MetricCounter)
+)
+
+// ErrBreakerOpen elk连续连接失败, 断开期间不再发送请求, 直接返回该错误
+var ErrBreakerOpen = errors.New("elasticsearch circuit breaker is open")
+
+// circuitBreaker elk的连接熔断, 连续threshold次连接失败后断开, 断开期间Send直接返回ErrBreakerOpen
+// 超过断开时间后只放行一个请求探测, 成功则恢复, 失败则断开时间翻倍, 最长maxBackoff
+type circuitBreaker struct {
+	lock       sync.Mutex
+	threshold  int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	failures   int       // 连续连接失败的次数
+	opens      int       // 恢复之前连续断开的次数, 用于计算断开时间
+	openUntil  time.Time // 断开的截止时间, 零值表示没有断开
+	probing    bool      // 已经放行了一个探测请求, 还没有返回结果
+	now        func() time.Time
+}
+
+func newCircuitBreaker(threshold int, backoff, maxBackoff time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		threshold = DefaultBreakerThreshold
+	}
+
+	if backoff <= 0 {
+		backoff = time.Duration(DefaultBreakerBackoff) * time.Second
+	}
+
+	if maxBackoff <= 0 {
+		maxBackoff = time.Duration(DefaultBreakerMaxBackoff) * time.Second
+	}
+
+	if maxBackoff < backoff {
+		maxBackoff = backoff
+	}
+
+	return &circuitBreaker{threshold: threshold, backoff: backoff, maxBackoff: maxBackoff, now: time.Now}
+}
+
+// delay 第opens次断开的时间
+func (b *circuitBreaker) delay(opens int) time.Duration {
+	delay := b.backoff << uint(opens)
+	if delay <= 0 || delay > b.maxBackoff {
+		delay = b.maxBackoff
+	}
+	return delay
+}
+
+// allow 断开期间返回ErrBreakerOpen, 超过断开时间后只放行一个探测请求
+func (b *circuitBreaker) allow() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.openUntil.IsZero() {
+		return nil
+	}
+
+	if now := b.now(); now.Before(b.openUntil) || b.probing {
+		return fmt.Errorf("%w, retry after %s", ErrBreakerOpen, b.openUntil.Format(time.RFC3339))
+	}
+
+	b.probing = true
+	return nil
+}
+
+// onSuccess 收到elk的响应, 连接恢复正常
+func (b *circuitBreaker) onSuccess() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !b.openUntil.IsZero() {
+		k3.K3LogInfo("[circuitBreaker] elasticsearch is reachable again, circuit breaker closed.")
+		MetricELKBreakerOpen.Set(0)
+		MetricELKBreakerTransitions.Add(1)
+	}
+
+	b.failures = 0
+	b.opens = 0
+	b.openUntil = time.Time{}
+	b.probing = false
+}
+
+// onFailure 连接失败, 达到threshold或者探测失败时断开
+func (b *circuitBreaker) onFailure(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures++
+	b.probing = false
+
+	if b.openUntil.IsZero() && b.failures < b.threshold {
+		return
+	}
+
+	delay := b.delay(b.opens)
+	if b.openUntil.IsZero() {
+		k3.K3LogWarn("[circuitBreaker] %d consecutive connection failures, circuit breaker opened, retry after %v: %s", b.failures, delay, err.Error())
+		MetricELKBreakerOpen.Set(1)
+		MetricELKBreakerTransitions.Add(1)
+	} else {
+		k3.K3LogWarn("[circuitBreaker] probe request failed, retry after %v: %s", delay, err.Error())
+	}
+
+	b.opens++
+	b.openUntil = b.now().Add(delay)
+}
+
+// onCancel 调用方取消了请求, 不能确定连接是否正常, 只释放探测请求, 之后的请求可以再次探测
+func (b *circuitBreaker) onCancel() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.probing = false
+}
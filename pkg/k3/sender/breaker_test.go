@@ -0,0 +1,109 @@
+package sender
+
+import (
+	"errors"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerBackoff(t *testing.T) {
+	var (
+		now         = time.Now()
+		breaker     = newCircuitBreaker(3, time.Second, 4*time.Second)
+		failed      = errors.New("connection refused")
+		transitions = MetricELKBreakerTransitions.Value()
+	)
+
+	breaker.now = func() time.Time { return now }
+
+	// 没有达到threshold时不断开
+	for i := 0; i < 2; i++ {
+		if err := breaker.allow(); err != nil {
+			t.Fatalf("breaker should be closed before threshold, got %v", err)
+		}
+		breaker.onFailure(failed)
+	}
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("breaker should be closed before threshold, got %v", err)
+	}
+	breaker.onFailure(failed)
+
+	if err := breaker.allow(); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("breaker should open after 3 failures, got %v", err)
+	}
+	if MetricELKBreakerOpen.Value() != 1 || MetricELKBreakerTransitions.Value() != transitions+1 {
+		t.Errorf("breaker open metrics not updated, open %d, transitions %d", MetricELKBreakerOpen.Value(), MetricELKBreakerTransitions.Value()-transitions)
+	}
+
+	// 每次探测失败断开时间翻倍, 最长maxBackoff
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		now = now.Add(delay - time.Millisecond)
+		if err := breaker.allow(); !errors.Is(err, ErrBreakerOpen) {
+			t.Fatalf("breaker should stay open within %v, got %v", delay, err)
+		}
+
+		now = now.Add(time.Millisecond)
+		if err := breaker.allow(); err != nil {
+			t.Fatalf("breaker should allow a probe after %v, got %v", delay, err)
+		}
+		// 探测请求返回之前不放行其他请求
+		if err := breaker.allow(); !errors.Is(err, ErrBreakerOpen) {
+			t.Fatalf("only one probe should be allowed, got %v", err)
+		}
+		breaker.onFailure(failed)
+	}
+
+	// 探测成功后恢复
+	now = now.Add(4 * time.Second)
+	if err := breaker.allow(); err != nil {
+		t.Fatal(err)
+	}
+	breaker.onSuccess()
+	if err := breaker.allow(); err != nil {
+		t.Errorf("breaker should close after a successful probe, got %v", err)
+	}
+	if MetricELKBreakerOpen.Value() != 0 || MetricELKBreakerTransitions.Value() != transitions+2 {
+		t.Errorf("breaker close metrics not updated, open %d, transitions %d", MetricELKBreakerOpen.Value(), MetricELKBreakerTransitions.Value()-transitions)
+	}
+
+	// 恢复后重新从threshold和第一次断开时间开始计算
+	for i := 0; i < 3; i++ {
+		breaker.onFailure(failed)
+	}
+	now = now.Add(time.Second)
+	if err := breaker.allow(); err != nil {
+		t.Errorf("backoff should reset after the breaker closed, got %v", err)
+	}
+	breaker.onSuccess()
+}
+
+func TestElasticsearchBreakerOpen(t *testing.T) {
+	// 监听后立即关闭, 连接该地址时直接失败
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := "http://" + listener.Addr().String()
+	_ = listener.Close()
+
+	client, err := NewElasticsearchWithConfig(config.ELK{Address: []string{address}, BreakerThreshold: 2, BreakerBackoff: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.breaker.onSuccess() // 恢复k3_elk_breaker_open, 避免影响其他测试
+
+	data := []protocol.Data{{UUID: "1", IndexName: "index_test", Properties: map[string]interface{}{"_data": "line 1"}}}
+	for i := 0; i < 2; i++ {
+		if err = client.Send(data); err == nil || errors.Is(err, ErrBreakerOpen) {
+			t.Fatalf("send %d should fail with connection error, got %v", i, err)
+		}
+	}
+
+	// 断开之后不再请求elk, 直接返回
+	if err = client.Send(data); !errors.Is(err, ErrBreakerOpen) {
+		t.Errorf("send should fail fast while breaker is open, got %v", err)
+	}
+}
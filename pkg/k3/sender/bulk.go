@@ -0,0 +1,239 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 文档_id的生成方式, 对应elk.id_strategy配置
+const (
+	IDStrategyNone        = "none"         // 默认, 使用日志的uuid, 重复发送时新增文档
+	IDStrategyContentHash = "content_hash" // 使用watch附加的ContentHashField, 重复发送同一条日志时覆盖文档
+)
+
+// ContentHashField id_strategy为content_hash时, watch附加的文件路径、offset和日志内容的hash, 非elk的sender作为普通字段发送
+const ContentHashField = "_content_hash"
+
+// OffsetField watch附加的日志在文件中的开始位置, 同一个文件中按照读取顺序递增, elk中作为@offset, 与@timestamp一起排序
+const OffsetField = "_offset"
+
+// BulkError _bulk请求中部分文档因为可以重试的状态码(429/503)写入失败, Failed只包含这部分文档, 重试时只需要重新发送Failed
+type BulkError struct {
+	Failed []protocol.Data
+	Reason string // 第一个失败文档的错误信息
+}
+
+func (b *BulkError) Error() string {
+	return fmt.Sprintf("[BulkError] %d documents failed with retryable status: %s", len(b.Failed), b.Reason)
+}
+
+// Bulk _bulk请求中的一条文档
+type Bulk struct {
+	Index      string
+	DocumentId string
+	body       string
+	data       *protocol.Data
+}
+
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkResponseItem `json:"items"`
+}
+
+type bulkResponseItem struct {
+	Index  string `json:"_index"`
+	Id     string `json:"_id"`
+	Status int    `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// isRetryableStatus 集群繁忙或者暂时不可用, 稍后重新发送可以成功
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// buildBulks 将一批日志转换为_bulk的文档, 没有_data的日志不发送
+func buildBulks(data []protocol.Data) []*Bulk {
+	var (
+		bulks = make([]*Bulk, 0, len(data))
+		body  string
+	)
+
+	for i := range data {
+		if body = consumerDataToElkData(&data[i]); len(body) == 0 {
+			continue
+		}
+
+		bulks = append(bulks, &Bulk{
+			Index:      resolveIndexName(&data[i]),
+			DocumentId: documentId(&data[i]),
+			body:       body,
+			data:       &data[i],
+		})
+	}
+
+	return bulks
+}
+
+// documentId id_strategy为content_hash且日志带有ContentHashField时使用hash作为文档_id, 否则使用uuid
+func documentId(data *protocol.Data) string {
+	if config.GlobalConfig.ELK.IDStrategy == IDStrategyContentHash {
+		if hash, ok := data.Properties[ContentHashField].(string); ok && len(hash) > 0 {
+			return hash
+		}
+	}
+
+	return data.UUID
+}
+
+// buildBulkBody _bulk请求体, 每条文档一行action和一行内容, 以换行结尾
+func buildBulkBody(bulks []*Bulk) string {
+	var buffer strings.Builder
+
+	for _, item := range bulks {
+		action := map[string]interface{}{
+			"index": map[string]interface{}{
+				"_index": item.Index,
+				"_id":    item.DocumentId,
+			},
+		}
+		buffer.WriteString(mustMarshal(action))
+		buffer.WriteString("\n")
+		buffer.WriteString(item.body)
+		buffer.WriteString("\n")
+	}
+
+	return buffer.String()
+}
+
+// parseBulkResponse 按照顺序对应每条文档的写入结果, 返回可以重试的文档和不可重试的失败数量
+func parseBulkResponse(body []byte, bulks []*Bulk) ([]protocol.Data, string, int, error) {
+	var (
+		response  bulkResponse
+		retryable []protocol.Data
+		reason    string
+		failed    int
+	)
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, "", 0, errors.New("parse bulk response failed: " + err.Error())
+	}
+
+	if !response.Errors {
+		return nil, "", 0, nil
+	}
+
+	if len(response.Items) != len(bulks) {
+		return nil, "", 0, fmt.Errorf("bulk response has %d items, %d documents sent", len(response.Items), len(bulks))
+	}
+
+	for i, result := range response.Items {
+		for _, item := range result {
+			if item.Status >= 200 && item.Status < 300 {
+				continue
+			}
+
+			message := fmt.Sprintf("index[%s] id[%s] status %d", item.Index, item.Id, item.Status)
+			if item.Error != nil {
+				message += " " + item.Error.Type + ": " + item.Error.Reason
+			}
+
+			if isRetryableStatus(item.Status) {
+				if len(reason) == 0 {
+					reason = message
+				}
+				retryable = append(retryable, *bulks[i].data)
+				continue
+			}
+
+			// mapping冲突等错误重试也不会成功, 记录到本地日志
+			failed++
+			k3.K3LogError("[parseBulkResponse] %s", message)
+			if config.GlobalConsumer != nil {
+				_ = config.GlobalConsumer.Add(*bulks[i].data)
+			}
+		}
+	}
+
+	return retryable, reason, failed, nil
+}
+
+// Send 一批日志通过一次_bulk请求写入, 部分文档因为429/503失败时返回只包含这部分文档的BulkError
+func (e *ElasticSearchClient) Send(data []protocol.Data) error {
+	return e.SendContext(context.Background(), data)
+}
+
+// SendContext 与Send相同, ctx取消时正在进行的_bulk请求立即返回
+func (e *ElasticSearchClient) SendContext(ctx context.Context, data []protocol.Data) error {
+	var (
+		bulks     = buildBulks(data)
+		res       *esapi.Response
+		body      []byte
+		retryable []protocol.Data
+		reason    string
+		failed    int
+		err       error
+	)
+
+	if len(bulks) == 0 {
+		return nil
+	}
+
+	// 熔断期间直接返回, 由consumer保留数据并触发背压
+	if err = e.breaker.allow(); err != nil {
+		return fmt.Errorf("[ElasticSearchClient.Send] %w", err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(e.timeout)*time.Second)
+	defer cancel()
+
+	if res, err = (esapi.BulkRequest{Body: strings.NewReader(buildBulkBody(bulks))}).Do(timeoutCtx, e.client); err != nil {
+		// 调用方取消的请求不是连接失败, 不影响熔断
+		if ctx.Err() != nil {
+			e.breaker.onCancel()
+		} else {
+			e.breaker.onFailure(err)
+		}
+		k3.GlobalWriteFailedCount = k3.GlobalWriteFailedCount + len(bulks)
+		return errors.New("[ElasticSearchClient.Send] bulk request failed: " + err.Error())
+	}
+	// 收到响应说明连接正常, 响应中的错误不影响熔断
+	e.breaker.onSuccess()
+
+	if body, err = readResponse(res); err != nil {
+		k3.GlobalWriteFailedCount = k3.GlobalWriteFailedCount + len(bulks)
+		return errors.New("[ElasticSearchClient.Send] bulk response failed: " + err.Error())
+	}
+
+	if retryable, reason, failed, err = parseBulkResponse(body, bulks); err != nil {
+		k3.GlobalWriteFailedCount = k3.GlobalWriteFailedCount + len(bulks)
+		return errors.New("[ElasticSearchClient.Send] " + err.Error())
+	}
+
+	k3.GlobalWriteFailedCount = k3.GlobalWriteFailedCount + failed + len(retryable)
+	k3.GlobalWriteSuccessCount = k3.GlobalWriteSuccessCount + len(bulks) - failed - len(retryable)
+	k3.K3LogInfo("[ElasticSearchClient.Send] Bulk send data(line:%v) to elasticsearch, failed: %d, retryable: %d.", len(bulks), failed, len(retryable))
+
+	if len(retryable) > 0 {
+		return &BulkError{Failed: retryable, Reason: reason}
+	}
+
+	return nil
+}
+
+// Close 每次发送都是同步完成的, 没有需要释放的资源
+func (e *ElasticSearchClient) Close() error {
+	return nil
+}
@@ -0,0 +1,147 @@
+package sender
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeBulk 模拟_bulk接口, statuses中的uuid按照对应的状态码返回, 其余的文档写入成功
+func newFakeBulk(t *testing.T, statuses map[string]int, bodies *[]string) *ElasticSearchClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			body, _ = io.ReadAll(r.Body)
+			lines   = strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+			items   []string
+			errs    bool
+		)
+
+		*bodies = append(*bodies, string(body))
+
+		for i := 0; i+1 < len(lines); i += 2 {
+			var action struct {
+				Index struct {
+					Index string `json:"_index"`
+					Id    string `json:"_id"`
+				} `json:"index"`
+			}
+			if err := json.Unmarshal([]byte(lines[i]), &action); err != nil {
+				t.Errorf("line %d should be an action: %s", i, lines[i])
+			}
+
+			status, ok := statuses[action.Index.Id]
+			if !ok {
+				status = http.StatusCreated
+			} else {
+				errs = true
+				delete(statuses, action.Index.Id)
+			}
+			items = append(items, fmt.Sprintf(`{"index":{"_index":%q,"_id":%q,"status":%d,"error":{"type":"t","reason":"r"}}}`,
+				action.Index.Index, action.Index.Id, status))
+		}
+
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"took":1,"errors":%v,"items":[%s]}`, errs, strings.Join(items, ","))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewElasticsearchWithConfig(config.ELK{Address: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func bulkTestData(uuids ...string) []protocol.Data {
+	var datas []protocol.Data
+	for _, uuid := range uuids {
+		datas = append(datas, protocol.Data{UUID: uuid, IndexName: "index_nginx", Properties: map[string]interface{}{"_data": "line " + uuid, "_path": "/tmp/app.log"}})
+	}
+	return datas
+}
+
+func TestElasticSearchBulkSend(t *testing.T) {
+	var (
+		bodies []string
+		client = newFakeBulk(t, map[string]int{"2": http.StatusTooManyRequests, "3": http.StatusBadRequest, "4": http.StatusServiceUnavailable}, &bodies)
+	)
+
+	err := client.Send(bulkTestData("1", "2", "3", "4"))
+
+	// 一次请求, 每条文档一行action一行内容, 以换行结尾
+	if len(bodies) != 1 {
+		t.Fatalf("batch should be sent in one request, got %d", len(bodies))
+	}
+	if !strings.HasSuffix(bodies[0], "\n") {
+		t.Errorf("bulk body should end with a newline")
+	}
+	lines := strings.Split(strings.TrimSuffix(bodies[0], "\n"), "\n")
+	if len(lines) != 8 {
+		t.Fatalf("4 action and 4 source lines expected, got %d", len(lines))
+	}
+	if !strings.Contains(lines[0], `"_index":"index_nginx"`) || !strings.Contains(lines[0], `"_id":"1"`) || !strings.Contains(lines[1], "line 1") {
+		t.Errorf("unexpected bulk framing: %s / %s", lines[0], lines[1])
+	}
+
+	// 只有429和503的文档需要重试, 400的文档重试也不会成功
+	var bulkError *BulkError
+	if !errors.As(err, &bulkError) {
+		t.Fatalf("partial failure should return BulkError, got %v", err)
+	}
+	if len(bulkError.Failed) != 2 || bulkError.Failed[0].UUID != "2" || bulkError.Failed[1].UUID != "4" {
+		t.Errorf("only the retryable subset should be returned, got %v", bulkError.Failed)
+	}
+}
+
+func TestElasticSearchBulkRetryFailedSubset(t *testing.T) {
+	var (
+		bodies []string
+		client = newFakeBulk(t, map[string]int{"2": http.StatusServiceUnavailable}, &bodies)
+		retry  = NewRetrySender(client, 2, time.Millisecond)
+	)
+
+	if err := retry.Send(bulkTestData("1", "2", "3")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("one retry expected, got %d requests", len(bodies))
+	}
+	if lines := strings.Split(strings.TrimSuffix(bodies[1], "\n"), "\n"); len(lines) != 2 || !strings.Contains(lines[0], `"_id":"2"`) {
+		t.Errorf("retry should only resend the failed document, got %s", bodies[1])
+	}
+}
+
+func TestBuildBulksContentHashId(t *testing.T) {
+	var datas = bulkTestData("1", "2", "3")
+	datas[0].Properties[ContentHashField] = "hash-a"
+	datas[1].Properties[ContentHashField] = "hash-a" // 重复发送的同一条日志
+	// 第3条没有hash, 使用uuid
+
+	config.GlobalConfig.ELK.IDStrategy = IDStrategyContentHash
+	t.Cleanup(func() { config.GlobalConfig.ELK.IDStrategy = "" })
+
+	bulks := buildBulks(datas)
+	if bulks[0].DocumentId != "hash-a" || bulks[1].DocumentId != "hash-a" || bulks[2].DocumentId != "3" {
+		t.Errorf("content hash should be used as _id, got %s, %s, %s", bulks[0].DocumentId, bulks[1].DocumentId, bulks[2].DocumentId)
+	}
+	if strings.Contains(bulks[0].body, ContentHashField) {
+		t.Errorf("content hash should not be sent as a field to elk, got %s", bulks[0].body)
+	}
+
+	// none时使用uuid
+	config.GlobalConfig.ELK.IDStrategy = IDStrategyNone
+	if bulks = buildBulks(datas); bulks[0].DocumentId != "1" || bulks[1].DocumentId != "2" {
+		t.Errorf("uuid should be used as _id, got %s, %s", bulks[0].DocumentId, bulks[1].DocumentId)
+	}
+}
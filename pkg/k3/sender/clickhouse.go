@@ -0,0 +1,274 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// protocol.Data中可以映射到列的字段, 其他字段从properties中获取
+const (
+	ClickHouseTimestampField = "_timestamp"  // Data.Timestamp, RFC3339格式, 为空时使用发送时间
+	ClickHouseIndexNameField = "_index_name" // Data.IndexName
+	ClickHouseIpField        = "_ip"         // Data.Ip
+	ClickHouseAccountIdField = "_account_id" // Data.AccountId
+	ClickHouseAppIdField     = "_app_id"     // Data.AppId
+	ClickHouseUUIDField      = "_uuid"       // Data.UUID
+)
+
+var (
+	DefaultClickHouseTimeout     = 30      // 秒, 一次请求的超时时间
+	DefaultClickHouseExtraColumn = "extra" // 没有映射到列的properties字段写入的json列
+	ClickHouseNoExtraColumn      = "-"     // extra_column为-时, 没有映射的字段不写入
+
+	// DefaultClickHouseColumns 没有配置columns时使用的映射, 列名 -> 字段
+	DefaultClickHouseColumns = map[string]string{
+		"timestamp":  ClickHouseTimestampField,
+		"index_name": ClickHouseIndexNameField,
+		"ip":         ClickHouseIpField,
+		"message":    "_data",
+		"path":       "_path",
+	}
+)
+
+// ClickHouseSender 一批日志通过clickhouse的http接口以INSERT INTO <table> FORMAT JSONEachRow写入
+// 配置的列从protocol.Data的字段中获取, 没有映射的properties字段序列化为json写入extra_column
+// buffer_rows大于0时先缓存, 达到buffer_rows再写入, 写入失败时本批次返回错误由重试层重新发送, 之前缓存的行保留到下一次写入
+type ClickHouseSender struct {
+	url         string
+	username    string
+	password    string
+	columns     map[string]string
+	extraColumn string
+	bufferRows  int
+	client      *http.Client
+
+	lock   *sync.Mutex
+	buffer [][]byte // 已经确认接收还没有写入的行
+}
+
+func NewClickHouseSender(clickHouseConfig config.ClickHouseSender) (*ClickHouseSender, error) {
+	var (
+		dsn    *url.URL
+		params url.Values
+		err    error
+	)
+
+	if len(clickHouseConfig.DSN) == 0 {
+		return nil, errors.New("[NewClickHouseSender] clickhouse dsn is empty")
+	}
+
+	if len(clickHouseConfig.Table) == 0 {
+		return nil, errors.New("[NewClickHouseSender] clickhouse table is empty")
+	}
+
+	if dsn, err = url.Parse(clickHouseConfig.DSN); err != nil {
+		return nil, errors.New("[NewClickHouseSender] invalid clickhouse dsn: " + err.Error())
+	}
+
+	if dsn.Scheme != "http" && dsn.Scheme != "https" {
+		return nil, errors.New("[NewClickHouseSender] clickhouse dsn must be http or https, got " + dsn.Scheme)
+	}
+
+	if clickHouseConfig.Timeout <= 0 {
+		clickHouseConfig.Timeout = DefaultClickHouseTimeout
+	}
+
+	if len(clickHouseConfig.Columns) == 0 {
+		clickHouseConfig.Columns = DefaultClickHouseColumns
+	}
+
+	if len(clickHouseConfig.ExtraColumn) == 0 {
+		clickHouseConfig.ExtraColumn = DefaultClickHouseExtraColumn
+	}
+
+	// dsn中的参数作为clickhouse的设置, 路径为数据库
+	params = dsn.Query()
+	params.Set("query", "INSERT INTO "+clickHouseConfig.Table+" FORMAT JSONEachRow")
+	params.Set("date_time_input_format", "best_effort")
+	if database := strings.Trim(dsn.Path, "/"); len(database) > 0 {
+		params.Set("database", database)
+	}
+	if clickHouseConfig.AsyncInsert {
+		params.Set("async_insert", "1")
+		params.Set("wait_for_async_insert", boolSetting(clickHouseConfig.WaitForAsyncInsert))
+	}
+
+	password, _ := dsn.User.Password()
+	return &ClickHouseSender{
+		url:         (&url.URL{Scheme: dsn.Scheme, Host: dsn.Host, Path: "/", RawQuery: params.Encode()}).String(),
+		username:    dsn.User.Username(),
+		password:    password,
+		columns:     clickHouseConfig.Columns,
+		extraColumn: clickHouseConfig.ExtraColumn,
+		bufferRows:  clickHouseConfig.BufferRows,
+		client:      &http.Client{Timeout: time.Duration(clickHouseConfig.Timeout) * time.Second},
+		lock:        &sync.Mutex{},
+	}, nil
+}
+
+func boolSetting(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+// fieldValue 获取字段的值, 以_开头的Data字段优先, 其他从properties中获取
+func (c *ClickHouseSender) fieldValue(data *protocol.Data, field string) (interface{}, bool) {
+	switch field {
+	case ClickHouseTimestampField:
+		if data.Timestamp.IsZero() {
+			return time.Now().Format(time.RFC3339Nano), true
+		}
+		return data.Timestamp.Format(time.RFC3339Nano), true
+	case ClickHouseIndexNameField:
+		return data.IndexName, true
+	case ClickHouseIpField:
+		return data.Ip, true
+	case ClickHouseAccountIdField:
+		return data.AccountId, true
+	case ClickHouseAppIdField:
+		return data.AppId, true
+	case ClickHouseUUIDField:
+		return data.UUID, true
+	}
+
+	value, ok := data.Properties[field]
+	return value, ok
+}
+
+// encodeRow 一条日志序列化为JSONEachRow的一行, 以换行结尾
+func (c *ClickHouseSender) encodeRow(data *protocol.Data) ([]byte, error) {
+	var (
+		row    = make(map[string]interface{}, len(c.columns)+1)
+		mapped = make(map[string]bool, len(c.columns))
+		extra  = make(map[string]interface{})
+	)
+
+	for column, field := range c.columns {
+		if value, ok := c.fieldValue(data, field); ok {
+			row[column] = value
+			mapped[field] = true
+		}
+	}
+
+	if c.extraColumn != ClickHouseNoExtraColumn {
+		for field, value := range data.Properties {
+			if !mapped[field] {
+				extra[field] = value
+			}
+		}
+		row[c.extraColumn] = extra
+	}
+
+	body, err := json.Marshal(row)
+	if err != nil {
+		return nil, err
+	}
+	return append(body, '\n'), nil
+}
+
+// Send 非2xx响应或者连接失败时返回错误, 由调用方决定是否重试
+func (c *ClickHouseSender) Send(datas []protocol.Data) error {
+	return c.SendContext(context.Background(), datas)
+}
+
+// SendContext 与Send相同, ctx取消时正在进行的请求立即返回
+func (c *ClickHouseSender) SendContext(ctx context.Context, datas []protocol.Data) error {
+	var rows [][]byte
+
+	if len(datas) == 0 {
+		return nil
+	}
+
+	for i := range datas {
+		row, err := c.encodeRow(&datas[i])
+		if err != nil {
+			k3.K3LogError("[ClickHouseSender.Send] marshal data failed: %s, data: %s", err.Error(), datas[i].String())
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// 没有达到buffer_rows时只缓存, 返回nil表示已经接收
+	pending := append(c.buffer[:len(c.buffer):len(c.buffer)], rows...)
+	if c.bufferRows > 0 && len(pending) < c.bufferRows {
+		c.buffer = pending
+		return nil
+	}
+
+	// 写入失败时缓存不变, 本批次由调用方重新发送
+	if err := c.insert(ctx, pending); err != nil {
+		return err
+	}
+	c.buffer = nil
+
+	return nil
+}
+
+// insert 一次请求写入所有的行, 调用时需要持有lock
+func (c *ClickHouseSender) insert(ctx context.Context, rows [][]byte) error {
+	var (
+		request *http.Request
+		res     *http.Response
+		err     error
+	)
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	if request, err = http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(bytes.Join(rows, nil))); err != nil {
+		return errors.New("[ClickHouseSender.insert] create request failed: " + err.Error())
+	}
+
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	if len(c.username) > 0 {
+		request.Header.Set("X-ClickHouse-User", c.username)
+		request.Header.Set("X-ClickHouse-Key", c.password)
+	}
+
+	if res, err = c.client.Do(request); err != nil {
+		return errors.New("[ClickHouseSender.insert] insert failed: " + err.Error())
+	}
+	defer res.Body.Close()
+
+	// 返回结果过程中出错时状态码仍然是200, 错误码在响应头中
+	if res.StatusCode < 200 || res.StatusCode >= 300 || len(res.Header.Get("X-ClickHouse-Exception-Code")) > 0 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, int64(MaxHttpErrorBodySize)))
+		return fmt.Errorf("[ClickHouseSender.insert] insert %d rows failed: %s %s", len(rows), res.Status, string(message))
+	}
+
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	return nil
+}
+
+// Close 写入缓存中剩余的行, 可以重复调用
+func (c *ClickHouseSender) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	err := c.insert(context.Background(), c.buffer)
+	if err == nil {
+		c.buffer = nil
+	}
+	c.client.CloseIdleConnections()
+
+	return err
+}
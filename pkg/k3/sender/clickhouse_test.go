@@ -0,0 +1,236 @@
+package sender
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clickHouseRecorder 记录clickhouse收到的写入请求
+type clickHouseRecorder struct {
+	lock    sync.Mutex
+	status  int
+	query   []url.Values
+	header  []http.Header
+	rows    [][]map[string]interface{}
+	rawBody []string
+}
+
+func newClickHouseServer(t *testing.T, recorder *clickHouseRecorder) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rows []map[string]interface{}
+
+		body, _ := io.ReadAll(r.Body)
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			var row map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Errorf("each line should be a json object: %s", err.Error())
+			}
+			rows = append(rows, row)
+		}
+
+		recorder.lock.Lock()
+		defer recorder.lock.Unlock()
+		recorder.query = append(recorder.query, r.URL.Query())
+		recorder.header = append(recorder.header, r.Header.Clone())
+		recorder.rows = append(recorder.rows, rows)
+		recorder.rawBody = append(recorder.rawBody, string(body))
+
+		if recorder.status != 0 {
+			w.WriteHeader(recorder.status)
+			_, _ = w.Write([]byte("Code: 60. DB::Exception: Table logs.k3_logs does not exist"))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func clickHouseTestData(line string) protocol.Data {
+	return protocol.Data{
+		IndexName: "index_nginx",
+		Ip:        "10.0.0.1",
+		Timestamp: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC),
+		Properties: map[string]interface{}{
+			"_data":   line,
+			"_path":   "/logs/nginx/access.log",
+			"_offset": 128,
+			"host":    "web-1",
+		},
+	}
+}
+
+func TestClickHouseSenderInsert(t *testing.T) {
+	var (
+		recorder = &clickHouseRecorder{}
+		server   = newClickHouseServer(t, recorder)
+	)
+
+	ch, err := NewClickHouseSender(config.ClickHouseSender{
+		DSN:                strings.Replace(server.URL, "http://", "http://writer:secret@", 1) + "/logs?insert_quorum=2",
+		Table:              "k3_logs",
+		AsyncInsert:        true,
+		WaitForAsyncInsert: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ch.Send([]protocol.Data{clickHouseTestData("GET / 200"), clickHouseTestData("GET /api 500")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.query) != 1 {
+		t.Fatalf("one batch should be inserted with one request, got %d", len(recorder.query))
+	}
+
+	query := recorder.query[0]
+	if query.Get("query") != "INSERT INTO k3_logs FORMAT JSONEachRow" || query.Get("database") != "logs" {
+		t.Errorf("unexpected insert query %v", query)
+	}
+	if query.Get("async_insert") != "1" || query.Get("wait_for_async_insert") != "1" || query.Get("insert_quorum") != "2" {
+		t.Errorf("async insert and dsn settings should be sent, got %v", query)
+	}
+	if header := recorder.header[0]; header.Get("X-ClickHouse-User") != "writer" || header.Get("X-ClickHouse-Key") != "secret" {
+		t.Errorf("credentials should be sent in headers, got %v", header)
+	}
+
+	// 一行一个json对象, 没有映射的字段写入extra
+	if lines := strings.Split(strings.TrimSuffix(recorder.rawBody[0], "\n"), "\n"); len(lines) != 2 {
+		t.Fatalf("body should have one line per row, got %q", recorder.rawBody[0])
+	}
+	row := recorder.rows[0][1]
+	expected := map[string]interface{}{
+		"timestamp":  "2024-01-02T15:04:05Z",
+		"index_name": "index_nginx",
+		"ip":         "10.0.0.1",
+		"message":    "GET /api 500",
+		"path":       "/logs/nginx/access.log",
+	}
+	for column, value := range expected {
+		if row[column] != value {
+			t.Errorf("column %s should be %v, got %v", column, value, row[column])
+		}
+	}
+	extra, _ := row["extra"].(map[string]interface{})
+	if len(extra) != 2 || extra["host"] != "web-1" || extra["_offset"] != float64(128) {
+		t.Errorf("unmapped fields should be written to extra, got %v", row["extra"])
+	}
+}
+
+func TestClickHouseSenderColumns(t *testing.T) {
+	var (
+		recorder = &clickHouseRecorder{}
+		server   = newClickHouseServer(t, recorder)
+	)
+
+	ch, err := NewClickHouseSender(config.ClickHouseSender{
+		DSN:         server.URL,
+		Table:       "k3_logs",
+		Columns:     map[string]string{"ts": ClickHouseTimestampField, "line": "_data", "missing": "not_exists"},
+		ExtraColumn: ClickHouseNoExtraColumn,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ch.Send([]protocol.Data{clickHouseTestData("GET / 200")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if query := recorder.query[0]; query.Has("async_insert") || query.Has("database") {
+		t.Errorf("async insert and database should not be set, got %v", query)
+	}
+	if row := recorder.rows[0][0]; len(row) != 2 || row["line"] != "GET / 200" || row["ts"] != "2024-01-02T15:04:05Z" {
+		t.Errorf("only configured columns should be written, got %v", row)
+	}
+}
+
+func TestClickHouseSenderErrors(t *testing.T) {
+	var (
+		recorder = &clickHouseRecorder{status: http.StatusNotFound}
+		server   = newClickHouseServer(t, recorder)
+	)
+
+	ch, err := NewClickHouseSender(config.ClickHouseSender{DSN: server.URL, Table: "k3_logs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 非2xx响应返回错误, 错误信息包含clickhouse的异常
+	if err = ch.Send([]protocol.Data{clickHouseTestData("GET / 200")}); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("clickhouse exception should be returned, got %v", err)
+	}
+
+	// 连接失败返回错误, 由重试层重新发送
+	server.Close()
+	if err = ch.Send([]protocol.Data{clickHouseTestData("GET / 200")}); err == nil {
+		t.Error("connection error should be returned")
+	}
+
+	for _, c := range []config.ClickHouseSender{
+		{Table: "k3_logs"},
+		{DSN: "http://127.0.0.1:8123"},
+		{DSN: "tcp://127.0.0.1:9000", Table: "k3_logs"},
+	} {
+		if _, err = NewClickHouseSender(c); err == nil {
+			t.Errorf("invalid config %+v should be rejected", c)
+		}
+	}
+}
+
+func TestClickHouseSenderBuffer(t *testing.T) {
+	var (
+		recorder = &clickHouseRecorder{}
+		server   = newClickHouseServer(t, recorder)
+	)
+
+	ch, err := NewClickHouseSender(config.ClickHouseSender{DSN: server.URL, Table: "k3_logs", BufferRows: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有达到buffer_rows时只缓存
+	if err = ch.Send([]protocol.Data{clickHouseTestData("line 1"), clickHouseTestData("line 2")}); err != nil || len(recorder.rows) != 0 {
+		t.Fatalf("rows should be buffered, got %v, %d requests", err, len(recorder.rows))
+	}
+
+	// 写入失败时本批次返回错误, 之前缓存的行保留
+	recorder.status = http.StatusServiceUnavailable
+	if err = ch.Send([]protocol.Data{clickHouseTestData("line 3")}); err == nil {
+		t.Fatal("insert error should be returned")
+	}
+
+	recorder.status = 0
+	if err = ch.Send([]protocol.Data{clickHouseTestData("line 3")}); err != nil {
+		t.Fatal(err)
+	}
+	if rows := recorder.rows[1]; len(rows) != 3 || rows[0]["message"] != "line 1" || rows[2]["message"] != "line 3" {
+		t.Errorf("buffered rows and the resent batch should be inserted together, got %v", rows)
+	}
+
+	// Close写入剩余的行
+	if err = ch.Send([]protocol.Data{clickHouseTestData("line 4")}); err != nil {
+		t.Fatal(err)
+	}
+	if err = ch.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.rows) != 3 || len(recorder.rows[2]) != 1 || recorder.rows[2][0]["message"] != "line 4" {
+		t.Errorf("close should flush buffered rows, got %v", recorder.rows)
+	}
+	if err = ch.Close(); err != nil || len(recorder.rows) != 3 {
+		t.Errorf("close should be idempotent, got %v", err)
+	}
+}
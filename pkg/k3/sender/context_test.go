@@ -0,0 +1,70 @@
+package sender
+
+import (
+	"context"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newHungServer 收到请求后一直不响应, 直到客户端断开或者测试结束
+func newHungServer(t *testing.T) *httptest.Server {
+	var release = make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	return server
+}
+
+// assertCancelReturn 发送开始后取消ctx, send需要立即返回错误
+func assertCancelReturn(t *testing.T, name string, send func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	if err := send(ctx); err == nil {
+		t.Errorf("%s should fail after context is cancelled", name)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("%s should return promptly after context is cancelled, took %v", name, elapsed)
+	}
+}
+
+func TestSendContextCancel(t *testing.T) {
+	var (
+		server = newHungServer(t)
+		data   = bulkTestData("1")
+	)
+
+	httpSender, err := NewHTTPSender(config.HttpSender{URL: server.URL, Timeout: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCancelReturn(t, "http sender", func(ctx context.Context) error {
+		return httpSender.SendContext(ctx, data)
+	})
+
+	elk, err := NewElasticsearchWithConfig(config.ELK{Address: []string{server.URL}, Timeout: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCancelReturn(t, "elasticsearch sender", func(ctx context.Context) error {
+		return elk.SendContext(ctx, data)
+	})
+
+	// 包装的sender将ctx传递给内层, 取消后不再重试
+	wrapped := NewRetrySender(NewRouteSender(httpSender, nil, nil), 3, time.Hour)
+	assertCancelReturn(t, "retry sender", func(ctx context.Context) error {
+		return protocol.SendContext(ctx, wrapped, data)
+	})
+}
@@ -1,19 +1,18 @@
 package sender
 
 import (
-	"context"
 	"encoding/json"
-	"fmt"
+	"github.com/elastic/elastic-transport-go/v8/elastictransport"
 	"github.com/elastic/go-elasticsearch/v8"
-	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"log"
 	"log-engine-sdk/pkg/k3"
 	"log-engine-sdk/pkg/k3/config"
 	"log-engine-sdk/pkg/k3/protocol"
+	"net/http"
 	"os"
 	"strings"
-	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -21,23 +20,14 @@ var (
 	DefaultMaxRetry       = 10    // 重试次数
 	DefaultTimeout        = 30    // 秒, 数据发送的超时时间
 	DefaultRetryInterval  = 3     // 秒， 默认队列满等待时间间隔
-	BulkData              []*Bulk
+	MaxIndexNameLength    = 255   // elk索引名最大长度(字节)
 )
 
-type Bulk struct {
-	Index      string
-	DocumentId string
-	body       string
-}
-
 type ElasticSearchClient struct {
-	config        elasticsearch.Config
-	client        *elasticsearch.Client
-	dataChan      chan *protocol.Data // 最大队列
-	maxRetries    int                 // 最大重试次数
-	retryInterval int                 // 每次重试时间间隔
-	timeout       int                 // 最后超时时间
-	sg            *sync.WaitGroup
+	config  elasticsearch.Config
+	client  *elasticsearch.Client
+	timeout int             // 单位秒, 一次_bulk请求的超时时间
+	breaker *circuitBreaker // 连续连接失败后断开, 断开期间不再请求elk
 }
 
 func NewElasticsearch(address []string, username, password string) (*ElasticSearchClient, error) {
@@ -59,27 +49,53 @@ func NewElasticsearch(address []string, username, password string) (*ElasticSear
 	}
 
 	return NewElasticsearchWithConfig(config.ELK{
-		Address:        address,
-		Username:       username,
-		Password:       password,
-		MaxChannelSize: config.GlobalConfig.ELK.MaxChannelSize,
-		MaxRetry:       config.GlobalConfig.ELK.MaxRetry,
-		RetryInterval:  config.GlobalConfig.ELK.RetryInterval,
-		Timeout:        config.GlobalConfig.ELK.Timeout,
+		Address:            address,
+		Username:           username,
+		Password:           password,
+		MaxChannelSize:     config.GlobalConfig.ELK.MaxChannelSize,
+		MaxRetry:           config.GlobalConfig.ELK.MaxRetry,
+		RetryInterval:      config.GlobalConfig.ELK.RetryInterval,
+		Timeout:            config.GlobalConfig.ELK.Timeout,
+		NodeCooldown:       config.GlobalConfig.ELK.NodeCooldown,
+		BreakerThreshold:   config.GlobalConfig.ELK.BreakerThreshold,
+		BreakerBackoff:     config.GlobalConfig.ELK.BreakerBackoff,
+		BreakerMaxBackoff:  config.GlobalConfig.ELK.BreakerMaxBackoff,
+		APIKey:             config.GlobalConfig.ELK.APIKey,
+		CACertPath:         config.GlobalConfig.ELK.CACertPath,
+		InsecureSkipVerify: config.GlobalConfig.ELK.InsecureSkipVerify,
 	})
 }
 
 func NewElasticsearchWithConfig(elasticsearchConfig config.ELK) (*ElasticSearchClient, error) {
 	var (
-		cfg    elasticsearch.Config
-		client *elasticsearch.Client
-		err    error
+		cfg       elasticsearch.Config
+		client    *elasticsearch.Client
+		transport *http.Transport
+		err       error
 	)
 
+	if transport, err = newElasticsearchTransport(elasticsearchConfig); err != nil {
+		k3.K3LogError("[NewElasticsearchWithConfig] Failed to create Elasticsearch transport: %v", err)
+		return nil, err
+	}
+
 	cfg = elasticsearch.Config{
 		Addresses: elasticsearchConfig.Address,
-		Username:  elasticsearchConfig.Username,
-		Password:  elasticsearchConfig.Password,
+		Header:    newElasticsearchHeader(elasticsearchConfig),
+		// 多个节点时轮流发送, 连接失败的节点冷却后再尝试, 请求失败时由客户端换下一个节点重试
+		ConnectionPoolFunc: func(conns []*elastictransport.Connection, _ elastictransport.Selector) elastictransport.ConnectionPool {
+			return newNodePool(conns, time.Duration(elasticsearchConfig.NodeCooldown)*time.Second)
+		},
+	}
+
+	// api_key优先于用户名密码
+	if len(elasticsearchConfig.APIKey) == 0 {
+		cfg.Username = elasticsearchConfig.Username
+		cfg.Password = elasticsearchConfig.Password
+	}
+
+	if transport != nil {
+		cfg.Transport = transport
 	}
 
 	if client, err = elasticsearch.NewClient(cfg); err != nil {
@@ -87,171 +103,134 @@ func NewElasticsearchWithConfig(elasticsearchConfig config.ELK) (*ElasticSearchC
 		return nil, err
 	}
 
-	// 开启协程，从管道中读取数据， 写入集群
+	if elasticsearchConfig.Timeout <= 0 {
+		elasticsearchConfig.Timeout = DefaultTimeout
+	}
 
 	c := &ElasticSearchClient{
-		config:        cfg,
-		client:        client,
-		dataChan:      make(chan *protocol.Data, elasticsearchConfig.MaxChannelSize),
-		maxRetries:    elasticsearchConfig.MaxRetry,
-		retryInterval: elasticsearchConfig.RetryInterval,
-		timeout:       elasticsearchConfig.Timeout,
-		sg:            &sync.WaitGroup{},
+		config:  cfg,
+		client:  client,
+		timeout: elasticsearchConfig.Timeout,
+		breaker: newCircuitBreaker(elasticsearchConfig.BreakerThreshold,
+			time.Duration(elasticsearchConfig.BreakerBackoff)*time.Second,
+			time.Duration(elasticsearchConfig.BreakerMaxBackoff)*time.Second),
 	}
 
-	c.sg.Add(1)
-	go WriteDataToElasticSearch(c)
-
 	return c, nil
 }
 
-// WriteDataToElasticSearch 从管道读取数据，写入elk
-func WriteDataToElasticSearch(client *ElasticSearchClient) {
-	defer client.sg.Done()
-
-	BulkData = make([]*Bulk, 0)
-
-	for {
-		var (
-			requestBody string
-			index       string
-		)
-
-		select {
-		// 获取consumer 提交过来的日志
-		case data, ok := <-client.dataChan:
-			if !ok {
-				k3.K3LogError("[WriteDataToElasticSearch] data-channel closed !")
-
-				return
-			}
-
-			if requestBody = consumerDataToElkData(data); len(requestBody) == 0 {
-				continue
-			}
-
-			k3.K3LogDebug("[WriteDataToElasticSearch] request body : %s", requestBody)
-
-			if len(data.IndexName) == 0 {
-				index = config.GlobalConfig.ELK.DefaultIndexName
-			} else {
-				index = data.IndexName
-			}
-
-			if config.GlobalConfig.ELK.IsUseSuffixDate {
-				index = index + "_" + time.Now().Format("20060102")
-			}
-
-			// 将数据写入BulkData
-			BulkData = append(BulkData, &Bulk{
-				Index:      index,
-				DocumentId: fmt.Sprintf("%s", data.UUID),
-				body:       requestBody,
-			})
+// resolveIndexName 计算日志写入elk的索引名
+// 1. index_name开启index_override_field(watch.index中配置)时, 日志内容中包含该字段且字段值合法, 使用字段值作为索引名
+// 2. 否则使用文件对应的index_name, index_name为空时使用default_index_name
+// 3. 按照index_date_pattern或者is_use_suffix_date加上日期后缀
+func resolveIndexName(data *protocol.Data) string {
+	var (
+		index string
+		ok    bool
+	)
 
-			sendBulkElasticSearch(client.client, false)
+	if index, ok = fetchOverrideIndexName(data, config.GlobalConfig.Watch.Index[data.IndexName].IndexOverrideField); !ok {
+		if len(data.IndexName) == 0 {
+			index = config.GlobalConfig.ELK.DefaultIndexName
+		} else {
+			index = data.IndexName
 		}
 	}
-}
 
-func (e *ElasticSearchClient) Close() error {
-
-	close(e.dataChan)
-	e.sg.Wait()
-	sendBulkElasticSearch(e.client, true)
-	return nil
+	return index + indexDateSuffix(data)
 }
 
-func sendBulkElasticSearch(client *elasticsearch.Client, force bool) {
+// indexDateSuffix 按天滚动的索引后缀, 使用日志的时间, 没有时间时使用当前时间
+// 配置了index_date_pattern时为 -日期, 否则is_use_suffix_date为 _20060102
+func indexDateSuffix(data *protocol.Data) string {
+	var (
+		ts = data.Timestamp
+	)
 
-	var buffer strings.Builder
+	if ts.IsZero() {
+		ts = time.Now()
+	}
 
-	currentBulkSize := len(BulkData)
-	if currentBulkSize == 0 {
-		return
+	if len(config.GlobalConfig.ELK.IndexDatePattern) > 0 {
+		return "-" + ts.Format(config.GlobalConfig.ELK.IndexDatePattern)
 	}
 
-	// 检查是否满足批量提交的条件
-	if currentBulkSize >= config.GlobalConfig.ELK.BulkSize || force == true {
+	if config.GlobalConfig.ELK.IsUseSuffixDate {
+		return "_" + ts.Format("20060102")
+	}
 
-		for _, item := range BulkData {
-			action := map[string]interface{}{
-				"index": map[string]interface{}{
-					"_index": item.Index,
-					"_id":    item.DocumentId,
-				},
-			}
-			buffer.WriteString(mustMarshal(action))
-			buffer.WriteString("\n")
-			buffer.WriteString(item.body)
-			buffer.WriteString("\n")
-		}
+	return ""
+}
 
-		k3.K3LogDebug("[sendBulkElasticSearch] bulk_data:%s\n", buffer.String())
+// fetchOverrideIndexName 从日志内容中读取field字段作为索引名, 先查找Properties, 再查找_data中的json字段
+func fetchOverrideIndexName(data *protocol.Data, field string) (string, bool) {
+	var (
+		value  interface{}
+		ok     bool
+		_data  string
+		record map[string]interface{}
+		index  string
+	)
 
-		BulkData = make([]*Bulk, 0)
+	if len(field) == 0 {
+		return "", false
+	}
 
-		// 创建批量请求
-		req := esapi.BulkRequest{
-			Body: strings.NewReader(buffer.String()),
+	if value, ok = data.Properties[field]; !ok {
+		if _data, ok = k3.InterfaceToString(data.Properties["_data"]); !ok {
+			return "", false
 		}
 
-		// 批量提交
-		res, err := req.Do(context.Background(), client)
-
-		if err != nil {
-			k3.GlobalWriteFailedCount = k3.GlobalWriteFailedCount + currentBulkSize
-			k3.K3LogError("[sendBulkElasticSearch] Bulk send to elasticsearch failed: %v", err)
-			return
+		if err := json.Unmarshal([]byte(_data), &record); err != nil {
+			return "", false
 		}
 
-		if res.IsError() {
-			k3.GlobalWriteFailedCount = k3.GlobalWriteFailedCount + currentBulkSize
-			k3.K3LogError("[sendBulkElasticSearch] Bulk response from elasticsearch failed: %s", res.String())
-			res.Body.Close()
-			return
+		if value, ok = record[field]; !ok {
+			return "", false
 		}
+	}
 
-		res.Body.Close()
-		k3.GlobalWriteSuccessCount = k3.GlobalWriteSuccessCount + currentBulkSize
-		k3.K3LogInfo("[sendBulkElasticSearch] Bulk send data(line:%v) to elasticsearch successfully.", currentBulkSize)
-	} else {
-		k3.K3LogDebug("[sendBulkElasticSearch] Bulk size(%v) is less than MaxBulkSize(%v)", currentBulkSize, config.GlobalConfig.ELK.BulkSize)
+	if index, ok = k3.InterfaceToString(value); !ok {
+		k3.K3LogWarn("[fetchOverrideIndexName] field(%s) value(%v) is not a string, use index_name(%s)", field, value, data.IndexName)
+		return "", false
 	}
-}
 
-func (e *ElasticSearchClient) Send(data []protocol.Data) error {
-	// 循环发送数据
-	for _, d := range data {
-		if err := e.sendWithRetries(&d); err != nil {
-			k3.K3LogError("[Send] data(%v) to elk's data-channel error : %v", d.UUID, err)
-		}
+	if index, ok = sanitizeIndexName(index); !ok {
+		k3.K3LogWarn("[fetchOverrideIndexName] field(%s) value(%v) is not a valid index name, use index_name(%s)", field, value, data.IndexName)
+		return "", false
 	}
-	return nil
+
+	return index, true
 }
 
-func (e *ElasticSearchClient) sendWithRetries(d *protocol.Data) error {
-	timeout, cancel := context.WithTimeout(context.Background(), time.Duration(e.timeout)*time.Second)
-	defer cancel()
-
-	for i := 0; i < e.maxRetries; i++ {
-		select {
-		case <-timeout.Done():
-			return timeout.Err()
-		case e.dataChan <- d:
-			return nil
-		default:
-			k3.K3LogWarn("[sendWithRetries] %d attempt, the data-channel is full, uuid(%v) retry ......", i, d.UUID)
-			time.Sleep(time.Duration(e.retryInterval) * time.Second)
+// sanitizeIndexName 按照elk索引名规则清理索引名: 全部小写, 非法字符替换为_, 不能以-_+开头, 长度不超过255字节
+// 以.开头的是系统或隐藏索引(如.kibana、.security), 不允许通过日志内容写入
+func sanitizeIndexName(index string) (string, bool) {
+	index = strings.ToLower(strings.TrimSpace(index))
+
+	index = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`\/*?"<>| ,#:`, r) {
+			return '_'
 		}
-	}
+		return r
+	}, index)
+
+	index = strings.TrimLeft(index, "-_+")
 
-	k3.GlobalWriteToChannelFailedCount++
-	// 记录丢弃日志
+	// 按字节截断, 不能截断在多字节字符的中间
+	if len(index) > MaxIndexNameLength {
+		end := MaxIndexNameLength
+		for end > 0 && !utf8.RuneStart(index[end]) {
+			end--
+		}
+		index = index[:end]
+	}
 
-	_ = config.GlobalConsumer.Add(*d)
+	if len(index) == 0 || strings.HasPrefix(index, ".") {
+		return "", false
+	}
 
-	return fmt.Errorf("[sendWithRetries] data-channel is full after retries")
+	return index, true
 }
 
 // consumerDataToElkData 将consumer的数据转换为elk的数据
@@ -277,8 +256,10 @@ func consumerDataToElkData(data *protocol.Data) string {
 		_path = "nil"
 	}
 
-	// host_ip 和 host_name 、uuid 需要生成，SubmitLog 中并没有这些数据
-	if hostName, err = os.Hostname(); err != nil {
+	// host_ip 和 host_name 、uuid 需要生成，SubmitLog 中并没有这些数据, watch附加了host字段时直接使用
+	if host, exists := data.Properties["host"].(string); exists {
+		hostName = host
+	} else if hostName, err = os.Hostname(); err != nil {
 		k3.K3LogError("[consumerDataToElkData] Failed to get hostname: %v", err)
 		hostName = "unknown"
 	}
@@ -292,11 +273,18 @@ func consumerDataToElkData(data *protocol.Data) string {
 		elkData.AppId = data.AppId
 		elkData.Timestamp = data.Timestamp
 		elkData.Path = _path.(string)
+		elkData.Offset = dataOffset(data)
 		elkData.ExtendData = protocol.ExtendData{
 			Content: map[string]interface{}{
 				"text": _data.(string),
 			},
 		}
+		// watch附加的字段和parse_json解析出的字段放到content中
+		for key, value := range data.Properties {
+			if key != "_data" && key != "_path" && key != "host" && key != "text" && key != ContentHashField && key != OffsetField {
+				elkData.ExtendData.Content[key] = value
+			}
+		}
 		if b, err = json.Marshal(&elkData); err != nil {
 			return _data.(string)
 		} else {
@@ -311,6 +299,7 @@ func consumerDataToElkData(data *protocol.Data) string {
 		elkData.AppId = data.AppId
 		elkData.Timestamp = data.Timestamp
 		elkData.Path = _path.(string)
+		elkData.Offset = dataOffset(data)
 		if b, err = json.Marshal(elkData); err != nil {
 			return _data.(string)
 		} else {
@@ -319,6 +308,20 @@ func consumerDataToElkData(data *protocol.Data) string {
 	}
 }
 
+// dataOffset watch附加的OffsetField, wal重放的数据经过json编码后为float64, 没有时返回0
+func dataOffset(data *protocol.Data) int64 {
+	switch offset := data.Properties[OffsetField].(type) {
+	case int64:
+		return offset
+	case float64:
+		return int64(offset)
+	case json.Number:
+		value, _ := offset.Int64()
+		return value
+	}
+	return 0
+}
+
 // mustMarshal 将结构体或映射转换为JSON字符串
 func mustMarshal(v interface{}) string {
 	b, err := json.Marshal(v)
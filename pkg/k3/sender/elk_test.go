@@ -0,0 +1,198 @@
+package sender
+
+import (
+	"encoding/json"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestResolveIndexNameOverride(t *testing.T) {
+	config.GlobalConfig.Watch.Index = map[string]config.Index{
+		"index_nginx": {IndexOverrideField: "_target_index"},
+		"index_api":   {},
+	}
+	config.GlobalConfig.ELK.DefaultIndexName = "logstash"
+	config.GlobalConfig.ELK.IsUseSuffixDate = false
+	defer func() {
+		config.GlobalConfig.ELK = config.ELK{}
+		config.GlobalConfig.Watch.Index = nil
+	}()
+
+	// _data 中包含覆盖字段
+	data := &protocol.Data{
+		IndexName: "index_nginx",
+		Properties: map[string]interface{}{
+			"_data": `{"_target_index": "Index_Audit", "event_name": "login"}`,
+		},
+	}
+	if index := resolveIndexName(data); index != "index_audit" {
+		t.Errorf("override from _data failed, got %s", index)
+	}
+
+	// Properties 中包含覆盖字段, 优先于 _data
+	data.Properties["_target_index"] = "index_pay"
+	if index := resolveIndexName(data); index != "index_pay" {
+		t.Errorf("override from properties failed, got %s", index)
+	}
+
+	// 字段不存在时回退到文件对应的index_name
+	data = &protocol.Data{
+		IndexName:  "index_nginx",
+		Properties: map[string]interface{}{"_data": `{"event_name": "login"}`},
+	}
+	if index := resolveIndexName(data); index != "index_nginx" {
+		t.Errorf("fallback to index name failed, got %s", index)
+	}
+
+	// 非json日志回退到文件对应的index_name
+	data.Properties["_data"] = "plain text line"
+	if index := resolveIndexName(data); index != "index_nginx" {
+		t.Errorf("fallback for plain text failed, got %s", index)
+	}
+
+	// 字段值非法时回退
+	data.Properties["_data"] = `{"_target_index": ".."}`
+	if index := resolveIndexName(data); index != "index_nginx" {
+		t.Errorf("fallback for invalid value failed, got %s", index)
+	}
+
+	// 系统或隐藏索引不能通过日志内容写入
+	data.Properties["_data"] = `{"_target_index": ".kibana"}`
+	if index := resolveIndexName(data); index != "index_nginx" {
+		t.Errorf("fallback for system index failed, got %s", index)
+	}
+
+	// 字段值不是字符串时回退
+	data.Properties["_data"] = `{"_target_index": 1001}`
+	if index := resolveIndexName(data); index != "index_nginx" {
+		t.Errorf("fallback for non-string value failed, got %s", index)
+	}
+
+	// 按index_name开启, 没有开启的index_name不覆盖
+	data = &protocol.Data{
+		IndexName:  "index_api",
+		Properties: map[string]interface{}{"_data": `{"_target_index": "index_audit"}`},
+	}
+	if index := resolveIndexName(data); index != "index_api" {
+		t.Errorf("override should be disabled for index_api, got %s", index)
+	}
+
+	// 没有个性化配置的index_name不覆盖
+	data.IndexName = "index_admin"
+	if index := resolveIndexName(data); index != "index_admin" {
+		t.Errorf("override should be disabled for unconfigured index, got %s", index)
+	}
+}
+
+func TestSanitizeIndexName(t *testing.T) {
+	var cases = map[string]string{
+		"Index_Audit":     "index_audit",
+		" audit log ":     "audit_log",
+		"-_+audit":        "audit",
+		"a/b\\c*d?e\"f<g": "a_b_c_d_e_f_g",
+		"audit#1,2:3|4>5": "audit_1_2_3_4_5",
+	}
+
+	for input, expected := range cases {
+		if index, ok := sanitizeIndexName(input); !ok || index != expected {
+			t.Errorf("sanitizeIndexName(%q) = %q, %v, expected %q", input, index, ok, expected)
+		}
+	}
+
+	for _, input := range []string{"", ".", "..", "___", "  ", ".kibana", ".security-7", "-.tasks", " .Kibana_1"} {
+		if index, ok := sanitizeIndexName(input); ok {
+			t.Errorf("sanitizeIndexName(%q) should be invalid, got %q", input, index)
+		}
+	}
+
+	// 超过最大长度时按字节截断, 多字节字符不会被截断成非法的utf8
+	long := strings.Repeat("a", MaxIndexNameLength-1) + "审计"
+	if index, ok := sanitizeIndexName(long); !ok || index != strings.Repeat("a", MaxIndexNameLength-1) || !utf8.ValidString(index) {
+		t.Errorf("long index name should be cut at a rune boundary, got %q", index)
+	}
+}
+
+func TestResolveIndexNameDatePattern(t *testing.T) {
+	var (
+		day  = time.Date(2024, 10, 16, 23, 59, 0, 0, time.Local)
+		data = &protocol.Data{IndexName: "index_nginx", Timestamp: day, Properties: map[string]interface{}{}}
+	)
+
+	defer func() {
+		config.GlobalConfig.ELK = config.ELK{}
+	}()
+
+	// 没有配置时不加后缀
+	config.GlobalConfig.ELK = config.ELK{}
+	if index := resolveIndexName(data); index != "index_nginx" {
+		t.Errorf("empty pattern should not add suffix, got %s", index)
+	}
+
+	// 使用日志的时间, 而不是发送时的时间
+	config.GlobalConfig.ELK.IndexDatePattern = "2006.01.02"
+	if index := resolveIndexName(data); index != "index_nginx-2024.10.16" {
+		t.Errorf("custom pattern failed, got %s", index)
+	}
+
+	data.Timestamp = day.Add(time.Minute)
+	if index := resolveIndexName(data); index != "index_nginx-2024.10.17" {
+		t.Errorf("event of the next day should route to the next index, got %s", index)
+	}
+
+	// 优先于is_use_suffix_date
+	config.GlobalConfig.ELK.IsUseSuffixDate = true
+	if index := resolveIndexName(data); index != "index_nginx-2024.10.17" {
+		t.Errorf("index_date_pattern should take precedence, got %s", index)
+	}
+
+	config.GlobalConfig.ELK.IndexDatePattern = ""
+	if index := resolveIndexName(data); index != "index_nginx_20241017" {
+		t.Errorf("is_use_suffix_date should use event timestamp, got %s", index)
+	}
+
+	// 没有时间时使用当前时间
+	data.Timestamp = time.Time{}
+	config.GlobalConfig.ELK = config.ELK{IndexDatePattern: "2006.01.02"}
+	if index := resolveIndexName(data); index != "index_nginx-"+time.Now().Format("2006.01.02") {
+		t.Errorf("zero timestamp should use now, got %s", index)
+	}
+}
+
+func TestConsumerDataToElkDataFields(t *testing.T) {
+	var elkData protocol.ElasticSearchData
+
+	data := &protocol.Data{
+		IndexName: "index_nginx",
+		Properties: map[string]interface{}{
+			"_data":       `{"level": "error", "msg": "db timeout"}`,
+			"_path":       "/tmp/app.log",
+			"host":        "web-01",
+			"source_path": "/tmp/app.log",
+			"level":       "error",
+			OffsetField:   int64(42),
+		},
+	}
+
+	if err := json.Unmarshal([]byte(consumerDataToElkData(data)), &elkData); err != nil {
+		t.Fatal(err)
+	}
+
+	// watch附加的host作为host_name, 其它字段放到content中
+	if elkData.HostName != "web-01" || elkData.Path != "/tmp/app.log" {
+		t.Errorf("host and path expected, got %s %s", elkData.HostName, elkData.Path)
+	}
+	if elkData.ExtendData.Content["level"] != "error" || elkData.ExtendData.Content["source_path"] != "/tmp/app.log" {
+		t.Errorf("fields should be merged into content, got %v", elkData.ExtendData.Content)
+	}
+	// 文件内的顺序作为@offset, 不重复放到content中
+	if _, exists := elkData.ExtendData.Content[OffsetField]; elkData.Offset != 42 || exists {
+		t.Errorf("_offset should be sent as @offset, got %d, content %v", elkData.Offset, elkData.ExtendData.Content)
+	}
+	if elkData.ExtendData.Content["text"] != data.Properties["_data"] {
+		t.Errorf("raw line should be kept as text, got %v", elkData.ExtendData.Content["text"])
+	}
+}
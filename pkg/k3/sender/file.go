@@ -0,0 +1,87 @@
+package sender
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"sync"
+	"time"
+)
+
+// FileSender 每批日志以一行一个json(ndjson)追加写入本地文件, 用于无法连接网络的环境, 之后由其他工具收集
+// 按照大小或者时间轮转, 一批日志在内存中编码后一次写入, 同一批日志不会被拆分到两个文件中
+type FileSender struct {
+	file   *k3.K3RotateFile
+	lock   *sync.Mutex
+	closed bool
+}
+
+func NewFileSender(fileConfig config.FileSender) (*FileSender, error) {
+	if len(fileConfig.Path) == 0 {
+		return nil, errors.New("[NewFileSender] file path is empty")
+	}
+
+	file, err := k3.NewRotateFile(k3.K3RotateFileConfig{
+		Path:           fileConfig.Path,
+		MaxSizeMB:      fileConfig.MaxSizeMB,
+		MaxBackups:     fileConfig.MaxBackups,
+		Compress:       fileConfig.Compress,
+		RotateInterval: time.Duration(fileConfig.RotateInterval) * time.Minute,
+	})
+	if err != nil {
+		return nil, errors.New("[NewFileSender] open file failed: " + err.Error())
+	}
+
+	return &FileSender{
+		file: file,
+		lock: &sync.Mutex{},
+	}, nil
+}
+
+// Send 一批日志编码后一次写入
+func (f *FileSender) Send(datas []protocol.Data) error {
+	var (
+		buffer  bytes.Buffer
+		encoder = json.NewEncoder(&buffer)
+	)
+
+	for i := range datas {
+		// Encode每条日志以换行结尾
+		if err := encoder.Encode(datas[i]); err != nil {
+			k3.K3LogError("[FileSender.Send] marshal data failed: %s, data: %s", err.Error(), datas[i].String())
+		}
+	}
+
+	if buffer.Len() == 0 {
+		return nil
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return errors.New("[FileSender.Send] file sender is closed")
+	}
+
+	if _, err := f.file.Write(buffer.Bytes()); err != nil {
+		return errors.New("[FileSender.Send] write file failed: " + err.Error())
+	}
+
+	return nil
+}
+
+// Close 同步到硬盘后关闭文件, 可以重复调用
+func (f *FileSender) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+
+	return errors.Join(f.file.Sync(), f.file.Close())
+}
@@ -0,0 +1,98 @@
+package sender
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// readEvents 读取path及其所有备份中的日志uuid
+func readEvents(t *testing.T, path string) []string {
+	var uuids []string
+
+	files, _ := filepath.Glob(path + "*")
+	for _, name := range files {
+		fd, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var reader io.Reader = fd
+		if strings.HasSuffix(name, ".gz") {
+			if reader, err = gzip.NewReader(fd); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+		for scanner.Scan() {
+			var data protocol.Data
+			if err = json.Unmarshal(scanner.Bytes(), &data); err != nil {
+				t.Fatalf("%s: each line should be a json event: %s", name, err)
+			}
+			uuids = append(uuids, data.UUID)
+		}
+		_ = fd.Close()
+	}
+
+	sort.Strings(uuids)
+	return uuids
+}
+
+func TestFileSenderRotate(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		var (
+			path     = filepath.Join(t.TempDir(), "events.ndjson")
+			line     = strings.Repeat("a", 100*1024)
+			expected []string
+		)
+
+		fs, err := NewFileSender(config.FileSender{Path: path, MaxSizeMB: 1, MaxBackups: 10, Compress: compress})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 每批4条100KB的日志, 第3批写入时超过1MB
+		for batch := 0; batch < 5; batch++ {
+			var datas []protocol.Data
+			for i := 0; i < 4; i++ {
+				uuid := fmt.Sprintf("%d-%d", batch, i)
+				expected = append(expected, uuid)
+				datas = append(datas, protocol.Data{UUID: uuid, IndexName: "index_test", Properties: map[string]interface{}{"_data": line}})
+			}
+			if err = fs.Send(datas); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err = fs.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err = fs.Send([]protocol.Data{{UUID: "closed"}}); err == nil {
+			t.Errorf("compress %v: send after close should return error", compress)
+		}
+
+		backup := path + ".1"
+		if compress {
+			backup += ".gz"
+		}
+		if _, err = os.Stat(backup); err != nil {
+			t.Errorf("compress %v: file should be rotated: %s", compress, err)
+		}
+
+		sort.Strings(expected)
+		if uuids := readEvents(t, path); strings.Join(uuids, ",") != strings.Join(expected, ",") {
+			t.Errorf("compress %v: all events should be recoverable, got %v", compress, uuids)
+		}
+	}
+}
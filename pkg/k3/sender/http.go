@@ -0,0 +1,130 @@
+package sender
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net/http"
+	"time"
+)
+
+var (
+	DefaultHttpTimeout   = 30   // 秒, 一次请求的超时时间
+	MaxHttpErrorBodySize = 1024 // 非2xx响应时, 错误信息中最多保留的响应内容
+)
+
+// HTTPSender 将一批日志作为json数组POST到自定义的接收接口
+type HTTPSender struct {
+	url     string
+	headers map[string]string
+	gzip    bool
+	client  *http.Client
+}
+
+func NewHTTPSender(httpConfig config.HttpSender) (*HTTPSender, error) {
+	if len(httpConfig.URL) == 0 {
+		return nil, errors.New("[NewHTTPSender] http url is empty")
+	}
+
+	if httpConfig.Timeout <= 0 {
+		httpConfig.Timeout = DefaultHttpTimeout
+	}
+
+	return &HTTPSender{
+		url:     httpConfig.URL,
+		headers: httpConfig.Headers,
+		gzip:    httpConfig.Gzip,
+		client:  &http.Client{Timeout: time.Duration(httpConfig.Timeout) * time.Second},
+	}, nil
+}
+
+// encodeBody 序列化一批日志, 开启gzip时压缩
+func (h *HTTPSender) encodeBody(datas []protocol.Data) ([]byte, error) {
+	var (
+		body   []byte
+		buffer bytes.Buffer
+		writer *gzip.Writer
+		err    error
+	)
+
+	if body, err = json.Marshal(datas); err != nil {
+		return nil, err
+	}
+
+	if !h.gzip {
+		return body, nil
+	}
+
+	writer = gzip.NewWriter(&buffer)
+	if _, err = writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Send 非2xx响应返回错误, 由调用方决定是否重试
+func (h *HTTPSender) Send(datas []protocol.Data) error {
+	return h.SendContext(context.Background(), datas)
+}
+
+// SendContext 与Send相同, ctx取消时正在进行的请求立即返回
+func (h *HTTPSender) SendContext(ctx context.Context, datas []protocol.Data) error {
+	var (
+		body    []byte
+		request *http.Request
+		res     *http.Response
+		err     error
+	)
+
+	if len(datas) == 0 {
+		return nil
+	}
+
+	if body, err = h.encodeBody(datas); err != nil {
+		return errors.New("[HTTPSender.Send] encode body failed: " + err.Error())
+	}
+
+	if request, err = http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body)); err != nil {
+		return errors.New("[HTTPSender.Send] create request failed: " + err.Error())
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	if h.gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+	for key, value := range h.headers {
+		if len(value) > 0 {
+			request.Header.Set(key, value)
+		}
+	}
+
+	if res, err = h.client.Do(request); err != nil {
+		return errors.New("[HTTPSender.Send] post failed: " + err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, int64(MaxHttpErrorBodySize)))
+		return fmt.Errorf("[HTTPSender.Send] post %s failed: %s %s", h.url, res.Status, string(message))
+	}
+
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	return nil
+}
+
+// Close 每次发送都是同步完成的, 没有需要释放的资源, 可以重复调用
+func (h *HTTPSender) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
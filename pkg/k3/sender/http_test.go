@@ -0,0 +1,111 @@
+package sender
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// requestRecorder 记录接收接口收到的请求
+type requestRecorder struct {
+	header http.Header
+	datas  []protocol.Data
+}
+
+func newHttpServer(t *testing.T, status int, recorder *requestRecorder) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reader io.Reader = r.Body
+
+		recorder.header = r.Header.Clone()
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			reader = gz
+		}
+
+		if err := json.NewDecoder(reader).Decode(&recorder.datas); err != nil {
+			t.Error(err)
+		}
+
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("ingest unavailable"))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestHTTPSenderSend(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		var (
+			recorder = &requestRecorder{}
+			server   = newHttpServer(t, http.StatusAccepted, recorder)
+			datas    = []protocol.Data{
+				{UUID: "1", IndexName: "index_a", Properties: map[string]interface{}{"_data": "line 1"}},
+				{UUID: "2", IndexName: "index_a", Properties: map[string]interface{}{"_data": "line 2"}},
+			}
+		)
+
+		hs, err := NewHTTPSender(config.HttpSender{
+			URL:     server.URL,
+			Headers: map[string]string{"Authorization": "Bearer token", "X-Empty": ""},
+			Gzip:    compress,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = hs.Send(datas); err != nil {
+			t.Fatalf("gzip %v: %s", compress, err)
+		}
+
+		if recorder.header.Get("Authorization") != "Bearer token" || recorder.header.Get("Content-Type") != "application/json" {
+			t.Errorf("gzip %v: custom headers should be sent, got %v", compress, recorder.header)
+		}
+		if _, ok := recorder.header["X-Empty"]; ok {
+			t.Errorf("gzip %v: empty header should not be sent", compress)
+		}
+		if len(recorder.datas) != 2 || recorder.datas[1].Properties["_data"] != "line 2" {
+			t.Errorf("gzip %v: body should be the json array of the batch, got %v", compress, recorder.datas)
+		}
+
+		if err = hs.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err = hs.Close(); err != nil {
+			t.Errorf("close should be safe to call twice: %s", err)
+		}
+	}
+}
+
+func TestHTTPSenderServerError(t *testing.T) {
+	var (
+		recorder = &requestRecorder{}
+		server   = newHttpServer(t, http.StatusInternalServerError, recorder)
+	)
+
+	hs, err := NewHTTPSender(config.HttpSender{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = hs.Send([]protocol.Data{{UUID: "1", IndexName: "index_a"}})
+	if err == nil || !strings.Contains(err.Error(), "500") || !strings.Contains(err.Error(), "ingest unavailable") {
+		t.Errorf("500 response should return error with status and body, got %v", err)
+	}
+}
+
+func TestNewHTTPSender(t *testing.T) {
+	if _, err := NewHTTPSender(config.HttpSender{}); err == nil {
+		t.Errorf("empty url should return error")
+	}
+}
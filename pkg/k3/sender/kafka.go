@@ -0,0 +1,194 @@
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"os"
+	"sync"
+	"time"
+)
+
+// sender.type配置
+const (
+	TypeElk          = "elk"
+	TypeKafka        = "kafka"
+	TypeHttp         = "http"
+	TypeSyslog       = "syslog"        // SyslogSender, RFC5424格式发送到syslog服务
+	TypeLoki         = "loki"          // LokiSender, 推送到grafana loki
+	TypeClickHouse   = "clickhouse"    // ClickHouseSender, 通过http接口写入clickhouse
+	TypeFile         = "file"          // FileSender, 写入本地文件
+	TypeStdout       = "stdout"        // Default, 打印到标准输出, 用于调试
+	TypeStdoutNDJSON = "stdout_ndjson" // StdoutNDJSON, 每条日志一行json打印到标准输出, 用于调试和jq等工具
+	TypeMulti        = "multi"         // MultiSender, 同时发送到sender.multi中的所有目标
+)
+
+var (
+	DefaultKafkaTimeout = 30 // 秒, 一批消息写入的超时时间
+)
+
+// KafkaMessage 写入kafka的一条消息
+type KafkaMessage struct {
+	Key   []byte
+	Value []byte
+}
+
+// KafkaProducer kafka客户端需要实现的接口
+type KafkaProducer interface {
+	// WriteMessages 同步写入一批消息, 全部写入成功后返回nil
+	WriteMessages(ctx context.Context, messages ...KafkaMessage) error
+	// Close 写入还在缓冲区中的消息后关闭
+	Close() error
+}
+
+// NewKafkaProducer 根据配置创建kafka客户端, 由引入的kafka客户端库注册, 没有注册时无法使用kafka sender
+var NewKafkaProducer func(cfg config.Kafka, tlsConfig *tls.Config) (KafkaProducer, error)
+
+type Kafka struct {
+	producer KafkaProducer
+	topic    string
+	timeout  time.Duration
+	lock     *sync.Mutex
+	closed   bool
+}
+
+// NewKafka 通过注册的NewKafkaProducer创建kafka sender
+func NewKafka(kafkaConfig config.Kafka) (*Kafka, error) {
+	var (
+		tlsConfig *tls.Config
+		producer  KafkaProducer
+		err       error
+	)
+
+	if len(kafkaConfig.Brokers) == 0 {
+		return nil, errors.New("[NewKafka] kafka brokers is empty")
+	}
+
+	if len(kafkaConfig.Topic) == 0 {
+		return nil, errors.New("[NewKafka] kafka topic is empty")
+	}
+
+	if NewKafkaProducer == nil {
+		return nil, errors.New("[NewKafka] kafka producer is not registered")
+	}
+
+	if tlsConfig, err = newKafkaTLSConfig(kafkaConfig.TLS); err != nil {
+		return nil, errors.New("[NewKafka] load tls config failed: " + err.Error())
+	}
+
+	if producer, err = NewKafkaProducer(kafkaConfig, tlsConfig); err != nil {
+		return nil, errors.New("[NewKafka] create kafka producer failed: " + err.Error())
+	}
+
+	return NewKafkaWithProducer(kafkaConfig, producer), nil
+}
+
+// NewKafkaWithProducer 使用已经创建好的kafka客户端
+func NewKafkaWithProducer(kafkaConfig config.Kafka, producer KafkaProducer) *Kafka {
+	if kafkaConfig.Timeout <= 0 {
+		kafkaConfig.Timeout = DefaultKafkaTimeout
+	}
+
+	return &Kafka{
+		producer: producer,
+		topic:    kafkaConfig.Topic,
+		timeout:  time.Duration(kafkaConfig.Timeout) * time.Second,
+		lock:     &sync.Mutex{},
+	}
+}
+
+// newKafkaTLSConfig 没有开启tls时返回nil
+func newKafkaTLSConfig(tlsConfig config.KafkaTLS) (*tls.Config, error) {
+	var (
+		cfg  *tls.Config
+		ca   []byte
+		cert tls.Certificate
+		err  error
+	)
+
+	if !tlsConfig.Enable {
+		return nil, nil
+	}
+
+	cfg = &tls.Config{InsecureSkipVerify: tlsConfig.InsecureSkipVerify}
+
+	if len(tlsConfig.CAFile) > 0 {
+		if ca, err = os.ReadFile(tlsConfig.CAFile); err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("invalid ca file " + tlsConfig.CAFile)
+		}
+	}
+
+	if len(tlsConfig.CertFile) > 0 || len(tlsConfig.KeyFile) > 0 {
+		if cert, err = tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile); err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// Send 一批日志作为一次批量写入, 每条日志序列化为json, 消息key为index_name
+func (k *Kafka) Send(datas []protocol.Data) error {
+	return k.SendContext(context.Background(), datas)
+}
+
+// SendContext 与Send相同, ctx取消时正在进行的写入立即返回
+func (k *Kafka) SendContext(ctx context.Context, datas []protocol.Data) error {
+	var (
+		messages = make([]KafkaMessage, 0, len(datas))
+		value    []byte
+		err      error
+	)
+
+	for i := range datas {
+		if value, err = json.Marshal(datas[i]); err != nil {
+			k3.K3LogError("[Kafka.Send] marshal data failed: %s, data: %s", err.Error(), datas[i].String())
+			continue
+		}
+
+		messages = append(messages, KafkaMessage{Key: []byte(datas[i].IndexName), Value: value})
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if k.closed {
+		return errors.New("[Kafka.Send] kafka sender is closed")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, k.timeout)
+	defer cancel()
+
+	if err = k.producer.WriteMessages(ctx, messages...); err != nil {
+		return errors.New("[Kafka.Send] write " + k.topic + " failed: " + err.Error())
+	}
+
+	return nil
+}
+
+// Close 关闭kafka客户端, 客户端缓冲区中的消息在关闭前写入
+func (k *Kafka) Close() error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if k.closed {
+		return nil
+	}
+	k.closed = true
+
+	return k.producer.Close()
+}
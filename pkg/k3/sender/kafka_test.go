@@ -0,0 +1,124 @@
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"sync"
+	"testing"
+)
+
+// mockProducer 记录写入的消息, 客户端库的批量写入对应一次WriteMessages调用
+type mockProducer struct {
+	lock    sync.Mutex
+	batches [][]KafkaMessage
+	err     error
+	closed  bool
+}
+
+func (m *mockProducer) WriteMessages(ctx context.Context, messages ...KafkaMessage) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+	m.batches = append(m.batches, messages)
+	return nil
+}
+
+func (m *mockProducer) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.closed = true
+	return nil
+}
+
+func TestKafkaSend(t *testing.T) {
+	var (
+		producer = &mockProducer{}
+		kafka    = NewKafkaWithProducer(config.Kafka{Topic: "k3_logs"}, producer)
+		datas    = []protocol.Data{
+			{UUID: "1", IndexName: "index_a", Properties: map[string]interface{}{"_data": "line 1"}},
+			{UUID: "2", IndexName: "index_b", Properties: map[string]interface{}{"_data": "line 2"}},
+		}
+	)
+
+	if err := kafka.Send(datas); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(producer.batches) != 1 || len(producer.batches[0]) != 2 {
+		t.Fatalf("one batch of 2 messages expected, got %v", producer.batches)
+	}
+
+	for i, message := range producer.batches[0] {
+		var data protocol.Data
+		if string(message.Key) != datas[i].IndexName {
+			t.Errorf("message %d key should be %s, got %s", i, datas[i].IndexName, message.Key)
+		}
+		if err := json.Unmarshal(message.Value, &data); err != nil {
+			t.Fatal(err)
+		}
+		if data.UUID != datas[i].UUID || data.Properties["_data"] != datas[i].Properties["_data"] {
+			t.Errorf("message %d value mismatch: %s", i, message.Value)
+		}
+	}
+
+	if err := kafka.Close(); err != nil || !producer.closed {
+		t.Fatalf("close should close the producer, err: %v", err)
+	}
+
+	if err := kafka.Send(datas); err == nil {
+		t.Errorf("send after close should return error")
+	}
+}
+
+func TestKafkaSendFailed(t *testing.T) {
+	var (
+		producer = &mockProducer{err: errors.New("leader not available")}
+		kafka    = NewKafkaWithProducer(config.Kafka{Topic: "k3_logs"}, producer)
+	)
+
+	if err := kafka.Send([]protocol.Data{{UUID: "1", IndexName: "index_a"}}); err == nil {
+		t.Errorf("producer error should be returned")
+	}
+}
+
+func TestNewKafka(t *testing.T) {
+	var (
+		kafkaConfig = config.Kafka{Brokers: []string{"127.0.0.1:9092"}, Topic: "k3_logs", TLS: config.KafkaTLS{Enable: true}}
+		producer    = &mockProducer{}
+		registered  *tls.Config
+	)
+
+	defer func() { NewKafkaProducer = nil }()
+
+	if _, err := NewKafka(kafkaConfig); err == nil {
+		t.Errorf("kafka without registered producer should return error")
+	}
+
+	NewKafkaProducer = func(cfg config.Kafka, tlsConfig *tls.Config) (KafkaProducer, error) {
+		registered = tlsConfig
+		return producer, nil
+	}
+
+	if _, err := NewKafka(config.Kafka{Brokers: kafkaConfig.Brokers}); err == nil {
+		t.Errorf("kafka without topic should return error")
+	}
+
+	kafka, err := NewKafka(kafkaConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered == nil {
+		t.Errorf("tls config should be passed to the producer")
+	}
+	if kafka.timeout.Seconds() != float64(DefaultKafkaTimeout) {
+		t.Errorf("default timeout should be %d seconds, got %v", DefaultKafkaTimeout, kafka.timeout)
+	}
+}
@@ -0,0 +1,229 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	DefaultLokiTimeout = 30 // 秒, 一次请求的超时时间
+	LokiPushPath       = "/loki/api/v1/push"
+	LokiJobLabel       = "job" // index_name对应的标签
+)
+
+// lokiPushRequest /loki/api/v1/push的json请求体
+type lokiPushRequest struct {
+	Streams []*lokiStream `json:"streams"`
+}
+
+// lokiStream 同一组标签的日志, values中每一项为[纳秒时间戳字符串, 日志内容]
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+
+	key        string // 标签排序后的字符串, 用于记录每个stream最后的时间戳
+	lastUnixNs int64  // 本批次中最后一条日志的时间戳
+}
+
+// LokiSender 一批日志按照标签(job=index_name和固定标签)分组为stream后推送到grafana loki
+// 同一个stream的时间戳严格递增, 日志时间不大于上一条时使用上一条的时间戳加1纳秒, 避免loki拒绝乱序的日志
+type LokiSender struct {
+	url      string
+	username string
+	password string
+	tenantID string
+	labels   map[string]string
+	client   *http.Client
+
+	lock       *sync.Mutex
+	lastUnixNs map[string]int64 // stream -> 已经推送成功的最后一条日志的时间戳
+}
+
+func NewLokiSender(lokiConfig config.LokiSender) (*LokiSender, error) {
+	var labels = make(map[string]string, len(lokiConfig.Labels))
+
+	if len(lokiConfig.URL) == 0 {
+		return nil, errors.New("[NewLokiSender] loki url is empty")
+	}
+
+	if lokiConfig.Timeout <= 0 {
+		lokiConfig.Timeout = DefaultLokiTimeout
+	}
+
+	// 值为空的标签loki不会记录, 直接忽略
+	for name, value := range lokiConfig.Labels {
+		if len(value) > 0 {
+			labels[name] = value
+		}
+	}
+
+	return &LokiSender{
+		url:        strings.TrimSuffix(lokiConfig.URL, "/") + LokiPushPath,
+		username:   lokiConfig.Username,
+		password:   lokiConfig.Password,
+		tenantID:   lokiConfig.TenantID,
+		labels:     labels,
+		client:     &http.Client{Timeout: time.Duration(lokiConfig.Timeout) * time.Second},
+		lock:       &sync.Mutex{},
+		lastUnixNs: make(map[string]int64),
+	}, nil
+}
+
+// streamLabels 一条日志的标签, job为index_name, 固定标签中的job会被覆盖
+func (l *LokiSender) streamLabels(data *protocol.Data) (map[string]string, string) {
+	var (
+		labels = make(map[string]string, len(l.labels)+1)
+		names  = make([]string, 0, len(l.labels)+1)
+		key    strings.Builder
+	)
+
+	for name, value := range l.labels {
+		labels[name] = value
+	}
+	labels[LokiJobLabel] = data.IndexName
+
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key.WriteString(name + "=" + strconv.Quote(labels[name]) + ",")
+	}
+
+	return labels, key.String()
+}
+
+// lokiLine 日志内容, 原始日志不存在时使用json格式的properties
+func lokiLine(data *protocol.Data) (string, error) {
+	if line, ok := data.Properties["_data"].(string); ok {
+		return line, nil
+	}
+
+	body, err := json.Marshal(data.Properties)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// buildStreams 按照标签分组, stream的顺序为第一条日志出现的顺序, 调用时需要持有lock
+func (l *LokiSender) buildStreams(datas []protocol.Data) []*lokiStream {
+	var (
+		streams []*lokiStream
+		indexes = make(map[string]*lokiStream)
+	)
+
+	for i := range datas {
+		line, err := lokiLine(&datas[i])
+		if err != nil {
+			k3.K3LogError("[LokiSender.Send] marshal data failed: %s, data: %s", err.Error(), datas[i].String())
+			continue
+		}
+
+		labels, key := l.streamLabels(&datas[i])
+		stream, ok := indexes[key]
+		if !ok {
+			stream = &lokiStream{Stream: labels, key: key, lastUnixNs: l.lastUnixNs[key]}
+			indexes[key] = stream
+			streams = append(streams, stream)
+		}
+
+		timestamp := datas[i].Timestamp
+		if timestamp.IsZero() {
+			timestamp = time.Now()
+		}
+
+		// 同一个stream的时间戳必须严格递增
+		unixNs := timestamp.UnixNano()
+		if unixNs <= stream.lastUnixNs {
+			unixNs = stream.lastUnixNs + 1
+		}
+		stream.lastUnixNs = unixNs
+
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(unixNs, 10), line})
+	}
+
+	return streams
+}
+
+// Send 非2xx响应返回错误, 由调用方决定是否重试
+func (l *LokiSender) Send(datas []protocol.Data) error {
+	return l.SendContext(context.Background(), datas)
+}
+
+// SendContext 与Send相同, ctx取消时正在进行的请求立即返回
+func (l *LokiSender) SendContext(ctx context.Context, datas []protocol.Data) error {
+	var (
+		streams []*lokiStream
+		body    []byte
+		request *http.Request
+		res     *http.Response
+		err     error
+	)
+
+	if len(datas) == 0 {
+		return nil
+	}
+
+	// 计算时间戳到推送成功之间持有锁, 保证同一个stream的时间戳在多个批次之间也是递增的
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if streams = l.buildStreams(datas); len(streams) == 0 {
+		return nil
+	}
+
+	if body, err = json.Marshal(lokiPushRequest{Streams: streams}); err != nil {
+		return errors.New("[LokiSender.Send] encode body failed: " + err.Error())
+	}
+
+	if request, err = http.NewRequestWithContext(ctx, http.MethodPost, l.url, bytes.NewReader(body)); err != nil {
+		return errors.New("[LokiSender.Send] create request failed: " + err.Error())
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	if len(l.username) > 0 {
+		request.SetBasicAuth(l.username, l.password)
+	}
+	if len(l.tenantID) > 0 {
+		request.Header.Set("X-Scope-OrgID", l.tenantID)
+	}
+
+	if res, err = l.client.Do(request); err != nil {
+		return errors.New("[LokiSender.Send] push failed: " + err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, int64(MaxHttpErrorBodySize)))
+		return fmt.Errorf("[LokiSender.Send] push %s failed: %s %s", l.url, res.Status, string(message))
+	}
+
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	for _, stream := range streams {
+		l.lastUnixNs[stream.key] = stream.lastUnixNs
+	}
+
+	return nil
+}
+
+// Close 每次发送都是同步完成的, 没有需要释放的资源, 可以重复调用
+func (l *LokiSender) Close() error {
+	l.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,154 @@
+package sender
+
+import (
+	"encoding/json"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// lokiRecorder 记录loki收到的推送请求
+type lokiRecorder struct {
+	path    string
+	header  http.Header
+	request lokiPushRequest
+}
+
+func newLokiServer(t *testing.T, status int, recorder *lokiRecorder) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.path = r.URL.Path
+		recorder.header = r.Header.Clone()
+		recorder.request = lokiPushRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&recorder.request); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func lokiTestData(indexName, line string, timestamp time.Time) protocol.Data {
+	return protocol.Data{
+		IndexName:  indexName,
+		Timestamp:  timestamp,
+		Properties: map[string]interface{}{"_data": line},
+	}
+}
+
+func TestLokiSenderStreams(t *testing.T) {
+	var (
+		recorder = &lokiRecorder{}
+		server   = newLokiServer(t, http.StatusNoContent, recorder)
+		now      = time.Unix(1700000000, 0)
+	)
+
+	loki, err := NewLokiSender(config.LokiSender{
+		URL:      server.URL + "/",
+		Username: "user",
+		Password: "secret",
+		TenantID: "tenant-a",
+		Labels:   map[string]string{"env": "prod", "empty": ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = loki.Send([]protocol.Data{
+		lokiTestData("index_nginx", "nginx 1", now),
+		lokiTestData("index_api", "api 1", now),
+		lokiTestData("index_nginx", "nginx 2", now.Add(time.Second)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.path != LokiPushPath {
+		t.Errorf("should push to %s, got %s", LokiPushPath, recorder.path)
+	}
+	if username, password, ok := (&http.Request{Header: recorder.header}).BasicAuth(); !ok || username != "user" || password != "secret" {
+		t.Errorf("basic auth should be sent, got %s %s", username, password)
+	}
+	if recorder.header.Get("X-Scope-OrgID") != "tenant-a" {
+		t.Errorf("tenant header should be sent, got %s", recorder.header.Get("X-Scope-OrgID"))
+	}
+
+	// 按照index_name分组, stream的顺序为第一条日志出现的顺序
+	streams := recorder.request.Streams
+	if len(streams) != 2 {
+		t.Fatalf("batch should be grouped into 2 streams, got %d", len(streams))
+	}
+	if streams[0].Stream["job"] != "index_nginx" || streams[0].Stream["env"] != "prod" || len(streams[0].Stream) != 2 {
+		t.Errorf("unexpected labels of the first stream %v", streams[0].Stream)
+	}
+	if streams[1].Stream["job"] != "index_api" {
+		t.Errorf("unexpected labels of the second stream %v", streams[1].Stream)
+	}
+
+	expected := [][2]string{
+		{strconv.FormatInt(now.UnixNano(), 10), "nginx 1"},
+		{strconv.FormatInt(now.Add(time.Second).UnixNano(), 10), "nginx 2"},
+	}
+	if len(streams[0].Values) != 2 || streams[0].Values[0] != expected[0] || streams[0].Values[1] != expected[1] {
+		t.Errorf("values should be nanosecond timestamps and lines, got %v", streams[0].Values)
+	}
+}
+
+func TestLokiSenderOutOfOrder(t *testing.T) {
+	var (
+		recorder = &lokiRecorder{}
+		status   = http.StatusInternalServerError
+		server   = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			recorder.request = lokiPushRequest{}
+			_ = json.NewDecoder(r.Body).Decode(&recorder.request)
+			w.WriteHeader(status)
+		}))
+		now = time.Unix(1700000000, 0)
+	)
+	defer server.Close()
+
+	loki, err := NewLokiSender(config.LokiSender{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 推送失败时不记录时间戳
+	if err = loki.Send([]protocol.Data{lokiTestData("index_nginx", "failed", now.Add(time.Hour))}); err == nil {
+		t.Fatal("non 2xx response should return error")
+	}
+
+	// 同一批次中时间相同或者更早的日志, 时间戳为上一条加1纳秒
+	status = http.StatusNoContent
+	if err = loki.Send([]protocol.Data{
+		lokiTestData("index_nginx", "line 1", now),
+		lokiTestData("index_nginx", "line 2", now),
+		lokiTestData("index_nginx", "line 3", now.Add(-time.Second)),
+		lokiTestData("index_nginx", "line 4", now.Add(time.Second)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var timestamps []int64
+	for _, value := range recorder.request.Streams[0].Values {
+		timestamp, _ := strconv.ParseInt(value[0], 10, 64)
+		timestamps = append(timestamps, timestamp)
+	}
+	expected := []int64{now.UnixNano(), now.UnixNano() + 1, now.UnixNano() + 2, now.Add(time.Second).UnixNano()}
+	for i := range expected {
+		if timestamps[i] != expected[i] {
+			t.Fatalf("timestamps should be strictly increasing %v, got %v", expected, timestamps)
+		}
+	}
+
+	// 之后的批次也不能早于已经推送的日志
+	if err = loki.Send([]protocol.Data{lokiTestData("index_nginx", "late", now)}); err != nil {
+		t.Fatal(err)
+	}
+	if value := recorder.request.Streams[0].Values[0][0]; value != strconv.FormatInt(now.Add(time.Second).UnixNano()+1, 10) {
+		t.Errorf("timestamp should be later than the previous batch, got %s", value)
+	}
+}
@@ -0,0 +1,288 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"io"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"sort"
+	"strings"
+)
+
+// mapping检查模式, 对应elk.mapping_check配置
+const (
+	MappingCheckAdvisory = "advisory" // 只告警, 不影响启动
+	MappingCheckStrict   = "strict"   // 存在冲突或者无法获取mapping时启动失败
+)
+
+// DefaultMappingFields agent发送的文档字段(protocol.ElasticSearchData)的期望类型, 嵌套字段使用.分隔
+var DefaultMappingFields = map[string]string{
+	"app_id":              "keyword",
+	"account_id":          "keyword",
+	"uuid":                "keyword",
+	"log_level":           "keyword",
+	"host_name":           "keyword",
+	"host_ip":             "keyword",
+	"trace_id":            "keyword",
+	"domain":              "keyword",
+	"protocol":            "keyword",
+	"http_code":           "long",
+	"client_ip":           "keyword",
+	"org":                 "keyword",
+	"project":             "keyword",
+	"code_name":           "keyword",
+	"log_src":             "keyword",
+	"event_id":            "long",
+	"event_name":          "keyword",
+	"@timestamp":          "date",
+	"@path":               "keyword",
+	"@offset":             "long",
+	"extend_data":         "object",
+	"extend_data.content": "object",
+}
+
+// compatibleMappingTypes 发送的字段类型 -> elk中可以接收该类型数据的mapping类型
+var compatibleMappingTypes = map[string][]string{
+	"object":  {"object", "nested", "flattened"},
+	"keyword": {"keyword", "text", "wildcard", "match_only_text", "constant_keyword", "ip"},
+	"text":    {"keyword", "text", "wildcard", "match_only_text", "constant_keyword"},
+	"long":    {"long", "integer", "short", "byte", "unsigned_long", "double", "float", "half_float", "scaled_float", "keyword", "text"},
+	"double":  {"double", "float", "half_float", "scaled_float", "keyword", "text"},
+	"date":    {"date", "date_nanos", "keyword", "text"},
+	"boolean": {"boolean", "keyword", "text"},
+	"ip":      {"ip", "keyword", "text"},
+}
+
+// MappingConflict 一个可能导致写入失败的字段
+type MappingConflict struct {
+	Index    string // 索引名或者索引模板名
+	Field    string // 字段路径, 嵌套字段使用.分隔
+	Expected string // agent发送的类型
+	Actual   string // elk中mapping的类型
+}
+
+func (m MappingConflict) String() string {
+	return fmt.Sprintf("index[%s] field[%s] is sent as %s but mapped as %s", m.Index, m.Field, m.Expected, m.Actual)
+}
+
+// fieldMapping elk mapping中的一个字段, 没有type但是有properties的字段为object
+type fieldMapping struct {
+	Type       string                  `json:"type"`
+	Properties map[string]fieldMapping `json:"properties"`
+}
+
+type indexMapping struct {
+	Mappings fieldMapping `json:"mappings"`
+}
+
+type indexTemplates struct {
+	IndexTemplates []struct {
+		Name          string `json:"name"`
+		IndexTemplate struct {
+			Template indexMapping `json:"template"`
+		} `json:"index_template"`
+	} `json:"index_templates"`
+}
+
+// MappingFields 默认的字段类型合并配置中的字段类型, 配置优先
+func MappingFields(fields map[string]string) map[string]string {
+	var (
+		merged = make(map[string]string, len(DefaultMappingFields)+len(fields))
+	)
+
+	for field, fieldType := range DefaultMappingFields {
+		merged[field] = fieldType
+	}
+
+	for field, fieldType := range fields {
+		merged[field] = strings.ToLower(fieldType)
+	}
+
+	return merged
+}
+
+// flattenMapping 将嵌套的mapping展开为 字段路径 -> 类型
+func flattenMapping(prefix string, mapping fieldMapping, fields map[string]string) {
+	for name, child := range mapping.Properties {
+		path := name
+		if len(prefix) > 0 {
+			path = prefix + "." + name
+		}
+
+		fieldType := child.Type
+		if len(fieldType) == 0 && child.Properties != nil {
+			fieldType = "object"
+		}
+		fields[path] = fieldType
+
+		flattenMapping(path, child, fields)
+	}
+}
+
+// isCompatibleMapping 发送的字段类型expected是否可以写入mapping类型为actual的字段
+func isCompatibleMapping(expected, actual string) bool {
+	if expected == actual {
+		return true
+	}
+
+	if types, ok := compatibleMappingTypes[expected]; ok {
+		return k3.InSlice(actual, types)
+	}
+
+	return false
+}
+
+// checkMappingFields 检查mapping中已经存在的字段, mapping中不存在的字段由elk动态创建, 不检查
+func checkMappingFields(index string, mapping fieldMapping, expected map[string]string) []MappingConflict {
+	var (
+		actual    = make(map[string]string)
+		conflicts []MappingConflict
+	)
+
+	flattenMapping("", mapping, actual)
+
+	for field, expectedType := range expected {
+		if actualType, ok := actual[field]; ok && !isCompatibleMapping(expectedType, actualType) {
+			conflicts = append(conflicts, MappingConflict{Index: index, Field: field, Expected: expectedType, Actual: actualType})
+		}
+	}
+
+	return conflicts
+}
+
+// readResponse 读取elk的响应内容, 响应状态码错误时返回错误
+func readResponse(res *esapi.Response) ([]byte, error) {
+	var (
+		body []byte
+		err  error
+	)
+
+	defer res.Body.Close()
+
+	if body, err = io.ReadAll(res.Body); err != nil {
+		return nil, err
+	}
+
+	if res.IsError() {
+		return nil, errors.New(res.Status() + " " + string(body))
+	}
+
+	return body, nil
+}
+
+// CheckMapping 获取indexNames(可以是通配符)和索引模板template的mapping, 返回与expected不兼容的字段
+func (e *ElasticSearchClient) CheckMapping(indexNames []string, template string, expected map[string]string) ([]MappingConflict, error) {
+	var (
+		allowNoIndices = true
+		res            *esapi.Response
+		body           []byte
+		mappings       map[string]indexMapping
+		templates      indexTemplates
+		conflicts      []MappingConflict
+		err            error
+	)
+
+	if len(indexNames) > 0 {
+		if res, err = (esapi.IndicesGetMappingRequest{
+			Index:             indexNames,
+			AllowNoIndices:    &allowNoIndices,
+			IgnoreUnavailable: &allowNoIndices,
+		}).Do(context.Background(), e.client); err != nil {
+			return nil, errors.New("[CheckMapping] get mapping failed: " + err.Error())
+		}
+
+		if body, err = readResponse(res); err != nil {
+			return nil, errors.New("[CheckMapping] get mapping failed: " + err.Error())
+		}
+
+		if err = json.Unmarshal(body, &mappings); err != nil {
+			return nil, errors.New("[CheckMapping] parse mapping failed: " + err.Error())
+		}
+
+		for index, mapping := range mappings {
+			conflicts = append(conflicts, checkMappingFields(index, mapping.Mappings, expected)...)
+		}
+	}
+
+	if len(template) > 0 {
+		if res, err = (esapi.IndicesGetIndexTemplateRequest{Name: template}).Do(context.Background(), e.client); err != nil {
+			return nil, errors.New("[CheckMapping] get index template failed: " + err.Error())
+		}
+
+		if body, err = readResponse(res); err != nil {
+			return nil, errors.New("[CheckMapping] get index template failed: " + err.Error())
+		}
+
+		if err = json.Unmarshal(body, &templates); err != nil {
+			return nil, errors.New("[CheckMapping] parse index template failed: " + err.Error())
+		}
+
+		for _, t := range templates.IndexTemplates {
+			conflicts = append(conflicts, checkMappingFields(t.Name, t.IndexTemplate.Template.Mappings, expected)...)
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Index != conflicts[j].Index {
+			return conflicts[i].Index < conflicts[j].Index
+		}
+		return conflicts[i].Field < conflicts[j].Field
+	})
+
+	return conflicts, nil
+}
+
+// VerifyMapping 按照elk.mapping_check配置检查indexNames对应的索引mapping
+// advisory模式只记录告警日志; strict模式存在冲突或者无法获取mapping时返回错误, 启动失败
+func (e *ElasticSearchClient) VerifyMapping(indexNames []string) error {
+	var (
+		mode      = config.GlobalConfig.ELK.MappingCheck
+		patterns  []string
+		conflicts []MappingConflict
+		err       error
+	)
+
+	if len(mode) == 0 {
+		return nil
+	}
+
+	if mode != MappingCheckAdvisory && mode != MappingCheckStrict {
+		return errors.New("[VerifyMapping] unsupported mapping_check: " + mode)
+	}
+
+	if len(config.GlobalConfig.ELK.DefaultIndexName) > 0 && !k3.InSlice(config.GlobalConfig.ELK.DefaultIndexName, indexNames) {
+		indexNames = append(indexNames, config.GlobalConfig.ELK.DefaultIndexName)
+	}
+
+	// 使用日期后缀时, 检查所有日期的索引
+	for _, indexName := range indexNames {
+		if len(config.GlobalConfig.ELK.IndexDatePattern) > 0 {
+			indexName = indexName + "-*"
+		} else if config.GlobalConfig.ELK.IsUseSuffixDate {
+			indexName = indexName + "_*"
+		}
+		patterns = append(patterns, indexName)
+	}
+
+	if conflicts, err = e.CheckMapping(patterns, config.GlobalConfig.ELK.MappingTemplate, MappingFields(config.GlobalConfig.ELK.MappingFields)); err != nil {
+		if mode == MappingCheckStrict {
+			return err
+		}
+		k3.K3LogWarn("[VerifyMapping] %s", err.Error())
+		return nil
+	}
+
+	for _, conflict := range conflicts {
+		k3.K3LogWarn("[VerifyMapping] mapping conflict: %s", conflict.String())
+	}
+
+	if len(conflicts) > 0 && mode == MappingCheckStrict {
+		return fmt.Errorf("[VerifyMapping] %d mapping conflicts found, first: %s", len(conflicts), conflicts[0].String())
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package sender
+
+import (
+	"log-engine-sdk/pkg/k3/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const fakeMappingResponse = `{
+	"index_nginx_20241001": {
+		"mappings": {
+			"properties": {
+				"@timestamp": {"type": "date"},
+				"http_code": {"type": "keyword"},
+				"event_id": {"type": "boolean"},
+				"extend_data": {"type": "keyword"},
+				"host_name": {"type": "text", "fields": {"keyword": {"type": "keyword"}}}
+			}
+		}
+	},
+	"index_nginx_20241002": {
+		"mappings": {
+			"properties": {
+				"extend_data": {
+					"properties": {
+						"content": {"type": "keyword"}
+					}
+				}
+			}
+		}
+	}
+}`
+
+const fakeTemplateResponse = `{
+	"index_templates": [{
+		"name": "k3_template",
+		"index_template": {
+			"template": {
+				"mappings": {
+					"properties": {
+						"log_level": {"type": "long"}
+					}
+				}
+			}
+		}
+	}]
+}`
+
+// newFakeElasticsearch 返回固定_mapping和_index_template响应的elk服务
+func newFakeElasticsearch(t *testing.T, status int, requests *[]string) *ElasticSearchClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests != nil {
+			*requests = append(*requests, r.URL.Path)
+		}
+
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+
+		switch {
+		case status != http.StatusOK:
+			_, _ = w.Write([]byte(`{"error": "unavailable"}`))
+		case strings.HasSuffix(r.URL.Path, "/_mapping"):
+			_, _ = w.Write([]byte(fakeMappingResponse))
+		case strings.HasPrefix(r.URL.Path, "/_index_template/"):
+			_, _ = w.Write([]byte(fakeTemplateResponse))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewElasticsearchWithConfig(config.ELK{Address: []string{server.URL}, MaxChannelSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+func TestCheckMapping(t *testing.T) {
+	var (
+		client = newFakeElasticsearch(t, http.StatusOK, nil)
+	)
+
+	conflicts, err := client.CheckMapping([]string{"index_nginx_*"}, "k3_template", MappingFields(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// http_code 发送数字, keyword可以接收; host_name 发送字符串, text可以接收
+	var expected = []string{
+		"index[index_nginx_20241001] field[event_id] is sent as long but mapped as boolean",
+		"index[index_nginx_20241001] field[extend_data] is sent as object but mapped as keyword",
+		"index[index_nginx_20241002] field[extend_data.content] is sent as object but mapped as keyword",
+		"index[k3_template] field[log_level] is sent as keyword but mapped as long",
+	}
+
+	if len(conflicts) != len(expected) {
+		t.Fatalf("expected %d conflicts, got %v", len(expected), conflicts)
+	}
+
+	for i := range expected {
+		if conflicts[i].String() != expected[i] {
+			t.Errorf("conflict %d expected %q, got %q", i, expected[i], conflicts[i].String())
+		}
+	}
+
+	// 配置的字段类型覆盖默认值
+	conflicts, err = client.CheckMapping([]string{"index_nginx_*"}, "", MappingFields(map[string]string{
+		"event_id": "Boolean",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(conflicts) != 2 || conflicts[0].Field != "extend_data" || conflicts[1].Field != "extend_data.content" {
+		t.Errorf("override field type should be compatible, got %v", conflicts)
+	}
+}
+
+func TestVerifyMapping(t *testing.T) {
+	var (
+		requests []string
+		client   = newFakeElasticsearch(t, http.StatusOK, &requests)
+	)
+	defer func() {
+		config.GlobalConfig.ELK = config.ELK{}
+	}()
+
+	// 默认不检查
+	if err := client.VerifyMapping([]string{"index_nginx"}); err != nil || len(requests) != 0 {
+		t.Fatalf("mapping check should be disabled, got %v, %v", err, requests)
+	}
+
+	config.GlobalConfig.ELK.IsUseSuffixDate = true
+	config.GlobalConfig.ELK.MappingCheck = MappingCheckAdvisory
+	if err := client.VerifyMapping([]string{"index_nginx"}); err != nil {
+		t.Errorf("advisory mode should not return error, got %v", err)
+	}
+
+	if len(requests) != 1 || requests[0] != "/index_nginx_*/_mapping" {
+		t.Errorf("should get mapping of index_nginx_*, got %v", requests)
+	}
+
+	config.GlobalConfig.ELK.MappingCheck = MappingCheckStrict
+	if err := client.VerifyMapping([]string{"index_nginx"}); err == nil || !strings.Contains(err.Error(), "3 mapping conflicts") {
+		t.Errorf("strict mode should return conflicts error, got %v", err)
+	}
+
+	config.GlobalConfig.ELK.MappingCheck = "unknown"
+	if err := client.VerifyMapping([]string{"index_nginx"}); err == nil {
+		t.Errorf("unsupported mapping_check should return error")
+	}
+}
+
+func TestVerifyMappingUnavailable(t *testing.T) {
+	var (
+		client = newFakeElasticsearch(t, http.StatusInternalServerError, nil)
+	)
+	defer func() {
+		config.GlobalConfig.ELK = config.ELK{}
+	}()
+
+	config.GlobalConfig.ELK.MappingCheck = MappingCheckAdvisory
+	if err := client.VerifyMapping([]string{"index_nginx"}); err != nil {
+		t.Errorf("advisory mode should ignore unavailable elk, got %v", err)
+	}
+
+	config.GlobalConfig.ELK.MappingCheck = MappingCheckStrict
+	if err := client.VerifyMapping([]string{"index_nginx"}); err == nil {
+		t.Errorf("strict mode should return error when mapping is unavailable")
+	}
+}
@@ -0,0 +1,48 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log-engine-sdk/pkg/k3/protocol"
+)
+
+// MultiSender 同一批日志发送到所有的目标, 一个目标失败不影响其他目标接收数据
+type MultiSender struct {
+	senders []protocol.Sender
+}
+
+func NewMultiSender(senders ...protocol.Sender) *MultiSender {
+	return &MultiSender{senders: senders}
+}
+
+// Send 所有目标都会发送, 返回所有失败目标的错误
+func (m *MultiSender) Send(data []protocol.Data) error {
+	return m.SendContext(context.Background(), data)
+}
+
+// SendContext 与Send相同, ctx传递给每个目标
+func (m *MultiSender) SendContext(ctx context.Context, data []protocol.Data) error {
+	var errs []error
+
+	for i, s := range m.senders {
+		if err := protocol.SendContext(ctx, s, data); err != nil {
+			errs = append(errs, fmt.Errorf("[MultiSender.Send] sender %d: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close 关闭所有目标, 返回所有关闭失败的错误
+func (m *MultiSender) Close() error {
+	var errs []error
+
+	for i, s := range m.senders {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("[MultiSender.Close] sender %d: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,61 @@
+package sender
+
+import (
+	"errors"
+	"log-engine-sdk/pkg/k3/protocol"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// closeFailedSender 关闭失败的sender
+type closeFailedSender struct {
+	flakySender
+}
+
+func (c *closeFailedSender) Close() error {
+	return errors.New("close failed")
+}
+
+func TestMultiSender(t *testing.T) {
+	var (
+		first  = &flakySender{}
+		second = &flakySender{}
+		multi  = NewMultiSender(first, second)
+		datas  = []protocol.Data{{UUID: "1", IndexName: "index_a"}, {UUID: "2", IndexName: "index_a"}}
+	)
+
+	if err := multi.Send(datas); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(first.delivered, [][]protocol.Data{datas}) || !reflect.DeepEqual(first.delivered, second.delivered) {
+		t.Errorf("both senders should receive the identical batch, got %v and %v", first.delivered, second.delivered)
+	}
+
+	if err := multi.Close(); err != nil || !first.closed || !second.closed {
+		t.Errorf("close should close all senders, err: %v", err)
+	}
+}
+
+func TestMultiSenderPartialFailure(t *testing.T) {
+	var (
+		failed = &closeFailedSender{flakySender{failures: 1}}
+		ok     = &flakySender{}
+		multi  = NewMultiSender(failed, ok)
+		datas  = []protocol.Data{{UUID: "1", IndexName: "index_a"}}
+	)
+
+	err := multi.Send(datas)
+	if err == nil || !strings.Contains(err.Error(), "sender 0") || !strings.Contains(err.Error(), "elk unavailable") {
+		t.Errorf("partial failure should be reported, got %v", err)
+	}
+
+	if len(ok.delivered) != 1 {
+		t.Errorf("a failing sender should not prevent the others from receiving data")
+	}
+
+	if err = multi.Close(); err == nil || !ok.closed {
+		t.Errorf("close should close all senders and report the failed one, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package sender
+
+import (
+	"errors"
+	"github.com/elastic/elastic-transport-go/v8/elastictransport"
+	"log-engine-sdk/pkg/k3"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	DefaultNodeCooldown = 60 // 秒, 节点连接失败后, 超过该时间再重新尝试
+)
+
+// nodePool elk节点的连接池, 请求轮流发送到健康的节点, 连接失败的节点在冷却时间内不再发送
+// 所有节点都不健康时, 使用最早失败的节点, 避免完全停止写入
+type nodePool struct {
+	lock     sync.Mutex
+	conns    []*elastictransport.Connection
+	cooldown time.Duration
+	current  int
+}
+
+func newNodePool(conns []*elastictransport.Connection, cooldown time.Duration) *nodePool {
+	if cooldown <= 0 {
+		cooldown = time.Duration(DefaultNodeCooldown) * time.Second
+	}
+
+	return &nodePool{conns: conns, cooldown: cooldown, current: -1}
+}
+
+// available 节点健康或者已经过了冷却时间
+func (p *nodePool) available(conn *elastictransport.Connection, now time.Time) bool {
+	conn.Lock()
+	defer conn.Unlock()
+
+	return !conn.IsDead || now.Sub(conn.DeadSince) >= p.cooldown
+}
+
+func (p *nodePool) Next() (*elastictransport.Connection, error) {
+	var (
+		now    = time.Now()
+		oldest *elastictransport.Connection
+	)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(p.conns) == 0 {
+		return nil, errors.New("[nodePool] no elasticsearch node")
+	}
+
+	for i := 0; i < len(p.conns); i++ {
+		p.current = (p.current + 1) % len(p.conns)
+		if conn := p.conns[p.current]; p.available(conn, now) {
+			return conn, nil
+		}
+	}
+
+	for _, conn := range p.conns {
+		if oldest == nil || conn.DeadSince.Before(oldest.DeadSince) {
+			oldest = conn
+		}
+	}
+
+	return oldest, nil
+}
+
+func (p *nodePool) OnSuccess(conn *elastictransport.Connection) error {
+	conn.Lock()
+	defer conn.Unlock()
+
+	if conn.IsDead {
+		k3.K3LogInfo("[nodePool] elasticsearch node %s is healthy again.", conn.URL.String())
+	}
+	conn.IsDead = false
+	conn.Failures = 0
+
+	return nil
+}
+
+func (p *nodePool) OnFailure(conn *elastictransport.Connection) error {
+	conn.Lock()
+	defer conn.Unlock()
+
+	if !conn.IsDead {
+		k3.K3LogWarn("[nodePool] elasticsearch node %s is unhealthy, retry after %v.", conn.URL.String(), p.cooldown)
+	}
+	conn.IsDead = true
+	conn.DeadSince = time.Now()
+	conn.Failures++
+
+	return nil
+}
+
+func (p *nodePool) URLs() []*url.URL {
+	var urls = make([]*url.URL, 0, len(p.conns))
+
+	for _, conn := range p.conns {
+		urls = append(urls, conn.URL)
+	}
+
+	return urls
+}
@@ -0,0 +1,85 @@
+package sender
+
+import (
+	"github.com/elastic/elastic-transport-go/v8/elastictransport"
+	"log-engine-sdk/pkg/k3/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestElasticSearchNodeFailover(t *testing.T) {
+	var (
+		requests int32
+		healthy  = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.Header().Set("X-Elastic-Product", "Elasticsearch")
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+		}))
+		// 关闭后的地址连接被拒绝
+		down = httptest.NewServer(http.NotFoundHandler())
+	)
+	defer healthy.Close()
+	down.Close()
+
+	client, err := NewElasticsearchWithConfig(config.ELK{Address: []string{down.URL, healthy.URL}, NodeCooldown: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if err = client.Send(bulkTestData("1")); err != nil {
+			t.Fatalf("send %d should fail over to the healthy node: %s", i, err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Errorf("all requests should reach the healthy node, got %d", n)
+	}
+}
+
+func TestNodePoolCooldown(t *testing.T) {
+	var (
+		first  = &elastictransport.Connection{URL: &url.URL{Scheme: "http", Host: "node1:9200"}}
+		second = &elastictransport.Connection{URL: &url.URL{Scheme: "http", Host: "node2:9200"}}
+		pool   = newNodePool([]*elastictransport.Connection{first, second}, time.Minute)
+	)
+
+	// 轮流发送
+	if conn, _ := pool.Next(); conn != first {
+		t.Errorf("first node expected")
+	}
+	if conn, _ := pool.Next(); conn != second {
+		t.Errorf("second node expected")
+	}
+
+	// 连接失败的节点冷却时间内不再使用
+	_ = pool.OnFailure(first)
+	for i := 0; i < 3; i++ {
+		if conn, _ := pool.Next(); conn != second {
+			t.Errorf("unhealthy node should be skipped during cooldown")
+		}
+	}
+
+	// 所有节点都失败时, 使用最早失败的节点
+	_ = pool.OnFailure(second)
+	first.DeadSince = time.Now().Add(-30 * time.Second)
+	if conn, _ := pool.Next(); conn != first {
+		t.Errorf("the earliest failed node should be used when all nodes are unhealthy")
+	}
+
+	// 冷却时间过后重新尝试, 成功后恢复
+	first.DeadSince = time.Now().Add(-2 * time.Minute)
+	conn, _ := pool.Next()
+	if conn != first {
+		t.Fatalf("node should be retried after cooldown")
+	}
+	_ = pool.OnSuccess(first)
+	if first.IsDead {
+		t.Errorf("node should be healthy after success")
+	}
+}
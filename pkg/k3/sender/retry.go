@@ -0,0 +1,108 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/protocol"
+	"math/rand"
+	"time"
+)
+
+var (
+	DefaultRetryMaxDelay = 30 * time.Second // 指数退避的最长等待时间
+)
+
+// RetrySender 发送失败时按照指数退避加随机抖动重试, 重试次数用完后返回最后一次的错误
+// 返回BulkError时只重试其中失败的数据
+type RetrySender struct {
+	inner      protocol.Sender
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// NewRetrySender 包装任意sender, maxRetries为失败后最多重试的次数, baseDelay为第一次重试前的等待时间
+func NewRetrySender(inner protocol.Sender, maxRetries int, baseDelay time.Duration) *RetrySender {
+	return NewRetrySenderWithContext(context.Background(), inner, maxRetries, baseDelay)
+}
+
+// NewRetrySenderWithContext ctx取消后不再重试, 正在等待的重试立即返回, 不阻塞退出
+func NewRetrySenderWithContext(ctx context.Context, inner protocol.Sender, maxRetries int, baseDelay time.Duration) *RetrySender {
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+
+	if baseDelay <= 0 {
+		baseDelay = time.Duration(DefaultRetryInterval) * time.Second
+	}
+
+	r := &RetrySender{
+		inner:      inner,
+		maxRetries: maxRetries,
+		baseDelay:  baseDelay,
+		maxDelay:   DefaultRetryMaxDelay,
+	}
+	r.ctx, r.cancel = context.WithCancel(ctx)
+
+	return r
+}
+
+// backoff 第attempt次重试前的等待时间, 在[delay/2, delay]之间随机, 避免多个agent同时重试
+func (r *RetrySender) backoff(attempt int) time.Duration {
+	delay := r.baseDelay << uint(attempt)
+	if delay <= 0 || delay > r.maxDelay {
+		delay = r.maxDelay
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (r *RetrySender) Send(data []protocol.Data) error {
+	return r.SendContext(context.Background(), data)
+}
+
+// SendContext 与Send相同, ctx传递给被包装的sender, ctx取消时不再重试
+func (r *RetrySender) SendContext(ctx context.Context, data []protocol.Data) error {
+	var (
+		err       error
+		bulkError *BulkError
+		timer     *time.Timer
+	)
+
+	for attempt := 0; ; attempt++ {
+		if err = protocol.SendContext(ctx, r.inner, data); err == nil {
+			return nil
+		}
+
+		// 只有部分数据发送失败时, 只重试失败的数据
+		if errors.As(err, &bulkError) {
+			data = bulkError.Failed
+		}
+
+		if attempt >= r.maxRetries {
+			return err
+		}
+
+		k3.K3LogWarn("[RetrySender.Send] send %d data failed, retry %d/%d: %s", len(data), attempt+1, r.maxRetries, err.Error())
+
+		timer = time.NewTimer(r.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-r.ctx.Done():
+			timer.Stop()
+			return errors.New("[RetrySender.Send] retry canceled: " + err.Error())
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.New("[RetrySender.Send] retry canceled: " + err.Error())
+		}
+	}
+}
+
+// Close 取消正在等待的重试, 再关闭被包装的sender
+func (r *RetrySender) Close() error {
+	r.cancel()
+	return r.inner.Close()
+}
@@ -0,0 +1,110 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/protocol"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakySender 前failures次发送失败, 之后发送成功
+type flakySender struct {
+	lock      sync.Mutex
+	failures  int
+	attempts  int
+	delivered [][]protocol.Data
+	closed    bool
+}
+
+func (f *flakySender) Send(data []protocol.Data) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.attempts++
+	if f.attempts <= f.failures {
+		return errors.New("elk unavailable")
+	}
+	f.delivered = append(f.delivered, append([]protocol.Data(nil), data...))
+	return nil
+}
+
+func (f *flakySender) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestRetrySenderThirdAttempt(t *testing.T) {
+	var (
+		inner = &flakySender{failures: 2}
+		retry = NewRetrySender(inner, 3, time.Millisecond)
+	)
+
+	consumer, err := k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{Sender: retry, BatchSize: 3, CacheCapacity: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, uuid := range []string{"1", "2", "3"} {
+		if err = consumer.Add(protocol.Data{UUID: uuid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if inner.attempts != 3 {
+		t.Errorf("send should succeed on the third attempt, got %d attempts", inner.attempts)
+	}
+
+	if len(inner.delivered) != 1 || len(inner.delivered[0]) != 3 {
+		t.Fatalf("exactly one batch of 3 data should be delivered, got %v", inner.delivered)
+	}
+
+	if err = consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !inner.closed {
+		t.Errorf("close should close the inner sender")
+	}
+}
+
+func TestRetrySenderExhausted(t *testing.T) {
+	var (
+		inner = &flakySender{failures: 10}
+		retry = NewRetrySender(inner, 2, time.Millisecond)
+	)
+
+	if err := retry.Send([]protocol.Data{{UUID: "1"}}); err == nil || err.Error() != "elk unavailable" {
+		t.Errorf("last error should be returned after retries, got %v", err)
+	}
+
+	if inner.attempts != 3 {
+		t.Errorf("1 send and 2 retries expected, got %d attempts", inner.attempts)
+	}
+}
+
+func TestRetrySenderCanceled(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		inner       = &flakySender{failures: 10}
+		retry       = NewRetrySenderWithContext(ctx, inner, 5, time.Hour)
+		done        = make(chan error)
+	)
+
+	go func() {
+		done <- retry.Send([]protocol.Data{{UUID: "1"}})
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("canceled retry should return error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cancel should stop the waiting retry")
+	}
+}
@@ -0,0 +1,90 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log-engine-sdk/pkg/k3/protocol"
+	"sort"
+)
+
+// RouteSender 按照日志的index_name将一批日志分组, 发送到index_name绑定的sender, 没有绑定的发送到fallback
+// 一个sender失败不影响其他sender接收数据, 返回的错误使整批日志重新发送, 已经成功的sender可能收到重复的数据
+type RouteSender struct {
+	fallback protocol.Sender
+	senders  map[string]protocol.Sender // sender名称 -> sender
+	routes   map[string]string          // index_name -> sender名称
+}
+
+// NewRouteSender 多个index_name可以绑定同一个sender, 绑定的sender名称不存在于senders中时发送到fallback
+func NewRouteSender(fallback protocol.Sender, senders map[string]protocol.Sender, routes map[string]string) *RouteSender {
+	return &RouteSender{fallback: fallback, senders: senders, routes: routes}
+}
+
+// senderName index_name绑定的sender名称, 没有绑定时为空, 发送到fallback
+func (r *RouteSender) senderName(indexName string) string {
+	if name := r.routes[indexName]; r.senders[name] != nil {
+		return name
+	}
+	return ""
+}
+
+// Send 分组后依次发送, 同一个sender中日志的顺序与原来相同, 返回所有失败sender的错误
+func (r *RouteSender) Send(data []protocol.Data) error {
+	return r.SendContext(context.Background(), data)
+}
+
+// SendContext 与Send相同, ctx传递给每个sender
+func (r *RouteSender) SendContext(ctx context.Context, data []protocol.Data) error {
+	var (
+		groups = make(map[string][]protocol.Data)
+		names  []string
+		errs   []error
+	)
+
+	for _, d := range data {
+		name := r.senderName(d.IndexName)
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], d)
+	}
+
+	for _, name := range names {
+		s := r.fallback
+		if len(name) > 0 {
+			s = r.senders[name]
+		}
+
+		if err := protocol.SendContext(ctx, s, groups[name]); err != nil {
+			errs = append(errs, fmt.Errorf("[RouteSender.Send] sender %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close 关闭fallback和所有命名的sender, 返回所有关闭失败的错误
+func (r *RouteSender) Close() error {
+	var (
+		errs  []error
+		names = make([]string, 0, len(r.senders))
+	)
+
+	if err := r.fallback.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("[RouteSender.Close] fallback sender: %w", err))
+	}
+
+	for name := range r.senders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := r.senders[name].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("[RouteSender.Close] sender %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
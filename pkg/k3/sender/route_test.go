@@ -0,0 +1,65 @@
+package sender
+
+import (
+	"log-engine-sdk/pkg/k3/protocol"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRouteSender(t *testing.T) {
+	var (
+		fallback = &flakySender{}
+		audit    = &flakySender{}
+		route    = NewRouteSender(fallback, map[string]protocol.Sender{"audit": audit}, map[string]string{"index_audit": "audit", "index_login": "audit", "index_lost": "missing"})
+		datas    = []protocol.Data{
+			{UUID: "1", IndexName: "index_nginx"},
+			{UUID: "2", IndexName: "index_audit"},
+			{UUID: "3", IndexName: "index_lost"},
+			{UUID: "4", IndexName: "index_login"},
+			{UUID: "5", IndexName: "index_audit"},
+		}
+	)
+
+	if err := route.Send(datas); err != nil {
+		t.Fatal(err)
+	}
+
+	// 绑定的index_name发送到对应的sender, 保持原来的顺序; 没有绑定或者绑定的sender不存在时发送到fallback
+	if expected := [][]protocol.Data{{datas[1], datas[3], datas[4]}}; !reflect.DeepEqual(audit.delivered, expected) {
+		t.Errorf("audit sender expected %v, got %v", expected, audit.delivered)
+	}
+	if expected := [][]protocol.Data{{datas[0], datas[2]}}; !reflect.DeepEqual(fallback.delivered, expected) {
+		t.Errorf("fallback sender expected %v, got %v", expected, fallback.delivered)
+	}
+
+	// 只有fallback的日志时, 不调用其他sender
+	if err := route.Send(datas[:1]); err != nil || len(audit.delivered) != 1 {
+		t.Errorf("audit sender should not receive unrelated batches, got %v, %v", audit.delivered, err)
+	}
+
+	if err := route.Close(); err != nil || !fallback.closed || !audit.closed {
+		t.Errorf("close should close all senders, err: %v", err)
+	}
+}
+
+func TestRouteSenderPartialFailure(t *testing.T) {
+	var (
+		fallback = &flakySender{}
+		audit    = &closeFailedSender{flakySender{failures: 1}}
+		route    = NewRouteSender(fallback, map[string]protocol.Sender{"audit": audit}, map[string]string{"index_audit": "audit"})
+		datas    = []protocol.Data{{UUID: "1", IndexName: "index_audit"}, {UUID: "2", IndexName: "index_nginx"}}
+	)
+
+	err := route.Send(datas)
+	if err == nil || !strings.Contains(err.Error(), `sender "audit"`) {
+		t.Errorf("failure of the audit sender should be reported, got %v", err)
+	}
+	if len(fallback.delivered) != 1 {
+		t.Errorf("a failing sender should not prevent the others from receiving data")
+	}
+
+	if err = route.Close(); err == nil || !fallback.closed {
+		t.Errorf("close should close all senders and report the failed one, got %v", err)
+	}
+}
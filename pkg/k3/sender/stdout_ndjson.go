@@ -0,0 +1,64 @@
+package sender
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/protocol"
+	"os"
+	"sync"
+)
+
+// StdoutNDJSON 每条日志输出为一行json(ndjson), 便于通过管道交给jq等工具处理, 用于本地调试
+// 与Default不同, Default将一批日志输出为一个json数组
+type StdoutNDJSON struct {
+	writer io.Writer
+	lock   *sync.Mutex
+}
+
+// NewStdoutNDJSON writer为nil时输出到标准输出
+func NewStdoutNDJSON(writer io.Writer) *StdoutNDJSON {
+	if writer == nil {
+		writer = os.Stdout
+	}
+
+	return &StdoutNDJSON{
+		writer: writer,
+		lock:   &sync.Mutex{},
+	}
+}
+
+// Send 一批日志编码后一次写入, 多个协程同时发送时不同批次的行不会交错
+func (s *StdoutNDJSON) Send(datas []protocol.Data) error {
+	var (
+		buffer  bytes.Buffer
+		encoder = json.NewEncoder(&buffer)
+	)
+
+	for i := range datas {
+		// Encode每条日志以换行结尾
+		if err := encoder.Encode(datas[i]); err != nil {
+			k3.K3LogError("[StdoutNDJSON.Send] marshal data failed: %s, data: %s", err.Error(), datas[i].String())
+		}
+	}
+
+	if buffer.Len() == 0 {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.writer.Write(buffer.Bytes()); err != nil {
+		return errors.New("[StdoutNDJSON.Send] write failed: " + err.Error())
+	}
+
+	return nil
+}
+
+// Close 不关闭writer, 标准输出由进程管理
+func (s *StdoutNDJSON) Close() error {
+	return nil
+}
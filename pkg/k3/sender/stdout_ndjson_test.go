@@ -0,0 +1,45 @@
+package sender
+
+import (
+	"bytes"
+	"encoding/json"
+	"log-engine-sdk/pkg/k3/protocol"
+	"strings"
+	"testing"
+)
+
+func TestStdoutNDJSON(t *testing.T) {
+	var (
+		output bytes.Buffer
+		s      = NewStdoutNDJSON(&output)
+		datas  = []protocol.Data{
+			{UUID: "1", IndexName: "index_test", Properties: map[string]interface{}{"_data": "line 1"}},
+			{UUID: "2", IndexName: "index_test", Properties: map[string]interface{}{"_data": "line\n2"}},
+		}
+	)
+
+	if err := s.Send(datas); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(datas[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 每行一个json对象, 日志内容中的换行被转义
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("3 lines expected, got %d: %q", len(lines), output.String())
+	}
+	for i, expected := range []string{"1", "2", "1"} {
+		var data protocol.Data
+		if err := json.Unmarshal([]byte(lines[i]), &data); err != nil {
+			t.Fatalf("line %d should be a json object: %s", i, err)
+		}
+		if data.UUID != expected {
+			t.Errorf("line %d: uuid %s expected, got %s", i, expected, data.UUID)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package sender
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sender.syslog.network配置
+const (
+	SyslogNetworkTCP = "tcp"
+	SyslogNetworkUDP = "udp"
+	SyslogNetworkTLS = "tls"
+)
+
+// sender.syslog.framing配置, 只对tcp/tls生效
+const (
+	SyslogFramingOctetCounting  = "octet_counting"  // RFC6587 3.4.1, 消息前加上长度和空格
+	SyslogFramingNonTransparent = "non_transparent" // RFC6587 3.4.2, 消息以换行结尾
+)
+
+var (
+	DefaultSyslogTimeout  = 30 // 秒, 连接和一批消息写入的超时时间
+	DefaultSyslogFacility = "local0"
+	DefaultSyslogAppName  = "k3"
+	SyslogSeverity        = 6 // informational, 日志内容的级别由接收方解析
+)
+
+// syslogFacilities RFC5424 facility名称 -> 编号
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogSender 每条日志作为一条RFC5424 syslog消息发送到syslog服务(如rsyslog)
+// 连接在第一次发送时建立, 写入失败时关闭连接, 重新连接后重试一次
+type SyslogSender struct {
+	network   string
+	address   string
+	priority  int
+	appName   string
+	framing   string
+	timeout   time.Duration
+	tlsConfig *tls.Config
+	conn      net.Conn
+	lock      *sync.Mutex
+	closed    bool
+}
+
+func NewSyslogSender(syslogConfig config.SyslogSender) (*SyslogSender, error) {
+	var (
+		facility  int
+		ok        bool
+		tlsConfig *tls.Config
+		err       error
+	)
+
+	if len(syslogConfig.Address) == 0 {
+		return nil, errors.New("[NewSyslogSender] syslog address is empty")
+	}
+
+	switch syslogConfig.Network {
+	case "":
+		syslogConfig.Network = SyslogNetworkTCP
+	case SyslogNetworkTCP, SyslogNetworkUDP, SyslogNetworkTLS:
+	default:
+		return nil, errors.New("[NewSyslogSender] unsupported syslog network: " + syslogConfig.Network)
+	}
+
+	switch syslogConfig.Framing {
+	case "":
+		syslogConfig.Framing = SyslogFramingOctetCounting
+	case SyslogFramingOctetCounting, SyslogFramingNonTransparent:
+	default:
+		return nil, errors.New("[NewSyslogSender] unsupported syslog framing: " + syslogConfig.Framing)
+	}
+
+	if len(syslogConfig.Facility) == 0 {
+		syslogConfig.Facility = DefaultSyslogFacility
+	}
+	if facility, ok = syslogFacilities[syslogConfig.Facility]; !ok {
+		return nil, errors.New("[NewSyslogSender] unknown syslog facility: " + syslogConfig.Facility)
+	}
+
+	if len(syslogConfig.AppName) == 0 {
+		syslogConfig.AppName = DefaultSyslogAppName
+	}
+
+	if syslogConfig.Timeout <= 0 {
+		syslogConfig.Timeout = DefaultSyslogTimeout
+	}
+
+	if syslogConfig.Network == SyslogNetworkTLS {
+		syslogConfig.TLS.Enable = true
+		if tlsConfig, err = newKafkaTLSConfig(syslogConfig.TLS); err != nil {
+			return nil, errors.New("[NewSyslogSender] load tls config failed: " + err.Error())
+		}
+	}
+
+	return &SyslogSender{
+		network:   syslogConfig.Network,
+		address:   syslogConfig.Address,
+		priority:  facility*8 + SyslogSeverity,
+		appName:   syslogHeaderField(syslogConfig.AppName, 48),
+		framing:   syslogConfig.Framing,
+		timeout:   time.Duration(syslogConfig.Timeout) * time.Second,
+		tlsConfig: tlsConfig,
+		lock:      &sync.Mutex{},
+	}, nil
+}
+
+// syslogHeaderField header中的字段只能是可见的ascii字符, 超过maxLen截断, 为空时使用NILVALUE(-)
+func syslogHeaderField(value string, maxLen int) string {
+	var field = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+
+	if len(field) > maxLen {
+		field = field[:maxLen]
+	}
+	if len(field) == 0 {
+		return "-"
+	}
+	return field
+}
+
+// formatMessage 一条日志的RFC5424消息: <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+// HOSTNAME使用附加的host字段, 没有时使用日志来源IP, MSGID为index_name, MSG为原始日志, 没有原始日志时为json格式的properties
+func (s *SyslogSender) formatMessage(data *protocol.Data) (string, error) {
+	var (
+		hostname  = data.Ip
+		timestamp = "-"
+		message   string
+	)
+
+	if host, ok := data.Properties["host"].(string); ok && len(host) > 0 {
+		hostname = host
+	}
+
+	if !data.Timestamp.IsZero() {
+		timestamp = data.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00")
+	}
+
+	if line, ok := data.Properties["_data"].(string); ok {
+		message = line
+	} else {
+		body, err := json.Marshal(data.Properties)
+		if err != nil {
+			return "", err
+		}
+		message = string(body)
+	}
+
+	// 以换行分帧时, 消息中的换行会被当作下一条消息
+	if s.network != SyslogNetworkUDP && s.framing == SyslogFramingNonTransparent {
+		message = strings.ReplaceAll(message, "\n", " ")
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s - %s - %s", s.priority, timestamp,
+		syslogHeaderField(hostname, 255), s.appName, syslogHeaderField(data.IndexName, 32), message), nil
+}
+
+// frame tcp/tls按照framing分帧, udp每条消息一个数据包不需要分帧
+func (s *SyslogSender) frame(message string) string {
+	if s.network == SyslogNetworkUDP {
+		return message
+	}
+	if s.framing == SyslogFramingNonTransparent {
+		return message + "\n"
+	}
+	return fmt.Sprintf("%d %s", len(message), message)
+}
+
+// dial 建立连接, 调用时需要持有lock
+func (s *SyslogSender) dial() error {
+	var (
+		dialer = &net.Dialer{Timeout: s.timeout}
+		conn   net.Conn
+		err    error
+	)
+
+	if s.network == SyslogNetworkTLS {
+		conn, err = tls.DialWithDialer(dialer, SyslogNetworkTCP, s.address, s.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(s.network, s.address)
+	}
+	if err != nil {
+		return errors.New("dial " + s.network + " " + s.address + " failed: " + err.Error())
+	}
+
+	s.conn = conn
+	return nil
+}
+
+// write 写入一批消息, 调用时需要持有lock, tcp/tls一次写入, udp每条消息写入一次
+func (s *SyslogSender) write(frames []string) error {
+	var err error
+
+	if s.conn == nil {
+		if err = s.dial(); err != nil {
+			return err
+		}
+	}
+
+	if err = s.conn.SetWriteDeadline(time.Now().Add(s.timeout)); err != nil {
+		return err
+	}
+
+	if s.network != SyslogNetworkUDP {
+		var buffer bytes.Buffer
+		for _, frame := range frames {
+			buffer.WriteString(frame)
+		}
+		_, err = s.conn.Write(buffer.Bytes())
+		return err
+	}
+
+	for _, frame := range frames {
+		if _, err = s.conn.Write([]byte(frame)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeConn 关闭连接, 下次写入时重新连接, 调用时需要持有lock
+func (s *SyslogSender) closeConn() error {
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// Send 一批日志作为一次写入, 写入失败时重新连接后重试一次, 仍然失败时返回错误, 由调用方决定是否重试
+func (s *SyslogSender) Send(datas []protocol.Data) error {
+	var (
+		frames  = make([]string, 0, len(datas))
+		message string
+		err     error
+	)
+
+	for i := range datas {
+		if message, err = s.formatMessage(&datas[i]); err != nil {
+			k3.K3LogError("[SyslogSender.Send] format data failed: %s, data: %s", err.Error(), datas[i].String())
+			continue
+		}
+		frames = append(frames, s.frame(message))
+	}
+
+	if len(frames) == 0 {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return errors.New("[SyslogSender.Send] syslog sender is closed")
+	}
+
+	if err = s.write(frames); err == nil {
+		return nil
+	}
+
+	// 连接可能已经被服务端关闭, 重新连接后重试一次
+	k3.K3LogWarn("[SyslogSender.Send] write to %s failed, reconnecting: %s", s.address, err.Error())
+	_ = s.closeConn()
+	if err = s.write(frames); err != nil {
+		_ = s.closeConn()
+		return errors.New("[SyslogSender.Send] write to " + s.address + " failed: " + err.Error())
+	}
+
+	return nil
+}
+
+// Close 关闭连接, 可以重复调用
+func (s *SyslogSender) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+	return s.closeConn()
+}
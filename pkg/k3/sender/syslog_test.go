@@ -0,0 +1,248 @@
+package sender
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc5424Header <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA
+var rfc5424Header = regexp.MustCompile(`^<(\d{1,3})>1 (\S+) (\S+) (\S+) (\S+) (\S+) - `)
+
+// syslogListener 本地tcp syslog服务, 按照octet counting解析收到的消息
+type syslogListener struct {
+	listener net.Listener
+	messages chan string
+	conns    chan net.Conn
+}
+
+func newSyslogListener(t *testing.T, address string) *syslogListener {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := &syslogListener{listener: listener, messages: make(chan string, 100), conns: make(chan net.Conn, 10)}
+	t.Cleanup(l.close)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			l.conns <- conn
+			go l.read(conn)
+		}
+	}()
+
+	return l
+}
+
+// read 读取octet counting分帧的消息: 长度 空格 消息
+func (l *syslogListener) read(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		size, err := reader.ReadString(' ')
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil {
+			l.messages <- "invalid frame length: " + size
+			return
+		}
+		message := make([]byte, n)
+		if _, err = io.ReadFull(reader, message); err != nil {
+			return
+		}
+		l.messages <- string(message)
+	}
+}
+
+// close 关闭监听和所有已经建立的连接, 模拟syslog服务重启
+func (l *syslogListener) close() {
+	_ = l.listener.Close()
+	for {
+		select {
+		case conn := <-l.conns:
+			_ = conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (l *syslogListener) next(t *testing.T) string {
+	select {
+	case message := <-l.messages:
+		return message
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait syslog message timeout")
+		return ""
+	}
+}
+
+func syslogTestData(uuid, line string) protocol.Data {
+	return protocol.Data{
+		UUID:       uuid,
+		IndexName:  "index nginx",
+		Ip:         "10.0.0.1",
+		Timestamp:  time.Date(2024, 10, 1, 12, 0, 0, 123456000, time.UTC),
+		Properties: map[string]interface{}{"_data": line, "host": "web-01"},
+	}
+}
+
+func TestSyslogSenderFrames(t *testing.T) {
+	var listener = newSyslogListener(t, "127.0.0.1:0")
+
+	ss, err := NewSyslogSender(config.SyslogSender{Address: listener.listener.Addr().String(), Facility: "local3", AppName: "k3 agent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	if err = ss.Send([]protocol.Data{syslogTestData("1", "GET /index 200"), syslogTestData("2", "line with\nnewline")}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"GET /index 200", "line with\nnewline"} {
+		message := listener.next(t)
+		matches := rfc5424Header.FindStringSubmatch(message)
+		if matches == nil {
+			t.Fatalf("message should be a RFC5424 frame, got %q", message)
+		}
+		// local3(19) * 8 + informational(6)
+		if matches[1] != "158" || matches[2] != "2024-10-01T12:00:00.123456Z" || matches[3] != "web-01" ||
+			matches[4] != "k3_agent" || matches[5] != "-" || matches[6] != "index_nginx" {
+			t.Errorf("unexpected header fields %v", matches[1:])
+		}
+		if !strings.HasSuffix(message, " - "+line) {
+			t.Errorf("message should end with the raw line %q, got %q", line, message)
+		}
+	}
+}
+
+func TestSyslogSenderNonTransparent(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	ss, err := NewSyslogSender(config.SyslogSender{Address: listener.Addr().String(), Framing: SyslogFramingNonTransparent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	if err = ss.Send([]protocol.Data{syslogTestData("1", "line 1"), syslogTestData("2", "line\n2")}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for _, line := range []string{"line 1", "line 2"} {
+		message, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rfc5424Header.MatchString(message) || !strings.HasSuffix(message, " - "+line+"\n") {
+			t.Errorf("message should be a newline terminated RFC5424 frame ending with %q, got %q", line, message)
+		}
+	}
+}
+
+func TestSyslogSenderUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ss, err := NewSyslogSender(config.SyslogSender{Network: SyslogNetworkUDP, Address: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	if err = ss.Send([]protocol.Data{syslogTestData("1", "line 1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2048)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// local0(16) * 8 + informational(6), 每条消息一个数据包, 不需要分帧
+	if message := string(buf[:n]); !strings.HasPrefix(message, "<134>1 ") || !strings.HasSuffix(message, " - line 1") {
+		t.Errorf("datagram should be a single RFC5424 message, got %q", message)
+	}
+}
+
+func TestSyslogSenderReconnect(t *testing.T) {
+	var (
+		listener = newSyslogListener(t, "127.0.0.1:0")
+		address  = listener.listener.Addr().String()
+	)
+
+	ss, err := NewSyslogSender(config.SyslogSender{Address: address, Timeout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	if err = ss.Send([]protocol.Data{syslogTestData("1", "before restart")}); err != nil {
+		t.Fatal(err)
+	}
+	listener.next(t)
+
+	// syslog服务重启, 之前的连接被关闭
+	listener.close()
+	listener = newSyslogListener(t, address)
+
+	// 服务端关闭后第一次写入可能仍然成功(写入内核缓冲区), 之后的写入失败时重新连接
+	for i := 0; i < 10; i++ {
+		if err = ss.Send([]protocol.Data{syslogTestData(fmt.Sprintf("%d", i), "after restart")}); err != nil {
+			t.Fatalf("send should reconnect after the listener restarts: %s", err)
+		}
+
+		select {
+		case message := <-listener.messages:
+			if !strings.HasSuffix(message, " - after restart") {
+				t.Errorf("unexpected message after reconnect %q", message)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	t.Fatal("messages should be received by the restarted listener")
+}
+
+func TestSyslogSenderConfig(t *testing.T) {
+	for _, cfg := range []config.SyslogSender{
+		{},
+		{Address: "127.0.0.1:514", Network: "unix"},
+		{Address: "127.0.0.1:514", Framing: "length"},
+		{Address: "127.0.0.1:514", Facility: "local8"},
+	} {
+		if _, err := NewSyslogSender(cfg); err == nil {
+			t.Errorf("invalid config %+v should return error", cfg)
+		}
+	}
+}
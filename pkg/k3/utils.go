@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"github.com/google/uuid"
 	"io"
-	"io/fs"
 	"net"
 	"os"
 	"path/filepath"
@@ -119,35 +118,55 @@ func GenerateUUID() string {
 	return newUUID.String()
 }
 
-// FetchDirectory 递归遍历目录, maxDepth -1 全部遍历, 返回所有的文件
-func FetchDirectory(dir string, maxDepth int) ([]string, error) {
+// DefaultIgnoreSuffixes 遍历目录时默认跳过的文件后缀, 编辑器和shell产生的临时文件
+var DefaultIgnoreSuffixes = []string{".swp", ".swo", ".swx", ".tmp", "~"}
 
-	var (
-		files     []string
-		rootDepth = len(strings.Split(dir, string(os.PathSeparator)))
-		err       error
-	)
+// FetchDirectoryConfig 遍历目录的配置
+type FetchDirectoryConfig struct {
+	MaxDepth       int      // -1 全部遍历, 0只遍历dir本身, 1遍历dir下的文件和目录, 以此类推
+	FollowSymlinks bool     // 是否进入指向目录的软链接, 默认不进入; 进入时跳过已经遍历过的目录(设备和inode相同), 避免软链接循环
+	IncludeAll     bool     // 返回所有的文件, 默认跳过以.开头的隐藏文件和IgnoreSuffixes后缀的文件
+	IgnoreSuffixes []string // 跳过的文件后缀, 为nil时使用DefaultIgnoreSuffixes, 为空时不跳过
+}
 
-	// fmt.Println(files, rootDepth, string(os.PathSeparator))
-	if err = filepath.WalkDir(dir, func(currentPath string, d os.DirEntry, err error) error {
-		var (
-			currentDepth int
-		)
+// Ignored 文件名以.开头或者后缀在IgnoreSuffixes中时返回true, IncludeAll时返回false
+func (c FetchDirectoryConfig) Ignored(name string) bool {
+	var suffixes = c.IgnoreSuffixes
 
-		if err != nil {
-			return err
-		}
+	if c.IncludeAll {
+		return false
+	}
 
-		currentDepth = len(strings.Split(currentPath, string(os.PathSeparator))) - rootDepth
+	name = filepath.Base(name)
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
 
-		if maxDepth != -1 && currentDepth > maxDepth {
-			return filepath.SkipDir // 跳过当前目录
+	if suffixes == nil {
+		suffixes = DefaultIgnoreSuffixes
+	}
+	for _, suffix := range suffixes {
+		if len(suffix) > 0 && strings.HasSuffix(name, suffix) {
+			return true
 		}
+	}
+
+	return false
+}
+
+// FetchDirectory 递归遍历目录, maxDepth -1 全部遍历, 返回所有的文件, 不进入指向目录的软链接, 跳过隐藏文件和临时文件
+func FetchDirectory(dir string, maxDepth int) ([]string, error) {
+	return FetchDirectoryWithConfig(dir, FetchDirectoryConfig{MaxDepth: maxDepth})
+}
 
-		if !d.IsDir() {
+// FetchDirectoryWithConfig 递归遍历目录, 返回所有的文件, 指向文件的软链接作为文件返回, 没有开启IncludeAll时跳过隐藏文件和临时文件
+func FetchDirectoryWithConfig(dir string, config FetchDirectoryConfig) ([]string, error) {
+	var files []string
+
+	if err := walkDirectory(dir, config, func(currentPath string, isDir bool) {
+		if !isDir {
 			files = append(files, currentPath)
 		}
-		return nil
 	}); err != nil {
 		return nil, err
 	}
@@ -155,40 +174,99 @@ func FetchDirectory(dir string, maxDepth int) ([]string, error) {
 	return files, nil
 }
 
-// FetchDirectoryPath 递归遍历目录, maxDepth -1 全部遍历, 返回所有的目录
+// FetchDirectoryPath 递归遍历目录, maxDepth -1 全部遍历, 返回所有的目录, 不进入指向目录的软链接
 func FetchDirectoryPath(dir string, maxDepth int) ([]string, error) {
+	return FetchDirectoryPathWithConfig(dir, FetchDirectoryConfig{MaxDepth: maxDepth})
+}
+
+// FetchDirectoryPathWithConfig 递归遍历目录, 返回所有的目录, 包括dir本身
+func FetchDirectoryPathWithConfig(dir string, config FetchDirectoryConfig) ([]string, error) {
+	var paths []string
+
+	if err := walkDirectory(dir, config, func(currentPath string, isDir bool) {
+		if isDir {
+			paths = append(paths, currentPath)
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// walkDirectory 按照文件名顺序遍历dir, 每个文件和目录调用fn, dir不是目录时只对dir本身调用一次
+func walkDirectory(dir string, config FetchDirectoryConfig, fn func(currentPath string, isDir bool)) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		fn(dir, false)
+		return nil
+	}
+
+	return walkDirectoryDepth(dir, 0, config, make(map[[2]uint64]bool), fn)
+}
+
+// walkDirectoryDepth 遍历depth层的目录dir, visited记录进入软链接时已经遍历过的目录
+func walkDirectoryDepth(dir string, depth int, config FetchDirectoryConfig, visited map[[2]uint64]bool, fn func(currentPath string, isDir bool)) error {
 	var (
-		err       error
-		paths     []string
-		rootDepth = len(strings.Split(dir, string(os.PathSeparator)))
+		entries []os.DirEntry
+		err     error
 	)
 
-	if err = filepath.WalkDir(dir, func(currentPath string, d fs.DirEntry, err error) error {
-		var (
-			currentDepth int
-		)
-
+	if config.FollowSymlinks {
+		dev, inode, err := FileIdentity(dir)
 		if err != nil {
 			return err
 		}
+		identity := [2]uint64{dev, inode}
+		if visited[identity] {
+			return nil
+		}
+		visited[identity] = true
+	}
 
-		currentDepth = len(strings.Split(currentPath, string(os.PathSeparator))) - rootDepth
+	fn(dir, true)
 
-		if maxDepth != -1 && currentDepth > maxDepth {
-			return filepath.SkipDir
-		}
+	if config.MaxDepth != -1 && depth >= config.MaxDepth {
+		return nil
+	}
 
-		if d.IsDir() {
-			paths = append(paths, currentPath)
+	if entries, err = os.ReadDir(dir); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		var (
+			currentPath = filepath.Join(dir, entry.Name())
+			isDir       = entry.IsDir()
+		)
+
+		// 软链接按照指向的目标判断, 指向目录时只有FollowSymlinks才进入, 指向文件或者已经失效时作为文件返回
+		if entry.Type()&os.ModeSymlink != 0 {
+			if info, err := os.Stat(currentPath); err == nil && info.IsDir() {
+				if !config.FollowSymlinks {
+					continue
+				}
+				isDir = true
+			}
 		}
 
-		return nil
+		if !isDir {
+			if !config.Ignored(entry.Name()) {
+				fn(currentPath, false)
+			}
+			continue
+		}
 
-	}); err != nil {
-		return nil, err
+		if err = walkDirectoryDepth(currentPath, depth+1, config, visited, fn); err != nil {
+			return err
+		}
 	}
 
-	return paths, nil
+	return nil
 }
 
 func InterfaceToString(val interface{}) (string, bool) {
@@ -221,9 +299,10 @@ func RemoveDuplicateElement(src []string) []string {
 	return res
 }
 
-func InSlice(s string, slice []string) bool {
+// InSlice 判断v是否在slice中
+func InSlice[T comparable](v T, slice []T) bool {
 	for _, d := range slice {
-		if s == d && len(s) == len(d) {
+		if v == d {
 			return true
 		}
 	}
@@ -247,12 +326,12 @@ func GetRootPath() string {
 }
 
 // GetMapKeys 获取map中的所有key，并返回数组
-func GetMapKeys(m map[string]interface{}) []string {
+func GetMapKeys[K comparable, V any](m map[K]V) []K {
 	var (
-		keys []string
+		keys = make([]K, 0, len(m))
 	)
 
-	for k, _ := range m {
+	for k := range m {
 		keys = append(keys, k)
 	}
 	return keys
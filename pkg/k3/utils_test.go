@@ -0,0 +1,195 @@
+package k3
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestInSlice(t *testing.T) {
+	if !InSlice(2, []int{1, 2, 3}) || InSlice(4, []int{1, 2, 3}) {
+		t.Error("InSlice should find ints")
+	}
+
+	if !InSlice("nginx", []string{"api", "nginx"}) || InSlice("ngin", []string{"api", "nginx"}) {
+		t.Error("InSlice should compare whole strings")
+	}
+
+	if InSlice("", nil) {
+		t.Error("nothing is in a nil slice")
+	}
+}
+
+func TestGetMapKeys(t *testing.T) {
+	var ints = GetMapKeys(map[int]string{3: "c", 1: "a", 2: "b"})
+	sort.Ints(ints)
+	if len(ints) != 3 || ints[0] != 1 || ints[1] != 2 || ints[2] != 3 {
+		t.Errorf("unexpected int keys %v", ints)
+	}
+
+	type state struct{ offset int64 }
+	var strs = GetMapKeys(map[string]*state{"/logs/a.log": {offset: 1}, "/logs/b.log": nil})
+	sort.Strings(strs)
+	if len(strs) != 2 || strs[0] != "/logs/a.log" || strs[1] != "/logs/b.log" {
+		t.Errorf("unexpected string keys %v", strs)
+	}
+
+	if keys := GetMapKeys(map[string]interface{}{}); len(keys) != 0 {
+		t.Errorf("empty map should have no keys, got %v", keys)
+	}
+}
+
+// newDepthTree 创建 root/a.log, root/d1/b.log, root/d1/d2/c.log, root/d1/d2/d3/d.log
+func newDepthTree(t *testing.T) string {
+	var root = t.TempDir()
+
+	for _, file := range []string{"a.log", "d1/b.log", "d1/d2/c.log", "d1/d2/d3/d.log"} {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("line\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+// relPaths 转换为相对root的路径
+func relPaths(t *testing.T, root string, paths []string) []string {
+	var rels []string
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rels = append(rels, rel)
+	}
+	return rels
+}
+
+func TestFetchDirectoryMaxDepth(t *testing.T) {
+	var root = newDepthTree(t)
+
+	for _, c := range []struct {
+		maxDepth int
+		files    []string
+		dirs     []string
+	}{
+		{0, nil, []string{"."}},
+		{1, []string{"a.log"}, []string{".", "d1"}},
+		{2, []string{"a.log", "d1/b.log"}, []string{".", "d1", "d1/d2"}},
+		{-1, []string{"a.log", "d1/b.log", "d1/d2/c.log", "d1/d2/d3/d.log"}, []string{".", "d1", "d1/d2", "d1/d2/d3"}},
+	} {
+		files, err := FetchDirectory(root, c.maxDepth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rels := relPaths(t, root, files); !equalStrings(rels, c.files) {
+			t.Errorf("max depth %d should return files %v, got %v", c.maxDepth, c.files, rels)
+		}
+
+		dirs, err := FetchDirectoryPath(root, c.maxDepth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rels := relPaths(t, root, dirs); !equalStrings(rels, c.dirs) {
+			t.Errorf("max depth %d should return directories %v, got %v", c.maxDepth, c.dirs, rels)
+		}
+	}
+}
+
+func TestFetchDirectorySymlinks(t *testing.T) {
+	var (
+		root  = newDepthTree(t)
+		other = t.TempDir()
+	)
+
+	if err := os.WriteFile(filepath.Join(other, "e.log"), []byte("line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// d1/d2/loop指向root形成循环, link指向另一个目录, a.link指向文件
+	for link, target := range map[string]string{"d1/d2/loop": root, "link": other, "a.link": filepath.Join(root, "a.log")} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 默认不进入指向目录的软链接, 指向文件的软链接作为文件返回
+	files, err := FetchDirectory(root, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rels, expected := relPaths(t, root, files), []string{"a.link", "a.log", "d1/b.log", "d1/d2/c.log", "d1/d2/d3/d.log"}; !equalStrings(rels, expected) {
+		t.Errorf("symlinked directories should not be followed, expected %v, got %v", expected, rels)
+	}
+
+	// 进入软链接时, 已经遍历过的root不再遍历, 遍历可以结束
+	files, err = FetchDirectoryWithConfig(root, FetchDirectoryConfig{MaxDepth: -1, FollowSymlinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rels, expected := relPaths(t, root, files), []string{"a.link", "a.log", "d1/b.log", "d1/d2/c.log", "d1/d2/d3/d.log", "link/e.log"}; !equalStrings(rels, expected) {
+		t.Errorf("each directory should be walked once, expected %v, got %v", expected, rels)
+	}
+
+	dirs, err := FetchDirectoryPathWithConfig(root, FetchDirectoryConfig{MaxDepth: -1, FollowSymlinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rels, expected := relPaths(t, root, dirs), []string{".", "d1", "d1/d2", "d1/d2/d3", "link"}; !equalStrings(rels, expected) {
+		t.Errorf("unexpected directories %v, got %v", expected, rels)
+	}
+}
+
+func TestFetchDirectoryIgnored(t *testing.T) {
+	var root = newDepthTree(t)
+
+	for _, file := range []string{".hidden.log", "a.log.swp", "d1/.b.log.swo", "d1/b.log~", "d1/d2/c.tmp"} {
+		if err := os.WriteFile(filepath.Join(root, file), []byte("line\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 默认跳过隐藏文件和临时文件
+	files, err := FetchDirectory(root, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rels, expected := relPaths(t, root, files), []string{"a.log", "d1/b.log", "d1/d2/c.log", "d1/d2/d3/d.log"}; !equalStrings(rels, expected) {
+		t.Errorf("hidden and temp files should be skipped, expected %v, got %v", expected, rels)
+	}
+
+	// 自定义后缀时只跳过隐藏文件和配置的后缀
+	files, err = FetchDirectoryWithConfig(root, FetchDirectoryConfig{MaxDepth: -1, IgnoreSuffixes: []string{".tmp"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rels, expected := relPaths(t, root, files), []string{"a.log", "a.log.swp", "d1/b.log", "d1/b.log~", "d1/d2/c.log", "d1/d2/d3/d.log"}; !equalStrings(rels, expected) {
+		t.Errorf("only configured suffixes should be skipped, expected %v, got %v", expected, rels)
+	}
+
+	// 开启IncludeAll时返回所有文件
+	files, err = FetchDirectoryWithConfig(root, FetchDirectoryConfig{MaxDepth: -1, IncludeAll: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rels, expected := relPaths(t, root, files), []string{".hidden.log", "a.log", "a.log.swp", "d1/.b.log.swo", "d1/b.log", "d1/b.log~", "d1/d2/c.log", "d1/d2/c.tmp", "d1/d2/d3/d.log"}; !equalStrings(rels, expected) {
+		t.Errorf("all files should be returned, expected %v, got %v", expected, rels)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	DefaultBackpressureRetryInterval = 200 * time.Millisecond // 暂停读取后, 再次尝试读取文件的间隔
+
+	backpressurePaused atomic.Bool // consumer中等待发送的数据过多, 暂停读取文件
+)
+
+// resetBackpressure 恢复读取, InitVars时调用
+func resetBackpressure() {
+	backpressurePaused.Store(false)
+}
+
+// backpressureLow 恢复读取的阈值, 没有配置或者不小于backpressure_high时为backpressure_high的一半
+func backpressureLow(watch config.Watch) int {
+	if watch.BackpressureLow <= 0 || watch.BackpressureLow >= watch.BackpressureHigh {
+		return watch.BackpressureHigh / 2
+	}
+	return watch.BackpressureLow
+}
+
+// checkBackpressure consumer中等待发送的数据达到backpressure_high时暂停读取, 低于backpressure_low时恢复, 暂停时返回true
+// 两个阈值之间保持当前的状态, 避免在阈值附近频繁地暂停和恢复
+func checkBackpressure() bool {
+	var (
+		watch = config.GlobalConfig.Watch
+		depth int
+	)
+
+	if watch.BackpressureHigh <= 0 {
+		return false
+	}
+
+	depth = GlobalDataAnalytics.QueueDepth()
+
+	if backpressurePaused.Load() {
+		// 队列为空时一定恢复, 避免backpressure_high为1时无法恢复
+		if depth >= backpressureLow(watch) && depth > 0 {
+			return true
+		}
+		if backpressurePaused.CompareAndSwap(true, false) {
+			k3.K3LogInfo("[checkBackpressure] consumer queue depth %d below %d, resume reading.", depth, backpressureLow(watch))
+		}
+		return false
+	}
+
+	if depth < watch.BackpressureHigh {
+		return false
+	}
+
+	if backpressurePaused.CompareAndSwap(false, true) {
+		k3.K3LogWarn("[checkBackpressure] consumer queue depth %d reaches %d, pause reading.", depth, watch.BackpressureHigh)
+	}
+	return true
+}
+
+// scheduleBackpressureRead 暂停读取时offset不移动, 文件没有新的写入时不会再触发读取, 需要定时再读取一次
+func scheduleBackpressureRead(fileState *FileState) {
+	GlobalFileStatesLock.Lock()
+	if fileState.backpressureScheduled {
+		GlobalFileStatesLock.Unlock()
+		return
+	}
+	fileState.backpressureScheduled = true
+	GlobalFileStatesLock.Unlock()
+
+	scheduleRead(fileState.IndexName, fileState.Path, DefaultBackpressureRetryInterval, func() {
+		fileState.backpressureScheduled = false
+	})
+}
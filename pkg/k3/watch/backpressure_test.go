@@ -0,0 +1,89 @@
+package watch
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestBackpressurePauseAndResume(t *testing.T) {
+	var (
+		path    = filepath.Join(t.TempDir(), "app.log")
+		record  = &recordSender{block: make(chan struct{})}
+		release sync.Once
+	)
+
+	initTestWatch(t)
+	t.Cleanup(func() { release.Do(func() { close(record.block) }) })
+
+	config.GlobalConfig.Watch.BackpressureHigh = 3
+	config.GlobalConfig.Watch.BackpressureLow = 1
+
+	batch, err := k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{
+		Sender:    record,
+		BatchSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := k3.NewAsyncConsumer(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+
+	appendLines(t, path, "line 1", "line 2", "line 3", "line 4", "line 5")
+	createFile("index_backpressure", path)
+
+	// 第一次读取时队列为空, 5条日志都交给consumer, 第一条阻塞在sender中
+	writeEvent("index_backpressure", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+	waitFor(t, func() bool { return GlobalDataAnalytics.QueueDepth() == 5 })
+
+	// 超过backpressure_high, 暂停读取, offset不移动
+	appendLines(t, path, "line 6", "line 7")
+	writeEvent("index_backpressure", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if !backpressurePaused.Load() {
+		t.Fatal("reading should be paused when the consumer queue is full")
+	}
+	GlobalFileStatesLock.Lock()
+	offset := GlobalFileStates[path].Offset
+	GlobalFileStatesLock.Unlock()
+	if offset != 35 {
+		t.Fatalf("offset should stay at the end of line 5 while paused, got %d", offset)
+	}
+
+	// sender恢复后队列低于backpressure_low, 定时的再次读取发送剩余的日志, 没有新的写入事件
+	release.Do(func() { close(record.block) })
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		GlobalFileStatesLock.Lock()
+		defer GlobalFileStatesLock.Unlock()
+		return GlobalFileStates[path].Offset == info.Size()
+	})
+	waitFor(t, func() bool {
+		record.lock.Lock()
+		defer record.lock.Unlock()
+		return len(record.datas) == 7
+	})
+
+	for i, data := range record.datas {
+		if line := data.Properties["_data"]; line != fmt.Sprintf("line %d", i+1) {
+			t.Errorf("line %d expected, got %v", i+1, line)
+		}
+	}
+	if backpressurePaused.Load() {
+		t.Error("reading should resume after the consumer queue drains")
+	}
+}
@@ -0,0 +1,21 @@
+package watch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// contentHash 文件路径、日志在文件中的开始位置和日志内容的sha256
+// 状态文件没有及时同步, 重启后从旧的offset重新读取时, 同一条日志的hash不变
+func contentHash(path string, offset int64, data string) string {
+	var hash = sha256.New()
+
+	hash.Write([]byte(path))
+	hash.Write([]byte{0})
+	hash.Write([]byte(strconv.FormatInt(offset, 10)))
+	hash.Write([]byte{0})
+	hash.Write([]byte(data))
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,91 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/sender"
+	"path/filepath"
+	"testing"
+)
+
+// hashes 返回所有收到的日志的content hash
+func (c *captureConsumer) hashes() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var hashes []string
+	for _, data := range c.datas {
+		hash, _ := data.Properties[sender.ContentHashField].(string)
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+func TestContentHashReplay(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+		hashes   []string
+	)
+	config.GlobalConfig.ELK.IDStrategy = sender.IDStrategyContentHash
+	t.Cleanup(func() { config.GlobalConfig.ELK.IDStrategy = "" })
+
+	// 相同内容的两行位于不同的offset
+	appendLines(t, path, "same line", "same line")
+	createFile("index_test", path)
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if hashes = consumer.hashes(); len(hashes) != 2 || len(hashes[0]) == 0 {
+		t.Fatalf("each line should have a content hash, got %v", hashes)
+	}
+	if hashes[0] == hashes[1] {
+		t.Errorf("same content at different offsets should have different hashes, got %v", hashes)
+	}
+
+	// 崩溃后状态文件中的offset没有同步, 从头重新读取
+	GlobalFileStatesLock.Lock()
+	GlobalFileStates[path].Offset = 0
+	GlobalFileStatesLock.Unlock()
+	appendLines(t, path, "new line")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	replayed := consumer.hashes()
+	if len(replayed) != 5 {
+		t.Fatalf("replay should read all 3 lines again, got %v", consumer.lines())
+	}
+	if replayed[2] != hashes[0] || replayed[3] != hashes[1] {
+		t.Errorf("replayed lines should have identical hashes, got %v and %v", hashes, replayed[2:4])
+	}
+	if replayed[4] == hashes[0] || replayed[4] == hashes[1] {
+		t.Errorf("new line should have a new hash, got %v", replayed)
+	}
+}
+
+func TestContentHashMultiline(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+	config.GlobalConfig.ELK.IDStrategy = sender.IDStrategyContentHash
+	t.Cleanup(func() { config.GlobalConfig.ELK.IDStrategy = "" })
+	if err := InitMultiline(config.Multiline{Pattern: `^\s`, Match: MultilineMatchAfter}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = InitMultiline(config.Multiline{}) })
+
+	// 第一条日志长度为26, 第二条日志从offset 26开始
+	appendLines(t, path, "error", "  at main.go:1", "  at", "next", "last")
+	createFile("index_test", path)
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	hashes := consumer.hashes()
+	if len(hashes) != 2 {
+		t.Fatalf("expected 2 finished events, got %v", consumer.lines())
+	}
+	if hashes[0] != contentHash(path, 0, "error\n  at main.go:1\n  at") || hashes[1] != contentHash(path, 26, "next") {
+		t.Errorf("multiline event hash should use the offset of its first line, got %v", hashes)
+	}
+}
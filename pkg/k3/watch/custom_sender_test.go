@@ -0,0 +1,74 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// memorySender 测试用的自定义sender, 记录收到的日志
+type memorySender struct {
+	lock   sync.Mutex
+	lines  []string
+	closed bool
+}
+
+func (m *memorySender) Send(data []protocol.Data) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, d := range data {
+		m.lines = append(m.lines, d.Properties["_data"].(string))
+	}
+	return nil
+}
+
+func (m *memorySender) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.closed = true
+	return nil
+}
+
+func TestSetSender(t *testing.T) {
+	var (
+		path   = filepath.Join(t.TempDir(), "app.log")
+		custom = &memorySender{}
+	)
+
+	initTestWatch(t)
+	// 配置的sender不可用, 自定义sender时不会创建
+	config.GlobalConfig.Sender.Type = "unknown"
+	config.GlobalConfig.Consumer = config.Consumer{ConsumerBatchSize: 1}
+	SetSender(custom)
+	t.Cleanup(func() {
+		SetSender(nil)
+		config.GlobalConfig.Sender.Type = ""
+		config.GlobalConfig.Consumer = config.Consumer{}
+	})
+
+	if err := InitConsumerBatchLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, "custom line 1", "custom line 2")
+	createFile("index_test", path)
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+	if err := GlobalDataAnalytics.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	custom.lock.Lock()
+	defer custom.lock.Unlock()
+	if len(custom.lines) != 2 || custom.lines[0] != "custom line 1" || custom.lines[1] != "custom line 2" {
+		t.Errorf("events should flow to the custom sender, got %v", custom.lines)
+	}
+	if !custom.closed {
+		t.Error("custom sender should be closed with the consumer")
+	}
+}
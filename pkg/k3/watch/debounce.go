@@ -0,0 +1,58 @@
+package watch
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	DefaultDebounceMaxWait = 10 // 持续写入时, 最多延迟debounce_interval的倍数后读取, 避免文件一直写入时一直不读取
+)
+
+var (
+	debounceLock   = &sync.Mutex{}
+	debounceTimers = make(map[string]*debounceTimer) // path -> 等待中的读取
+)
+
+// debounceTimer 同一个文件在窗口内的多次写入事件合并为一次读取
+type debounceTimer struct {
+	timer *time.Timer
+	first time.Time // 第一次写入事件的时间
+}
+
+// debounceWrite 窗口内同一个文件的写入事件只触发一次读取, 每次新的写入事件重新计时
+// 等待中的读取计入processingWg, 退出时可以等待读取完成
+func debounceWrite(path string, window time.Duration, read func()) {
+	var now = time.Now()
+
+	debounceLock.Lock()
+	defer debounceLock.Unlock()
+
+	if entry, exists := debounceTimers[path]; exists && now.Sub(entry.first) < window*time.Duration(DefaultDebounceMaxWait) {
+		// Stop失败表示读取已经触发, 需要等待新的读取
+		if entry.timer.Stop() {
+			entry.timer.Reset(window)
+			return
+		}
+	}
+
+	var (
+		wg        = processingWg
+		scheduler = GlobalScheduler
+		entry     = &debounceTimer{first: now}
+	)
+
+	wg.Add(1)
+	entry.timer = time.AfterFunc(window, func() {
+		defer wg.Done()
+
+		debounceLock.Lock()
+		if debounceTimers[path] == entry {
+			delete(debounceTimers, path)
+		}
+		debounceLock.Unlock()
+
+		scheduler.Submit(read)
+	})
+	debounceTimers[path] = entry
+}
@@ -0,0 +1,74 @@
+package watch
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countScheduler 记录提交的读取任务数量
+type countScheduler struct {
+	Scheduler
+	submitted int32
+}
+
+func (s *countScheduler) Submit(task func()) {
+	atomic.AddInt32(&s.submitted, 1)
+	s.Scheduler.Submit(task)
+}
+
+func TestDebounceWrite(t *testing.T) {
+	var (
+		consumer  = initTestWatch(t)
+		path      = filepath.Join(t.TempDir(), "app.log")
+		scheduler = &countScheduler{Scheduler: GlobalScheduler}
+		expected  []string
+	)
+
+	GlobalScheduler = scheduler
+	config.GlobalConfig.Watch.DebounceInterval = 50
+
+	// 100ms内50次写入
+	for i := 0; i < 50; i++ {
+		line := fmt.Sprintf("line %d", i)
+		expected = append(expected, line)
+		appendLines(t, path, line)
+		writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+		time.Sleep(2 * time.Millisecond)
+	}
+	processingWg.Wait()
+
+	if submitted := atomic.LoadInt32(&scheduler.submitted); submitted < 1 || submitted > 3 {
+		t.Errorf("write events should be coalesced into a few reads, got %d", submitted)
+	}
+	assertLines(t, consumer, expected...)
+}
+
+func TestDebounceMaxWait(t *testing.T) {
+	var (
+		consumer  = initTestWatch(t)
+		path      = filepath.Join(t.TempDir(), "app.log")
+		scheduler = &countScheduler{Scheduler: GlobalScheduler}
+		deadline  = time.Now().Add(300 * time.Millisecond)
+	)
+
+	GlobalScheduler = scheduler
+	config.GlobalConfig.Watch.DebounceInterval = 10
+
+	// 持续写入超过10个窗口, 仍然需要读取
+	appendLines(t, path, "line 1")
+	for time.Now().Before(deadline) {
+		writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+		time.Sleep(time.Millisecond)
+	}
+
+	if atomic.LoadInt32(&scheduler.submitted) == 0 {
+		t.Errorf("continuous writes should not delay the read forever")
+	}
+	processingWg.Wait()
+	assertLines(t, consumer, "line 1")
+}
@@ -0,0 +1,179 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3/protocol"
+	"log-engine-sdk/pkg/k3/sender"
+	"sync"
+)
+
+// pendingEvent 已经交给consumer, 还没有被sender确认接收的一条日志
+type pendingEvent struct {
+	offset    int64 // 日志在文件中的开始位置
+	delivered bool
+}
+
+// deliveryTracker 按照读取顺序记录一个文件已经交给consumer的日志
+// FileState.Offset是读取位置, 落盘的offset不超过最早一条没有确认的日志的开始位置, 发送失败时重启后从这条日志重新读取
+type deliveryTracker struct {
+	lock          sync.Mutex
+	pending       []*pendingEvent
+	dropped       bool  // 有日志被consumer丢弃, 不会再确认
+	droppedOffset int64 // 最早一条被丢弃的日志的开始位置, 落盘的offset不超过该位置, 重启后从这条日志重新读取
+}
+
+// newPending 创建一条等待确认的日志和sender确认接收后的回调, consumer接收之后才通过add加入等待确认的列表
+// consumer可能在接收时同步发送并调用回调, 此时add加入的日志已经确认
+func (d *deliveryTracker) newPending(offset int64) (*pendingEvent, func()) {
+	var event = &pendingEvent{offset: offset}
+
+	return event, func() {
+		d.deliver(event)
+	}
+}
+
+// add 记录一条consumer已经接收的日志, 同一个文件的日志由一个协程按照读取顺序加入
+func (d *deliveryTracker) add(event *pendingEvent) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// 落盘的offset已经停在被丢弃的日志, 之后的日志不需要再等待确认
+	if d.dropped && event.offset >= d.droppedOffset {
+		return
+	}
+
+	d.pending = append(d.pending, event)
+	d.trim()
+}
+
+// drop 标记offset开始的日志已经被consumer丢弃, 丢弃发生在add之前(异步队列已满时Add同步丢弃)
+// 被丢弃的日志不会再确认, 落盘的offset停在这里; 之后的日志不再记录, 避免等待确认的列表一直增长
+func (d *deliveryTracker) drop(offset int64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.dropped && d.droppedOffset <= offset {
+		return
+	}
+	d.dropped = true
+	d.droppedOffset = offset
+
+	var pending []*pendingEvent
+	for _, event := range d.pending {
+		if event.offset < offset {
+			pending = append(pending, event)
+		}
+	}
+	d.pending = pending
+}
+
+// deliver 标记日志已经确认, 移除开头所有已经确认的日志, 批次可能乱序确认(如部分文档重试)
+func (d *deliveryTracker) deliver(event *pendingEvent) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	event.delivered = true
+	d.trim()
+}
+
+// trim 移除开头所有已经确认的日志, 调用时需要持有lock
+func (d *deliveryTracker) trim() {
+	for len(d.pending) > 0 && d.pending[0].delivered {
+		d.pending[0] = nil
+		d.pending = d.pending[1:]
+	}
+}
+
+// committed 可以落盘的offset, readOffset为当前读取位置
+func (d *deliveryTracker) committed(readOffset int64) int64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var offset = readOffset
+
+	// 文件被清空或者轮转后读取位置重置, 之前没有确认的日志不再影响新的读取位置
+	if len(d.pending) > 0 && d.pending[0].offset < offset {
+		offset = d.pending[0].offset
+	}
+	if d.dropped && d.droppedOffset < offset {
+		offset = d.droppedOffset
+	}
+
+	return offset
+}
+
+// deliveryTrackerOf 返回fileState的deliveryTracker, 第一次发送时创建
+func deliveryTrackerOf(fileState *FileState) *deliveryTracker {
+	GlobalFileStatesLock.Lock()
+	defer GlobalFileStatesLock.Unlock()
+
+	if fileState.delivery == nil {
+		fileState.delivery = &deliveryTracker{}
+	}
+	return fileState.delivery
+}
+
+// committedOffset 落盘的offset, 调用时需要持有GlobalFileStatesLock
+func (f *FileState) committedOffset() int64 {
+	if f.delivery == nil {
+		return f.Offset
+	}
+	return f.delivery.committed(f.Offset)
+}
+
+// deliveredDatas consumer的OnSend回调中sender已经确认接收的数据, 返回BulkError时除了Failed都已经确认
+func deliveredDatas(datas []protocol.Data, err error) []protocol.Data {
+	var (
+		failed    = make(map[string]bool)
+		delivered []protocol.Data
+	)
+
+	if bulkError, ok := err.(*sender.BulkError); ok {
+		for _, data := range bulkError.Failed {
+			failed[data.UUID] = true
+		}
+	} else if err != nil {
+		return nil
+	}
+
+	for _, data := range datas {
+		if !failed[data.UUID] {
+			delivered = append(delivered, data)
+		}
+	}
+
+	return delivered
+}
+
+// ackDeliveries 通知每条已经确认的日志, 之后读取位置可以落盘
+func ackDeliveries(datas []protocol.Data, err error) {
+	for _, data := range deliveredDatas(datas, err) {
+		if data.Ack != nil {
+			data.Ack()
+		}
+	}
+}
+
+// dropDeliveries 异步consumer的OnDrop回调, 被丢弃的日志(队列已满)不会再发送, 不能确认
+// 落盘的offset停在第一条被丢弃的日志, 重启后从这条日志重新读取, 不丢数据
+func dropDeliveries(datas []protocol.Data) {
+	for _, data := range datas {
+		// wal重放的数据没有ack, 读取位置之前已经落盘
+		if data.Ack == nil {
+			continue
+		}
+
+		path, _ := data.Properties["_path"].(string)
+		offset, ok := data.Properties[sender.OffsetField].(int64)
+		if !ok {
+			continue
+		}
+
+		GlobalFileStatesLock.Lock()
+		fileState, exists := GlobalFileStates[path]
+		GlobalFileStatesLock.Unlock()
+
+		if exists {
+			deliveryTrackerOf(fileState).drop(offset)
+		}
+	}
+}
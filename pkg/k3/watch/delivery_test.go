@@ -0,0 +1,237 @@
+package watch
+
+import (
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/protocol"
+	"log-engine-sdk/pkg/k3/sender"
+	"path/filepath"
+	"testing"
+)
+
+// funcSender 测试用sender, 返回fail(data)的错误
+type funcSender struct {
+	fail func(data []protocol.Data) error
+}
+
+func (s *funcSender) Send(data []protocol.Data) error {
+	return s.fail(data)
+}
+
+func (s *funcSender) Close() error {
+	return nil
+}
+
+// initTestDelivery 使用批量consumer, FlushAll时提交给sender
+func initTestDelivery(t *testing.T, fail func(data []protocol.Data) error) *k3.K3BatchConsumer {
+	initTestWatch(t)
+
+	consumer, err := k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{
+		Sender:    &funcSender{fail: fail},
+		BatchSize: 100,
+		OnSend:    onBatchSent,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+
+	return consumer.(*k3.K3BatchConsumer)
+}
+
+// readAndFlush 读取path新写入的日志并提交, 返回内存中的读取位置和落盘的offset
+func readAndFlush(t *testing.T, consumer *k3.K3BatchConsumer, path string) (int64, int64) {
+	writeEvent("index_delivery", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+	_ = consumer.FlushAll()
+
+	if err := SaveGlobalFileStatesToDiskFile(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	GlobalFileStatesLock.Lock()
+	defer GlobalFileStatesLock.Unlock()
+	return GlobalFileStates[path].Offset, diskOffset(path)
+}
+
+func TestDeliveryOffsetSendFailed(t *testing.T) {
+	var (
+		path     = filepath.Join(t.TempDir(), "app.log")
+		failed   = true
+		consumer = initTestDelivery(t, func(data []protocol.Data) error {
+			if failed {
+				return errors.New("elk unavailable")
+			}
+			return nil
+		})
+	)
+
+	appendLines(t, path, "line 1", "line 2")
+	createFile("index_delivery", path)
+
+	// 发送失败, 读取位置前进, 落盘的offset停在第一条没有确认的日志
+	if readOffset, committed := readAndFlush(t, consumer, path); readOffset != 14 || committed != 0 {
+		t.Fatalf("undelivered lines should not be committed, read offset %d, committed %d", readOffset, committed)
+	}
+
+	// 失败的批次保留在consumer中, 之后重新提交成功时offset前进
+	failed = false
+	appendLines(t, path, "line 3")
+	if readOffset, committed := readAndFlush(t, consumer, path); readOffset != 21 || committed != 21 {
+		t.Fatalf("offset should be committed after retry, read offset %d, committed %d", readOffset, committed)
+	}
+}
+
+func TestDeliveryOffsetPartialBulkFailure(t *testing.T) {
+	var (
+		path     = filepath.Join(t.TempDir(), "app.log")
+		consumer = initTestDelivery(t, func(data []protocol.Data) error {
+			// 第二条日志写入失败, 其余的已经确认
+			return &sender.BulkError{Failed: data[1:2], Reason: "es_rejected_execution_exception"}
+		})
+	)
+
+	appendLines(t, path, "line 1", "line 2", "line 3")
+	createFile("index_delivery", path)
+
+	if readOffset, committed := readAndFlush(t, consumer, path); readOffset != 21 || committed != 7 {
+		t.Fatalf("offset should stop at the first failed line, read offset %d, committed %d", readOffset, committed)
+	}
+}
+
+func TestDeliveryOffsetDelivered(t *testing.T) {
+	var (
+		path     = filepath.Join(t.TempDir(), "app.log")
+		consumer = initTestDelivery(t, func(data []protocol.Data) error { return nil })
+	)
+
+	appendLines(t, path, "line 1", "line 2")
+	createFile("index_delivery", path)
+
+	if readOffset, committed := readAndFlush(t, consumer, path); readOffset != 14 || committed != 14 {
+		t.Fatalf("delivered lines should be committed, read offset %d, committed %d", readOffset, committed)
+	}
+}
+
+func TestDeliveryOffsetFlushRetry(t *testing.T) {
+	var (
+		path   = filepath.Join(t.TempDir(), "app.log")
+		failed = true
+	)
+
+	initTestWatch(t)
+	consumer, err := k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{
+		Sender: &funcSender{fail: func(data []protocol.Data) error {
+			if failed {
+				return errors.New("elk unavailable")
+			}
+			return nil
+		}},
+		BatchSize: 2,
+		OnSend:    onBatchSent,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+
+	// 批次满时Flush发送失败, 落盘的offset不前进
+	appendLines(t, path, "line 1", "line 2")
+	createFile("index_delivery", path)
+	writeEvent("index_delivery", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if err = SaveGlobalFileStatesToDiskFile(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if committed := diskOffset(path); committed != 0 {
+		t.Fatalf("offset should not advance past the failed batch, committed %d", committed)
+	}
+
+	// 失败的批次保留在consumer中, 之后的Flush重新提交
+	failed = false
+	appendLines(t, path, "line 3", "line 4")
+	writeEvent("index_delivery", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if err = SaveGlobalFileStatesToDiskFile(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if committed := diskOffset(path); committed != 28 {
+		t.Errorf("offset should advance after the failed batch is sent again, committed %d", committed)
+	}
+}
+
+func TestDeliveryOffsetAsyncDropped(t *testing.T) {
+	var (
+		path    = filepath.Join(t.TempDir(), "app.log")
+		release = make(chan struct{})
+	)
+
+	initTestWatch(t)
+	batch, err := k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{
+		Sender: &funcSender{fail: func(data []protocol.Data) error {
+			<-release
+			return nil
+		}},
+		BatchSize: 1,
+		OnSend:    onBatchSent,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := k3.NewAsyncConsumerWithConfig(k3.K3AsyncConsumerConfig{
+		Consumer:    batch,
+		ChannelSize: 1,
+		Overflow:    k3.AsyncOverflowDrop,
+		OnDrop:      dropDeliveries,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+
+	// sender阻塞时队列只能容纳一条日志, 其余的被丢弃
+	appendLines(t, path, "line 1", "line 2", "line 3")
+	createFile("index_delivery", path)
+	writeEvent("index_delivery", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	close(release)
+	if err = consumer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if dropped := consumer.(*k3.K3AsyncConsumer).Dropped(); dropped == 0 {
+		t.Fatal("some lines should be dropped while the sender is blocked")
+	}
+	if err = SaveGlobalFileStatesToDiskFile(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	// 被丢弃的日志不会再发送, 落盘的offset停在第一条被丢弃的日志(line 2或者line 3)
+	committed := diskOffset(path)
+	if committed != 7 && committed != 14 {
+		t.Fatalf("offset should stop at the first dropped line, committed %d", committed)
+	}
+
+	// 之后发送成功的日志不会使offset越过被丢弃的日志, 也不再等待确认
+	appendLines(t, path, "line 4")
+	writeEvent("index_delivery", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+	if err = consumer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = SaveGlobalFileStatesToDiskFile(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if offset := diskOffset(path); offset != committed {
+		t.Errorf("offset should stay at the dropped line %d, committed %d", committed, offset)
+	}
+	tracker := deliveryTrackerOf(GlobalFileStates[path])
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	if len(tracker.pending) != 0 {
+		t.Errorf("lines after the dropped line should not be tracked, got %d", len(tracker.pending))
+	}
+}
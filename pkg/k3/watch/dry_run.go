@@ -0,0 +1,35 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"time"
+)
+
+// isDryRun system.dry_run开启时只读取和解析, 日志交给Default sender打印, 不发送到elk
+func isDryRun() bool {
+	return config.GlobalConfig.System.DryRun
+}
+
+// logDryRunFilter dry_run时输出每行日志的过滤结果
+func logDryRunFilter(fileState *FileState, line string, ship bool) {
+	if !isDryRun() {
+		return
+	}
+
+	if ship {
+		k3.K3LogDebug("[dry_run] index_name[%s] path[%s] ship: %s", fileState.IndexName, fileState.Path, line)
+	} else {
+		k3.K3LogDebug("[dry_run] index_name[%s] path[%s] filtered by include_patterns/exclude_patterns: %s", fileState.IndexName, fileState.Path, line)
+	}
+}
+
+// logDryRunParse dry_run时输出每行日志的解析结果, fields为nil表示没有按照format解析出字段
+func logDryRunParse(rule *IndexRule, fileState *FileState, fields map[string]interface{}, timestamp time.Time, parsedTime bool) {
+	if !isDryRun() {
+		return
+	}
+
+	k3.K3LogDebug("[dry_run] index_name[%s] path[%s] format[%s] fields: %v, timestamp: %s, timestamp parsed: %t",
+		fileState.IndexName, fileState.Path, rule.format, fields, timestamp.Format(time.RFC3339Nano), parsedTime)
+}
@@ -0,0 +1,75 @@
+package watch
+
+import (
+	"io"
+	"log-engine-sdk/pkg/k3/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestDryRun(t *testing.T) {
+	var (
+		requests atomic.Int64
+		server   = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+		}))
+		path = filepath.Join(t.TempDir(), "app.log")
+	)
+	defer server.Close()
+
+	initTestWatch(t)
+	config.GlobalConfig.ELK.Address = []string{server.URL}
+	config.GlobalConfig.System.DryRun = true
+	config.GlobalConfig.Consumer = config.Consumer{ConsumerBatchSize: 1}
+	t.Cleanup(func() {
+		config.GlobalConfig.ELK.Address = nil
+		config.GlobalConfig.System.DryRun = false
+		config.GlobalConfig.Consumer = config.Consumer{}
+	})
+
+	// Default sender打印到标准输出
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		content, _ := io.ReadAll(reader)
+		output <- string(content)
+	}()
+
+	if err = InitConsumerBatchLog(); err != nil {
+		os.Stdout = stdout
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, "dry run line 1", "dry run line 2")
+	createFile("index_test", path)
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+	_ = GlobalDataAnalytics.Close()
+
+	os.Stdout = stdout
+	_ = writer.Close()
+	printed := <-output
+
+	for _, line := range []string{"dry run line 1", "dry run line 2"} {
+		if !strings.Contains(printed, line) {
+			t.Errorf("default sender should print %q, got %q", line, printed)
+		}
+	}
+	if requests.Load() != 0 {
+		t.Errorf("dry run should not call elk, got %d requests", requests.Load())
+	}
+}
@@ -0,0 +1,45 @@
+package watch
+
+import (
+	"encoding/base64"
+	"errors"
+	"log-engine-sdk/pkg/k3/config"
+)
+
+// 日志内容的编码, 对应watch.index.encoding配置
+const (
+	EncodingUTF8   = "utf8"   // 按文本发送(默认), 不合法的utf8在序列化时会被替换
+	EncodingBase64 = "base64" // 保留原始字节, base64编码后发送
+)
+
+var (
+	EncodingField = "_encoding" // base64编码发送的日志附加的标记, 值为base64
+)
+
+// resolveEncoding 日志内容的编码, base64只支持format为raw, 编码后的内容无法解析
+func resolveEncoding(indexName string, index config.Index) (string, error) {
+	switch index.Encoding {
+	case "", EncodingUTF8:
+		return EncodingUTF8, nil
+	case EncodingBase64:
+		if (len(index.Format) > 0 && index.Format != FormatRaw) || index.ParseJSON {
+			return "", errors.New("[NewIndexRule] index_name[" + indexName + "] encoding base64 only supports format raw")
+		}
+		return EncodingBase64, nil
+	default:
+		return "", errors.New("[NewIndexRule] index_name[" + indexName + "] unsupported encoding: " + index.Encoding)
+	}
+}
+
+// rawBytes 是否保留日志的原始字节, 不去掉首尾的空白, 截断时不处理不完整的utf8字符
+func (r *IndexRule) rawBytes() bool {
+	return r.encoding == EncodingBase64
+}
+
+// encodeData 按照encoding编码发送的日志内容, 返回是否经过了编码
+func (r *IndexRule) encodeData(data string) (string, bool) {
+	if r.encoding == EncodingBase64 {
+		return base64.StdEncoding.EncodeToString([]byte(data)), true
+	}
+	return data, false
+}
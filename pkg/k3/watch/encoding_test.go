@@ -0,0 +1,85 @@
+package watch
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncodingBase64(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "binary.log")
+		lines    = []string{
+			"\xff\xfe binary \x00\x80  ", // 不合法的utf8, 首尾的空白保留
+			"\xc4\xe3\xba\xc3",           // gbk编码的"你好"
+			"\xe4\xb8\xad\xe6\x96",       // 截断后的最后一个字符不完整
+		}
+	)
+
+	if err := InitIndexRules(map[string]config.Index{"index_binary": {Encoding: EncodingBase64}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, lines[0], lines[1])
+	writeEvent("index_binary", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if len(consumer.datas) != 2 {
+		t.Fatalf("2 lines expected, got %d", len(consumer.datas))
+	}
+
+	// 经过json序列化之后解码, 与原始字节相同
+	for i, data := range consumer.datas {
+		var decoded map[string]interface{}
+
+		content, err := json.Marshal(data.Properties)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = json.Unmarshal(content, &decoded)
+
+		raw, err := base64.StdEncoding.DecodeString(decoded["_data"].(string))
+		if err != nil || string(raw) != lines[i] {
+			t.Errorf("line %d should round-trip losslessly, got %q, %v", i, raw, err)
+		}
+		if decoded[EncodingField] != EncodingBase64 {
+			t.Errorf("line %d should be marked with %s, got %v", i, EncodingField, decoded[EncodingField])
+		}
+	}
+
+	// 截断的行保留原始字节, 不去掉不完整的字符
+	config.GlobalConfig.Watch.MaxLineBytes = len(lines[2])
+	appendLines(t, path, lines[2]+"\x87\xe6\x96\x87")
+	writeEvent("index_binary", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if len(consumer.datas) != 3 {
+		t.Fatalf("truncated line expected, got %d lines", len(consumer.datas))
+	}
+	if raw, _ := base64.StdEncoding.DecodeString(consumer.datas[2].Properties["_data"].(string)); string(raw) != lines[2] {
+		t.Errorf("truncated line should keep raw bytes, got %q", raw)
+	}
+}
+
+func TestResolveEncoding(t *testing.T) {
+	for _, c := range []struct {
+		index    config.Index
+		expected string
+		err      bool
+	}{
+		{config.Index{}, EncodingUTF8, false},
+		{config.Index{Encoding: EncodingBase64}, EncodingBase64, false},
+		{config.Index{Encoding: EncodingBase64, Format: FormatRaw}, EncodingBase64, false},
+		{config.Index{Encoding: EncodingBase64, Format: FormatJSON}, "", true},
+		{config.Index{Encoding: "gbk"}, "", true},
+	} {
+		encoding, err := resolveEncoding("index_test", c.index)
+		if (err != nil) != c.err || encoding != c.expected {
+			t.Errorf("%+v: expected %q (error %v), got %q, %v", c.index, c.expected, c.err, encoding, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package watch
+
+import (
+	"errors"
+	"log-engine-sdk/pkg/k3"
+	"os"
+	"sync"
+	"time"
+)
+
+// 每条日志附加的字段, 对应watch.enrich_fields配置
+const (
+	EnrichHost       = "host"        // 主机名, 启动时获取一次
+	EnrichSourcePath = "source_path" // 日志所在的文件
+	EnrichIndexName  = "index_name"  // 日志所属的index_name
+	EnrichIngestTime = "ingest_time" // agent读取日志的时间
+	EnrichNone       = "none"        // 不附加任何字段
+)
+
+var (
+	DefaultEnrichFields = []string{EnrichHost, EnrichSourcePath, EnrichIndexName, EnrichIngestTime}
+)
+
+var (
+	enrichLock   = &sync.RWMutex{}
+	GlobalEnrich *Enricher // 为nil时不附加字段
+)
+
+// Enricher 由watch.enrich_fields编译而来, 主机名只在创建时获取一次
+type Enricher struct {
+	fields   map[string]bool
+	hostname string
+}
+
+// NewEnricher 编译需要附加的字段, fields为空时附加所有字段, 配置none时返回nil, 不支持的字段返回错误
+func NewEnricher(fields []string) (*Enricher, error) {
+	var (
+		enricher = &Enricher{fields: make(map[string]bool)}
+		err      error
+	)
+
+	if len(fields) == 0 {
+		fields = DefaultEnrichFields
+	}
+
+	for _, field := range fields {
+		switch field {
+		case EnrichNone:
+			return nil, nil
+		case EnrichHost, EnrichSourcePath, EnrichIndexName, EnrichIngestTime:
+			enricher.fields[field] = true
+		default:
+			return nil, errors.New("[NewEnricher] unsupported enrich field: " + field)
+		}
+	}
+
+	if enricher.fields[EnrichHost] {
+		if enricher.hostname, err = os.Hostname(); err != nil {
+			k3.K3LogError("[NewEnricher] get hostname failed: %s", err.Error())
+			enricher.hostname = "unknown"
+		}
+	}
+
+	return enricher, nil
+}
+
+// InitEnrich 编译watch.enrich_fields配置
+func InitEnrich(fields []string) error {
+	var (
+		enricher *Enricher
+		err      error
+	)
+
+	if enricher, err = NewEnricher(fields); err != nil {
+		return err
+	}
+
+	enrichLock.Lock()
+	GlobalEnrich = enricher
+	enrichLock.Unlock()
+
+	return nil
+}
+
+// getEnrich 获取当前的附加字段规则
+func getEnrich() *Enricher {
+	enrichLock.RLock()
+	defer enrichLock.RUnlock()
+
+	return GlobalEnrich
+}
+
+// enrich 将配置的字段附加到日志properties中, 没有开启时不做处理
+func (e *Enricher) enrich(properties map[string]interface{}, fileState *FileState) {
+	if e == nil {
+		return
+	}
+
+	if e.fields[EnrichHost] {
+		properties[EnrichHost] = e.hostname
+	}
+
+	if e.fields[EnrichSourcePath] {
+		properties[EnrichSourcePath] = fileState.Path
+	}
+
+	if e.fields[EnrichIndexName] {
+		properties[EnrichIndexName] = fileState.IndexName
+	}
+
+	if e.fields[EnrichIngestTime] {
+		properties[EnrichIngestTime] = nowFunc().Format(time.RFC3339Nano)
+	}
+}
@@ -0,0 +1,76 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnrichFields(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+		now      = time.Date(2024, 10, 16, 8, 30, 0, 0, time.UTC)
+	)
+
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	if err := InitEnrich(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	assertLines(t, consumer, "line 1")
+
+	hostname, _ := os.Hostname()
+	properties := consumer.datas[0].Properties
+	for field, expected := range map[string]string{
+		EnrichHost:       hostname,
+		EnrichSourcePath: path,
+		EnrichIndexName:  "index_test",
+		EnrichIngestTime: "2024-10-16T08:30:00Z",
+	} {
+		if properties[field] != expected {
+			t.Errorf("%s expected %q, got %v", field, expected, properties[field])
+		}
+	}
+}
+
+func TestEnrichFieldsConfigurable(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+
+	// 不附加主机名
+	if err := InitEnrich([]string{EnrichSourcePath}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	properties := consumer.datas[0].Properties
+	if properties[EnrichSourcePath] != path {
+		t.Errorf("source_path should be added, got %v", properties[EnrichSourcePath])
+	}
+	for _, field := range []string{EnrichHost, EnrichIndexName, EnrichIngestTime} {
+		if _, exists := properties[field]; exists {
+			t.Errorf("%s should not be added", field)
+		}
+	}
+
+	if enricher, err := NewEnricher([]string{EnrichNone}); err != nil || enricher != nil {
+		t.Errorf("none should disable enrichment, got %v %v", enricher, err)
+	}
+	if _, err := NewEnricher([]string{"hostname"}); err == nil {
+		t.Errorf("unsupported enrich field should return error")
+	}
+}
@@ -0,0 +1,12 @@
+package watch
+
+import "errors"
+
+// 主要失败点的错误, 调用方可以通过errors.Is判断在哪个阶段失败, 底层的错误(如os.ErrNotExist)同样可以通过errors.Is/errors.As获取
+var (
+	ErrStateLoad    = errors.New("load state file failed")           // 创建, 读取或者解析状态文件失败
+	ErrStateSave    = errors.New("save state file failed")           // 写入状态文件失败
+	ErrScan         = errors.New("scan log files failed")            // 启动时扫描日志文件并保存状态失败
+	ErrWatcherInit  = errors.New("init watcher failed")              // 创建监听协程或者目录加入监听失败
+	ErrDrainTimeout = errors.New("drain reading goroutines timeout") // 退出时等待读取协程结束超时
+)
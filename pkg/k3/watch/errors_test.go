@@ -0,0 +1,53 @@
+package watch
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorsWrapCause(t *testing.T) {
+	initTestWatch(t)
+
+	var (
+		dir         = t.TempDir()
+		missing     = filepath.Join(dir, "missing", "core.json")
+		corrupt     = filepath.Join(dir, "corrupt.json")
+		syntaxError *json.SyntaxError
+	)
+
+	if err := os.WriteFile(corrupt, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := LoadDiskFileToGlobalFileStates(missing)
+	if !errors.Is(err, ErrStateLoad) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("load missing state file should match ErrStateLoad and fs.ErrNotExist, got %v", err)
+	}
+
+	err = LoadDiskFileToGlobalFileStates(corrupt)
+	if !errors.Is(err, ErrStateLoad) || !errors.As(err, &syntaxError) {
+		t.Errorf("load corrupt state file should match ErrStateLoad and *json.SyntaxError, got %v", err)
+	}
+
+	err = SaveGlobalFileStatesToDiskFile(missing)
+	if !errors.Is(err, ErrStateSave) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("save state file should match ErrStateSave and fs.ErrNotExist, got %v", err)
+	}
+
+	err = ScanLogFileToGlobalFileStatesAndSaveToDiskFile(map[string][]string{"index_test": {dir}}, missing)
+	if !errors.Is(err, ErrScan) || !errors.Is(err, ErrStateSave) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("scan should match ErrScan, ErrStateSave and fs.ErrNotExist, got %v", err)
+	}
+
+	config.GlobalConfig.Watch.FailOnPartialInit = true
+	err = InitWatcher(map[string][]string{"index_test": {filepath.Join(dir, "missing")}}, FileStateFilePath)
+	if !errors.Is(err, ErrWatcherInit) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("init watcher should match ErrWatcherInit and fs.ErrNotExist, got %v", err)
+	}
+	WatcherWG.Wait()
+}
@@ -0,0 +1,131 @@
+package watch
+
+import (
+	"context"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	DefaultRetryInterval = 10 // 单位秒, 定时重新监听加入失败的目录
+)
+
+var (
+	failedDirectoriesLock = &sync.Mutex{}
+	failedDirectories     = make(map[string]map[string]error) // index_name -> 加入监听失败的目录 -> 失败原因
+)
+
+// recordFailedDirectory 记录加入监听失败的目录
+func recordFailedDirectory(indexName, dir string, err error) {
+	failedDirectoriesLock.Lock()
+	defer failedDirectoriesLock.Unlock()
+
+	if _, ok := failedDirectories[indexName]; !ok {
+		failedDirectories[indexName] = make(map[string]error)
+	}
+	failedDirectories[indexName][dir] = err
+}
+
+// clearFailedDirectory 目录重新加入监听成功或者不再监听时移除记录
+func clearFailedDirectory(indexName, dir string) {
+	failedDirectoriesLock.Lock()
+	defer failedDirectoriesLock.Unlock()
+
+	delete(failedDirectories[indexName], dir)
+	if len(failedDirectories[indexName]) == 0 {
+		delete(failedDirectories, indexName)
+	}
+}
+
+// resetFailedDirectories 清空所有记录, InitVars时调用
+func resetFailedDirectories() {
+	failedDirectoriesLock.Lock()
+	failedDirectories = make(map[string]map[string]error)
+	failedDirectoriesLock.Unlock()
+}
+
+// FailedDirectories 返回加入监听失败的目录, index_name -> 目录(已排序), 用于之后重试
+func FailedDirectories() map[string][]string {
+	failedDirectoriesLock.Lock()
+	defer failedDirectoriesLock.Unlock()
+
+	var directory = make(map[string][]string, len(failedDirectories))
+	for indexName, dirs := range failedDirectories {
+		for dir := range dirs {
+			directory[indexName] = append(directory[indexName], dir)
+		}
+		sort.Strings(directory[indexName])
+	}
+
+	return directory
+}
+
+// ClockRetryFailedDirectories 定时重新监听加入失败的目录(如应用第一次写入时才创建的目录), 成功后读取目录中已经存在的文件
+func ClockRetryFailedDirectories() {
+	var (
+		retryInterval = config.GlobalConfig.Watch.RetryInterval
+		ctx           = WatcherContext
+		clockWG       = ClockWG
+		t             *time.Ticker
+	)
+
+	if retryInterval <= 0 {
+		retryInterval = DefaultRetryInterval
+	}
+	t = time.NewTicker(time.Duration(retryInterval) * time.Second)
+
+	clockWG.Add(1)
+	go func(ctx context.Context) {
+		defer clockWG.Done()
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				retryFailedDirectories()
+			case <-ctx.Done():
+				k3.K3LogInfo("[ClockRetryFailedDirectories] Accept clock goroutine exit singal.")
+				return
+			}
+		}
+	}(ctx)
+}
+
+// retryFailedDirectories 重新监听所有加入失败的目录, 目录出现后连同已经存在的子目录一起加入监听, read_path中的文件出现后监听所在的目录
+func retryFailedDirectories() {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	for indexName, dirs := range FailedDirectories() {
+		entry, exists := getIndexWatcher(indexName)
+		if !exists {
+			continue
+		}
+
+		for _, dir := range dirs {
+			paths, err := expandWatchPath(dir)
+			if err != nil {
+				k3.K3LogDebug("[retryFailedDirectories] index_name[%s] dir[%s] still not available: %s", indexName, dir, err.Error())
+				continue
+			}
+
+			for _, path := range paths {
+				if err = addWatchDirectory(indexName, entry.watcher, path); err != nil {
+					break
+				}
+			}
+
+			if err != nil {
+				k3.K3LogError("[retryFailedDirectories] index_name[%s] add dir[%s] to watcher failed: %s", indexName, dir, err.Error())
+				recordFailedDirectory(indexName, dir, err)
+				continue
+			}
+
+			k3.K3LogInfo("[retryFailedDirectories] index_name[%s] dir[%s] is watched.", indexName, dir)
+			clearFailedDirectory(indexName, dir)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInitWatcherSkipFailedDirectory(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		missing  = filepath.Join(t.TempDir(), "missing") // 不存在的目录, 加入监听失败
+	)
+
+	if err := InitWatcher(map[string][]string{"index_test": {missing, dir}, "index_missing": {missing + "_other"}}, FileStateFilePath); err != nil {
+		t.Fatalf("failed directory should be skipped, got %s", err)
+	}
+
+	if err := checkLive(); err != nil {
+		t.Fatalf("watcher should keep running, got %s", err)
+	}
+
+	failed := FailedDirectories()
+	if len(failed) != 2 || len(failed["index_test"]) != 1 || failed["index_test"][0] != missing || failed["index_missing"][0] != missing+"_other" {
+		t.Fatalf("failed directories should be recorded, got %v", failed)
+	}
+
+	// 其他目录照常监听并读取
+	appendLines(t, filepath.Join(dir, "app.log"), "line 1")
+	waitFor(t, func() bool { return len(consumer.lines()) == 1 })
+
+	assertLines(t, consumer, "line 1")
+
+	clearFailedDirectory("index_test", missing)
+	if failed = FailedDirectories(); len(failed["index_test"]) != 0 {
+		t.Errorf("cleared directory should not be recorded, got %v", failed)
+	}
+}
+
+func TestInitWatcherFailOnPartialInit(t *testing.T) {
+	initTestWatch(t)
+	config.GlobalConfig.Watch.FailOnPartialInit = true
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	if err := InitWatcher(map[string][]string{"index_test": {t.TempDir(), missing}}, FileStateFilePath); err == nil {
+		t.Fatalf("fail_on_partial_init should abort when a directory fails")
+	}
+
+	WatcherWG.Wait()
+	if WatcherContext.Err() == nil {
+		t.Errorf("all watchers should exit when fail_on_partial_init is set")
+	}
+
+	if failed := FailedDirectories(); len(failed) != 0 {
+		t.Errorf("aborted init should not record failed directories, got %v", failed)
+	}
+}
+
+func TestRetryFailedDirectory(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		missing  = filepath.Join(t.TempDir(), "missing")
+	)
+	config.GlobalConfig.Watch.RetryInterval = 1
+
+	if err := InitWatcher(ExpandWatchDirectory(map[string][]string{"index_test": {dir, missing}}), FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	ClockRetryFailedDirectories()
+
+	if failed := FailedDirectories(); len(failed["index_test"]) != 1 {
+		t.Fatalf("missing directory should be recorded, got %v", failed)
+	}
+
+	// 应用启动后才创建目录并写入
+	time.Sleep(200 * time.Millisecond)
+	if err := os.MkdirAll(filepath.Join(missing, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, filepath.Join(missing, "app.log"), "line 1")
+	appendLines(t, filepath.Join(missing, "sub", "app.log"), "sub 1")
+
+	waitFor(t, func() bool { return len(FailedDirectories()) == 0 })
+	waitFor(t, func() bool { return len(consumer.lines()) == 2 })
+
+	// 重试成功后新的写入通过监听读取
+	appendLines(t, filepath.Join(missing, "sub", "app.log"), "sub 2")
+	waitFor(t, func() bool { return len(consumer.lines()) == 3 })
+
+	lines := consumer.lines()
+	sort.Strings(lines)
+	if strings.Join(lines, ",") != "line 1,sub 1,sub 2" {
+		t.Errorf("files in retried directory should be read, got %v", lines)
+	}
+
+	// 退出信号之后重试协程退出
+	WatcherContextCancel()
+	ClockWG.Wait()
+	WatcherWG.Wait()
+	processingWg.Wait()
+}
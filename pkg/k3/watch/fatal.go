@@ -0,0 +1,35 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3"
+	"sync"
+)
+
+var (
+	fatalLock     = &sync.Mutex{}
+	fatalCallback func(err error) // 监听协程异常退出时调用
+)
+
+// OnFatal 设置监听协程异常退出(如fsnotify的通道被关闭、返回错误)时的回调, err为退出的原因
+// 此时WatcherContext已经被取消, 不会再读取日志, 嵌入的程序可以在回调中退出或者重新调用Run
+// 回调在单独的协程中执行, 可以在回调中调用Run返回的关闭函数; 设置为nil时取消回调
+func OnFatal(callback func(err error)) {
+	fatalLock.Lock()
+	fatalCallback = callback
+	fatalLock.Unlock()
+}
+
+// reportFatal 记录日志并调用OnFatal设置的回调, 热加载或者退出时主动停止的协程不调用
+func reportFatal(err error) {
+	var callback func(err error)
+
+	k3.K3LogError("[forkWatcher] watcher exit abnormally: %s", err.Error())
+
+	fatalLock.Lock()
+	callback = fatalCallback
+	fatalLock.Unlock()
+
+	if callback != nil {
+		go callback(err)
+	}
+}
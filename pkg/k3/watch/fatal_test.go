@@ -0,0 +1,64 @@
+package watch
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOnFatal(t *testing.T) {
+	var fatal = make(chan error, 1)
+
+	initTestWatch(t)
+	OnFatal(func(err error) { fatal <- err })
+	t.Cleanup(func() { OnFatal(nil) })
+
+	if err := InitWatcher(map[string][]string{"index_test": {t.TempDir()}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, ok := getIndexWatcher("index_test")
+	if !ok {
+		t.Fatal("watcher of index_test should be registered")
+	}
+
+	// 关闭fsnotify的watcher会关闭事件通道, 监听协程异常退出
+	if err := entry.watcher.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-fatal:
+		if !strings.Contains(err.Error(), "index_name[index_test]") || !strings.Contains(err.Error(), "channel closed") {
+			t.Errorf("fatal error should carry the index_name and the cause, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnFatal callback should fire when the watcher exits abnormally")
+	}
+
+	if WatcherContext.Err() == nil {
+		t.Error("WatcherContext should be cancelled after the watcher exits abnormally")
+	}
+}
+
+func TestOnFatalCancelled(t *testing.T) {
+	var fatal = make(chan error, 1)
+
+	initTestWatch(t)
+	OnFatal(func(err error) { fatal <- err })
+	t.Cleanup(func() { OnFatal(nil) })
+
+	if err := InitWatcher(map[string][]string{"index_test": {t.TempDir()}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// 主动取消时不是异常退出, 不调用回调
+	WatcherContextCancel()
+	WatcherWG.Wait()
+
+	select {
+	case err := <-fatal:
+		t.Errorf("OnFatal callback should not fire on cancel, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,236 @@
+package watch
+
+import (
+	"container/list"
+	"errors"
+	"log-engine-sdk/pkg/k3"
+	"os"
+	"sync"
+	"time"
+)
+
+// fdEntry 缓存中的一个文件句柄
+type fdEntry struct {
+	path     string
+	fd       *os.File
+	inUse    int       // 正在使用该句柄的读取协程数量, 使用中的句柄不会被淘汰
+	lastUsed time.Time // 最后一次使用该句柄的时间
+}
+
+// FdCache 缓存正在读取的文件句柄, 避免每次写事件都重新打开文件
+// 文件被删除后, 已打开的句柄依然可以读取到删除前写入的数据(Linux)
+// 缓存数量超过capacity时, 按照LRU淘汰最久未使用的句柄
+type FdCache struct {
+	lock     *sync.Mutex
+	capacity int                      // 最大缓存句柄数量
+	lru      *list.List               // 最近使用的句柄在前面
+	fds      map[string]*list.Element // path -> lru element
+	taken    map[*os.File]*fdEntry    // 已经从缓存中取出, 等待最后一个使用者Release时关闭的句柄
+	evicted  int                      // 累计淘汰的句柄数量
+}
+
+func NewFdCache(capacity int) *FdCache {
+	if capacity <= 0 {
+		capacity = DefaultMaxOpenFiles
+	}
+
+	return &FdCache{
+		lock:     &sync.Mutex{},
+		capacity: capacity,
+		lru:      list.New(),
+		fds:      make(map[string]*list.Element),
+		taken:    make(map[*os.File]*fdEntry),
+	}
+}
+
+// Open 获取path对应的句柄, 缓存中不存在就以只读方式打开并缓存
+// 使用完句柄后必须调用Release释放, 否则该句柄不会被淘汰
+func (c *FdCache) Open(path string) (*os.File, error) {
+	var (
+		fd      *os.File
+		element *list.Element
+		entry   *fdEntry
+		ok      bool
+		err     error
+	)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok = c.fds[path]; ok {
+		entry = element.Value.(*fdEntry)
+		entry.inUse++
+		entry.lastUsed = nowFunc()
+		c.lru.MoveToFront(element)
+		return entry.fd, nil
+	}
+
+	if fd, err = openFileFunc(path, os.O_RDONLY, 0666); err != nil {
+		return nil, err
+	}
+
+	if c.lru.Len() >= c.capacity {
+		c.evict()
+	}
+
+	c.fds[path] = c.lru.PushFront(&fdEntry{path: path, fd: fd, inUse: 1, lastUsed: nowFunc()})
+	k3.MetricFdCacheSize.Set(int64(c.lru.Len()))
+
+	return fd, nil
+}
+
+// Release 读取协程使用完句柄后释放, fd用于确认释放的是同一个句柄(同名文件可能已经被重新打开)
+// 已经被Take取出的句柄, 最后一个使用者释放时关闭
+func (c *FdCache) Release(path string, fd *os.File) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if entry, ok := c.taken[fd]; ok {
+		if entry.inUse--; entry.inUse == 0 {
+			if err := fd.Close(); err != nil {
+				k3.K3LogWarn("[FdCache] close taken fd[%s] failed: %s", entry.path, err.Error())
+			}
+			delete(c.taken, fd)
+		}
+		return
+	}
+
+	if element, ok := c.fds[path]; ok {
+		if entry := element.Value.(*fdEntry); entry.fd == fd && entry.inUse > 0 {
+			entry.inUse--
+			entry.lastUsed = nowFunc()
+		}
+	}
+}
+
+// evict 关闭并移除最久未使用, 且没有被使用的句柄, 调用方需要持有锁
+func (c *FdCache) evict() {
+	for element := c.lru.Back(); element != nil; element = element.Prev() {
+		entry := element.Value.(*fdEntry)
+		if entry.inUse > 0 {
+			continue
+		}
+
+		if err := entry.fd.Close(); err != nil {
+			k3.K3LogWarn("[FdCache] close evicted fd[%s] failed: %s", entry.path, err.Error())
+		}
+		c.lru.Remove(element)
+		delete(c.fds, entry.path)
+		c.evicted++
+		k3.MetricFdCacheEvictedTotal.Add(1)
+		k3.K3LogDebug("[FdCache] evict fd[%s], cache size: %d", entry.path, c.lru.Len())
+		return
+	}
+
+	k3.K3LogWarn("[FdCache] all %d cached fds are in use, cache size exceeds capacity(%d).", c.lru.Len(), c.capacity)
+}
+
+// CloseIdle 关闭超过idle时间没有使用的句柄, 返回关闭的句柄数量, 文件再次写入时重新打开
+func (c *FdCache) CloseIdle(idle time.Duration) int {
+	var (
+		deadline = nowFunc().Add(-idle)
+		closed   int
+	)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for element := c.lru.Back(); element != nil; {
+		entry := element.Value.(*fdEntry)
+		prev := element.Prev()
+
+		// 使用中的句柄不关闭
+		if entry.inUse == 0 && !entry.lastUsed.After(deadline) {
+			if err := entry.fd.Close(); err != nil {
+				k3.K3LogWarn("[FdCache] close idle fd[%s] failed: %s", entry.path, err.Error())
+			}
+			c.lru.Remove(element)
+			delete(c.fds, entry.path)
+			closed++
+			k3.K3LogDebug("[FdCache] close idle fd[%s], cache size: %d", entry.path, c.lru.Len())
+		}
+		element = prev
+	}
+	k3.MetricFdCacheSize.Set(int64(c.lru.Len()))
+
+	return closed
+}
+
+// Take 将path对应的句柄从缓存中取出, 之后Open同名文件时重新打开
+// 调用方使用完句柄后同样调用Release, 其他读取协程还在使用该句柄时, 最后一个使用者Release时才关闭
+func (c *FdCache) Take(path string) (*os.File, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.fds[path]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*fdEntry)
+	entry.inUse++
+	c.taken[entry.fd] = entry
+	c.lru.Remove(element)
+	delete(c.fds, path)
+	k3.MetricFdCacheSize.Set(int64(c.lru.Len()))
+
+	return entry.fd, true
+}
+
+// Remove 移除path对应的句柄, 没有被使用时立即关闭
+func (c *FdCache) Remove(path string) {
+	if fd, ok := c.Take(path); ok {
+		c.Release(path, fd)
+	}
+}
+
+// Cached path对应的句柄是否在缓存中
+func (c *FdCache) Cached(path string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, ok := c.fds[path]
+	return ok
+}
+
+// Len 当前缓存的句柄数量
+func (c *FdCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len()
+}
+
+// Evicted 累计淘汰的句柄数量
+func (c *FdCache) Evicted() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.evicted
+}
+
+// Close 关闭所有缓存的句柄
+func (c *FdCache) Close() error {
+	var (
+		errs []error
+	)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for path, element := range c.fds {
+		if err := element.Value.(*fdEntry).fd.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(c.fds, path)
+	}
+	c.lru.Init()
+	k3.MetricFdCacheSize.Set(0)
+
+	for fd := range c.taken {
+		if err := fd.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(c.taken, fd)
+	}
+
+	return errors.Join(errs...)
+}
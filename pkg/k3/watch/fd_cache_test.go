@@ -0,0 +1,245 @@
+package watch
+
+import (
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createTestFiles(t *testing.T, count int) []string {
+	var (
+		dir   = t.TempDir()
+		paths []string
+	)
+
+	for i := 0; i < count; i++ {
+		path := filepath.Join(dir, "app.log."+string(rune('a'+i)))
+		if err := os.WriteFile(path, []byte("line\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+func TestFdCacheEvict(t *testing.T) {
+	var (
+		cache = NewFdCache(2)
+		paths = createTestFiles(t, 3)
+		fds   []*os.File
+	)
+	defer cache.Close()
+
+	for _, path := range paths {
+		fd, err := cache.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.Release(path, fd)
+		fds = append(fds, fd)
+	}
+
+	if cache.Len() != 2 {
+		t.Errorf("cache size should be 2, got %d", cache.Len())
+	}
+
+	if cache.Evicted() != 1 {
+		t.Errorf("evicted count should be 1, got %d", cache.Evicted())
+	}
+
+	// 最久未使用的句柄被淘汰并关闭
+	if _, err := fds[0].Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("evicted fd should be closed, got %v", err)
+	}
+
+	if _, err := fds[2].Stat(); err != nil {
+		t.Errorf("cached fd should be open, got %v", err)
+	}
+}
+
+func TestFdCacheLRU(t *testing.T) {
+	var (
+		cache = NewFdCache(2)
+		paths = createTestFiles(t, 3)
+	)
+	defer cache.Close()
+
+	a, _ := cache.Open(paths[0])
+	cache.Release(paths[0], a)
+	b, _ := cache.Open(paths[1])
+	cache.Release(paths[1], b)
+
+	// 再次使用a, b变成最久未使用的句柄
+	if fd, _ := cache.Open(paths[0]); fd != a {
+		t.Errorf("cached fd should be reused")
+	}
+	cache.Release(paths[0], a)
+
+	c, _ := cache.Open(paths[2])
+	cache.Release(paths[2], c)
+
+	if _, err := b.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("least recently used fd should be closed, got %v", err)
+	}
+
+	if _, err := a.Stat(); err != nil {
+		t.Errorf("recently used fd should be open, got %v", err)
+	}
+}
+
+func TestFdCacheInUseNotEvicted(t *testing.T) {
+	var (
+		cache = NewFdCache(1)
+		paths = createTestFiles(t, 2)
+	)
+	defer cache.Close()
+
+	// a 还在使用中, 不能被淘汰
+	a, _ := cache.Open(paths[0])
+	b, _ := cache.Open(paths[1])
+	cache.Release(paths[1], b)
+
+	if _, err := a.Stat(); err != nil {
+		t.Errorf("in use fd should not be closed, got %v", err)
+	}
+
+	if cache.Len() != 2 || cache.Evicted() != 0 {
+		t.Errorf("cache size should be 2 and no eviction, got %d, %d", cache.Len(), cache.Evicted())
+	}
+
+	cache.Release(paths[0], a)
+	if err := cache.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := a.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("fd should be closed after cache closed, got %v", err)
+	}
+}
+
+func TestFdCacheCloseIdle(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+		now      = time.Now()
+	)
+
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	// 读取时缓存的句柄
+	fd, _ := GlobalFdCache.Open(path)
+	GlobalFdCache.Release(path, fd)
+
+	// 没有超过idle时间, 句柄保留
+	now = now.Add(30 * time.Second)
+	if closed := GlobalFdCache.CloseIdle(time.Minute); closed != 0 {
+		t.Errorf("fd should not be closed before idle timeout, closed %d", closed)
+	}
+
+	now = now.Add(31 * time.Second)
+	if closed := GlobalFdCache.CloseIdle(time.Minute); closed != 1 {
+		t.Errorf("idle fd should be closed, closed %d", closed)
+	}
+
+	if _, err := fd.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("idle fd should be closed, got %v", err)
+	}
+
+	if GlobalFdCache.Len() != 0 {
+		t.Errorf("fd cache should be empty, got %d", GlobalFdCache.Len())
+	}
+
+	// 文件状态保留, 再次写入时重新打开, 从上次的offset继续读取
+	if _, exists := GlobalFileStates[path]; !exists {
+		t.Fatalf("file state of %s should be kept", path)
+	}
+
+	appendLines(t, path, "line 2")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	assertLines(t, consumer, "line 1", "line 2")
+	if GlobalFdCache.Len() != 1 {
+		t.Errorf("fd should be reopened, cache size %d", GlobalFdCache.Len())
+	}
+}
+
+func TestFdCacheCloseIdleInUse(t *testing.T) {
+	var (
+		cache = NewFdCache(2)
+		paths = createTestFiles(t, 1)
+		now   = time.Now()
+	)
+	defer cache.Close()
+
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	fd, _ := cache.Open(paths[0])
+	now = now.Add(time.Hour)
+
+	// 正在读取的句柄不关闭
+	if closed := cache.CloseIdle(time.Minute); closed != 0 {
+		t.Errorf("in use fd should not be closed, closed %d", closed)
+	}
+
+	cache.Release(paths[0], fd)
+	if closed := cache.CloseIdle(time.Minute); closed != 0 {
+		t.Errorf("fd released just now should not be closed, closed %d", closed)
+	}
+}
+
+func TestFdCacheTakeInUse(t *testing.T) {
+	var (
+		cache = NewFdCache(2)
+		paths = createTestFiles(t, 2)
+	)
+	defer cache.Close()
+
+	// 正在读取的句柄被取出时不关闭, 最后一个使用者释放时才关闭
+	reading, _ := cache.Open(paths[0])
+	taken, ok := cache.Take(paths[0])
+	if !ok || taken != reading {
+		t.Fatal("cached fd should be taken")
+	}
+	if cache.Len() != 0 {
+		t.Errorf("taken fd should be removed from cache, got %d", cache.Len())
+	}
+
+	cache.Release(paths[0], taken)
+	if _, err := reading.Stat(); err != nil {
+		t.Errorf("fd still being read should be open, got %v", err)
+	}
+
+	// 同名文件重新打开新的句柄
+	if fd, _ := cache.Open(paths[0]); fd == reading {
+		t.Error("taken fd should not be reused")
+	} else {
+		cache.Release(paths[0], fd)
+	}
+
+	cache.Release(paths[0], reading)
+	if _, err := reading.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("taken fd should be closed after the last release, got %v", err)
+	}
+
+	// Remove同样等到读取结束后关闭
+	reading, _ = cache.Open(paths[1])
+	cache.Remove(paths[1])
+	if _, err := reading.Stat(); err != nil {
+		t.Errorf("fd still being read should be open after remove, got %v", err)
+	}
+	cache.Release(paths[1], reading)
+	if _, err := reading.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("removed fd should be closed after release, got %v", err)
+	}
+}
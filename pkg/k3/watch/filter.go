@@ -0,0 +1,44 @@
+package watch
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// compilePatterns 编译include_patterns/exclude_patterns, 任意一个正则不合法时返回错误
+func compilePatterns(name string, patterns []string) ([]*regexp.Regexp, error) {
+	var (
+		compiled = make([]*regexp.Regexp, 0, len(patterns))
+		re       *regexp.Regexp
+		err      error
+	)
+
+	for _, pattern := range patterns {
+		if re, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid %s[%s]: %w", name, pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+
+	return compiled, nil
+}
+
+// matchAny 是否匹配任意一个正则
+func matchAny(patterns []*regexp.Regexp, line string) bool {
+	for _, re := range patterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldShip 匹配include_patterns(没有配置时全部匹配)且不匹配任何exclude_patterns的日志才发送
+// 被过滤的日志不发送, offset照常前进
+func (r *IndexRule) shouldShip(line string) bool {
+	if len(r.includePatterns) > 0 && !matchAny(r.includePatterns, line) {
+		return false
+	}
+
+	return !matchAny(r.excludePatterns, line)
+}
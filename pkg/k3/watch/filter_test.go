@@ -0,0 +1,56 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilterPatterns(t *testing.T) {
+	var lines = []string{"DEBUG cache hit", "INFO request done", "WARN slow query", "ERROR db timeout", "ERROR healthcheck failed"}
+
+	for _, item := range []struct {
+		name     string
+		index    config.Index
+		expected []string
+	}{
+		{"include", config.Index{IncludePatterns: []string{`^ERROR`, `^WARN`}}, []string{"WARN slow query", "ERROR db timeout", "ERROR healthcheck failed"}},
+		{"exclude", config.Index{ExcludePatterns: []string{`^DEBUG`}}, []string{"INFO request done", "WARN slow query", "ERROR db timeout", "ERROR healthcheck failed"}},
+		{"combined", config.Index{IncludePatterns: []string{`^ERROR`, `^WARN`}, ExcludePatterns: []string{`healthcheck`}}, []string{"WARN slow query", "ERROR db timeout"}},
+	} {
+		t.Run(item.name, func(t *testing.T) {
+			var (
+				consumer = initTestWatch(t)
+				path     = filepath.Join(t.TempDir(), "app.log")
+			)
+
+			if err := InitIndexRules(map[string]config.Index{"index_test": item.index}); err != nil {
+				t.Fatal(err)
+			}
+
+			appendLines(t, path, lines...)
+			writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+			processingWg.Wait()
+
+			assertLines(t, consumer, item.expected...)
+
+			// 被过滤的日志offset照常前进
+			info, _ := os.Stat(path)
+			if GlobalFileStates[path].Offset != info.Size() {
+				t.Errorf("offset should advance past filtered lines, expected %d, got %d", info.Size(), GlobalFileStates[path].Offset)
+			}
+		})
+	}
+}
+
+func TestInvalidFilterPatterns(t *testing.T) {
+	if err := InitIndexRules(map[string]config.Index{"index_test": {IncludePatterns: []string{`ERROR(`}}}); err == nil {
+		t.Errorf("invalid include_patterns should return error")
+	}
+
+	if err := InitIndexRules(map[string]config.Index{"index_test": {ExcludePatterns: []string{`[DEBUG`}}}); err == nil {
+		t.Errorf("invalid exclude_patterns should return error")
+	}
+}
@@ -0,0 +1,83 @@
+package watch
+
+import (
+	"context"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"sync"
+	"time"
+)
+
+var (
+	flushSyncCh chan struct{} // 批量提交成功后请求同步状态文件, 容量为1, 同步之前的多次请求合并为一次
+)
+
+// resetFlushSync 丢弃之前的同步请求, InitVars时调用
+func resetFlushSync() {
+	flushSyncCh = make(chan struct{}, 1)
+}
+
+// requestFlushSync 请求同步状态文件, 已经有等待中的请求时直接返回, 不会阻塞consumer的提交
+func requestFlushSync() {
+	select {
+	case flushSyncCh <- struct{}{}:
+	default:
+	}
+}
+
+// onBatchSent consumer的OnSend回调, 确认wal和读取位置后, 提交成功时请求将最新的offset同步到硬盘
+// 不需要等待sync_interval的定时器, 减少崩溃后重复发送的数据
+func onBatchSent(datas []protocol.Data, err error) {
+	ackWals(datas, err)
+	ackDeliveries(datas, err)
+
+	if err == nil && len(datas) > 0 {
+		requestFlushSync()
+	}
+}
+
+// ClockFlushSync 批量提交成功后同步GlobalFileStates到硬盘, 两次同步之间至少间隔watch.flush_sync_interval
+// 间隔内的多次提交合并为一次同步, 避免频繁写入状态文件
+func ClockFlushSync(filePath string) {
+	var (
+		flushSyncInterval = config.GlobalConfig.Watch.FlushSyncInterval
+		ctx               = WatcherContext
+		clockWG           = ClockWG
+		ch                = flushSyncCh
+	)
+
+	// 没有配置时不开启, 只依赖sync_interval定时同步
+	if flushSyncInterval <= 0 {
+		return
+	}
+
+	clockWG.Add(1)
+	go func(clockWG *sync.WaitGroup, ctx context.Context) {
+		defer clockWG.Done()
+
+		for {
+			select {
+			case <-ch:
+				if err := SaveGlobalFileStatesToDiskFile(filePath); err != nil {
+					k3.K3LogError("[ClockFlushSync] save file state to disk failed: %s", err.Error())
+				} else {
+					k3.K3LogDebug("[ClockFlushSync] save file state to disk after batch flush success.")
+				}
+			case <-ctx.Done():
+				k3.K3LogInfo("[ClockFlushSync] Accept clock goroutine exit singal.")
+				return
+			}
+
+			// 等待间隔结束, 期间的同步请求留在ch中, 之后只同步一次
+			t := time.NewTimer(time.Duration(flushSyncInterval) * time.Millisecond)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				k3.K3LogInfo("[ClockFlushSync] Accept clock goroutine exit singal.")
+				return
+			}
+		}
+	}(clockWG, ctx)
+}
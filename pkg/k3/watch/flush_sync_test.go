@@ -0,0 +1,116 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// initTestFlushSync 使用批量consumer, 数据留在buffer中直到FlushAll提交, 提交成功后同步状态文件, sync_interval的定时器不会在测试期间触发
+func initTestFlushSync(t *testing.T, flushSyncInterval int) *k3.K3BatchConsumer {
+	var (
+		consumer protocol.K3Consumer
+		err      error
+	)
+
+	initTestWatch(t)
+	config.GlobalConfig.Watch.SyncInterval = DefaultSyncInterval
+	config.GlobalConfig.Watch.FlushSyncInterval = flushSyncInterval
+
+	if consumer, err = k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{
+		Sender:    &nopSender{},
+		BatchSize: 100,
+		OnSend:    onBatchSent,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+	t.Cleanup(func() { _ = consumer.Close() })
+
+	ClockSyncGlobalFileStatesToDiskFile(FileStateFilePath)
+	ClockFlushSync(FileStateFilePath)
+
+	return consumer.(*k3.K3BatchConsumer)
+}
+
+// diskOffset 状态文件中path的offset, 没有记录或者正在写入时返回-1
+func diskOffset(path string) int64 {
+	content, err := os.ReadFile(FileStateFilePath)
+	if err != nil || len(content) == 0 {
+		return -1
+	}
+
+	stateFile, _, err := decodeStateFile(content)
+	if err != nil {
+		return -1
+	}
+
+	if fileState, ok := stateFile.fileStates()[path]; ok {
+		return fileState.Offset
+	}
+	return -1
+}
+
+func TestFlushSyncAfterBatchFlush(t *testing.T) {
+	var (
+		path     = filepath.Join(t.TempDir(), "app.log")
+		consumer = initTestFlushSync(t, 1000)
+	)
+
+	appendLines(t, path, "line 1", "line 2")
+	createFile("index_flush", path)
+	writeEvent("index_flush", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	// 数据还在consumer的缓存中, 没有提交时不同步
+	time.Sleep(50 * time.Millisecond)
+	if offset := diskOffset(path); offset == 14 {
+		t.Fatal("offset should not be persisted before the batch is flushed")
+	}
+
+	if err := consumer.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 提交成功后立即同步, 不需要等待sync_interval
+	waitFor(t, func() bool { return diskOffset(path) == 14 })
+}
+
+func TestFlushSyncCoalesce(t *testing.T) {
+	var (
+		path     = filepath.Join(t.TempDir(), "app.log")
+		consumer = initTestFlushSync(t, 500)
+	)
+
+	appendLines(t, path, "line 1")
+	createFile("index_flush", path)
+	writeEvent("index_flush", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if err := consumer.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return diskOffset(path) == 7 })
+
+	// 间隔内的多次提交合并为一次同步, 间隔结束后才写入最新的offset
+	for _, line := range []string{"line 2", "line 3"} {
+		appendLines(t, path, line)
+		writeEvent("index_flush", fsnotify.Event{Name: path, Op: fsnotify.Write})
+		processingWg.Wait()
+		if err := consumer.FlushAll(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if offset := diskOffset(path); offset != 7 {
+		t.Errorf("flushes within the interval should be coalesced, got offset %d", offset)
+	}
+
+	waitFor(t, func() bool { return diskOffset(path) == 21 })
+}
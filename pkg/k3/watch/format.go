@@ -0,0 +1,86 @@
+package watch
+
+import (
+	"encoding/json"
+	"errors"
+	"log-engine-sdk/pkg/k3/config"
+)
+
+// 日志的解析格式, 对应watch.index.format配置
+const (
+	FormatRaw    = "raw"    // 原始日志, 不解析
+	FormatJSON   = "json"   // json对象, 字段合并到日志中
+	FormatLogfmt = "logfmt" // key=value格式, 字段合并到日志中, 原始日志保存在message中
+
+	FormatNginxCombined = "nginx_combined" // nginx/apache的combined格式访问日志, 字段合并到日志中, 原始日志保存在_data中
+)
+
+var (
+	DefaultJSONCollisionPrefix = "json_"        // 解析出的字段与已有字段冲突时加上的前缀
+	ParseErrorField            = "_parse_error" // 解析失败时的标记字段
+	LogfmtMessageField         = "message"      // logfmt格式保存原始日志的字段
+)
+
+// resolveFormat 日志的解析格式, 没有配置format时兼容parse_json
+func resolveFormat(indexName string, index config.Index) (string, error) {
+	switch index.Format {
+	case "":
+		if index.ParseJSON {
+			return FormatJSON, nil
+		}
+		return FormatRaw, nil
+	case FormatRaw, FormatJSON, FormatLogfmt, FormatNginxCombined:
+		return index.Format, nil
+	default:
+		return "", errors.New("[NewIndexRule] index_name[" + indexName + "] unsupported format: " + index.Format)
+	}
+}
+
+// mergeParsedFields 按照index_name的format解析日志, 解析出的字段合并到properties中, 字段名加上json_prefix
+// 与已有字段(_data, _path, 附加字段)冲突时, 再加上json_前缀, 不覆盖已有字段
+// 解析失败时保持原始日志, tag_parse_error开启时标记_parse_error, 返回解析出的字段, 没有解析时返回nil
+func mergeParsedFields(rule *IndexRule, line string, properties map[string]interface{}) map[string]interface{} {
+	var (
+		fields map[string]interface{}
+		err    error
+	)
+
+	switch rule.format {
+	case FormatJSON:
+		if err = json.Unmarshal([]byte(line), &fields); err == nil && fields == nil {
+			err = errors.New("not a json object")
+		}
+	case FormatLogfmt:
+		if fields, err = ParseLogfmt(line); err == nil {
+			mergeField(properties, LogfmtMessageField, line)
+		}
+	case FormatNginxCombined:
+		fields, err = ParseNginxCombined(line)
+	default:
+		return nil
+	}
+
+	if err != nil {
+		if rule.TagParseError {
+			properties[ParseErrorField] = true
+		}
+		return nil
+	}
+
+	for key, value := range fields {
+		mergeField(properties, rule.JSONPrefix+key, value)
+	}
+
+	return fields
+}
+
+// mergeField 字段已经存在时加上json_前缀, 直到不冲突
+func mergeField(properties map[string]interface{}, key string, value interface{}) {
+	for {
+		if _, exists := properties[key]; !exists {
+			break
+		}
+		key = DefaultJSONCollisionPrefix + key
+	}
+	properties[key] = value
+}
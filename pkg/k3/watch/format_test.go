@@ -0,0 +1,92 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseJSON(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+
+	if err := InitIndexRules(map[string]config.Index{"index_test": {ParseJSON: true, TagParseError: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, `{"level": "error", "code": 500, "user": {"id": "u1"}}`, `not json`)
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if len(consumer.datas) != 2 {
+		t.Fatalf("2 events expected, got %d", len(consumer.datas))
+	}
+
+	// 合法json的字段合并到日志中
+	properties := consumer.datas[0].Properties
+	if properties["level"] != "error" || properties["code"] != float64(500) {
+		t.Errorf("json fields should be merged, got %v", properties)
+	}
+	if user, ok := properties["user"].(map[string]interface{}); !ok || user["id"] != "u1" {
+		t.Errorf("nested object should be kept, got %v", properties["user"])
+	}
+	if _, exists := properties[ParseErrorField]; exists {
+		t.Errorf("valid json should not be tagged")
+	}
+
+	// 不合法的json按原始日志发送, 并标记_parse_error
+	properties = consumer.datas[1].Properties
+	if properties["_data"] != "not json" || properties[ParseErrorField] != true {
+		t.Errorf("invalid json should fall back to raw line with _parse_error, got %v", properties)
+	}
+}
+
+func TestParseJSONCollision(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+
+	if err := InitEnrich([]string{EnrichHost}); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitIndexRules(map[string]config.Index{"index_test": {ParseJSON: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, `{"host": "container-1", "_path": "/fake", "msg": "ok"}`, `[1, 2]`)
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	// 与已有字段冲突时加上json_前缀, 不覆盖已有字段
+	properties := consumer.datas[0].Properties
+	if properties["_path"] != path || properties["json__path"] != "/fake" {
+		t.Errorf("_path should not be overwritten, got %v", properties)
+	}
+	if properties["host"] == "container-1" || properties["json_host"] != "container-1" {
+		t.Errorf("enrichment host should not be overwritten, got %v", properties)
+	}
+	if properties["msg"] != "ok" {
+		t.Errorf("msg should be merged, got %v", properties["msg"])
+	}
+
+	// 不是对象的json不合并, 没有开启tag_parse_error时不标记
+	if properties = consumer.datas[1].Properties; properties["_data"] != "[1, 2]" || properties[ParseErrorField] != nil {
+		t.Errorf("non object json should be sent as raw line, got %v", properties)
+	}
+
+	// json_prefix
+	if err := InitIndexRules(map[string]config.Index{"index_test": {ParseJSON: true, JSONPrefix: "app."}}); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, path, `{"host": "container-1"}`)
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if properties = consumer.datas[2].Properties; properties["app.host"] != "container-1" {
+		t.Errorf("json_prefix should be applied, got %v", properties)
+	}
+}
@@ -0,0 +1,131 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// fileGlob 由include_globs/exclude_globs编译而来
+// 不包含/的glob匹配文件名(如 *.log); 包含/的glob匹配路径, /开头的从根目录匹配, 否则从任意一层目录开始匹配(如 nginx/**/*.log)
+type fileGlob struct {
+	re       *regexp.Regexp
+	withPath bool
+}
+
+// compileGlobs 编译include_globs/exclude_globs, 支持*, ?, [...]和跨目录的**
+func compileGlobs(name string, globs []string) ([]*fileGlob, error) {
+	var (
+		compiled = make([]*fileGlob, 0, len(globs))
+		pattern  string
+		re       *regexp.Regexp
+		err      error
+	)
+
+	for _, glob := range globs {
+		if pattern, err = globToRegexp(glob); err != nil {
+			return nil, fmt.Errorf("invalid %s[%s]: %w", name, glob, err)
+		}
+
+		if re, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid %s[%s]: %w", name, glob, err)
+		}
+		compiled = append(compiled, &fileGlob{re: re, withPath: strings.Contains(glob, "/")})
+	}
+
+	return compiled, nil
+}
+
+// globToRegexp 将glob转换为正则
+func globToRegexp(glob string) (string, error) {
+	var builder strings.Builder
+
+	switch {
+	case strings.HasPrefix(glob, "/") || !strings.Contains(glob, "/"):
+		builder.WriteString("^")
+	default:
+		builder.WriteString("(^|/)")
+	}
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				if i+2 < len(glob) && glob[i+2] == '/' {
+					// **/ 匹配0层或多层目录
+					builder.WriteString("(.*/)?")
+					i += 2
+				} else {
+					builder.WriteString(".*")
+					i++
+				}
+			} else {
+				builder.WriteString("[^/]*")
+			}
+		case '?':
+			builder.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return "", errors.New("missing ]")
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			builder.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	builder.WriteString("$")
+	return builder.String(), nil
+}
+
+// match 文件是否匹配glob
+func (g *fileGlob) match(path string) bool {
+	path = filepath.ToSlash(path)
+	if !g.withPath {
+		path = filepath.Base(path)
+	}
+	return g.re.MatchString(path)
+}
+
+// matchAnyGlob 是否匹配任意一个glob
+func matchAnyGlob(globs []*fileGlob, path string) bool {
+	for _, glob := range globs {
+		if glob.match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldWatch 匹配include_globs(没有配置时全部匹配)且不匹配任何exclude_globs的文件才读取, 同时匹配时以exclude_globs为准
+func (r *IndexRule) shouldWatch(path string) bool {
+	if len(r.includeGlobs) > 0 && !matchAnyGlob(r.includeGlobs, path) {
+		return false
+	}
+
+	return !matchAnyGlob(r.excludeGlobs, path)
+}
+
+// filterWatchFiles 过滤目录中不需要读取的文件
+func filterWatchFiles(indexName string, files []string) []string {
+	var (
+		rule     = getIndexRule(indexName)
+		filtered = files[:0:0]
+	)
+
+	for _, file := range files {
+		if rule.shouldWatch(file) {
+			filtered = append(filtered, file)
+		}
+	}
+
+	return filtered
+}
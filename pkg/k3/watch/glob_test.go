@@ -0,0 +1,114 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestShouldWatch(t *testing.T) {
+	for _, item := range []struct {
+		name    string
+		index   config.Index
+		path    string
+		matched bool
+	}{
+		{"no globs", config.Index{}, "/var/log/app.gz", true},
+		{"include base name", config.Index{IncludeGlobs: []string{"*.log"}}, "/var/log/app.log", true},
+		{"include miss", config.Index{IncludeGlobs: []string{"*.log"}}, "/var/log/app.txt", false},
+		{"include path", config.Index{IncludeGlobs: []string{"nginx/**/access*"}}, "/var/log/nginx/2024/01/access.log", true},
+		{"include path without sub directory", config.Index{IncludeGlobs: []string{"nginx/**/access*"}}, "/var/log/nginx/access.log", true},
+		{"include path miss", config.Index{IncludeGlobs: []string{"nginx/**/access*"}}, "/var/log/nginx/error.log", false},
+		{"star does not cross directory", config.Index{IncludeGlobs: []string{"/var/log/*.log"}}, "/var/log/nginx/access.log", false},
+		{"character class", config.Index{IncludeGlobs: []string{"app.[0-9].log"}}, "/var/log/app.1.log", true},
+		{"negated character class", config.Index{IncludeGlobs: []string{"app.[!0-9].log"}}, "/var/log/app.1.log", false},
+		{"exclude", config.Index{ExcludeGlobs: []string{"*.gz", "*.tmp"}}, "/var/log/app.log.gz", false},
+		{"exclude miss", config.Index{ExcludeGlobs: []string{"*.gz"}}, "/var/log/app.log", true},
+		{"exclude wins", config.Index{IncludeGlobs: []string{"app*"}, ExcludeGlobs: []string{"*.gz"}}, "/var/log/app.log.gz", false},
+	} {
+		t.Run(item.name, func(t *testing.T) {
+			rule, err := NewIndexRule("index_test", item.index)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if matched := rule.shouldWatch(item.path); matched != item.matched {
+				t.Errorf("path[%s] should watch %v, got %v", item.path, item.matched, matched)
+			}
+		})
+	}
+}
+
+func TestInvalidGlobs(t *testing.T) {
+	if _, err := NewIndexRule("index_test", config.Index{IncludeGlobs: []string{"app[.log"}}); err == nil {
+		t.Errorf("invalid include_globs should return error")
+	}
+
+	if _, err := NewIndexRule("index_test", config.Index{ExcludeGlobs: []string{"[a-"}}); err == nil {
+		t.Errorf("invalid exclude_globs should return error")
+	}
+}
+
+func TestScanGlobs(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+	)
+
+	if err := InitIndexRules(map[string]config.Index{"index_test": {
+		IncludeGlobs: []string{"*.log"},
+		ExcludeGlobs: []string{"debug*"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"app.log", "app.txt", "debug.log"} {
+		appendLines(t, filepath.Join(dir, name), name)
+	}
+
+	if err := ScanLogFileToGlobalFileStatesAndSaveToDiskFile(map[string][]string{"index_test": {dir}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	GlobalFileStatesLock.Lock()
+	tracked := make([]string, 0, len(GlobalFileStates))
+	for path := range GlobalFileStates {
+		tracked = append(tracked, filepath.Base(path))
+	}
+	GlobalFileStatesLock.Unlock()
+	sort.Strings(tracked)
+
+	if len(tracked) != 1 || tracked[0] != "app.log" {
+		t.Fatalf("only app.log should be tracked, got %v", tracked)
+	}
+
+	// 新建的不匹配的文件不加入, 之后的写入事件也不读取
+	for _, name := range []string{"new.txt", "debug.2.log"} {
+		path := filepath.Join(dir, name)
+		appendLines(t, path, name)
+
+		if createFile("index_test", path) {
+			t.Errorf("file[%s] not matching globs should not be created", name)
+		}
+		writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	}
+
+	// 新建的匹配的文件正常读取
+	path := filepath.Join(dir, "new.log")
+	appendLines(t, path, "new.log")
+	createEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Create}, nil)
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	assertLines(t, consumer, "new.log")
+
+	GlobalFileStatesLock.Lock()
+	defer GlobalFileStatesLock.Unlock()
+	for _, name := range []string{"new.txt", "debug.2.log"} {
+		if _, ok := GlobalFileStates[filepath.Join(dir, name)]; ok {
+			t.Errorf("file[%s] not matching globs should not be tracked", name)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log-engine-sdk/pkg/k3"
+	"os"
+	"strings"
+	"time"
+)
+
+// isGzipFile 以.gz结尾的文件按照gzip压缩文件读取
+func isGzipFile(path string) bool {
+	return strings.HasSuffix(path, ".gz")
+}
+
+// countingReader 记录从压缩文件中已经读取的字节数, 实现io.ByteReader, gzip不会再包一层bufio预读
+// 因此一个member解压结束时, n正好是下一个member在压缩文件中的开始位置
+type countingReader struct {
+	reader *bufio.Reader
+	n      int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.reader.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// gzipBoundary 一个member在压缩文件中的结束位置, 以及对应的解压后的位置
+type gzipBoundary struct {
+	compressed   int64
+	decompressed int64
+}
+
+// gzipMemberReader 依次解压每个member, 与gzip.Reader的multistream相同, 同时记录每个member的结束位置
+type gzipMemberReader struct {
+	source       *countingReader
+	gz           *gzip.Reader
+	decompressed int64          // 已经解压的字节数, 包含offset之前的内容
+	delimiter    []byte         // 行分隔符
+	tail         []byte         // 解压后的内容最后len(delimiter)个字节
+	boundaries   []gzipBoundary // 已经解压完成, 且以行分隔符结尾的member, 可以从下一个member继续读取
+}
+
+// openGzipReader 从压缩文件的offset处打开gzip解压流, offset必须是member的开始位置, decompressed为offset对应的解压后的位置
+func openGzipReader(fd *os.File, offset, decompressed int64, delimiter string) (*gzipMemberReader, error) {
+	var (
+		reader *gzipMemberReader
+		err    error
+	)
+
+	if len(delimiter) == 0 {
+		delimiter = DefaultLineDelimiter
+	}
+
+	// 开始位置总是在行首
+	reader = &gzipMemberReader{decompressed: decompressed, delimiter: []byte(delimiter), tail: []byte(delimiter)}
+
+	if _, err = fd.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek file failed: %w", err)
+	}
+
+	reader.source = &countingReader{reader: bufio.NewReader(fd), n: offset}
+	if reader.gz, err = gzip.NewReader(reader.source); err != nil {
+		return nil, err
+	}
+	reader.gz.Multistream(false)
+
+	return reader, nil
+}
+
+func (m *gzipMemberReader) Read(p []byte) (int, error) {
+	for {
+		n, err := m.gz.Read(p)
+		m.decompressed += int64(n)
+		m.updateTail(p[:n])
+
+		if err != io.EOF {
+			return n, err
+		}
+
+		// 当前member结束, 行在member中间断开时不能从下一个member继续读取; 没有下一个member时返回io.EOF
+		if bytes.Equal(m.tail, m.delimiter) {
+			m.boundaries = append(m.boundaries, gzipBoundary{compressed: m.source.n, decompressed: m.decompressed})
+		}
+		if err = m.gz.Reset(m.source); err != nil {
+			return n, err
+		}
+		m.gz.Multistream(false)
+
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// updateTail 记录解压后的内容最后len(delimiter)个字节
+func (m *gzipMemberReader) updateTail(p []byte) {
+	var size = len(m.delimiter)
+
+	if len(p) >= size {
+		m.tail = append(m.tail[:0], p[len(p)-size:]...)
+		return
+	}
+	m.tail = append(m.tail, p...)
+	m.tail = m.tail[len(m.tail)-size:]
+}
+
+// boundary 解压后的位置不超过offset的最后一个member的结束位置, 没有时ok为false, 返回的以及之前的位置不再保留
+func (m *gzipMemberReader) boundary(offset int64) (boundary gzipBoundary, ok bool) {
+	var i int
+
+	for i < len(m.boundaries) && m.boundaries[i].decompressed <= offset {
+		boundary, ok = m.boundaries[i], true
+		i++
+	}
+	m.boundaries = m.boundaries[i:]
+
+	return boundary, ok
+}
+
+func (m *gzipMemberReader) Close() error {
+	return m.gz.Close()
+}
+
+// checkGzipFile 从offset处完整解压一次, 确认文件已经写完, 避免发送一部分数据后失败, 下次重复发送
+func checkGzipFile(fd *os.File, offset int64) error {
+	var (
+		reader *gzipMemberReader
+		err    error
+	)
+
+	if reader, err = openGzipReader(fd, offset, 0, DefaultLineDelimiter); err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(io.Discard, reader)
+	return err
+}
+
+// isGzipMemberStart offset处是否是一个member的开始, 只有gzip头不合法时返回false
+func isGzipMemberStart(fd *os.File, offset int64) bool {
+	reader, err := openGzipReader(fd, offset, 0, DefaultLineDelimiter)
+	if err != nil {
+		return !errors.Is(err, gzip.ErrHeader)
+	}
+	_ = reader.Close()
+	return true
+}
+
+// readGzipFile gzip文件不能按照解压后的offset定位, 读取完成后标记Completed, 不再读取
+// 多个member拼接的文件(如cat a.gz b.gz, 或者pigz等分块压缩)每发送完一个member记录CompressedOffset, 中断后从下一个member继续读取
+// 只能从member的开始位置继续, 且member需要以行分隔符结尾: 单个member的文件中断后从头重新读取, 行在member中间断开时从上一个可以继续的位置读取
+// CompressedOffset处不是member的开始(如文件被替换)时从头读取; 已经发送的member还有没有确认的日志时, 状态文件中不记录CompressedOffset
+// 压缩工具还在写入的文件解压会失败, 此时不发送任何数据, 等待下一次写事件再读取
+func readGzipFile(fd *os.File, fileState *FileState) error {
+	var (
+		fileInfo   os.FileInfo
+		compressed int64 // 继续读取的member在压缩文件中的开始位置
+		offset     int64 // compressed对应的解压后的位置
+		skip       bool
+		err        error
+	)
+
+	GlobalFileStatesLock.Lock()
+	skip = fileState.Completed
+	if fileState.CompressedOffset > 0 {
+		compressed, offset = fileState.CompressedOffset, fileState.Offset
+	}
+	GlobalFileStatesLock.Unlock()
+
+	if skip {
+		return nil
+	}
+
+	if fileInfo, err = fd.Stat(); err != nil {
+		return fmt.Errorf("stat file failed: %w", err)
+	}
+
+	if compressed > fileInfo.Size() || (compressed > 0 && compressed < fileInfo.Size() && !isGzipMemberStart(fd, compressed)) {
+		k3.K3LogWarn("[readGzipFile] path[%s] compressed offset %d is not a gzip member boundary, read from start.", fileState.Path, compressed)
+		compressed, offset = 0, 0
+	}
+
+	// 最后一个member发送完时中断, 没有剩余的数据
+	if compressed < fileInfo.Size() {
+		if err = checkGzipFile(fd, compressed); err != nil {
+			k3.K3LogWarn("[readGzipFile] path[%s] gzip is incomplete, wait for next read: %s", fileState.Path, err.Error())
+			return nil
+		}
+
+		if compressed > 0 {
+			k3.K3LogInfo("[readGzipFile] path[%s] resume from compressed offset %d.", fileState.Path, compressed)
+		}
+
+		if offset, err = readGzipMembers(fd, fileState, compressed, offset); err != nil {
+			return err
+		}
+	}
+
+	// offset 记录为解压后的大小, 与发送的日志的offset一致, 是否读取完以Completed为准
+	GlobalFileStatesLock.Lock()
+	fileState.Offset = offset
+	fileState.CompressedOffset = 0
+	fileState.Completed = true
+	if fileState.StartReadTime == 0 {
+		fileState.StartReadTime = time.Now().Unix()
+	}
+	fileState.LastReadTime = nowFunc().Unix()
+	GlobalFileStatesLock.Unlock()
+
+	k3.K3LogDebug("[readGzipFile] path[%s] read gzip file over.", fileState.Path)
+
+	return nil
+}
+
+// readGzipMembers 从compressed处的member开始逐行发送, 每DefaultMaxReadCount行发送一次, 避免整个文件的内容都放在内存中
+// 发送完一个member的所有内容后, 将member的结束位置记录到CompressedOffset; 读取完成时返回解压后的大小
+func readGzipMembers(fd *os.File, fileState *FileState, compressed, offset int64) (int64, error) {
+	var (
+		reader    *gzipMemberReader
+		scanner   *lineScanner
+		line      string
+		consumed  int64
+		truncated bool
+		maxBytes  = maxLineBytes()
+		rule      = getIndexRule(fileState.IndexName)
+		content   strings.Builder // content在解压后的内容中的开始位置为offset
+		lineCount int
+		err       error
+	)
+
+	if reader, err = openGzipReader(fd, compressed, offset, rule.lineDelimiter); err != nil {
+		return 0, fmt.Errorf("open gzip failed: %w", err)
+	}
+	defer reader.Close()
+
+	// advance 已经发送完的member, 中断后不再读取
+	advance := func() {
+		if boundary, ok := reader.boundary(offset); ok && boundary.compressed > compressed {
+			compressed = boundary.compressed
+			GlobalFileStatesLock.Lock()
+			fileState.CompressedOffset = boundary.compressed
+			fileState.Offset = boundary.decompressed
+			fileState.LastReadTime = nowFunc().Unix()
+			GlobalFileStatesLock.Unlock()
+		}
+	}
+
+	scanner = newLineScanner(reader, rule.lineDelimiter, maxBytes)
+	scanner.raw = rule.rawBytes()
+	defer scanner.release()
+	for {
+		line, consumed, truncated, err = scanner.readLine()
+
+		// 超过max_line_bytes的行, 先发送之前的内容, 截断后单独作为一条日志
+		if truncated {
+			k3.K3LogWarn("[readGzipFile] path[%s] line at offset %d exceeds max_line_bytes %d, truncated.", fileState.Path, offset+int64(content.Len()), maxBytes)
+			if content.Len() > 0 {
+				sendContent(content.String(), offset, fileState)
+				offset += int64(content.Len())
+				content.Reset()
+				lineCount = 0
+			}
+			if sendErr := sendEvents([]readEvent{{content: line, offset: offset, truncated: true}}, fileState); errors.Is(sendErr, k3.ErrConsumerClosed) {
+				return 0, sendErr
+			}
+			offset += consumed
+			advance()
+		} else {
+			content.WriteString(line)
+			lineCount++
+		}
+
+		if lineCount >= DefaultMaxReadCount || (err != nil && content.Len() > 0) {
+			sendContent(content.String(), offset, fileState)
+			offset += int64(content.Len())
+			content.Reset()
+			lineCount = 0
+			advance()
+		}
+
+		if err == io.EOF {
+			return offset, nil
+		}
+
+		if err != nil {
+			return 0, fmt.Errorf("read gzip failed: %w", err)
+		}
+	}
+}
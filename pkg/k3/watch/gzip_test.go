@@ -0,0 +1,253 @@
+package watch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"log-engine-sdk/pkg/k3/sender"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeGzipFile 生成gzip压缩的日志文件
+func writeGzipFile(t *testing.T, path string, content string) {
+	fd, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	gz := gzip.NewWriter(fd)
+	if _, err = gz.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err = gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadGzipFile(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log.1.gz")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	writeGzipFile(t, path, "line 1\nline 2\nline 3")
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	assertLines(t, consumer, "line 1", "line 2", "line 3")
+
+	// offset为解压后的大小
+	if fileState := GlobalFileStates[path]; !fileState.Completed || fileState.Offset != int64(len("line 1\nline 2\nline 3")) {
+		t.Errorf("gzip file should be completed, got %s", fileState.String())
+	}
+
+	// 读取完成的gzip文件不再读取
+	writeEvent("index_test", event)
+	processingWg.Wait()
+	assertLines(t, consumer, "line 1", "line 2", "line 3")
+}
+
+func TestObsoleteGzipFile(t *testing.T) {
+	var (
+		dir        = t.TempDir()
+		path       = filepath.Join(dir, "app.log.1.gz")
+		incomplete = filepath.Join(dir, "app.log.2.gz")
+		now        = time.Now()
+	)
+
+	initTestWatch(t)
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	// 解压后比压缩文件大, offset与压缩文件的大小不相等
+	writeGzipFile(t, path, strings.Repeat("line\n", 100))
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	// 压缩工具还没有写完的文件
+	writeGzipFile(t, filepath.Join(dir, "full.gz"), "line 1\n")
+	content, _ := os.ReadFile(filepath.Join(dir, "full.gz"))
+	if err := os.WriteFile(incomplete, content[:len(content)-8], 0666); err != nil {
+		t.Fatal(err)
+	}
+	writeEvent("index_test", fsnotify.Event{Name: incomplete, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	now = now.Add(25 * time.Hour)
+	readObsoleteFiles(1, DefaultMaxReadCount)
+	processingWg.Wait()
+
+	if !GlobalFileStates[path].Obsolete {
+		t.Errorf("completed gzip file should be obsolete, got %s", GlobalFileStates[path].String())
+	}
+	if GlobalFileStates[incomplete].Obsolete {
+		t.Errorf("incomplete gzip file should not be obsolete")
+	}
+}
+
+func TestGzipStateUnackedCompleted(t *testing.T) {
+	var (
+		path      = filepath.Join(t.TempDir(), "app.log.1.gz")
+		fileState = &FileState{Path: path, IndexName: "index_test", Offset: 20, Completed: true}
+	)
+
+	// 读取完成, 但还有没有确认的日志, 重启后从头重新读取, 而不是跳过整个文件
+	event, _ := deliveryTrackerOf(fileState).newPending(7)
+	fileState.delivery.add(event)
+	if committed := newStateFile(map[string]*FileState{path: fileState}).Online[path]; committed.Completed || committed.Offset != 0 {
+		t.Errorf("completed gzip file with unacked lines should be read again, got %+v", committed)
+	}
+
+	// 全部确认后记录为读取完成
+	fileState.delivery.deliver(event)
+	if committed := newStateFile(map[string]*FileState{path: fileState}).Online[path]; !committed.Completed || committed.Offset != 20 {
+		t.Errorf("acked gzip file should stay completed, got %+v", committed)
+	}
+}
+
+func TestReadIncompleteGzipFile(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		path     = filepath.Join(dir, "app.log.1.gz")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	// 压缩工具还没有写完
+	writeGzipFile(t, filepath.Join(dir, "full.gz"), "line 1\nline 2\n")
+	content, _ := os.ReadFile(filepath.Join(dir, "full.gz"))
+	if err := os.WriteFile(path, content[:len(content)-8], 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	writeEvent("index_test", event)
+	processingWg.Wait()
+	assertLines(t, consumer)
+
+	if GlobalFileStates[path].Completed {
+		t.Fatalf("incomplete gzip file should not be completed")
+	}
+
+	// 写完后完整读取
+	if err := os.WriteFile(path, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+	writeEvent("index_test", event)
+	processingWg.Wait()
+	assertLines(t, consumer, "line 1", "line 2")
+}
+
+// gzipMembers 将每段内容压缩为一个member后拼接, 与cat a.gz b.gz相同, 返回拼接后的内容和每个member的结束位置
+func gzipMembers(t *testing.T, contents ...string) ([]byte, []int64) {
+	var (
+		buffer bytes.Buffer
+		ends   []int64
+	)
+
+	for _, content := range contents {
+		gz := gzip.NewWriter(&buffer)
+		if _, err := gz.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ends = append(ends, int64(buffer.Len()))
+	}
+
+	return buffer.Bytes(), ends
+}
+
+func TestReadGzipMembersRecordCompressedOffset(t *testing.T) {
+	var (
+		consumer      = initTestWatch(t)
+		path          = filepath.Join(t.TempDir(), "app.log.1.gz")
+		content, ends = gzipMembers(t, "line 1\nline 2\n", "line 3\n", "line 4\n")
+		observed      = make(map[string]int64)
+	)
+
+	if err := os.WriteFile(path, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// 每行发送一次, 记录发送每一行时已经保存的CompressedOffset
+	maxReadCount := DefaultMaxReadCount
+	DefaultMaxReadCount = 1
+	t.Cleanup(func() { DefaultMaxReadCount = maxReadCount })
+	RegisterProcessor("observe", func(config.Processor) (Processor, error) {
+		return funcProcessor(func(data *protocol.Data) (bool, error) {
+			GlobalFileStatesLock.Lock()
+			observed[data.Properties["_data"].(string)] = GlobalFileStates[path].CompressedOffset
+			GlobalFileStatesLock.Unlock()
+			return true, nil
+		}), nil
+	})
+	t.Cleanup(func() { RegisterProcessor("observe", nil) })
+	if err := InitIndexRules(map[string]config.Index{"index_test": {Processors: []config.Processor{{Type: "observe"}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+	assertLines(t, consumer, "line 1", "line 2", "line 3", "line 4")
+
+	// 发送完一个member后记录它的结束位置
+	if observed["line 3"] != ends[0] || observed["line 4"] != ends[1] {
+		t.Errorf("compressed offset should advance at member boundaries %v, got %v", ends, observed)
+	}
+	if fileState := GlobalFileStates[path]; !fileState.Completed || fileState.CompressedOffset != 0 {
+		t.Errorf("gzip file should be completed, got %+v", fileState)
+	}
+}
+
+func TestResumeGzipAtMemberBoundary(t *testing.T) {
+	var (
+		consumer      = initTestWatch(t)
+		path          = filepath.Join(t.TempDir(), "app.log.1.gz")
+		content, ends = gzipMembers(t, "line 1\nline 2\n", "line 3\n", "line 4\n")
+	)
+
+	if err := os.WriteFile(path, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一个member已经发送, 从第二个member继续读取, @offset与完整读取时相同
+	GlobalFileStates[path] = &FileState{Path: path, IndexName: "index_test", CompressedOffset: ends[0], Offset: int64(len("line 1\nline 2\n"))}
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	assertLines(t, consumer, "line 3", "line 4")
+	if consumer.datas[0].Properties[sender.OffsetField] != int64(14) || consumer.datas[1].Properties[sender.OffsetField] != int64(21) {
+		t.Errorf("offset should continue from the member boundary, got %v, %v", consumer.datas[0].Properties[sender.OffsetField], consumer.datas[1].Properties[sender.OffsetField])
+	}
+	if !GlobalFileStates[path].Completed {
+		t.Errorf("gzip file should be completed after resume")
+	}
+}
+
+func TestResumeGzipMidMember(t *testing.T) {
+	var (
+		consumer   = initTestWatch(t)
+		path       = filepath.Join(t.TempDir(), "app.log.1.gz")
+		content, _ = gzipMembers(t, "line 1\nline 2\n", "line 3\n")
+	)
+
+	if err := os.WriteFile(path, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// 记录的位置不是member的开始, 从头重新读取
+	GlobalFileStates[path] = &FileState{Path: path, IndexName: "index_test", CompressedOffset: 5, Offset: 7}
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	assertLines(t, consumer, "line 1", "line 2", "line 3")
+}
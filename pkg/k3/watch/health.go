@@ -0,0 +1,126 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	DefaultHealthStaleAfter = 300 // 单位秒, 有待发送的日志时, 超过该时间没有发送成功则readyz失败
+)
+
+// checkLive 监听协程都在运行且没有收到退出信号
+func checkLive() error {
+	if WatcherContext == nil || WatcherContext.Err() != nil {
+		return errors.New("watcher context is cancelled")
+	}
+
+	indexWatchersLock.Lock()
+	watchers := len(indexWatchers)
+	indexWatchersLock.Unlock()
+
+	if watchers == 0 {
+		return errors.New("no watcher goroutine is running")
+	}
+
+	return nil
+}
+
+// checkReady 在checkLive的基础上, 至少发送成功过一次, 且有待发送的日志时最近一次发送成功没有超过staleAfter
+// 配置了health.flush_on_ready时, 有待发送的日志先同步提交一次
+func checkReady(staleAfter time.Duration) error {
+	if err := checkLive(); err != nil {
+		return err
+	}
+
+	if config.GlobalConfig.Health.FlushOnReady && k3.MetricPendingEvents.Value() > 0 {
+		if err := GlobalDataAnalytics.Flush(); err != nil {
+			return fmt.Errorf("flush pending events failed: %w", err)
+		}
+	}
+
+	var last = k3.LastSendSuccess()
+
+	if last.IsZero() {
+		return errors.New("sender has not succeeded yet")
+	}
+
+	if pending := k3.MetricPendingEvents.Value(); pending > 0 && nowFunc().Sub(last) > staleAfter {
+		return fmt.Errorf("%d events pending, last successful send at %s", pending, last.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// healthStaleAfter health.stale_after, 没有配置时使用默认值
+func healthStaleAfter() time.Duration {
+	var staleAfter = config.GlobalConfig.Health.StaleAfter
+
+	if staleAfter <= 0 {
+		staleAfter = DefaultHealthStaleAfter
+	}
+
+	return time.Duration(staleAfter) * time.Second
+}
+
+// HealthzRouter 存活检查, 监听协程异常退出时返回503
+func HealthzRouter(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, checkLive())
+}
+
+// ReadyzRouter 就绪检查, sender还没有发送成功过或者发送长时间失败时返回503
+func ReadyzRouter(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, checkReady(healthStaleAfter()))
+}
+
+func writeHealth(w http.ResponseWriter, err error) {
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// HealthServer 在listen地址上开启/healthz和/readyz接口, 返回关闭函数
+func HealthServer(ctx context.Context, listen string) (func(), error) {
+	var (
+		listener net.Listener
+		mux      = http.NewServeMux()
+		err      error
+	)
+
+	if listener, err = net.Listen("tcp", listen); err != nil {
+		return nil, fmt.Errorf("[HealthServer] listen failed: %w", err)
+	}
+
+	mux.HandleFunc("/healthz", HealthzRouter)
+	mux.HandleFunc("/readyz", ReadyzRouter)
+	server := &http.Server{
+		Handler:     mux,
+		ReadTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			k3.K3LogError("[HealthServer] health server error: %s", err.Error())
+		}
+	}()
+
+	k3.K3LogInfo("[HealthServer] health server listen on %s", listener.Addr().String())
+
+	return func() {
+		timeoutCTX, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(timeoutCTX); err != nil {
+			k3.K3LogError("[HealthServer] health server shutdown error: %s", err.Error())
+		}
+	}, nil
+}
@@ -0,0 +1,135 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// checkHealth 调用handler, 返回状态码
+func checkHealth(handler http.HandlerFunc) int {
+	var recorder = httptest.NewRecorder()
+
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	return recorder.Code
+}
+
+func TestHealthzCancelledContext(t *testing.T) {
+	initTestWatch(t)
+
+	if code := checkHealth(HealthzRouter); code != http.StatusServiceUnavailable {
+		t.Errorf("healthz should fail without watcher goroutines, got %d", code)
+	}
+
+	if err := InitWatcher(map[string][]string{"index_test": {t.TempDir()}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if code := checkHealth(HealthzRouter); code != http.StatusOK {
+		t.Errorf("healthz should succeed while watchers are running, got %d", code)
+	}
+
+	// 监听协程异常退出时会取消WatcherContext
+	WatcherContextCancel()
+	if code := checkHealth(HealthzRouter); code != http.StatusServiceUnavailable {
+		t.Errorf("healthz should fail after context is cancelled, got %d", code)
+	}
+	if code := checkHealth(ReadyzRouter); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz should fail after context is cancelled, got %d", code)
+	}
+}
+
+func TestReadyzStaleSend(t *testing.T) {
+	var (
+		path = filepath.Join(t.TempDir(), "app.log")
+		now  = time.Now()
+	)
+
+	initTestWatch(t)
+	defer func() { nowFunc = time.Now }()
+
+	if err := InitWatcher(map[string][]string{"index_test": {t.TempDir()}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// 发送成功一次
+	consumer, err := k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{Sender: &recordSender{}, BatchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if code := checkHealth(ReadyzRouter); code != http.StatusOK {
+		t.Errorf("readyz should succeed after a successful send, got %d", code)
+	}
+
+	// 之后的日志一直没有发送
+	if consumer, err = k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{Sender: &failSender{}, BatchSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+	appendLines(t, path, "line 2")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if code := checkHealth(ReadyzRouter); code != http.StatusOK {
+		t.Errorf("readyz should succeed within stale window, got %d", code)
+	}
+
+	nowFunc = func() time.Time { return now.Add(healthStaleAfter() + time.Second) }
+	if code := checkHealth(ReadyzRouter); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz should fail when events are pending and no send succeeded within stale window, got %d", code)
+	}
+	if code := checkHealth(HealthzRouter); code != http.StatusOK {
+		t.Errorf("healthz should not depend on sender, got %d", code)
+	}
+}
+
+func TestReadyzFlushOnReady(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "app.log")
+
+	initTestWatch(t)
+	config.GlobalConfig.Health.FlushOnReady = true
+	defer func() { config.GlobalConfig.Health.FlushOnReady = false }()
+
+	if err := InitWatcher(map[string][]string{"index_test": {t.TempDir()}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer, err := k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{Sender: &recordSender{}, BatchSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	// 没有达到BatchSize, readyz提交缓存中的数据
+	if code := checkHealth(ReadyzRouter); code != http.StatusOK {
+		t.Errorf("readyz should succeed after flushing pending events, got %d", code)
+	}
+	if depth := GlobalDataAnalytics.QueueDepth(); depth != 0 {
+		t.Errorf("pending events should be flushed by readyz, got %d", depth)
+	}
+
+	// 提交失败时readyz失败
+	if consumer, err = k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{Sender: &failSender{}, BatchSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+	appendLines(t, path, "line 2")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if code := checkHealth(ReadyzRouter); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz should fail when flushing pending events failed, got %d", code)
+	}
+}
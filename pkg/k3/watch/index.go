@@ -0,0 +1,125 @@
+package watch
+
+import (
+	"fmt"
+	"log-engine-sdk/pkg/k3/config"
+	"regexp"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// IndexRule 由config.Index编译而来的读取规则, 启动时编译一次, 读取时直接使用
+type IndexRule struct {
+	config.Index
+	skipSignature   *regexp.Regexp   // 文件第一行匹配后, 整个文件跳过不读取
+	includePatterns []*regexp.Regexp // 配置后只发送匹配的日志
+	excludePatterns []*regexp.Regexp // 匹配的日志不发送
+	includeGlobs    []*fileGlob      // 配置后只读取匹配的文件
+	excludeGlobs    []*fileGlob      // 匹配的文件不读取
+	format          string           // 日志的解析格式, raw/json/logfmt/nginx_combined
+	timestampRegexp *regexp.Regexp   // 文本日志中匹配日志时间的正则
+	lineDelimiter   string           // 日志的分隔符, 默认换行符
+	encoding        string           // 日志内容的编码, utf8/base64
+	processors      []Processor      // 发送之前依次处理日志
+	rateLimiter     *rate.Limiter    // rate_limit的令牌桶, 同一个index_name的所有文件共用, 为nil不限制
+}
+
+var (
+	indexRulesLock    = &sync.RWMutex{}
+	GlobalIndexRules  = make(map[string]*IndexRule)                                                                // index_name -> 读取规则
+	defaultIndexRules = &IndexRule{format: FormatRaw, lineDelimiter: DefaultLineDelimiter, encoding: EncodingUTF8} // 没有配置的index_name使用的默认规则
+)
+
+// NewIndexRule 编译单个index_name的读取规则, 配置的正则不合法时返回错误
+func NewIndexRule(indexName string, index config.Index) (*IndexRule, error) {
+	var (
+		rule = &IndexRule{Index: index}
+		err  error
+	)
+
+	if len(index.SkipSignature) > 0 {
+		if rule.skipSignature, err = regexp.Compile(index.SkipSignature); err != nil {
+			return nil, fmt.Errorf("[NewIndexRule] index_name[%s] invalid skip_signature: %w", indexName, err)
+		}
+	}
+
+	if rule.lineDelimiter, err = resolveLineDelimiter(indexName, index.LineDelimiter); err != nil {
+		return nil, err
+	}
+
+	if err = validateSampleRate(indexName, index.SampleRate); err != nil {
+		return nil, err
+	}
+
+	if rule.format, err = resolveFormat(indexName, index); err != nil {
+		return nil, err
+	}
+
+	if rule.encoding, err = resolveEncoding(indexName, index); err != nil {
+		return nil, err
+	}
+
+	if rule.timestampRegexp, err = compileTimestamp(indexName, index.TimestampRegexp); err != nil {
+		return nil, err
+	}
+
+	if rule.includePatterns, err = compilePatterns("include_patterns", index.IncludePatterns); err != nil {
+		return nil, fmt.Errorf("[NewIndexRule] index_name[%s] %w", indexName, err)
+	}
+
+	if rule.excludePatterns, err = compilePatterns("exclude_patterns", index.ExcludePatterns); err != nil {
+		return nil, fmt.Errorf("[NewIndexRule] index_name[%s] %w", indexName, err)
+	}
+
+	if rule.includeGlobs, err = compileGlobs("include_globs", index.IncludeGlobs); err != nil {
+		return nil, fmt.Errorf("[NewIndexRule] index_name[%s] %w", indexName, err)
+	}
+
+	if rule.excludeGlobs, err = compileGlobs("exclude_globs", index.ExcludeGlobs); err != nil {
+		return nil, fmt.Errorf("[NewIndexRule] index_name[%s] %w", indexName, err)
+	}
+
+	if rule.processors, err = compileProcessors(index.Processors); err != nil {
+		return nil, fmt.Errorf("[NewIndexRule] index_name[%s] %w", indexName, err)
+	}
+
+	if rule.rateLimiter, err = newRateLimiter(indexName, index.RateLimit); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// InitIndexRules 编译所有index_name的读取规则
+func InitIndexRules(indexes map[string]config.Index) error {
+	var (
+		rules = make(map[string]*IndexRule)
+		rule  *IndexRule
+		err   error
+	)
+
+	for indexName, index := range indexes {
+		if rule, err = NewIndexRule(indexName, index); err != nil {
+			return err
+		}
+		rules[indexName] = rule
+	}
+
+	indexRulesLock.Lock()
+	GlobalIndexRules = rules
+	indexRulesLock.Unlock()
+
+	return nil
+}
+
+// getIndexRule 获取indexName对应的读取规则, 没有配置就返回默认规则
+func getIndexRule(indexName string) *IndexRule {
+	indexRulesLock.RLock()
+	defer indexRulesLock.RUnlock()
+
+	if rule, ok := GlobalIndexRules[indexName]; ok {
+		return rule
+	}
+	return defaultIndexRules
+}
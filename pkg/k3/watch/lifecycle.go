@@ -0,0 +1,100 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"sync"
+)
+
+// 文件生命周期事件, 记录agent开始和停止跟踪文件的过程
+const (
+	LifecycleFileDiscovered = "file_discovered" // 发现新文件
+	LifecycleFileObsoleted  = "file_obsoleted"  // 文件长时间未写入, 释放句柄
+	LifecycleFileRemoved    = "file_removed"    // 文件被删除或改名
+	LifecycleOffsetReset    = "offset_reset"    // 文件offset被重置
+)
+
+var (
+	DefaultLifecycleMaxPerSecond = 10             // 默认每秒最多发送的生命周期事件数量
+	DefaultLifecycleIndexName    = "k3_lifecycle" // 默认生命周期事件写入的索引
+)
+
+// lifecycleLimiter 按秒限制生命周期事件的发送数量
+var lifecycleLimiter = &struct {
+	lock    sync.Mutex
+	second  int64 // 当前计数的秒
+	count   int   // 当前秒已发送的事件数量
+	dropped int   // 累计丢弃的事件数量
+}{}
+
+// emitLifecycleEvent 发送文件生命周期事件, lifecycle.enable未开启时不发送
+func emitLifecycleEvent(event string, fileState *FileState) {
+	var (
+		lifecycle = config.GlobalConfig.Watch.Lifecycle
+		indexName = lifecycle.IndexName
+		data      string
+		err       error
+	)
+
+	if !lifecycle.Enable {
+		return
+	}
+
+	if len(indexName) == 0 {
+		indexName = DefaultLifecycleIndexName
+	}
+
+	if !allowLifecycleEvent(lifecycle.MaxPerSecond) {
+		k3.K3LogWarn("[emitLifecycleEvent] too many lifecycle events, drop event[%s] path[%s].", event, fileState.Path)
+		return
+	}
+
+	// event_name、extend_data 和 protocol.ElasticSearchData 的字段对应
+	if data, err = k3.InterfaceToJSONString(map[string]interface{}{
+		"event_name": event,
+		"log_src":    "lifecycle",
+		"extend_data": map[string]interface{}{
+			"content": map[string]interface{}{
+				"path":       fileState.Path,
+				"index_name": fileState.IndexName,
+				"offset":     fileState.Offset,
+			},
+		},
+	}); err != nil {
+		k3.K3LogError("[emitLifecycleEvent] marshal event[%s] failed: %s", event, err.Error())
+		return
+	}
+
+	if err = GlobalDataAnalytics.Track(config.GlobalConfig.Account.AccountId, config.GlobalConfig.Account.AppId, fetchLocalIP(), indexName,
+		map[string]interface{}{
+			"_data": data,
+			"_path": fileState.Path,
+		}); err != nil {
+		k3.K3LogError("[emitLifecycleEvent] Track: %s", err.Error())
+	}
+}
+
+// allowLifecycleEvent 当前秒发送的事件数量未超过maxPerSecond时返回true
+func allowLifecycleEvent(maxPerSecond int) bool {
+	var now = nowFunc().Unix()
+
+	if maxPerSecond <= 0 {
+		maxPerSecond = DefaultLifecycleMaxPerSecond
+	}
+
+	lifecycleLimiter.lock.Lock()
+	defer lifecycleLimiter.lock.Unlock()
+
+	if lifecycleLimiter.second != now {
+		lifecycleLimiter.second = now
+		lifecycleLimiter.count = 0
+	}
+
+	if lifecycleLimiter.count >= maxPerSecond {
+		lifecycleLimiter.dropped++
+		return false
+	}
+
+	lifecycleLimiter.count++
+	return true
+}
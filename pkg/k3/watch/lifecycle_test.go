@@ -0,0 +1,85 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLifecycleDiscoveredAndRemoved(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+
+	config.GlobalConfig.Watch.Lifecycle = config.Lifecycle{Enable: true, IndexName: "index_audit"}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	appendLines(t, path)
+	createEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Create}, watcher)
+	removeEvent(fsnotify.Event{Name: path, Op: fsnotify.Remove}, watcher)
+
+	if len(consumer.datas) != 2 {
+		t.Fatalf("expected 2 lifecycle events, got %d", len(consumer.datas))
+	}
+
+	for i, event := range []string{LifecycleFileDiscovered, LifecycleFileRemoved} {
+		data := consumer.datas[i]
+		if data.IndexName != "index_audit" {
+			t.Errorf("lifecycle event should be sent to index_audit, got %s", data.IndexName)
+		}
+
+		if content := data.Properties["_data"].(string); !strings.Contains(content, `"event_name":"`+event+`"`) || !strings.Contains(content, path) {
+			t.Errorf("lifecycle event %d should be %s of %s, got %s", i, event, path, content)
+		}
+	}
+}
+
+func TestLifecycleDisabled(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	appendLines(t, path)
+	createEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Create}, watcher)
+	removeEvent(fsnotify.Event{Name: path, Op: fsnotify.Remove}, watcher)
+
+	if len(consumer.datas) != 0 {
+		t.Errorf("lifecycle events should not be sent when disabled, got %d", len(consumer.datas))
+	}
+}
+
+func TestLifecycleRateLimit(t *testing.T) {
+	var now = time.Unix(1700000000, 0)
+
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	if !allowLifecycleEvent(2) || !allowLifecycleEvent(2) {
+		t.Errorf("first 2 events should be allowed")
+	}
+
+	if allowLifecycleEvent(2) {
+		t.Errorf("third event in the same second should be dropped")
+	}
+
+	now = now.Add(time.Second)
+	if !allowLifecycleEvent(2) {
+		t.Errorf("event in next second should be allowed")
+	}
+}
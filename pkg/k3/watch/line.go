@@ -0,0 +1,183 @@
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	DefaultMaxLineBytes  = 10 * 1024 * 1024 // 单行日志默认最大10MB
+	DefaultLineDelimiter = "\n"             // 默认按换行符拆分日志
+	MaxLineDelimiterSize = 16               // 分隔符的最大字节数
+	LineBufferSize       = 4096             // lineScanner初始的缓冲区大小, 超过时由bufio.Scanner扩容, 最大到max_line_bytes
+
+	TruncatedField = "_truncated" // 超过max_line_bytes被截断的日志, 值为true
+
+	nulEscape = regexp.MustCompile(`\\0([^0-7]|$)`) // go的转义不支持\0, 替换为\x00
+
+	// lineScannerPool 每次读取都需要一个lineScanner, 复用初始的缓冲区, 同一时间只属于一次读取
+	lineScannerPool = sync.Pool{
+		New: func() interface{} {
+			s := &lineScanner{buffer: make([]byte, 0, LineBufferSize)}
+			s.splitFunc = s.split
+			return s
+		},
+	}
+)
+
+// maxLineBytes 单行日志的最大字节数, 没有配置时使用DefaultMaxLineBytes
+func maxLineBytes() int {
+	if maxBytes := config.GlobalConfig.Watch.MaxLineBytes; maxBytes > 0 {
+		return maxBytes
+	}
+	return DefaultMaxLineBytes
+}
+
+// resolveLineDelimiter 解析index的line_delimiter, 支持\x1e、\0等转义; 为空时使用换行符
+func resolveLineDelimiter(indexName, delimiter string) (string, error) {
+	if len(delimiter) == 0 {
+		k3.K3LogWarn("[resolveLineDelimiter] index_name[%s] line_delimiter is empty, use newline.", indexName)
+		return DefaultLineDelimiter, nil
+	}
+
+	if strings.Contains(delimiter, `\`) {
+		escaped := nulEscape.ReplaceAllString(strings.ReplaceAll(delimiter, `"`, `\"`), `\x00$1`)
+		unquoted, err := strconv.Unquote(`"` + escaped + `"`)
+		if err != nil {
+			return "", fmt.Errorf("[resolveLineDelimiter] index_name[%s] invalid line_delimiter %s: %w", indexName, strconv.Quote(delimiter), err)
+		}
+		delimiter = unquoted
+	}
+
+	if len(delimiter) > MaxLineDelimiterSize {
+		return "", errors.New("[resolveLineDelimiter] index_name[" + indexName + "] line_delimiter " + strconv.Quote(delimiter) + " is longer than " + strconv.Itoa(MaxLineDelimiterSize) + " bytes")
+	}
+
+	return delimiter, nil
+}
+
+// lineScanner 使用bufio.Scanner按照分隔符拆分日志, 与reader.ReadString('\n')相同, 完整的行包含分隔符
+// 一行超过maxBytes字节(不含分隔符)时返回截断后的内容, 剩余的内容读取后丢弃, 内存占用不超过maxBytes的两倍
+type lineScanner struct {
+	scanner   bufio.Scanner
+	delimiter []byte
+	maxBytes  int
+	buffer    []byte          // 复用的初始缓冲区
+	splitFunc bufio.SplitFunc // s.split, 只创建一次
+
+	head      []byte // 超过maxBytes的行保留的前maxBytes字节, 为nil时没有正在丢弃的行
+	consumed  int64  // 当前行已经读取的字节数, 包含丢弃的内容
+	truncated bool   // 最近一次返回的行是否被截断
+	eof       bool   // 最近一次返回的行在文件末尾, 没有分隔符
+	raw       bool   // 保留原始字节, 截断时不去掉不完整的utf8字符
+	partial   []byte // 读到文件末尾时, 还没有分隔符的内容
+}
+
+// newLineScanner 从reader当前的位置开始读取, 分隔符为空时使用换行符
+// 从lineScannerPool中获取, 读取结束后调用release放回, 之后不能再使用
+func newLineScanner(reader io.Reader, delimiter string, maxBytes int) *lineScanner {
+	var s = lineScannerPool.Get().(*lineScanner)
+
+	if len(delimiter) == 0 {
+		delimiter = DefaultLineDelimiter
+	}
+
+	s.scanner = *bufio.NewScanner(reader)
+	s.delimiter = append(s.delimiter[:0], delimiter...)
+	s.maxBytes = maxBytes
+
+	// 缓冲区至少能放下maxBytes字节和一个分隔符, 才能判断一行是否超过maxBytes
+	s.scanner.Buffer(s.buffer[:0:min(cap(s.buffer), maxBytes+len(s.delimiter))], maxBytes+len(s.delimiter))
+	s.scanner.Split(s.splitFunc)
+
+	return s
+}
+
+// release 清除上一次读取的状态并放回lineScannerPool, 扩容后的缓冲区不保留
+func (s *lineScanner) release() {
+	s.scanner = bufio.Scanner{}
+	s.head, s.partial = nil, nil
+	s.consumed, s.truncated, s.eof, s.raw = 0, false, false, false
+	lineScannerPool.Put(s)
+}
+
+// split bufio.SplitFunc, 分隔符可能被缓冲区拆开, 没有找到分隔符时保留末尾len(delimiter)-1个字节
+func (s *lineScanner) split(data []byte, atEOF bool) (int, []byte, error) {
+	var (
+		i    = bytes.Index(data, s.delimiter)
+		keep = len(s.delimiter) - 1
+	)
+
+	// 正在丢弃超过maxBytes的行, 直到分隔符或者文件末尾
+	if s.head != nil {
+		switch {
+		case i >= 0:
+			return s.emitHead(i+len(s.delimiter), false)
+		case atEOF:
+			return s.emitHead(len(data), true)
+		case len(data) > keep:
+			s.consumed += int64(len(data) - keep)
+			return len(data) - keep, nil, nil
+		}
+		return 0, nil, nil
+	}
+
+	if i >= 0 && i <= s.maxBytes {
+		s.consumed, s.truncated, s.eof = int64(i+len(s.delimiter)), false, false
+		return i + len(s.delimiter), data[:i+len(s.delimiter)], nil
+	}
+
+	// 分隔符之前或者已经读到的内容超过maxBytes, 保留前maxBytes字节, 剩余的内容丢弃
+	if i > s.maxBytes || len(data)-keep > s.maxBytes || (atEOF && len(data) > s.maxBytes) {
+		s.head = append(make([]byte, 0, s.maxBytes), data[:s.maxBytes]...)
+		s.consumed = 0
+		return s.split(data, atEOF)
+	}
+
+	// 文件末尾还没有分隔符的内容不读取
+	if atEOF {
+		s.partial = data
+	}
+
+	return 0, nil, nil
+}
+
+// emitHead 丢弃advance字节, 返回截断后的行
+func (s *lineScanner) emitHead(advance int, eof bool) (int, []byte, error) {
+	var head = s.head
+
+	s.consumed += int64(advance)
+	s.truncated, s.eof, s.head = true, eof, nil
+
+	// 截断位置可能在多字节字符的中间
+	if s.raw {
+		return advance, head, nil
+	}
+	return advance, []byte(strings.ToValidUTF8(string(head), "")), nil
+}
+
+// readLine 读取一行, 返回行的内容、读取的字节数和是否被截断
+// 读到文件末尾还没有分隔符时err为io.EOF, line为没有分隔符的内容, 截断的行在文件末尾时同样返回io.EOF
+func (s *lineScanner) readLine() (line string, consumed int64, truncated bool, err error) {
+	if s.scanner.Scan() {
+		if s.eof {
+			err = io.EOF
+		}
+		return s.scanner.Text(), s.consumed, s.truncated, err
+	}
+
+	if err = s.scanner.Err(); err != nil {
+		return "", 0, false, err
+	}
+
+	return string(s.partial), int64(len(s.partial)), false, io.EOF
+}
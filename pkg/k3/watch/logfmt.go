@@ -0,0 +1,92 @@
+package watch
+
+import (
+	"errors"
+	"strings"
+)
+
+// ParseLogfmt 解析key=value格式的日志, 值可以使用双引号包含空格和=, 双引号内支持\"和\\转义
+// 没有=的key值为true, key=表示空字符串; 一个key=value都没有时返回错误, 表示不是logfmt格式
+func ParseLogfmt(line string) (map[string]interface{}, error) {
+	var (
+		fields   = make(map[string]interface{})
+		assigned bool // 是否至少有一个key=value
+		i        int
+	)
+
+	for i < len(line) {
+		// 跳过空白
+		for i < len(line) && isLogfmtSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+
+		// key到=或者空白结束
+		start := i
+		for i < len(line) && line[i] != '=' && !isLogfmtSpace(line[i]) {
+			i++
+		}
+		key := line[start:i]
+
+		if i >= len(line) || line[i] != '=' {
+			// 只有key
+			if len(key) > 0 {
+				fields[key] = true
+			}
+			continue
+		}
+
+		// 跳过=
+		i++
+		if len(key) == 0 {
+			// =value 没有key, 忽略value
+			for i < len(line) && !isLogfmtSpace(line[i]) {
+				i++
+			}
+			continue
+		}
+		assigned = true
+
+		if i < len(line) && line[i] == '"' {
+			var value strings.Builder
+
+			// 双引号的值, 到没有转义的双引号结束, 没有结束的双引号时取到行尾
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						value.WriteByte('\n')
+					case 't':
+						value.WriteByte('\t')
+					default:
+						value.WriteByte(line[i])
+					}
+					continue
+				}
+				value.WriteByte(line[i])
+			}
+			i++
+			fields[key] = value.String()
+			continue
+		}
+
+		start = i
+		for i < len(line) && !isLogfmtSpace(line[i]) {
+			i++
+		}
+		fields[key] = line[start:i]
+	}
+
+	if !assigned {
+		return nil, errors.New("[ParseLogfmt] no key=value pair found")
+	}
+
+	return fields, nil
+}
+
+func isLogfmtSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
@@ -0,0 +1,74 @@
+package watch
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	for _, item := range []struct {
+		line     string
+		expected map[string]interface{}
+	}{
+		{`level=info msg=started`, map[string]interface{}{"level": "info", "msg": "started"}},
+		{`msg="quoted value" code=200`, map[string]interface{}{"msg": "quoted value", "code": "200"}},
+		{`msg="say \"hi\" \\ ok"`, map[string]interface{}{"msg": `say "hi" \ ok`}},
+		{`query="a=1&b=2" path=/x?y=z`, map[string]interface{}{"query": "a=1&b=2", "path": "/x?y=z"}},
+		{`debug level=warn`, map[string]interface{}{"debug": true, "level": "warn"}},
+		{`empty= quoted="" after=1`, map[string]interface{}{"empty": "", "quoted": "", "after": "1"}},
+		{"  a=1\tb=2  ", map[string]interface{}{"a": "1", "b": "2"}},
+		{`=orphan a=1`, map[string]interface{}{"a": "1"}},
+		{`msg="unterminated value`, map[string]interface{}{"msg": "unterminated value"}},
+	} {
+		fields, err := ParseLogfmt(item.line)
+		if err != nil {
+			t.Errorf("%q should be parsed, got %s", item.line, err)
+			continue
+		}
+		if fmt.Sprint(fields) != fmt.Sprint(item.expected) {
+			t.Errorf("%q expected %v, got %v", item.line, item.expected, fields)
+		}
+	}
+
+	for _, line := range []string{"", "plain text line", "=value"} {
+		if _, err := ParseLogfmt(line); err == nil {
+			t.Errorf("%q has no key=value pair, should return error", line)
+		}
+	}
+}
+
+func TestFormatLogfmt(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+		line     = `level=error msg="db timeout" message=inner`
+	)
+
+	if err := InitIndexRules(map[string]config.Index{"index_test": {Format: FormatLogfmt, TagParseError: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, line, "plain text")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	// 原始日志保存在message中, 冲突的字段加上json_前缀
+	properties := consumer.datas[0].Properties
+	if properties["level"] != "error" || properties["msg"] != "db timeout" {
+		t.Errorf("logfmt fields should be merged, got %v", properties)
+	}
+	if properties[LogfmtMessageField] != line || properties["json_message"] != "inner" {
+		t.Errorf("original line should be kept in message, got %v", properties)
+	}
+
+	if properties = consumer.datas[1].Properties; properties[ParseErrorField] != true || properties[LogfmtMessageField] != nil {
+		t.Errorf("non logfmt line should be sent as raw line with _parse_error, got %v", properties)
+	}
+
+	if err := InitIndexRules(map[string]config.Index{"index_test": {Format: "xml"}}); err == nil {
+		t.Errorf("unsupported format should return error")
+	}
+}
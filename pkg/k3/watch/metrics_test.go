@@ -0,0 +1,106 @@
+package watch
+
+import (
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"io"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// failSender 测试用sender, 所有发送都失败
+type failSender struct{}
+
+func (s *failSender) Send(data []protocol.Data) error {
+	return errors.New("send failed")
+}
+
+func (s *failSender) Close() error {
+	return nil
+}
+
+// scrapeMetrics 抓取/metrics接口, 返回 name -> value
+func scrapeMetrics(t *testing.T, url string) map[string]int64 {
+	var metrics = make(map[string]int64)
+
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(string(body), "\n") {
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if value, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			metrics[fields[0]] = value
+		}
+	}
+
+	return metrics
+}
+
+func TestMetricsAfterIngest(t *testing.T) {
+	var (
+		path   = filepath.Join(t.TempDir(), "app.log")
+		server = httptest.NewServer(http.HandlerFunc(k3.MetricsRouter))
+	)
+	defer server.Close()
+
+	initTestWatch(t)
+	consumer, err := k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{Sender: &recordSender{}, BatchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+
+	before := scrapeMetrics(t, server.URL)
+
+	createFile("index_test", path)
+	appendLines(t, path, "line 1", "line 2")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	after := scrapeMetrics(t, server.URL)
+	if after["k3_files_watched"] != 1 {
+		t.Errorf("k3_files_watched expected 1, got %d", after["k3_files_watched"])
+	}
+	if n := after["k3_lines_read_total"] - before["k3_lines_read_total"]; n != 2 {
+		t.Errorf("k3_lines_read_total should increase by 2, got %d", n)
+	}
+	if n := after["k3_bytes_read_total"] - before["k3_bytes_read_total"]; n != int64(len("line 1\nline 2\n")) {
+		t.Errorf("k3_bytes_read_total should increase by the bytes read, got %d", n)
+	}
+	if n := after["k3_batches_sent_total"] - before["k3_batches_sent_total"]; n != 2 {
+		t.Errorf("k3_batches_sent_total should increase by 2, got %d", n)
+	}
+	if _, exists := after["k3_reader_goroutines"]; !exists {
+		t.Errorf("k3_reader_goroutines should be exported")
+	}
+
+	// 发送失败时增加k3_send_errors_total
+	if consumer, err = k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{Sender: &failSender{}, BatchSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+	appendLines(t, path, "line 3")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if n := scrapeMetrics(t, server.URL)["k3_send_errors_total"] - after["k3_send_errors_total"]; n != 1 {
+		t.Errorf("k3_send_errors_total should increase by 1, got %d", n)
+	}
+}
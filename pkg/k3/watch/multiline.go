@@ -0,0 +1,204 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"log-engine-sdk/pkg/k3/config"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 多行合并方式, 对应watch.multiline.match配置
+const (
+	MultilineMatchAfter  = "after"  // 匹配行追加到前一行之后, 不匹配的行开始新的一条日志
+	MultilineMatchBefore = "before" // 匹配行与后一行合并, 不匹配的行结束当前日志
+)
+
+var (
+	DefaultMultilineMaxLines = 500 // 一条日志最多合并的行数
+	DefaultMultilineTimeout  = 5   // 单位秒, 没有等到结束行时, 超过该时间直接发送已经合并的日志
+)
+
+var (
+	multilineLock   = &sync.RWMutex{}
+	GlobalMultiline *MultilineRule // 为nil时不开启多行合并
+)
+
+// MultilineRule 由config.Multiline编译而来的多行合并规则
+type MultilineRule struct {
+	pattern  *regexp.Regexp
+	negate   bool
+	match    string
+	maxLines int
+	timeout  time.Duration
+}
+
+// NewMultilineRule 编译多行合并规则, pattern为空时返回nil, 表示不开启
+func NewMultilineRule(multiline config.Multiline) (*MultilineRule, error) {
+	var (
+		rule = &MultilineRule{
+			negate:   multiline.Negate,
+			match:    multiline.Match,
+			maxLines: multiline.MaxLines,
+			timeout:  time.Duration(multiline.Timeout) * time.Second,
+		}
+		err error
+	)
+
+	if len(multiline.Pattern) == 0 {
+		return nil, nil
+	}
+
+	if rule.pattern, err = regexp.Compile(multiline.Pattern); err != nil {
+		return nil, fmt.Errorf("[NewMultilineRule] invalid multiline pattern: %w", err)
+	}
+
+	if len(rule.match) == 0 {
+		rule.match = MultilineMatchAfter
+	}
+
+	if rule.match != MultilineMatchAfter && rule.match != MultilineMatchBefore {
+		return nil, errors.New("[NewMultilineRule] unsupported multiline match: " + rule.match)
+	}
+
+	if rule.maxLines <= 0 {
+		rule.maxLines = DefaultMultilineMaxLines
+	}
+
+	if rule.timeout <= 0 {
+		rule.timeout = time.Duration(DefaultMultilineTimeout) * time.Second
+	}
+
+	return rule, nil
+}
+
+// InitMultiline 编译watch.multiline配置
+func InitMultiline(multiline config.Multiline) error {
+	var (
+		rule *MultilineRule
+		err  error
+	)
+
+	if rule, err = NewMultilineRule(multiline); err != nil {
+		return err
+	}
+
+	multilineLock.Lock()
+	GlobalMultiline = rule
+	multilineLock.Unlock()
+
+	return nil
+}
+
+// getMultiline 获取多行合并规则, 没有开启返回nil
+func getMultiline() *MultilineRule {
+	multilineLock.RLock()
+	defer multilineLock.RUnlock()
+
+	return GlobalMultiline
+}
+
+// matched 按照pattern和negate判断line是否为匹配行
+func (r *MultilineRule) matched(line string) bool {
+	return r.pattern.MatchString(strings.TrimRight(line, "\r\n")) != r.negate
+}
+
+// multilineBuffer 按照多行规则将逐行读取的内容合并为完整的日志
+type multilineBuffer struct {
+	rule  *MultilineRule
+	lines []string
+	size  int64 // 还没有结束的日志在文件中占用的字节数, 这部分内容不能提交offset
+}
+
+// add 加入一行, 返回因为这一行而结束的日志
+func (b *multilineBuffer) add(line string) (string, bool) {
+	var (
+		event    string
+		finished bool
+		matched  = b.rule.matched(line)
+	)
+
+	if b.rule.match == MultilineMatchBefore {
+		b.append(line)
+		if !matched || len(b.lines) >= b.rule.maxLines {
+			event, finished = b.flush()
+		}
+		return event, finished
+	}
+
+	// after: 匹配行是前一行的延续, 不匹配的行开始新的一条日志
+	if matched && len(b.lines) > 0 && len(b.lines) < b.rule.maxLines {
+		b.append(line)
+		return "", false
+	}
+
+	event, finished = b.flush()
+	b.append(line)
+
+	return event, finished
+}
+
+func (b *multilineBuffer) append(line string) {
+	b.lines = append(b.lines, line)
+	b.size += int64(len(line))
+}
+
+// flush 结束当前的日志
+func (b *multilineBuffer) flush() (string, bool) {
+	if len(b.lines) == 0 {
+		return "", false
+	}
+
+	event := strings.Join(b.lines, "")
+	b.lines = b.lines[:0]
+	b.size = 0
+
+	return event, true
+}
+
+// pending 是否有还没有结束的日志
+func (b *multilineBuffer) pending() bool {
+	return len(b.lines) > 0
+}
+
+// checkMultilineTimeout 读取结束时还有没有结束的日志, 超过timeout返回true直接发送; 否则在timeout后再读取一次文件
+func checkMultilineTimeout(rule *MultilineRule, fileState *FileState) bool {
+	var (
+		now       = nowFunc()
+		remaining time.Duration
+		schedule  bool
+	)
+
+	GlobalFileStatesLock.Lock()
+	if fileState.multilinePendingSince.IsZero() {
+		fileState.multilinePendingSince = now
+	}
+	remaining = rule.timeout - now.Sub(fileState.multilinePendingSince)
+	if remaining > 0 && !fileState.multilineScheduled {
+		fileState.multilineScheduled = true
+		schedule = true
+	}
+	GlobalFileStatesLock.Unlock()
+
+	if remaining <= 0 {
+		return true
+	}
+
+	// 文件没有新的写入时不会触发读取, 需要定时再读取一次, 超时后发送等待中的日志
+	if schedule {
+		scheduleRead(fileState.IndexName, fileState.Path, remaining, func() {
+			fileState.multilineScheduled = false
+		})
+	}
+
+	return false
+}
+
+// resetMultilineTimeout 没有等待中的日志时, 清除等待时间
+func resetMultilineTimeout(fileState *FileState) {
+	GlobalFileStatesLock.Lock()
+	fileState.multilinePendingSince = time.Time{}
+	GlobalFileStatesLock.Unlock()
+}
@@ -0,0 +1,150 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// javaException 以日期开头的行是一条新的日志, 异常堆栈追加到前一行
+var javaException = []string{
+	"2026-10-14 10:00:01 ERROR request failed",
+	"java.lang.IllegalStateException: boom",
+	"\tat com.example.App.run(App.java:10)",
+	"\tat com.example.App.main(App.java:5)",
+	"Caused by: java.io.IOException: disk full",
+	"\t... 2 more",
+}
+
+func initTestMultiline(t *testing.T, multiline config.Multiline) *captureConsumer {
+	consumer := initTestWatch(t)
+	if err := InitMultiline(multiline); err != nil {
+		t.Fatal(err)
+	}
+	return consumer
+}
+
+func TestMultilineJavaException(t *testing.T) {
+	var (
+		consumer = initTestMultiline(t, config.Multiline{Pattern: `^\d{4}-\d{2}-\d{2}`, Negate: true, Match: MultilineMatchAfter})
+		path     = filepath.Join(t.TempDir(), "app.log")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+		now      = time.Now()
+		first    = "2026-10-14 10:00:00 INFO start"
+		next     = "2026-10-14 10:00:02 INFO next"
+	)
+
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	// 堆栈的行数超过一次读取的最大次数, 也要读完整条日志
+	config.GlobalConfig.Watch.MaxReadCount = 2
+
+	appendLines(t, path, first)
+	appendLines(t, path, javaException...)
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	// 异常还没有等到下一条日志, 不能确定已经结束, 不发送也不提交offset
+	assertLines(t, consumer, first)
+	if offset := GlobalFileStates[path].Offset; offset != int64(len(first)+1) {
+		t.Fatalf("offset should stop at the pending exception, got %d", offset)
+	}
+
+	appendLines(t, path, next)
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	assertLines(t, consumer, first, strings.Join(javaException, "\n"))
+
+	// 最后一行等待超时后发送
+	now = now.Add(time.Duration(DefaultMultilineTimeout+1) * time.Second)
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	assertLines(t, consumer, first, strings.Join(javaException, "\n"), next)
+
+	size := len(first) + len(strings.Join(javaException, "\n")) + len(next) + 3
+	if offset := GlobalFileStates[path].Offset; offset != int64(size) {
+		t.Errorf("offset should reach the end of file %d, got %d", size, offset)
+	}
+}
+
+func TestMultilineRestartMidException(t *testing.T) {
+	var (
+		multiline = config.Multiline{Pattern: `^\d{4}-\d{2}-\d{2}`, Negate: true}
+		consumer  = initTestMultiline(t, multiline)
+		path      = filepath.Join(t.TempDir(), "app.log")
+		event     = fsnotify.Event{Name: path, Op: fsnotify.Write}
+		next      = "2026-10-14 10:00:02 INFO next"
+	)
+
+	// 堆栈只写入了一半时进程退出
+	appendLines(t, path, javaException[:3]...)
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	assertLines(t, consumer)
+	offset := GlobalFileStates[path].Offset
+	if offset != 0 {
+		t.Fatalf("offset of a pending exception should not be committed, got %d", offset)
+	}
+
+	// 重启后从状态文件记录的offset继续读取, 整个异常只发送一次
+	consumer = initTestMultiline(t, multiline)
+	GlobalFileStates[path] = &FileState{Path: path, Offset: offset, IndexName: "index_test"}
+
+	appendLines(t, path, javaException[3:]...)
+	appendLines(t, path, next)
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	assertLines(t, consumer, strings.Join(javaException, "\n"))
+}
+
+func TestMultilineMatchBefore(t *testing.T) {
+	var (
+		consumer = initTestMultiline(t, config.Multiline{Pattern: `\\$`, Match: MultilineMatchBefore})
+		path     = filepath.Join(t.TempDir(), "app.log")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	// 以\结尾的行与下一行合并
+	appendLines(t, path, "select * \\", "from t \\", "where id = 1", "single line", "pending \\")
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	assertLines(t, consumer, "select * \\\nfrom t \\\nwhere id = 1", "single line")
+}
+
+func TestMultilineMaxLines(t *testing.T) {
+	var (
+		consumer = initTestMultiline(t, config.Multiline{Pattern: `^\s`, MaxLines: 2})
+		path     = filepath.Join(t.TempDir(), "app.log")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	appendLines(t, path, "error", " at 1", " at 2", "next")
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	// 超过max_lines后开始新的一条日志
+	assertLines(t, consumer, "error\n at 1", "at 2")
+}
+
+func TestNewMultilineRule(t *testing.T) {
+	if rule, err := NewMultilineRule(config.Multiline{}); rule != nil || err != nil {
+		t.Errorf("empty pattern should disable multiline, got %v %v", rule, err)
+	}
+
+	if _, err := NewMultilineRule(config.Multiline{Pattern: `(`}); err == nil {
+		t.Errorf("invalid pattern should return error")
+	}
+
+	if _, err := NewMultilineRule(config.Multiline{Pattern: `^\s`, Match: "middle"}); err == nil {
+		t.Errorf("unsupported match should return error")
+	}
+}
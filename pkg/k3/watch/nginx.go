@@ -0,0 +1,67 @@
+package watch
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nginxCombinedPattern nginx/apache的combined格式, 没有referer和user_agent时兼容common格式, 之后附加的字段忽略
+// $remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"
+var nginxCombinedPattern = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]*)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\d+|-)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?(?:\s|$)`)
+
+// ParseNginxCombined 解析nginx/apache的combined格式访问日志, status和bytes为数字, 值为-的字段为空字符串
+// request可以拆分时同时返回method, path和protocol; 不匹配时返回错误
+func ParseNginxCombined(line string) (map[string]interface{}, error) {
+	var (
+		match  = nginxCombinedPattern.FindStringSubmatch(line)
+		fields map[string]interface{}
+		status int
+		bytes  int64
+		err    error
+	)
+
+	if match == nil {
+		return nil, errors.New("[ParseNginxCombined] line does not match nginx combined format")
+	}
+
+	if status, err = strconv.Atoi(match[5]); err != nil {
+		return nil, errors.New("[ParseNginxCombined] invalid status: " + match[5])
+	}
+
+	// 没有发送内容时为-
+	if match[6] != "-" {
+		if bytes, err = strconv.ParseInt(match[6], 10, 64); err != nil {
+			return nil, errors.New("[ParseNginxCombined] invalid bytes: " + match[6])
+		}
+	}
+
+	fields = map[string]interface{}{
+		"remote_addr": match[1],
+		"remote_user": nginxValue(match[2]),
+		"time_local":  match[3],
+		"request":     match[4],
+		"status":      status,
+		"bytes":       bytes,
+		"referer":     nginxValue(match[7]),
+		"user_agent":  nginxValue(match[8]),
+	}
+
+	// GET /index.html HTTP/1.1, 格式不对的请求(如扫描器发送的二进制内容)只保留request
+	if parts := strings.Split(match[4], " "); len(parts) == 3 {
+		fields["method"] = parts[0]
+		fields["path"] = parts[1]
+		fields["protocol"] = parts[2]
+	}
+
+	return fields, nil
+}
+
+// nginxValue nginx使用-表示变量为空
+func nginxValue(value string) string {
+	if value == "-" {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,97 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseNginxCombined(t *testing.T) {
+	fields, err := ParseNginxCombined(`203.0.113.7 - alice [16/Oct/2024:10:00:01 +0800] "GET /api/users?id=1 HTTP/1.1" 200 1534 "https://example.com/" "Mozilla/5.0 (X11; Linux x86_64)"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]interface{}{
+		"remote_addr": "203.0.113.7",
+		"remote_user": "alice",
+		"time_local":  "16/Oct/2024:10:00:01 +0800",
+		"request":     "GET /api/users?id=1 HTTP/1.1",
+		"method":      "GET",
+		"path":        "/api/users?id=1",
+		"protocol":    "HTTP/1.1",
+		"status":      200,
+		"bytes":       int64(1534),
+		"referer":     "https://example.com/",
+		"user_agent":  "Mozilla/5.0 (X11; Linux x86_64)",
+	} {
+		if fields[key] != expected {
+			t.Errorf("%s expected %v(%T), got %v(%T)", key, expected, expected, fields[key], fields[key])
+		}
+	}
+
+	// -表示空, 转义的双引号, 不能拆分的request, 之后附加的字段
+	fields, err = ParseNginxCombined(`10.0.0.1 - - [16/Oct/2024:10:00:02 +0800] "\x16\x03\x01" 400 - "-" "curl \"7.68\"" "10.0.0.2"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields["remote_user"] != "" || fields["bytes"] != int64(0) || fields["referer"] != "" || fields["status"] != 400 {
+		t.Errorf("- should be parsed as empty value, got %v", fields)
+	}
+	if fields["user_agent"] != `curl \"7.68\"` {
+		t.Errorf("escaped quote should be kept in user_agent, got %v", fields["user_agent"])
+	}
+	if _, ok := fields["method"]; ok {
+		t.Errorf("malformed request should not be split, got %v", fields)
+	}
+
+	// common格式没有referer和user_agent
+	if fields, err = ParseNginxCombined(`10.0.0.1 - - [16/Oct/2024:10:00:03 +0800] "POST /login HTTP/1.0" 302 0`); err != nil {
+		t.Fatal(err)
+	}
+	if fields["status"] != 302 || fields["user_agent"] != "" {
+		t.Errorf("common format should be parsed, got %v", fields)
+	}
+
+	for _, line := range []string{
+		"",
+		"plain text line",
+		`10.0.0.1 - - [16/Oct/2024:10:00:03 +0800] "GET / HTTP/1.1" abc 0`,
+		`10.0.0.1 - - 16/Oct/2024:10:00:03 "GET / HTTP/1.1" 200 0`,
+		`10.0.0.1 - - [16/Oct/2024:10:00:03 +0800] "GET / HTTP/1.1 200 0`,
+	} {
+		if _, err = ParseNginxCombined(line); err == nil {
+			t.Errorf("%q is not nginx combined format, should return error", line)
+		}
+	}
+}
+
+func TestFormatNginxCombined(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "access.log")
+		line     = `203.0.113.7 - - [16/Oct/2024:10:00:01 +0800] "GET / HTTP/1.1" 304 0 "-" "Mozilla/5.0"`
+	)
+
+	if err := InitIndexRules(map[string]config.Index{"index_test": {Format: FormatNginxCombined, TagParseError: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, line, "nginx: [warn] conflicting server name")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	// 原始日志保留在_data中
+	properties := consumer.datas[0].Properties
+	if properties["_data"] != line || properties["status"] != 304 || properties["bytes"] != int64(0) || properties["user_agent"] != "Mozilla/5.0" {
+		t.Errorf("nginx fields should be merged, got %v", properties)
+	}
+	if _, ok := properties[ParseErrorField]; ok {
+		t.Errorf("matched line should not be tagged, got %v", properties)
+	}
+
+	if properties = consumer.datas[1].Properties; properties[ParseErrorField] != true || properties["status"] != nil {
+		t.Errorf("unmatched line should be sent as raw line with _parse_error, got %v", properties)
+	}
+}
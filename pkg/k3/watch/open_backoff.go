@@ -0,0 +1,82 @@
+package watch
+
+import (
+	"errors"
+	"io/fs"
+	"log-engine-sdk/pkg/k3"
+	"os"
+	"time"
+)
+
+var (
+	DefaultOpenRetryInterval = time.Second     // 文件打开失败(如没有读权限)后第一次重试的间隔, 之后每次翻倍
+	MaxOpenRetryInterval     = 5 * time.Minute // 文件打开失败后重试的最长间隔
+
+	errOpenBackoff = errors.New("file open failed recently, waiting for retry")
+)
+
+// openWithBackoff 从GlobalFdCache获取文件句柄, 打开失败(如权限不足)只影响这一个文件:
+// 按照指数退避设置下次重试的时间, 在此之前的读取直接跳过, 到时间后再读取一次, 打开成功后清除退避
+// 文件不存在时直接返回错误, 由删除事件处理
+func openWithBackoff(indexName string, fileState *FileState) (*os.File, error) {
+	var (
+		now   = nowFunc()
+		fd    *os.File
+		delay time.Duration
+		err   error
+	)
+
+	GlobalFileStatesLock.Lock()
+	retryAt := fileState.openRetryAt
+	GlobalFileStatesLock.Unlock()
+
+	if now.Before(retryAt) {
+		return nil, errOpenBackoff
+	}
+
+	if fd, err = GlobalFdCache.Open(fileState.Path); err == nil {
+		GlobalFileStatesLock.Lock()
+		failures := fileState.openFailures
+		fileState.openFailures, fileState.openRetryAt = 0, time.Time{}
+		GlobalFileStatesLock.Unlock()
+
+		if failures > 0 {
+			k3.K3LogInfo("[openWithBackoff] path[%s] is readable again after %d failures.", fileState.Path, failures)
+		}
+		return fd, nil
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	GlobalFileStatesLock.Lock()
+	fileState.openFailures++
+	delay = DefaultOpenRetryInterval << uint(fileState.openFailures-1)
+	if delay <= 0 || delay > MaxOpenRetryInterval {
+		delay = MaxOpenRetryInterval
+	}
+	fileState.openRetryAt = now.Add(delay)
+	failures := fileState.openFailures
+	GlobalFileStatesLock.Unlock()
+
+	k3.K3LogWarn("[openWithBackoff] index_name[%s] path[%s] open failed %d times, retry after %s: %s", indexName, fileState.Path, failures, delay, err.Error())
+	scheduleOpenRetry(indexName, fileState, delay)
+
+	return nil, err
+}
+
+// scheduleOpenRetry 权限恢复(chmod)不会产生写入事件, 需要定时再读取一次
+func scheduleOpenRetry(indexName string, fileState *FileState, delay time.Duration) {
+	GlobalFileStatesLock.Lock()
+	if fileState.openRetryScheduled {
+		GlobalFileStatesLock.Unlock()
+		return
+	}
+	fileState.openRetryScheduled = true
+	GlobalFileStatesLock.Unlock()
+
+	scheduleRead(indexName, fileState.Path, delay, func() {
+		fileState.openRetryScheduled = false
+	})
+}
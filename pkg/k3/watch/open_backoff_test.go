@@ -0,0 +1,66 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOpenPermissionDenied(t *testing.T) {
+	var (
+		consumer   = initTestWatch(t)
+		dir        = t.TempDir()
+		denied     = filepath.Join(dir, "denied.log")
+		readable   = filepath.Join(dir, "app.log")
+		permission atomic.Bool
+		opens      atomic.Int32
+		interval   = DefaultOpenRetryInterval
+	)
+
+	// 以root运行时chmod不能阻止读取, 替换打开文件的函数
+	permission.Store(true)
+	openFileFunc = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		if name == denied && permission.Load() {
+			opens.Add(1)
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+		}
+		return os.OpenFile(name, flag, perm)
+	}
+	DefaultOpenRetryInterval = 200 * time.Millisecond
+	t.Cleanup(func() {
+		openFileFunc = os.OpenFile
+		DefaultOpenRetryInterval = interval
+	})
+
+	appendLines(t, denied, "secret 1")
+	appendLines(t, readable, "line 1")
+
+	// 读取后设置了定时的读取, 不能再使用processingWg.Wait
+	writeEvent("index_test", fsnotify.Event{Name: denied, Op: fsnotify.Write})
+	writeEvent("index_test", fsnotify.Event{Name: readable, Op: fsnotify.Write})
+	waitFor(t, func() bool { return len(consumer.lines()) == 1 })
+	assertLines(t, consumer, "line 1")
+
+	// 退避时间内再次写入不会打开文件
+	writeEvent("index_test", fsnotify.Event{Name: denied, Op: fsnotify.Write})
+	time.Sleep(50 * time.Millisecond)
+	if count := opens.Load(); count != 1 {
+		t.Errorf("file should not be opened again during backoff, opened %d times", count)
+	}
+
+	// 权限恢复后由定时的读取发送, 退避清除
+	permission.Store(false)
+	waitFor(t, func() bool { return len(consumer.lines()) == 2 })
+	assertLines(t, consumer, "line 1", "secret 1")
+
+	GlobalFileStatesLock.Lock()
+	failures, retryAt := GlobalFileStates[denied].openFailures, GlobalFileStates[denied].openRetryAt
+	GlobalFileStatesLock.Unlock()
+	if failures != 0 || !retryAt.IsZero() {
+		t.Errorf("backoff should be cleared once the file is readable, got %d failures, retry at %s", failures, retryAt)
+	}
+}
@@ -0,0 +1,54 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"time"
+)
+
+// partialLineTimeout 文件末尾没有换行符的行, 超过该时间没有继续写入时直接发送, 为0不开启
+func partialLineTimeout() time.Duration {
+	return time.Duration(config.GlobalConfig.Watch.PartialLineTimeout) * time.Millisecond
+}
+
+// checkPartialLine 文件末尾offset开始有size字节没有换行符的内容, 超过partial_line_timeout没有增长时返回true, 作为完整的一行发送
+// 内容还在增长(正在写入)时重新计时, 不会把正在写入的行拆开; 没有超时时在剩余时间后再读取一次文件
+func checkPartialLine(fileState *FileState, offset, size int64) bool {
+	var (
+		timeout   = partialLineTimeout()
+		now       = nowFunc()
+		remaining time.Duration
+		schedule  bool
+	)
+
+	if timeout <= 0 {
+		return false
+	}
+
+	GlobalFileStatesLock.Lock()
+	if fileState.partialOffset != offset || fileState.partialSize != size || fileState.partialSince.IsZero() {
+		fileState.partialOffset, fileState.partialSize, fileState.partialSince = offset, size, now
+	}
+	remaining = timeout - now.Sub(fileState.partialSince)
+	if remaining <= 0 {
+		fileState.partialOffset, fileState.partialSize, fileState.partialSince = 0, 0, time.Time{}
+	} else if !fileState.partialScheduled {
+		fileState.partialScheduled = true
+		schedule = true
+	}
+	GlobalFileStatesLock.Unlock()
+
+	if remaining <= 0 {
+		k3.K3LogDebug("[checkPartialLine] path[%s] line at offset %d has no newline for %s, send it.", fileState.Path, offset, timeout)
+		return true
+	}
+
+	// 应用写入后暂停时没有新的写入事件, 需要定时再读取一次, 超时后发送没有换行符的行
+	if schedule {
+		scheduleRead(fileState.IndexName, fileState.Path, remaining, func() {
+			fileState.partialScheduled = false
+		})
+	}
+
+	return false
+}
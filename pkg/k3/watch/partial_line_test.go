@@ -0,0 +1,92 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPartialLineIdleFlush(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	config.GlobalConfig.Watch.PartialLineTimeout = 300
+
+	appendLines(t, path, "line 1")
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	_, _ = fd.WriteString("line 2")
+
+	// 读取后设置了定时的读取, 不能再使用processingWg.Wait, 通过partialSize等待读取结束
+	writeEvent("index_test", event)
+	waitFor(t, func() bool { return partialSizeOf(path) == int64(len("line 2")) && len(consumer.lines()) == 1 })
+	assertLines(t, consumer, "line 1")
+
+	// 还在写入的行重新计时, 不会被拆开
+	time.Sleep(100 * time.Millisecond)
+	_, _ = fd.WriteString(" continued")
+	writeEvent("index_test", event)
+	waitFor(t, func() bool { return partialSizeOf(path) == int64(len("line 2 continued")) })
+	assertLines(t, consumer, "line 1")
+
+	// 没有新的写入事件, 超时后定时读取发送没有换行符的行
+	waitFor(t, func() bool { return len(consumer.lines()) == 2 })
+	assertLines(t, consumer, "line 1", "line 2 continued")
+
+	info, _ := os.Stat(path)
+	GlobalFileStatesLock.Lock()
+	offset := GlobalFileStates[path].Offset
+	GlobalFileStatesLock.Unlock()
+	if offset != info.Size() {
+		t.Errorf("offset should advance past the flushed line, expected %d, got %d", info.Size(), offset)
+	}
+
+	// 之后写入的内容作为新的一行
+	_, _ = fd.WriteString("\nline 3\n")
+	writeEvent("index_test", event)
+	processingWg.Wait()
+	assertLines(t, consumer, "line 1", "line 2 continued", "line 3")
+}
+
+func TestPartialLineDisabled(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+
+	appendLines(t, path, "line 1")
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	_, _ = fd.WriteString("line 2")
+
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if checkPartialLine(GlobalFileStates[path], int64(len("line 1\n")), int64(len("line 2"))) {
+		t.Error("partial lines should wait for the newline when partial_line_timeout is 0")
+	}
+	assertLines(t, consumer, "line 1")
+}
+
+// partialSizeOf 文件末尾没有换行符的行最近一次读取时的长度
+func partialSizeOf(path string) int64 {
+	GlobalFileStatesLock.Lock()
+	defer GlobalFileStatesLock.Unlock()
+
+	if fileState, ok := GlobalFileStates[path]; ok {
+		return fileState.partialSize
+	}
+	return 0
+}
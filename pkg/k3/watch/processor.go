@@ -0,0 +1,207 @@
+package watch
+
+import (
+	"fmt"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"regexp"
+	"sync"
+)
+
+// 内置的日志处理器, 对应index.processors的type
+const (
+	ProcessorRedact     = "redact"      // 正则替换字段中的内容, 如脱敏邮箱、token
+	ProcessorRename     = "rename"      // 重命名字段
+	ProcessorDropFields = "drop_fields" // 删除字段
+	ProcessorDrop       = "drop"        // 字段匹配正则时丢弃整条日志
+
+	DefaultRedactReplacement = "***"
+)
+
+// Processor 在解析之后、交给consumer之前处理一条日志, 可以修改data
+// keep为false时丢弃这条日志, offset照常前进; 返回错误时同样丢弃, 避免发送没有脱敏的数据
+type Processor interface {
+	Process(data *protocol.Data) (keep bool, err error)
+}
+
+// ProcessorFactory 由配置创建处理器, 配置不合法时返回错误
+type ProcessorFactory func(cfg config.Processor) (Processor, error)
+
+var (
+	processorFactoriesLock = &sync.RWMutex{}
+	processorFactories     = map[string]ProcessorFactory{
+		ProcessorRedact:     newRedactProcessor,
+		ProcessorRename:     newRenameProcessor,
+		ProcessorDropFields: newDropFieldsProcessor,
+		ProcessorDrop:       newDropProcessor,
+	}
+)
+
+// RegisterProcessor 注册自定义的处理器类型, 之后可以在index.processors中通过type使用, 需要在InitIndexRules之前调用
+// 与内置类型同名时覆盖内置类型, factory为nil时取消注册
+func RegisterProcessor(name string, factory ProcessorFactory) {
+	processorFactoriesLock.Lock()
+	defer processorFactoriesLock.Unlock()
+
+	if factory == nil {
+		delete(processorFactories, name)
+		return
+	}
+	processorFactories[name] = factory
+}
+
+// compileProcessors 按照配置的顺序创建处理器, 不支持的type或者配置不合法时返回错误
+func compileProcessors(configs []config.Processor) ([]Processor, error) {
+	var (
+		processors = make([]Processor, 0, len(configs))
+		processor  Processor
+		err        error
+	)
+
+	processorFactoriesLock.RLock()
+	defer processorFactoriesLock.RUnlock()
+
+	for i, cfg := range configs {
+		factory, ok := processorFactories[cfg.Type]
+		if !ok {
+			return nil, fmt.Errorf("processors[%d] unsupported type[%s]", i, cfg.Type)
+		}
+		if processor, err = factory(cfg); err != nil {
+			return nil, fmt.Errorf("processors[%d] type[%s]: %w", i, cfg.Type, err)
+		}
+		processors = append(processors, processor)
+	}
+
+	return processors, nil
+}
+
+// process 依次调用处理器, 返回是否发送这条日志
+func (r *IndexRule) process(data *protocol.Data) bool {
+	for _, processor := range r.processors {
+		keep, err := processor.Process(data)
+		if err != nil {
+			k3.K3LogError("[process] index_name[%s] processor %T failed, drop the event: %s", data.IndexName, processor, err.Error())
+			return false
+		}
+		if !keep {
+			return false
+		}
+	}
+
+	return true
+}
+
+// processorFields 没有配置fields时处理_data
+func processorFields(fields []string) []string {
+	if len(fields) == 0 {
+		return []string{"_data"}
+	}
+	return fields
+}
+
+// redactProcessor 将字段中匹配正则的内容替换为replacement, 只处理字符串类型的字段
+type redactProcessor struct {
+	fields      []string
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+func newRedactProcessor(cfg config.Processor) (Processor, error) {
+	var (
+		processor = &redactProcessor{fields: processorFields(cfg.Fields), replacement: cfg.Replacement}
+		err       error
+	)
+
+	if len(cfg.Pattern) == 0 {
+		return nil, fmt.Errorf("pattern is required")
+	}
+	if processor.pattern, err = regexp.Compile(cfg.Pattern); err != nil {
+		return nil, fmt.Errorf("invalid pattern[%s]: %w", cfg.Pattern, err)
+	}
+	if len(processor.replacement) == 0 {
+		processor.replacement = DefaultRedactReplacement
+	}
+
+	return processor, nil
+}
+
+func (p *redactProcessor) Process(data *protocol.Data) (bool, error) {
+	for _, field := range p.fields {
+		if value, ok := data.Properties[field].(string); ok {
+			data.Properties[field] = p.pattern.ReplaceAllString(value, p.replacement)
+		}
+	}
+	return true, nil
+}
+
+// renameProcessor 将字段from重命名为to, from不存在时不处理, to已经存在时覆盖
+type renameProcessor struct {
+	from string
+	to   string
+}
+
+func newRenameProcessor(cfg config.Processor) (Processor, error) {
+	if len(cfg.From) == 0 || len(cfg.To) == 0 {
+		return nil, fmt.Errorf("from and to are required")
+	}
+	return &renameProcessor{from: cfg.From, to: cfg.To}, nil
+}
+
+func (p *renameProcessor) Process(data *protocol.Data) (bool, error) {
+	if value, ok := data.Properties[p.from]; ok {
+		delete(data.Properties, p.from)
+		data.Properties[p.to] = value
+	}
+	return true, nil
+}
+
+// dropFieldsProcessor 删除配置的字段
+type dropFieldsProcessor struct {
+	fields []string
+}
+
+func newDropFieldsProcessor(cfg config.Processor) (Processor, error) {
+	if len(cfg.Fields) == 0 {
+		return nil, fmt.Errorf("fields is required")
+	}
+	return &dropFieldsProcessor{fields: cfg.Fields}, nil
+}
+
+func (p *dropFieldsProcessor) Process(data *protocol.Data) (bool, error) {
+	for _, field := range p.fields {
+		delete(data.Properties, field)
+	}
+	return true, nil
+}
+
+// dropProcessor 任意一个字段匹配正则时丢弃整条日志, 只检查字符串类型的字段
+type dropProcessor struct {
+	fields  []string
+	pattern *regexp.Regexp
+}
+
+func newDropProcessor(cfg config.Processor) (Processor, error) {
+	var (
+		processor = &dropProcessor{fields: processorFields(cfg.Fields)}
+		err       error
+	)
+
+	if len(cfg.Pattern) == 0 {
+		return nil, fmt.Errorf("pattern is required")
+	}
+	if processor.pattern, err = regexp.Compile(cfg.Pattern); err != nil {
+		return nil, fmt.Errorf("invalid pattern[%s]: %w", cfg.Pattern, err)
+	}
+
+	return processor, nil
+}
+
+func (p *dropProcessor) Process(data *protocol.Data) (bool, error) {
+	for _, field := range p.fields {
+		if value, ok := data.Properties[field].(string); ok && p.pattern.MatchString(value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,110 @@
+package watch
+
+import (
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessors(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+
+	// 先脱敏再丢弃, 丢弃判断的是脱敏之后的内容
+	if err := InitIndexRules(map[string]config.Index{"index_test": {Processors: []config.Processor{
+		{Type: ProcessorRedact, Pattern: `[\w.+-]+@[\w-]+\.[\w.]+`},
+		{Type: ProcessorRedact, Pattern: `(token=)\w+`, Replacement: "${1}<redacted>"},
+		{Type: ProcessorDrop, Pattern: `healthcheck`},
+	}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, "login user=alice@example.com token=abc123", "GET /healthcheck token=xyz", "logout user=bob@example.org")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	assertLines(t, consumer, "login user=*** token=<redacted>", "logout user=***")
+
+	// 丢弃的日志offset照常前进
+	info, _ := os.Stat(path)
+	if GlobalFileStates[path].Offset != info.Size() {
+		t.Errorf("offset should advance past dropped lines, expected %d, got %d", info.Size(), GlobalFileStates[path].Offset)
+	}
+}
+
+func TestProcessorFields(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+
+	if err := InitIndexRules(map[string]config.Index{"index_test": {Format: FormatJSON, Processors: []config.Processor{
+		{Type: ProcessorRename, From: "msg", To: "message"},
+		{Type: ProcessorDropFields, Fields: []string{"password"}},
+	}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, `{"msg": "login", "password": "secret"}`)
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if len(consumer.datas) != 1 {
+		t.Fatalf("1 event expected, got %d", len(consumer.datas))
+	}
+	properties := consumer.datas[0].Properties
+	if _, ok := properties["msg"]; ok || properties["message"] != "login" {
+		t.Errorf("msg should be renamed to message, got %v", properties)
+	}
+	if _, ok := properties["password"]; ok {
+		t.Errorf("password should be dropped, got %v", properties)
+	}
+}
+
+// failProcessor 测试用的自定义处理器, 总是返回错误
+type failProcessor struct{}
+
+func (failProcessor) Process(*protocol.Data) (bool, error) {
+	return true, errors.New("always fail")
+}
+
+func TestRegisterProcessor(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+
+	RegisterProcessor("fail", func(config.Processor) (Processor, error) { return failProcessor{}, nil })
+	t.Cleanup(func() { RegisterProcessor("fail", nil) })
+
+	if err := InitIndexRules(map[string]config.Index{"index_test": {Processors: []config.Processor{{Type: "fail"}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 处理失败的日志不发送
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+	assertLines(t, consumer)
+}
+
+func TestInvalidProcessors(t *testing.T) {
+	for _, processor := range []config.Processor{
+		{Type: "unknown"},
+		{Type: ProcessorRedact},
+		{Type: ProcessorRedact, Pattern: `token=(`},
+		{Type: ProcessorRename, From: "msg"},
+		{Type: ProcessorDropFields},
+		{Type: ProcessorDrop, Pattern: `[healthcheck`},
+	} {
+		if err := InitIndexRules(map[string]config.Index{"index_test": {Processors: []config.Processor{processor}}}); err == nil {
+			t.Errorf("%+v should return error", processor)
+		}
+	}
+}
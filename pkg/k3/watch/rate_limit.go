@@ -0,0 +1,52 @@
+package watch
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var MinRateLimitRetryInterval = 10 * time.Millisecond // 超过rate_limit暂停读取后, 再次尝试读取文件的最小间隔
+
+// newRateLimiter 每秒最多读取rateLimit行的令牌桶, 桶的大小为rateLimit, 0不限制返回nil, 小于0返回错误
+func newRateLimiter(indexName string, rateLimit int) (*rate.Limiter, error) {
+	if rateLimit < 0 {
+		return nil, fmt.Errorf("[NewIndexRule] index_name[%s] rate_limit must not be negative, got %d", indexName, rateLimit)
+	}
+
+	if rateLimit == 0 {
+		return nil, nil
+	}
+
+	return rate.NewLimiter(rate.Limit(rateLimit), rateLimit), nil
+}
+
+// allowRead 是否还可以读取一行, 没有配置rate_limit时总是返回true
+func (r *IndexRule) allowRead() bool {
+	return r.rateLimiter == nil || r.rateLimiter.Allow()
+}
+
+// rateLimitRetryInterval 产生一个令牌的时间, 最小为MinRateLimitRetryInterval
+func (r *IndexRule) rateLimitRetryInterval() time.Duration {
+	interval := time.Duration(float64(time.Second) / float64(r.rateLimiter.Limit()))
+	if interval < MinRateLimitRetryInterval {
+		return MinRateLimitRetryInterval
+	}
+	return interval
+}
+
+// scheduleRateLimitRead 超过rate_limit时offset不移动, 文件没有新的写入时不会再触发读取, 需要定时再读取一次
+func scheduleRateLimitRead(fileState *FileState, interval time.Duration) {
+	GlobalFileStatesLock.Lock()
+	if fileState.rateLimitScheduled {
+		GlobalFileStatesLock.Unlock()
+		return
+	}
+	fileState.rateLimitScheduled = true
+	GlobalFileStatesLock.Unlock()
+
+	scheduleRead(fileState.IndexName, fileState.Path, interval, func() {
+		fileState.rateLimitScheduled = false
+	})
+}
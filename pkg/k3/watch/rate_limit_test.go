@@ -0,0 +1,64 @@
+package watch
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	var (
+		consumer  = initTestWatch(t)
+		path      = filepath.Join(t.TempDir(), "app.log")
+		rateLimit = 100
+		total     = 250
+		lines     []string
+	)
+
+	if err := InitIndexRules(map[string]config.Index{"index_test": {RateLimit: rateLimit}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < total; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	appendLines(t, path, lines...)
+
+	start := time.Now()
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+
+	// 任意时刻发送的数量不超过突发的rate_limit加上经过的时间产生的令牌
+	for len(consumer.lines()) < total {
+		delivered, elapsed := len(consumer.lines()), time.Since(start)
+		if limit := rateLimit + int(elapsed.Seconds()*float64(rateLimit)) + 1; delivered > limit {
+			t.Fatalf("%d events delivered after %s, rate_limit %d allows at most %d", delivered, elapsed, rateLimit, limit)
+		}
+		if elapsed > 5*time.Second {
+			t.Fatalf("rate limited lines should be read later, got %d of %d", delivered, total)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// 限速时不丢弃, 按顺序读完所有的行
+	if elapsed := time.Since(start); elapsed < time.Duration(total-rateLimit)*time.Second/time.Duration(rateLimit)-100*time.Millisecond {
+		t.Errorf("%d lines should take about %s with rate_limit %d, took %s", total, time.Duration(total-rateLimit)*time.Second/time.Duration(rateLimit), rateLimit, elapsed)
+	}
+	assertLines(t, consumer, lines...)
+
+	info, _ := os.Stat(path)
+	GlobalFileStatesLock.Lock()
+	defer GlobalFileStatesLock.Unlock()
+	if GlobalFileStates[path].Offset != info.Size() {
+		t.Errorf("offset should reach the end of file, expected %d, got %d", info.Size(), GlobalFileStates[path].Offset)
+	}
+}
+
+func TestInvalidRateLimit(t *testing.T) {
+	if err := InitIndexRules(map[string]config.Index{"index_test": {RateLimit: -1}}); err == nil {
+		t.Errorf("negative rate_limit should return error")
+	}
+}
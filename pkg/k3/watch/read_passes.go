@@ -0,0 +1,66 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var DefaultMaxReadPasses = 10 // 一个读取任务中同一个文件最多连续读取的次数, 每次最多读取max_read_count行
+
+// maxReadPasses 一个读取任务中同一个文件最多连续读取的次数, 0或者负数使用默认值
+func maxReadPasses() int {
+	if passes := config.GlobalConfig.Watch.MaxReadPasses; passes > 0 {
+		return passes
+	}
+	return DefaultMaxReadPasses
+}
+
+// hasUnreadData 本次读取移动了offset并且还没有读到文件末尾, 可以继续读取
+// offset没有移动(如没有换行符的最后一行、还没有结束的多行日志、超过rate_limit)时不继续读取, 等待之后的写入或者定时读取
+// gzip和whole_file模式每次都读取整个文件, 不需要继续读取
+func hasUnreadData(fd *os.File, fileState *FileState, before int64) bool {
+	if isGzipFile(fileState.Path) || getIndexRule(fileState.IndexName).WholeFile {
+		return false
+	}
+
+	GlobalFileStatesLock.Lock()
+	offset := fileState.Offset
+	GlobalFileStatesLock.Unlock()
+
+	if offset == before {
+		return false
+	}
+
+	info, err := fd.Stat()
+	if err != nil {
+		return false
+	}
+
+	return offset < info.Size()
+}
+
+// yieldRead 连续读取max_read_passes次之后文件还有数据, 让出读取协程, 重新提交读取任务排在已经提交的任务之后, 其他文件也可以读取
+// 在新的协程中提交, 避免pool模型的队列已满时worker阻塞在提交上; 提交之前计入processingWg, 等待processingWg时包含重新提交的读取
+func yieldRead(indexName, path string) {
+	var (
+		ctx       = WatcherContext
+		wg        = processingWg
+		scheduler = GlobalScheduler
+		event     = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		scheduler.Submit(func() {
+			processing(indexName, event)
+		})
+	}()
+}
@@ -0,0 +1,87 @@
+package watch
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var readTimers *readTimerSet // 等待中的定时读取, InitVars时重新创建
+
+// readTimer 一个等待中的定时读取, 取消时需要清除fileState的标记
+type readTimer struct {
+	lock  *sync.Mutex
+	fired func()
+}
+
+// readTimerSet 定时重新读取文件的定时器(rate_limit、backpressure、多行日志超时、没有换行符的行超时、打开失败重试), 退出时取消
+type readTimerSet struct {
+	lock    sync.Mutex
+	timers  map[*time.Timer]readTimer
+	stopped bool
+}
+
+func newReadTimerSet() *readTimerSet {
+	return &readTimerSet{timers: make(map[*time.Timer]readTimer)}
+}
+
+// stop 取消所有等待中的定时读取, 之后不再设置新的定时读取; 已经触发的继续执行, 提交给已经关闭的调度器时丢弃
+func (r *readTimerSet) stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.stopped = true
+	for timer, pending := range r.timers {
+		if timer.Stop() {
+			pending.lock.Lock()
+			pending.fired()
+			pending.lock.Unlock()
+		}
+		delete(r.timers, timer)
+	}
+}
+
+// scheduleRead interval之后重新提交path的读取任务, 文件没有新的写入时不会再触发读取, 需要定时再读取一次
+// 设置时获取调度器、context和GlobalFileStatesLock, 触发时不受InitVars重新初始化的影响
+// 等待中的定时读取不计入processingWg(暂停读取期间会一直重新设置), DrainProcessing时取消; fired在触发或者取消时持有GlobalFileStatesLock调用, 用于清除fileState中已经设置的标记
+func scheduleRead(indexName, path string, interval time.Duration, fired func()) {
+	var (
+		ctx       = WatcherContext
+		lock      = GlobalFileStatesLock
+		scheduler = GlobalScheduler
+		timers    = readTimers
+		event     = fsnotify.Event{Name: path, Op: fsnotify.Write}
+		timer     *time.Timer
+	)
+
+	timers.lock.Lock()
+	defer timers.lock.Unlock()
+
+	if timers.stopped {
+		lock.Lock()
+		fired()
+		lock.Unlock()
+		return
+	}
+
+	timer = time.AfterFunc(interval, func() {
+		// 定时器已经触发, 不再需要取消; timer在持有锁时赋值, 也需要持有锁读取
+		timers.lock.Lock()
+		delete(timers.timers, timer)
+		timers.lock.Unlock()
+
+		lock.Lock()
+		fired()
+		lock.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		scheduler.Submit(func() {
+			processing(indexName, event)
+		})
+	})
+	timers.timers[timer] = readTimer{lock: lock, fired: fired}
+}
@@ -0,0 +1,48 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDrainProcessingStopsReadTimers(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+
+	appendLines(t, path, "line 1")
+	createFile("index_test", path)
+
+	GlobalFileStatesLock.Lock()
+	fileState := GlobalFileStates[path]
+	GlobalFileStatesLock.Unlock()
+
+	// 等待中的定时读取在退出时取消, 之后不会再读取
+	scheduleRateLimitRead(fileState, 50*time.Millisecond)
+	if err := DrainProcessing(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	GlobalFileStatesLock.Lock()
+	scheduled := fileState.rateLimitScheduled
+	GlobalFileStatesLock.Unlock()
+	if scheduled {
+		t.Error("cancelled read timer should clear rate_limit flag")
+	}
+
+	// 退出之后不再设置新的定时读取
+	scheduleBackpressureRead(fileState)
+	time.Sleep(DefaultBackpressureRetryInterval + 50*time.Millisecond)
+	if lines := consumer.lines(); len(lines) != 0 {
+		t.Errorf("no read should happen after drain, got %v", lines)
+	}
+
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+	if lines := consumer.lines(); len(lines) != 0 {
+		t.Errorf("scheduler should not accept reads after drain, got %v", lines)
+	}
+}
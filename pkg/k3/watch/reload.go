@@ -0,0 +1,420 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"io/fs"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	DefaultReloadDelay = 100 * time.Millisecond // 配置文件变化后等待的时间, 编辑器保存时会产生多个事件, 合并为一次加载
+)
+
+var (
+	watchDirectoryLock = &sync.RWMutex{}
+	watchDirectory     map[string][]string // 当前监听的目录, map[indexName][]dir, 包含所有子目录
+
+	indexWatchersLock = &sync.Mutex{}
+	indexWatchers     = make(map[string]*indexWatcher) // 每个index_name的监听协程
+
+	watcherStartedLock = &sync.Mutex{}
+	watcherStarted     context.Context // 已经调用过InitWatcher的WatcherContext, 再次调用时只对比目录的变化
+
+	reloadLock     = &sync.Mutex{}
+	reloadCallback func(cfg *config.Config, err error) // 每次热加载之后调用, err不为nil表示加载失败
+)
+
+// indexWatcher 单个index_name的监听协程, 热加载时用于增删目录和停止协程
+type indexWatcher struct {
+	watcher *fsnotify.Watcher
+	cancel  func()
+}
+
+// ExpandWatchDirectory 递归获取read_path中每个目录的所有子目录并去重, 由于watch碰到子目录是不会主动监控的, 所以需要子目录递归添加
+func ExpandWatchDirectory(readPath map[string][]string) map[string][]string {
+	var (
+		directory = make(map[string][]string)
+	)
+
+	for indexName, dirs := range readPath {
+		for _, dir := range dirs {
+			dir = resolveReadPath(dir)
+			if paths, err := expandWatchPath(dir); err != nil {
+				k3.K3LogError("[ExpandWatchDirectory] fetch directory path error: %s", err)
+				// 还没有创建的目录保留, 加入监听失败后定时重试
+				if errors.Is(err, fs.ErrNotExist) {
+					directory[indexName] = append(directory[indexName], dir)
+				}
+				continue
+			} else {
+				directory[indexName] = append(directory[indexName], paths...)
+			}
+		}
+	}
+
+	// 清理可能重复的目录
+	for indexName, dirs := range directory {
+		directory[indexName] = k3.RemoveDuplicateElement(dirs)
+	}
+
+	return directory
+}
+
+// SetReloadCallback 设置热加载之后的回调
+func SetReloadCallback(callback func(cfg *config.Config, err error)) {
+	reloadLock.Lock()
+	reloadCallback = callback
+	reloadLock.Unlock()
+}
+
+func setWatchDirectory(directory map[string][]string) {
+	var current = make(map[string][]string, len(directory))
+
+	for indexName, dirs := range directory {
+		current[indexName] = append([]string(nil), dirs...)
+	}
+
+	watchDirectoryLock.Lock()
+	watchDirectory = current
+	watchDirectoryLock.Unlock()
+}
+
+// getWatchDirectory 获取当前监听的目录
+func getWatchDirectory() map[string][]string {
+	watchDirectoryLock.RLock()
+	defer watchDirectoryLock.RUnlock()
+
+	return watchDirectory
+}
+
+func registerIndexWatcher(indexName string, entry *indexWatcher) {
+	indexWatchersLock.Lock()
+	indexWatchers[indexName] = entry
+	indexWatchersLock.Unlock()
+}
+
+// unregisterIndexWatcher 协程退出时删除记录, 同名index_name已经重新创建了协程时不删除
+func unregisterIndexWatcher(indexName string, watcher *fsnotify.Watcher) {
+	indexWatchersLock.Lock()
+	if entry, exists := indexWatchers[indexName]; exists && entry.watcher == watcher {
+		delete(indexWatchers, indexName)
+	}
+	indexWatchersLock.Unlock()
+}
+
+func getIndexWatcher(indexName string) (*indexWatcher, bool) {
+	indexWatchersLock.Lock()
+	defer indexWatchersLock.Unlock()
+
+	entry, exists := indexWatchers[indexName]
+	return entry, exists
+}
+
+// Reload 使用新的配置热加载, 对比新的read_path与当前监听的目录, 见applyWatchDirectory
+// 修改不能热加载的配置(如state_file_path)时拒绝加载, 返回错误
+func Reload(cfg *config.Config) error {
+	var err error
+
+	if err = config.CheckImmutable(config.GlobalConfig, cfg); err != nil {
+		k3.K3LogError("[Reload] reject reload config: %s", err.Error())
+		return err
+	}
+
+	config.GlobalConfig.Watch.ObsoleteOnReload = cfg.Watch.ObsoleteOnReload
+
+	if err = applyWatchDirectory(ExpandWatchDirectory(cfg.Watch.ReadPath)); err != nil {
+		return err
+	}
+	config.GlobalConfig.Watch.ReadPath = cfg.Watch.ReadPath
+
+	k3.K3LogInfo("[Reload] reload config success, watch directory: %v", getWatchDirectory())
+
+	return SaveGlobalFileStatesToDiskFile(FileStateFilePath)
+}
+
+// applyWatchDirectory 对比新的目录与当前监听的目录, 只处理有变化的部分, 相同的目录重复调用不会产生任何变化
+// 1. 新增的index_name创建监听协程, 删除的index_name停止监听协程, 其他index_name的协程不受影响
+// 2. 新增的目录加入监听, 目录中已经存在的文件从头开始读取, 加入监听失败的目录之后定时重试
+// 3. 删除的目录取消监听并关闭文件句柄, 文件状态删除, 开启obsolete_on_reload时标记为obsolete并保留offset
+// 4. 没有变化的目录中的文件状态和offset保持不变
+func applyWatchDirectory(next map[string][]string) error {
+	var (
+		current map[string][]string
+		err     error
+	)
+
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	// 先记录新的目录, 加入监听后立即收到的事件不会被shouldHandleEvent忽略
+	current = getWatchDirectory()
+	setWatchDirectory(next)
+
+	for indexName, dirs := range next {
+		entry, exists := getIndexWatcher(indexName)
+		if !exists {
+			if err = startIndexWatcher(indexName, dirs); err != nil {
+				k3.K3LogError("[applyWatchDirectory] index_name[%s] start watcher failed: %s", indexName, err.Error())
+				return fmt.Errorf("[applyWatchDirectory] %w: %w", ErrWatcherInit, err)
+			}
+			continue
+		}
+
+		for _, dir := range diffDirectory(current[indexName], dirs) {
+			removeWatchDirectory(indexName, entry.watcher, dir)
+		}
+
+		for _, dir := range diffDirectory(dirs, current[indexName]) {
+			// 加入监听失败(如目录还没有创建)时记录下来, 由ClockRetryFailedDirectories定时重试
+			if err = addWatchDirectory(indexName, entry.watcher, dir); err != nil {
+				k3.K3LogError("[applyWatchDirectory] index_name[%s] add dir[%s] to watcher failed, retry later: %s", indexName, dir, err.Error())
+				recordFailedDirectory(indexName, dir, err)
+				err = nil
+			}
+		}
+	}
+
+	for indexName, dirs := range current {
+		if _, exists := next[indexName]; exists {
+			continue
+		}
+
+		if entry, exists := getIndexWatcher(indexName); exists {
+			for _, dir := range dirs {
+				removeWatchDirectory(indexName, entry.watcher, dir)
+			}
+			unregisterIndexWatcher(indexName, entry.watcher)
+			entry.cancel()
+		}
+	}
+
+	return nil
+}
+
+// startIndexWatcher 热加载时为新增的index_name创建监听协程, 目录中已经存在的文件从头开始读取
+func startIndexWatcher(indexName string, dirs []string) error {
+	var (
+		isSuccess = make(chan error, 1)
+		err       error
+	)
+
+	WatcherWG.Add(1)
+	go forkWatcher(indexName, dirs, FileStateFilePath, isSuccess)
+
+	if err = <-isSuccess; err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		readDirectoryFiles(indexName, dir)
+	}
+
+	return nil
+}
+
+// addWatchDirectory 目录加入监听, 先添加监听再读取文件, 避免之后新建的文件没有被监听到
+func addWatchDirectory(indexName string, watcher *fsnotify.Watcher, dir string) error {
+	if err := watcher.Add(watchTarget(dir)); err != nil {
+		return err
+	}
+
+	readDirectoryFiles(indexName, dir)
+	return nil
+}
+
+// readDirectoryFiles 将目录中(不含子目录)的文件加入到GlobalFileStates并读取, dir是文件时只读取该文件
+func readDirectoryFiles(indexName string, dir string) {
+	var (
+		entries []os.DirEntry
+		err     error
+	)
+
+	if isWatchFile(dir) {
+		if createFile(indexName, dir) || isObsoleteFile(dir) {
+			writeEvent(indexName, fsnotify.Event{Name: dir, Op: fsnotify.Write})
+		}
+		return
+	}
+
+	if entries, err = os.ReadDir(dir); err != nil {
+		k3.K3LogError("[readDirectoryFiles] index_name[%s] read dir[%s] failed: %s", indexName, dir, err.Error())
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		// 之前删除目录时标记为obsolete的文件, 从保留的offset继续读取
+		path := filepath.Join(dir, entry.Name())
+		if createFile(indexName, path) || isObsoleteFile(path) {
+			writeEvent(indexName, fsnotify.Event{Name: path, Op: fsnotify.Write})
+		}
+	}
+}
+
+// removeWatchDirectory 目录取消监听, 删除目录中(不含子目录)文件的状态并关闭句柄, dir是文件时只删除该文件
+// 开启obsolete_on_reload时文件状态标记为obsolete, offset保留, 目录重新加入时继续读取
+func removeWatchDirectory(indexName string, watcher *fsnotify.Watcher, dir string) {
+	var (
+		fileStates []*FileState
+		target     = watchTarget(dir)
+	)
+
+	clearFailedDirectory(indexName, dir)
+
+	GlobalFileStatesLock.Lock()
+	for path, fileState := range GlobalFileStates {
+		if filepath.Dir(path) == dir || path == dir {
+			fileStates = append(fileStates, fileState)
+		}
+	}
+	GlobalFileStatesLock.Unlock()
+
+	for _, fileState := range fileStates {
+		if config.GlobalConfig.Watch.ObsoleteOnReload {
+			markObsoleteFile(fileState)
+		} else {
+			removeEvent(fsnotify.Event{Name: fileState.Path, Op: fsnotify.Remove}, watcher)
+		}
+	}
+
+	// 文件所在的目录还有其他文件或者本身在read_path中时, 继续监听
+	for _, entry := range getWatchDirectory()[indexName] {
+		if entry != dir && watchTarget(entry) == target {
+			return
+		}
+	}
+	_ = watcher.Remove(target)
+}
+
+// isObsoleteFile path的文件状态存在且已经标记为obsolete
+func isObsoleteFile(path string) bool {
+	GlobalFileStatesLock.Lock()
+	defer GlobalFileStatesLock.Unlock()
+
+	fileState, exists := GlobalFileStates[path]
+	return exists && fileState.Obsolete
+}
+
+// diffDirectory 返回在dirs中, 但不在exclude中的目录
+func diffDirectory(dirs []string, exclude []string) []string {
+	var (
+		excludeSet = make(map[string]bool, len(exclude))
+		diff       []string
+	)
+
+	for _, dir := range exclude {
+		excludeSet[dir] = true
+	}
+
+	for _, dir := range dirs {
+		if !excludeSet[dir] {
+			diff = append(diff, dir)
+		}
+	}
+
+	return diff
+}
+
+// WatchConfigFiles 监听配置文件, 文件变化时重新加载配置并热加载, 加载失败时只记录日志, 继续使用当前的配置
+// 编辑器保存文件时可能是先删除再创建, 所以监听配置文件所在的目录
+func WatchConfigFiles(fpaths []string) error {
+	var (
+		watcher *fsnotify.Watcher
+		files   = make(map[string]bool)
+		dirs    = make(map[string]bool)
+		err     error
+	)
+
+	if watcher, err = fsnotify.NewWatcher(); err != nil {
+		return fmt.Errorf("[WatchConfigFiles] new watcher failed: %w", err)
+	}
+
+	for _, fpath := range fpaths {
+		files[filepath.Clean(fpath)] = true
+		dirs[filepath.Dir(fpath)] = true
+	}
+
+	for dir := range dirs {
+		if err = watcher.Add(dir); err != nil {
+			_ = watcher.Close()
+			return fmt.Errorf("[WatchConfigFiles] add config dir to watcher failed: %w", err)
+		}
+	}
+
+	go func(ctx context.Context) {
+		var timer *time.Timer
+
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+			_ = watcher.Close()
+		}()
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if !files[filepath.Clean(event.Name)] || !event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					continue
+				}
+
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(DefaultReloadDelay, func() {
+					ReloadConfigFiles(fpaths)
+				})
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				k3.K3LogError("[WatchConfigFiles] config watcher error: %s", err)
+
+			case <-ctx.Done():
+				k3.K3LogInfo("[WatchConfigFiles] Accept config watcher exit signal.")
+				return
+			}
+		}
+	}(WatcherContext)
+
+	return nil
+}
+
+// ReloadConfigFiles 重新加载配置文件并热加载, 之后调用回调, 配置文件变化或者收到SIGHUP时调用
+func ReloadConfigFiles(fpaths []string) {
+	var (
+		cfg      *config.Config
+		err      error
+		callback func(cfg *config.Config, err error)
+	)
+
+	if cfg, err = config.Load(fpaths...); err != nil {
+		k3.K3LogError("[ReloadConfigFiles] load config failed: %s", err.Error())
+	} else {
+		err = Reload(cfg)
+	}
+
+	reloadLock.Lock()
+	callback = reloadCallback
+	reloadLock.Unlock()
+
+	if callback != nil {
+		callback(cfg, err)
+	}
+}
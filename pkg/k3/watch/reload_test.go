@@ -0,0 +1,218 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestConfig 写入只包含watch和sender配置的yaml文件
+func writeTestConfig(t *testing.T, path, stateFilePath string, readPath map[string][]string) {
+	var content = "sender :\n  type : \"stdout\"\nwatch :\n  state_file_path : \"" + stateFilePath + "\"\n  read_path :\n"
+
+	for indexName, dirs := range readPath {
+		content += "    " + indexName + " : [\"" + strings.Join(dirs, "\", \"") + "\"]\n"
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitReload 等待一次热加载完成
+func waitReload(t *testing.T, results chan error) error {
+	select {
+	case err := <-results:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload timeout")
+	}
+	return nil
+}
+
+func TestReloadConfigAddDirectory(t *testing.T) {
+	var (
+		consumer   = initTestWatch(t)
+		root       = t.TempDir()
+		dirA       = filepath.Join(root, "a")
+		dirB       = filepath.Join(root, "b")
+		dirC       = filepath.Join(root, "c")
+		configPath = filepath.Join(t.TempDir(), "watch.yaml")
+		results    = make(chan error, 10)
+	)
+
+	for _, dir := range []string{dirA, dirB, dirC} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendLines(t, filepath.Join(dirB, "b.log"), "b 1")
+	appendLines(t, filepath.Join(dirC, "c.log"), "c 1")
+
+	config.GlobalConfig.Watch.ReadPath = map[string][]string{"index_a": {dirA}}
+	writeTestConfig(t, configPath, "core.json", config.GlobalConfig.Watch.ReadPath)
+
+	SetReloadCallback(func(cfg *config.Config, err error) { results <- err })
+	defer SetReloadCallback(nil)
+
+	if err := InitWatcher(ExpandWatchDirectory(config.GlobalConfig.Watch.ReadPath), FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := WatchConfigFiles([]string{configPath}); err != nil {
+		t.Fatal(err)
+	}
+
+	// index_a新增目录b, 新增index_c
+	writeTestConfig(t, configPath, "core.json", map[string][]string{"index_a": {dirA, dirB}, "index_c": {dirC}})
+	if err := waitReload(t, results); err != nil {
+		t.Fatalf("reload should succeed: %s", err)
+	}
+
+	if dirs := getWatchDirectory()["index_a"]; strings.Join(dirs, ",") != dirA+","+dirB {
+		t.Errorf("index_a should watch a and b, got %v", dirs)
+	}
+	if _, exists := getIndexWatcher("index_c"); !exists {
+		t.Errorf("index_c watcher should be started")
+	}
+
+	// 新目录中已经存在的文件从头读取, 之后的写入通过监听读取
+	processingWg.Wait()
+	appendLines(t, filepath.Join(dirB, "b.log"), "b 2")
+
+	var lines []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		processingWg.Wait()
+		if lines = consumer.lines(); len(lines) == 3 {
+			break
+		}
+	}
+	sort.Strings(lines)
+	if strings.Join(lines, ",") != "b 1,b 2,c 1" {
+		t.Errorf("lines of new directories expected, got %v", lines)
+	}
+
+	// 修改state_file_path时拒绝加载, 监听的目录保持不变
+	writeTestConfig(t, configPath, "other.json", map[string][]string{"index_a": {dirA}})
+	if err := waitReload(t, results); err == nil {
+		t.Errorf("reload changing state_file_path should be rejected")
+	}
+	if dirs := getWatchDirectory()["index_a"]; len(dirs) != 2 {
+		t.Errorf("rejected reload should keep watch directory, got %v", dirs)
+	}
+
+	// 删除目录b和index_c, 对应的文件状态也删除
+	writeTestConfig(t, configPath, "core.json", map[string][]string{"index_a": {dirA}})
+	if err := waitReload(t, results); err != nil {
+		t.Fatalf("reload should succeed: %s", err)
+	}
+	if _, exists := getIndexWatcher("index_c"); exists {
+		t.Errorf("index_c watcher should be stopped")
+	}
+
+	GlobalFileStatesLock.Lock()
+	_, existsB := GlobalFileStates[filepath.Join(dirB, "b.log")]
+	_, existsC := GlobalFileStates[filepath.Join(dirC, "c.log")]
+	GlobalFileStatesLock.Unlock()
+	if existsB || existsC {
+		t.Errorf("file states of removed directories should be deleted")
+	}
+
+	// 热加载停止的协程不影响其他协程
+	if WatcherContext.Err() != nil {
+		t.Errorf("stopping a watcher by reload should not cancel other watchers")
+	}
+}
+
+// countIndexWatchers 正在运行的index_name监听协程数量
+func countIndexWatchers() int {
+	indexWatchersLock.Lock()
+	defer indexWatchersLock.Unlock()
+	return len(indexWatchers)
+}
+
+func TestInitWatcherDiff(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		root     = t.TempDir()
+		dirA     = filepath.Join(root, "a")
+		dirB     = filepath.Join(root, "b")
+		pathA    = filepath.Join(dirA, "a.log")
+		pathB    = filepath.Join(dirB, "b.log")
+	)
+
+	for _, dir := range []string{dirA, dirB} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendLines(t, pathA, "a 1")
+	appendLines(t, pathB, "b 1")
+
+	if err := InitWatcher(map[string][]string{"index_a": {dirA}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	readDirectoryFiles("index_a", dirA)
+	processingWg.Wait()
+
+	GlobalFileStatesLock.Lock()
+	stateA := GlobalFileStates[pathA]
+	GlobalFileStatesLock.Unlock()
+	baseline := runtime.NumGoroutine()
+
+	// 再次调用时新增index_b, index_a的协程和offset不变
+	for i := 0; i < 2; i++ {
+		if err := InitWatcher(map[string][]string{"index_a": {dirA}, "index_b": {dirB}}, FileStateFilePath); err != nil {
+			t.Fatal(err)
+		}
+		processingWg.Wait()
+		if count := countIndexWatchers(); count != 2 {
+			t.Fatalf("calling InitWatcher again should only add index_b, got %d watchers", count)
+		}
+	}
+	if runtime.NumGoroutine() <= baseline {
+		t.Errorf("index_b watcher goroutine should be running")
+	}
+
+	GlobalFileStatesLock.Lock()
+	if GlobalFileStates[pathA] != stateA || stateA.Offset != int64(len("a 1\n")) {
+		t.Errorf("offset of remaining directory should be preserved, got %v", GlobalFileStates[pathA])
+	}
+	GlobalFileStatesLock.Unlock()
+	assertLines(t, consumer, "a 1", "b 1")
+
+	// 删除index_b, 协程退出, 文件标记为obsolete并保留offset, 句柄关闭
+	config.GlobalConfig.Watch.ObsoleteOnReload = true
+	if err := InitWatcher(map[string][]string{"index_a": {dirA}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := getIndexWatcher("index_b"); exists {
+		t.Errorf("index_b watcher should be stopped")
+	}
+	waitFor(t, func() bool { return runtime.NumGoroutine() <= baseline })
+
+	GlobalFileStatesLock.Lock()
+	stateB := GlobalFileStates[pathB]
+	GlobalFileStatesLock.Unlock()
+	if stateB == nil || !stateB.Obsolete || stateB.Offset != int64(len("b 1\n")) {
+		t.Fatalf("file of removed directory should be obsolete with its offset, got %v", stateB)
+	}
+	if _, cached := GlobalFdCache.Take(pathB); cached {
+		t.Errorf("fd of removed directory should be closed")
+	}
+	if WatcherContext.Err() != nil {
+		t.Errorf("removing an index should not cancel other watchers")
+	}
+
+	// 重新加入时从保留的offset继续读取
+	appendLines(t, pathB, "b 2")
+	if err := InitWatcher(map[string][]string{"index_a": {dirA}, "index_b": {dirB}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	processingWg.Wait()
+	assertLines(t, consumer, "a 1", "b 1", "b 2")
+}
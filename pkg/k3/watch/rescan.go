@@ -0,0 +1,81 @@
+package watch
+
+import (
+	"context"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ClockRescanWatchDirectory 定时重新遍历监听的目录, 读取GlobalFileStates中没有记录的文件
+// 目录中短时间创建大量文件时fsnotify的缓冲区会溢出, 丢失的创建事件要等到下次重启扫描才能发现, 定时扫描用于弥补
+// 与obsolete的定时清理相互独立, watch.rescan_interval为0时不开启
+func ClockRescanWatchDirectory() {
+	var (
+		rescanInterval = config.GlobalConfig.Watch.RescanInterval
+		ctx            = WatcherContext
+		clockWG        = ClockWG
+		t              *time.Ticker
+	)
+
+	if rescanInterval <= 0 {
+		return
+	}
+	t = time.NewTicker(time.Duration(rescanInterval) * time.Second)
+
+	clockWG.Add(1)
+	go func(ctx context.Context) {
+		defer clockWG.Done()
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				rescanWatchDirectory()
+			case <-ctx.Done():
+				k3.K3LogInfo("[ClockRescanWatchDirectory] Accept clock goroutine exit singal.")
+				return
+			}
+		}
+	}(ctx)
+}
+
+// rescanWatchDirectory 遍历每个index_name监听的目录, 将没有记录的文件加入GlobalFileStates并读取, 返回新发现的文件数量
+func rescanWatchDirectory() int {
+	var discovered int
+
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	directory := getWatchDirectory()
+	for _, indexName := range fetchIndexNames(directory) {
+		// 监听的目录包含了所有的子目录, 同一个文件只处理一次
+		var seen = make(map[string]struct{})
+
+		for _, dir := range directory[indexName] {
+			files, err := k3.FetchDirectoryWithConfig(resolveReadPath(dir), fetchDirectoryConfig())
+			if err != nil {
+				k3.K3LogDebug("[rescanWatchDirectory] index_name[%s] fetch dir[%s] failed: %s", indexName, dir, err.Error())
+				continue
+			}
+			sortScanFiles(files)
+
+			for _, path := range files {
+				if _, ok := seen[path]; ok {
+					continue
+				}
+				seen[path] = struct{}{}
+
+				if createFile(indexName, path) {
+					k3.K3LogWarn("[rescanWatchDirectory] index_name[%s] file[%s] missed by watcher, discovered by rescan.", indexName, path)
+					writeEvent(indexName, fsnotify.Event{Name: path, Op: fsnotify.Write})
+					discovered++
+				}
+			}
+		}
+	}
+
+	return discovered
+}
@@ -0,0 +1,55 @@
+package watch
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRescanWatchDirectory(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+	)
+
+	if err := InitWatcher(map[string][]string{"index_test": {dir}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// 暂停监听目录, 模拟fsnotify缓冲区溢出丢失了创建事件
+	entry, ok := getIndexWatcher("index_test")
+	if !ok {
+		t.Fatal("watcher of index_test should be registered")
+	}
+	if err := entry.watcher.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, filepath.Join(dir, "a.log"), "line a")
+	appendLines(t, filepath.Join(dir, "b.log"), "line b")
+	time.Sleep(50 * time.Millisecond)
+
+	GlobalFileStatesLock.Lock()
+	tracked := len(GlobalFileStates)
+	GlobalFileStatesLock.Unlock()
+	if tracked != 0 {
+		t.Fatalf("files created while the watcher is paused should not be tracked, got %d", tracked)
+	}
+
+	if discovered := rescanWatchDirectory(); discovered != 2 {
+		t.Errorf("rescan should discover 2 files, got %d", discovered)
+	}
+	processingWg.Wait()
+
+	lines := consumer.lines()
+	sort.Strings(lines)
+	if !equalLines(lines, []string{"line a", "line b"}) {
+		t.Errorf("files discovered by rescan should be read, got %v", lines)
+	}
+
+	// 已经记录的文件再次扫描时不会重复读取
+	if discovered := rescanWatchDirectory(); discovered != 0 {
+		t.Errorf("tracked files should not be discovered again, got %d", discovered)
+	}
+}
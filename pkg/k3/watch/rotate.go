@@ -0,0 +1,122 @@
+package watch
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"time"
+)
+
+// renameEvent 文件被改名(如logrotate将app.log改名为app.log.1), 不删除文件状态, 保留缓存的旧句柄
+// 旧句柄还在缓存中时立即读取一次, 读完改名前写入的数据; 同名的新文件创建后由checkRotatedFile根据inode判断轮转, 从新文件的开头读取
+// 没有重新创建的文件, 由定时扫描在state_retention之后删除文件状态; 目录和没有记录的文件与删除相同
+func renameEvent(indexName string, event fsnotify.Event, watcher *fsnotify.Watcher) {
+	if !trackedFile(event.Name) {
+		removeEvent(event, watcher)
+		return
+	}
+
+	k3.K3LogInfo("[renameEvent] path[%s] renamed, keep file state until the rotated file is drained.", event.Name)
+	if GlobalFdCache.Cached(event.Name) {
+		writeEvent(indexName, fsnotify.Event{Name: event.Name, Op: fsnotify.Write})
+	}
+}
+
+// trackedFile path是否在GlobalFileStates中
+func trackedFile(path string) bool {
+	GlobalFileStatesLock.Lock()
+	defer GlobalFileStatesLock.Unlock()
+
+	_, exists := GlobalFileStates[path]
+	return exists
+}
+
+// checkRotatedFile 检查fileState.Path的inode是否发生变化(logrotate将文件改名后创建了同名的新文件)
+// 文件被轮转时, 通过缓存的旧句柄读完旧文件剩余的数据, 再将offset重置为0, 从新文件的开头读取
+// 调用方需要已经占用processingMap, 保证同一时间只有一个协程读取该文件
+func checkRotatedFile(fileState *FileState) {
+	var (
+		dev, inode       uint64
+		oldDev, oldInode uint64
+		drainTimeout     = config.GlobalConfig.Watch.DrainTimeout
+		err              error
+	)
+
+	if dev, inode, err = k3.FileIdentity(fileState.Path); err != nil {
+		return
+	}
+
+	GlobalFileStatesLock.Lock()
+	oldDev, oldInode = fileState.Dev, fileState.Inode
+	// 旧的状态文件中没有记录inode, 以当前文件为准
+	if oldInode == 0 {
+		fileState.Dev, fileState.Inode = dev, inode
+	}
+	GlobalFileStatesLock.Unlock()
+
+	if oldInode == 0 || (oldDev == dev && oldInode == inode) {
+		return
+	}
+
+	k3.K3LogWarn("[checkRotatedFile] path[%s] inode changed from %d to %d, file rotated.", fileState.Path, oldInode, inode)
+
+	if drainTimeout <= 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+
+	// 缓存的句柄还指向旧文件, 读完旧文件剩余的数据
+	if fd, cached := GlobalFdCache.Take(fileState.Path); cached {
+		if fdDev, fdInode, err := k3.FdIdentity(fd); err == nil && fdDev == oldDev && fdInode == oldInode {
+			if err = drainFd(fd, fileState, time.Now().Add(time.Duration(drainTimeout)*time.Second)); err != nil {
+				k3.K3LogError("[checkRotatedFile] path[%s] drain rotated file failed: %s", fileState.Path, err.Error())
+			}
+		}
+		GlobalFdCache.Release(fileState.Path, fd)
+	} else {
+		k3.K3LogWarn("[checkRotatedFile] path[%s] rotated file is not opened, unread data of the rotated file may be lost.", fileState.Path)
+	}
+
+	// 从新文件的开头读取, 新文件需要重新检查skip_signature和whole_file的内容
+	GlobalFileStatesLock.Lock()
+	fileState.Offset = 0
+	fileState.SkipLine = false
+	fileState.Dev, fileState.Inode = dev, inode
+	fileState.ContentHash = ""
+	fileState.SignatureChecked = false
+	fileState.Skipped = false
+	fileState.Completed = false
+	fileState.CompressedOffset = 0
+	GlobalFileStatesLock.Unlock()
+
+	emitLifecycleEvent(LifecycleOffsetReset, fileState)
+}
+
+// checkTruncatedFile 文件大小小于已经读取的offset时, 文件已经被清空(如logrotate的copytruncate), 将offset重置为0从头读取
+// 清空后写入的数据超过了原来的offset时无法通过文件大小发现
+func checkTruncatedFile(fd *os.File, fileState *FileState) error {
+	var (
+		fileInfo os.FileInfo
+		offset   int64
+		err      error
+	)
+
+	if fileInfo, err = fd.Stat(); err != nil {
+		return fmt.Errorf("stat file failed: %w", err)
+	}
+
+	GlobalFileStatesLock.Lock()
+	if offset = fileState.Offset; fileInfo.Size() < offset {
+		fileState.Offset = 0
+		fileState.SkipLine = false
+	}
+	GlobalFileStatesLock.Unlock()
+
+	if fileInfo.Size() < offset {
+		k3.K3LogWarn("[checkTruncatedFile] path[%s] size %d is less than offset %d, file truncated, read from start.", fileState.Path, fileInfo.Size(), offset)
+		emitLifecycleEvent(LifecycleOffsetReset, fileState)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatedFile(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		path     = filepath.Join(dir, "app.log")
+	)
+	setWatchDirectory(map[string][]string{"index_test": {dir}})
+
+	appendLines(t, path, "line 1")
+	handlerEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write}, FileStateFilePath, nil)
+	processingWg.Wait()
+
+	oldInode := GlobalFileStates[path].Inode
+	if oldInode == 0 {
+		t.Fatalf("inode of %s should be recorded", path)
+	}
+
+	// 轮转前写入的数据还没有被读取, logrotate改名后创建同名的新文件
+	appendLines(t, path, "line 2")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	handlerEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Rename}, FileStateFilePath, nil)
+	processingWg.Wait()
+
+	appendLines(t, path, "line 3")
+	handlerEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Create}, FileStateFilePath, nil)
+	processingWg.Wait()
+
+	assertLines(t, consumer, "line 1", "line 2", "line 3")
+
+	fileState := GlobalFileStates[path]
+	if fileState.Inode == oldInode || fileState.Offset != int64(len("line 3\n")) {
+		t.Errorf("file state should track the new file, got %s", fileState.String())
+	}
+}
+
+func TestRotatedFileRecreatedFirst(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		path     = filepath.Join(dir, "app.log")
+	)
+	setWatchDirectory(map[string][]string{"index_test": {dir}})
+
+	appendLines(t, path, "line 1")
+	handlerEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write}, FileStateFilePath, nil)
+	processingWg.Wait()
+
+	// 处理改名事件时同名的新文件已经创建, 由inode判断轮转, 先读完旧文件
+	appendLines(t, path, "line 2")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, path, "line 3")
+	handlerEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Rename}, FileStateFilePath, nil)
+	processingWg.Wait()
+	handlerEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Create}, FileStateFilePath, nil)
+	processingWg.Wait()
+
+	assertLines(t, consumer, "line 1", "line 2", "line 3")
+}
+
+func TestRotatedFileNotCached(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	appendLines(t, path, "history line 1", "history line 2")
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	// 旧文件的句柄已经关闭(例如重启), 新文件比旧文件的offset短也要从头读取
+	GlobalFdCache.Remove(path)
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, path, "line 1")
+
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	assertLines(t, consumer, "history line 1", "history line 2", "line 1")
+}
+
+func TestTruncatedFile(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+		expected []string
+	)
+
+	// 10行, 每行10个字节
+	for i := 0; i < 10; i++ {
+		line := "line 000" + string(rune('0'+i))
+		appendLines(t, path, line)
+		expected = append(expected, line)
+	}
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	if offset := GlobalFileStates[path].Offset; offset != 100 {
+		t.Fatalf("offset should be 100, got %d", offset)
+	}
+
+	if err := os.Truncate(path, 10); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, path, "new line")
+
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	// 清空后从头读取, 保留的第一行和新写入的数据都需要读取
+	assertLines(t, consumer, append(expected, "line 0000", "new line")...)
+
+	if offset := GlobalFileStates[path].Offset; offset != int64(len("line 0000\nnew line\n")) {
+		t.Errorf("offset should be reset and advanced, got %d", offset)
+	}
+}
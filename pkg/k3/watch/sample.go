@@ -0,0 +1,69 @@
+package watch
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math/rand/v2"
+	"strconv"
+)
+
+var (
+	SampleRateField = "_sample_rate" // 采样发送的日志附加的采样率, 统计时除以该值还原数量
+)
+
+// validateSampleRate sample_rate必须在0到1之间, 0和1不采样
+func validateSampleRate(indexName string, rate float64) error {
+	if rate < 0 || rate > 1 {
+		return errors.New("[NewIndexRule] index_name[" + indexName + "] sample_rate must be between 0 and 1, got " + strconv.FormatFloat(rate, 'f', -1, 64))
+	}
+	return nil
+}
+
+// sampleRate 实际的采样率, 没有开启采样时为1
+func (r *IndexRule) sampleRate() float64 {
+	if r.SampleRate <= 0 || r.SampleRate >= 1 {
+		return 1
+	}
+	return r.SampleRate
+}
+
+// sampled 按照sample_rate的概率保留一条日志, 没有保留的日志不发送, offset照常前进
+// 配置了sample_seed时由seed、文件路径和日志的offset决定, 同一条日志重新读取时结果相同; 否则随机
+func (r *IndexRule) sampled(path string, offset int64) bool {
+	var rate = r.sampleRate()
+
+	if rate >= 1 {
+		return true
+	}
+
+	if r.SampleSeed == 0 {
+		return rand.Float64() < rate
+	}
+
+	return sampleHash(r.SampleSeed, path, offset) < rate
+}
+
+// sampleHash seed、path和offset的hash, 均匀分布在[0, 1)
+func sampleHash(seed int64, path string, offset int64) float64 {
+	var (
+		h   = fnv.New64a()
+		buf [8]byte
+	)
+
+	binary.LittleEndian.PutUint64(buf[:], uint64(seed))
+	_, _ = h.Write(buf[:])
+	_, _ = h.Write([]byte(path))
+	binary.LittleEndian.PutUint64(buf[:], uint64(offset))
+	_, _ = h.Write(buf[:])
+
+	// fnv相邻的输入高位差别不大, 使用splitmix64的finalizer打散
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return float64(x>>11) / (1 << 53)
+}
@@ -0,0 +1,108 @@
+package watch
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSampled(t *testing.T) {
+	const total = 100000
+
+	for _, c := range []struct {
+		name string
+		rule *IndexRule
+	}{
+		{"random", &IndexRule{Index: config.Index{SampleRate: 0.1}}},
+		{"seeded", &IndexRule{Index: config.Index{SampleRate: 0.1, SampleSeed: 42}}},
+	} {
+		var kept int
+		for offset := int64(0); offset < total; offset++ {
+			if c.rule.sampled("/logs/debug.log", offset*64) {
+				kept++
+			}
+		}
+
+		if rate := float64(kept) / total; math.Abs(rate-0.1) > 0.01 {
+			t.Errorf("%s: about 10%% lines should be kept, got %.4f", c.name, rate)
+		}
+	}
+
+	// 配置了seed时同一条日志的结果固定, 不同的seed结果不同
+	var (
+		seeded  = &IndexRule{Index: config.Index{SampleRate: 0.5, SampleSeed: 42}}
+		other   = &IndexRule{Index: config.Index{SampleRate: 0.5, SampleSeed: 7}}
+		differs bool
+	)
+	for offset := int64(0); offset < 1000; offset++ {
+		if seeded.sampled("/logs/debug.log", offset) != seeded.sampled("/logs/debug.log", offset) {
+			t.Fatalf("line at offset %d should be sampled deterministically", offset)
+		}
+		differs = differs || seeded.sampled("/logs/debug.log", offset) != other.sampled("/logs/debug.log", offset)
+	}
+	if !differs {
+		t.Error("different seeds should sample different lines")
+	}
+
+	for _, rate := range []float64{0, 1} {
+		if rule := (&IndexRule{Index: config.Index{SampleRate: rate}}); !rule.sampled("/logs/debug.log", 0) || rule.sampleRate() != 1 {
+			t.Errorf("sample_rate %v should keep every line", rate)
+		}
+	}
+
+	if err := InitIndexRules(map[string]config.Index{"index_test": {SampleRate: 1.5}}); err == nil {
+		t.Error("sample_rate above 1 should return error")
+	}
+}
+
+func TestSampleRead(t *testing.T) {
+	for _, c := range []struct {
+		rate     float64
+		min, max int
+	}{
+		{0.25, 400, 600},
+		{1, 2000, 2000},
+	} {
+		var (
+			consumer = initTestWatch(t)
+			path     = filepath.Join(t.TempDir(), "debug.log")
+			lines    = make([]string, 2000)
+		)
+
+		if err := InitIndexRules(map[string]config.Index{"index_debug": {SampleRate: c.rate, SampleSeed: 1}}); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range lines {
+			lines[i] = fmt.Sprintf("DEBUG cache hit %d", i)
+		}
+		appendLines(t, path, lines...)
+
+		// 每次最多读取DefaultMaxReadCount行
+		for i := 0; i < len(lines)/DefaultMaxReadCount; i++ {
+			writeEvent("index_debug", fsnotify.Event{Name: path, Op: fsnotify.Write})
+			processingWg.Wait()
+		}
+
+		if kept := len(consumer.lines()); kept < c.min || kept > c.max {
+			t.Errorf("sample_rate %v: expected %d-%d lines, got %d", c.rate, c.min, c.max, kept)
+		}
+
+		// 发送的日志附加采样率, 不采样时不附加
+		for _, data := range consumer.datas {
+			if rate, ok := data.Properties[SampleRateField]; (c.rate < 1) != ok || (ok && rate != c.rate) {
+				t.Fatalf("sample_rate %v: unexpected %s %v", c.rate, SampleRateField, rate)
+			}
+		}
+
+		// 没有发送的日志offset照常前进
+		info, _ := os.Stat(path)
+		if offset := GlobalFileStates[path].Offset; offset != info.Size() {
+			t.Errorf("sample_rate %v: offset should advance to %d, got %d", c.rate, info.Size(), offset)
+		}
+	}
+}
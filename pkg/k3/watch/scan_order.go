@@ -0,0 +1,44 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"sort"
+	"time"
+)
+
+// 扫描目录时文件的处理顺序, 对应watch.scan_order配置
+const (
+	ScanOrderPath  = "path"  // 按照路径排序
+	ScanOrderMtime = "mtime" // 按照修改时间排序, 最早修改的文件先读取, 修改时间相同时按照路径排序
+)
+
+// sortScanFiles 按照watch.scan_order排序扫描到的文件, 没有配置时保持目录遍历的顺序
+// 加入GlobalFileStates和提交读取任务都按照该顺序, concurrency为pool时读取的开始顺序与提交顺序相同
+func sortScanFiles(files []string) {
+	switch config.GlobalConfig.Watch.ScanOrder {
+	case ScanOrderPath:
+		sort.Strings(files)
+	case ScanOrderMtime:
+		var modTimes = make(map[string]time.Time, len(files))
+
+		// 获取不到修改时间的文件(如已经被删除)排在最后
+		for _, path := range files {
+			if info, err := os.Stat(path); err == nil {
+				modTimes[path] = info.ModTime()
+			}
+		}
+
+		sort.SliceStable(files, func(i, j int) bool {
+			left, leftOk := modTimes[files[i]]
+			right, rightOk := modTimes[files[j]]
+			if leftOk != rightOk {
+				return leftOk
+			}
+			if !left.Equal(right) {
+				return left.Before(right)
+			}
+			return files[i] < files[j]
+		})
+	}
+}
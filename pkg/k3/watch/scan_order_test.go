@@ -0,0 +1,49 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanOrder(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		now   = time.Now()
+		files = map[string]time.Duration{"a.log": 2 * time.Hour, "b.log": time.Hour, "c.log": 3 * time.Hour} // 修改时间距离现在的时间
+	)
+
+	for name, age := range files {
+		path := filepath.Join(dir, name)
+		appendLines(t, path, name)
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for order, expected := range map[string][]string{
+		ScanOrderPath:  {"a.log", "b.log", "c.log"},
+		ScanOrderMtime: {"c.log", "a.log", "b.log"},
+	} {
+		consumer := initTestWatch(t)
+		config.GlobalConfig.Watch.ScanOrder = order
+		config.GlobalConfig.Watch.Lifecycle = config.Lifecycle{Enable: true, IndexName: "index_audit"}
+
+		if err := ScanLogFileToGlobalFileStatesAndSaveToDiskFile(map[string][]string{"index_test": {dir}}, FileStateFilePath); err != nil {
+			t.Fatal(err)
+		}
+
+		// file_discovered事件按照scan_order的顺序发送
+		if len(consumer.datas) != len(expected) {
+			t.Fatalf("scan_order %s: %d discovered events expected, got %d", order, len(expected), len(consumer.datas))
+		}
+		for i, name := range expected {
+			if content := consumer.datas[i].Properties["_data"].(string); !strings.Contains(content, filepath.Join(dir, name)) {
+				t.Errorf("scan_order %s: event %d should be %s, got %s", order, i, name, content)
+			}
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"sync"
+	"sync/atomic"
+)
+
+// 读取任务的并发模型, 对应watch.concurrency配置
+const (
+	ConcurrencySemaphore = "semaphore" // 每个读取任务一个协程, 信号量限制同时读取的数量
+	ConcurrencyPool      = "pool"      // 固定数量的worker从共享队列中获取读取任务
+)
+
+var (
+	DefaultMaxConcurrency   = 100   // 默认最大并发读取数量(semaphore) / worker数量(pool)
+	DefaultQueueSize        = 1000  // pool模型默认任务队列长度
+	MaxConcurrentReadsLimit = 10000 // max_concurrent_reads的上限, 超过时使用上限
+)
+
+var (
+	activeReaders atomic.Int64 // 当前正在执行的读取任务数量
+	readersLimit  int          // 当前调度器允许同时执行的读取任务数量
+)
+
+// ActiveReaders 当前正在执行的读取任务数量, 持续等于上限时表示读取已经饱和
+func ActiveReaders() int64 {
+	return activeReaders.Load()
+}
+
+// maxConcurrentReads 同时执行的读取任务数量, max_concurrent_reads优先, 兼容旧的max_concurrency
+func maxConcurrentReads(watch config.Watch) int {
+	var reads = watch.MaxConcurrentReads
+
+	if reads == 0 {
+		reads = watch.MaxConcurrency
+	}
+
+	if reads < 0 {
+		k3.K3LogWarn("[maxConcurrentReads] invalid max_concurrent_reads[%d], use %d.", reads, DefaultMaxConcurrency)
+		return DefaultMaxConcurrency
+	}
+
+	if reads == 0 {
+		return DefaultMaxConcurrency
+	}
+
+	if reads > MaxConcurrentReadsLimit {
+		k3.K3LogWarn("[maxConcurrentReads] max_concurrent_reads[%d] too large, use %d.", reads, MaxConcurrentReadsLimit)
+		return MaxConcurrentReadsLimit
+	}
+
+	return reads
+}
+
+// logActiveReaders 记录当前正在执行的读取任务数量, 达到上限时告警
+func logActiveReaders() {
+	var active = ActiveReaders()
+
+	if readersLimit > 0 && active >= int64(readersLimit) {
+		k3.K3LogWarn("[logActiveReaders] active readers %d reach max_concurrent_reads %d, reading is saturated.", active, readersLimit)
+		return
+	}
+
+	k3.K3LogDebug("[logActiveReaders] active readers %d/%d.", active, readersLimit)
+}
+
+// runReader 执行读取任务, 执行期间计入activeReaders
+func runReader(task func()) {
+	activeReaders.Add(1)
+	defer activeReaders.Add(-1)
+
+	task()
+}
+
+// Scheduler 读取任务的调度模型, 只决定读取任务在哪个协程中执行, 读取逻辑由任务本身完成
+// 提交的任务都计入创建时的processingWg, 可以通过processingWg等待所有任务结束, 重新初始化之后旧的调度器不影响新的processingWg
+type Scheduler interface {
+	Submit(task func()) // 提交一个读取任务
+	Close()             // 停止接收新任务, 已经提交的任务继续执行
+}
+
+// NewScheduler 根据并发模型创建调度器, 模型为空或者不支持时使用semaphore
+func NewScheduler(model string, maxConcurrency, queueSize int) Scheduler {
+	if maxConcurrency <= 0 {
+		maxConcurrency = DefaultMaxConcurrency
+	}
+
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+
+	switch model {
+	case ConcurrencyPool:
+		return NewPoolScheduler(maxConcurrency, queueSize)
+	case ConcurrencySemaphore, "":
+	default:
+		k3.K3LogWarn("[NewScheduler] unsupported concurrency[%s], use %s.", model, ConcurrencySemaphore)
+	}
+
+	return NewSemaphoreScheduler(maxConcurrency)
+}
+
+// SemaphoreScheduler 每个读取任务开一个协程, 协程数量不限制, 同时读取的数量由信号量限制
+// 热点文件的写事件可以立即得到处理, 延迟低
+type SemaphoreScheduler struct {
+	sem    chan struct{}
+	lock   *sync.RWMutex
+	wg     *sync.WaitGroup
+	closed bool
+}
+
+func NewSemaphoreScheduler(maxConcurrency int) *SemaphoreScheduler {
+	return &SemaphoreScheduler{sem: make(chan struct{}, maxConcurrency), lock: &sync.RWMutex{}, wg: processingWg}
+}
+
+func (s *SemaphoreScheduler) Submit(task func()) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.closed {
+		k3.K3LogWarn("[SemaphoreScheduler] scheduler closed, task dropped.")
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		// 信号量满时阻塞, 等待其他读取任务结束
+		s.sem <- struct{}{}
+		defer func() {
+			<-s.sem
+		}()
+
+		runReader(task)
+	}()
+}
+
+// Close 之后提交的任务直接丢弃, 已经提交的任务(包括等待信号量的)继续执行
+func (s *SemaphoreScheduler) Close() {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+}
+
+// PoolScheduler 固定数量的worker从共享队列中获取读取任务, 协程数量固定, 资源占用可控
+// 队列满时Submit阻塞, Close之后阻塞中的Submit丢弃任务并返回
+type PoolScheduler struct {
+	lock       *sync.Mutex
+	queue      chan func()
+	done       chan struct{}   // Close时关闭, 通知阻塞中的Submit
+	submitting *sync.WaitGroup // 正在向队列写入的Submit, 全部返回之后才能关闭队列
+	wg         *sync.WaitGroup
+	closed     bool
+}
+
+func NewPoolScheduler(workers, queueSize int) *PoolScheduler {
+	var (
+		pool = &PoolScheduler{
+			lock:       &sync.Mutex{},
+			queue:      make(chan func(), queueSize),
+			done:       make(chan struct{}),
+			submitting: &sync.WaitGroup{},
+			wg:         processingWg,
+		}
+	)
+
+	for i := 0; i < workers; i++ {
+		go pool.work()
+	}
+
+	return pool
+}
+
+func (p *PoolScheduler) work() {
+	for task := range p.queue {
+		p.run(task)
+	}
+}
+
+// run 执行单个任务, 防止任务中的异常导致worker退出
+func (p *PoolScheduler) run(task func()) {
+	defer p.wg.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			k3.K3LogError("[PoolScheduler] task panic: %v", r)
+		}
+	}()
+
+	runReader(task)
+}
+
+// Submit 写入队列时不持有锁, 队列满并且读取卡住时, Close不会因为等待锁而无法返回
+func (p *PoolScheduler) Submit(task func()) {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		k3.K3LogWarn("[PoolScheduler] scheduler closed, task dropped.")
+		return
+	}
+	p.wg.Add(1)
+	p.submitting.Add(1)
+	p.lock.Unlock()
+
+	defer p.submitting.Done()
+
+	select {
+	case p.queue <- task:
+	case <-p.done:
+		p.wg.Done()
+		k3.K3LogWarn("[PoolScheduler] scheduler closed while the queue is full, task dropped.")
+	}
+}
+
+// Close 不再接收新任务并立即返回, 阻塞中的Submit丢弃任务; 所有Submit返回之后关闭任务队列, worker执行完队列中剩余的任务后退出
+func (p *PoolScheduler) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.done)
+
+	go func() {
+		p.submitting.Wait()
+		close(p.queue)
+	}()
+}
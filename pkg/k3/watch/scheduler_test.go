@@ -0,0 +1,376 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// discardConsumer 测试用consumer, 只记录收到的数据条数
+type discardConsumer struct {
+	count atomic.Int64
+}
+
+func (c *discardConsumer) Add(data protocol.Data) error {
+	c.count.Add(1)
+	return nil
+}
+
+func (c *discardConsumer) Flush() error {
+	return nil
+}
+
+func (c *discardConsumer) Close() error {
+	return nil
+}
+
+// useScheduler 替换当前的调度模型
+func useScheduler(model string, maxConcurrency, queueSize int) {
+	GlobalScheduler.Close()
+	GlobalScheduler = NewScheduler(model, maxConcurrency, queueSize)
+}
+
+func TestSchedulerModels(t *testing.T) {
+	for _, model := range []string{ConcurrencySemaphore, ConcurrencyPool} {
+		t.Run(model, func(t *testing.T) {
+			var (
+				consumer = initTestWatch(t)
+				dir      = t.TempDir()
+				paths    []string
+			)
+			useScheduler(model, 4, 8)
+
+			for i := 0; i < 20; i++ {
+				path := filepath.Join(dir, "app.log."+strconv.Itoa(i))
+				appendLines(t, path, path+" line 1", path+" line 2", path+" line 3")
+				paths = append(paths, path)
+			}
+
+			for _, path := range paths {
+				writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+			}
+			processingWg.Wait()
+
+			// 不同文件之间没有顺序, 同一个文件内的数据保持顺序
+			var lines = make(map[string][]string)
+			consumer.lock.Lock()
+			for _, data := range consumer.datas {
+				path := data.Properties["_path"].(string)
+				lines[path] = append(lines[path], data.Properties["_data"].(string))
+			}
+			consumer.lock.Unlock()
+
+			for _, path := range paths {
+				expected := fmt.Sprint([]string{path + " line 1", path + " line 2", path + " line 3"})
+				if got := fmt.Sprint(lines[path]); got != expected {
+					t.Errorf("%s expected %s, got %s", path, expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPoolSchedulerWorkers(t *testing.T) {
+	var (
+		running    atomic.Int32
+		maxRunning atomic.Int32
+		done       atomic.Int32
+		release    = make(chan struct{})
+	)
+
+	initTestWatch(t)
+	useScheduler(ConcurrencyPool, 2, 10)
+
+	for i := 0; i < 10; i++ {
+		GlobalScheduler.Submit(func() {
+			current := running.Add(1)
+			for {
+				if m := maxRunning.Load(); current <= m || maxRunning.CompareAndSwap(m, current) {
+					break
+				}
+			}
+			<-release
+			running.Add(-1)
+			done.Add(1)
+		})
+	}
+	close(release)
+	processingWg.Wait()
+
+	if done.Load() != 10 {
+		t.Errorf("all tasks should be done, got %d", done.Load())
+	}
+
+	if maxRunning.Load() > 2 {
+		t.Errorf("pool should run at most 2 tasks at the same time, got %d", maxRunning.Load())
+	}
+
+	// 关闭后提交的任务直接丢弃
+	GlobalScheduler.Close()
+	GlobalScheduler.Submit(func() { done.Add(1) })
+	processingWg.Wait()
+	if done.Load() != 10 {
+		t.Errorf("task submitted after close should be dropped, got %d", done.Load())
+	}
+}
+
+func TestPoolSchedulerCloseWhileFull(t *testing.T) {
+	var (
+		block     = make(chan struct{})
+		submitted = make(chan struct{})
+	)
+
+	initTestWatch(t)
+	useScheduler(ConcurrencyPool, 1, 1)
+	t.Cleanup(func() {
+		close(block)
+		processingWg.Wait()
+	})
+
+	// worker卡住, 队列已满, 之后的Submit阻塞
+	GlobalScheduler.Submit(func() { <-block })
+	GlobalScheduler.Submit(func() {})
+	go func() {
+		defer close(submitted)
+		GlobalScheduler.Submit(func() {})
+	}()
+	time.Sleep(50 * time.Millisecond) // 等待Submit阻塞在写入队列上
+
+	// Close不会等待阻塞中的Submit, 超时后返回
+	if err := DrainProcessing(100 * time.Millisecond); !errors.Is(err, ErrDrainTimeout) {
+		t.Fatalf("DrainProcessing should return ErrDrainTimeout, got %v", err)
+	}
+
+	select {
+	case <-submitted:
+	case <-time.After(time.Second):
+		t.Fatal("blocked Submit should return after Close")
+	}
+
+	// 关闭后提交的任务直接丢弃, 不会阻塞
+	GlobalScheduler.Submit(func() {})
+}
+
+// concurrencyConsumer 测试用consumer, 记录同时调用Add的最大数量, 每次Add模拟耗时的处理
+type concurrencyConsumer struct {
+	running    atomic.Int32
+	maxRunning atomic.Int32
+	count      atomic.Int32
+}
+
+func (c *concurrencyConsumer) Add(data protocol.Data) error {
+	current := c.running.Add(1)
+	for {
+		if m := c.maxRunning.Load(); current <= m || c.maxRunning.CompareAndSwap(m, current) {
+			break
+		}
+	}
+	time.Sleep(2 * time.Millisecond)
+	c.running.Add(-1)
+	c.count.Add(1)
+	return nil
+}
+
+func (c *concurrencyConsumer) Flush() error {
+	return nil
+}
+
+func (c *concurrencyConsumer) Close() error {
+	return nil
+}
+
+func TestMaxConcurrentReads(t *testing.T) {
+	for _, model := range []string{ConcurrencySemaphore, ConcurrencyPool} {
+		t.Run(model, func(t *testing.T) {
+			var (
+				consumer = &concurrencyConsumer{}
+				dir      = t.TempDir()
+			)
+
+			initTestWatch(t)
+			GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+			config.GlobalConfig.Watch.MaxConcurrentReads = 2
+			readersLimit = maxConcurrentReads(config.GlobalConfig.Watch)
+			useScheduler(model, readersLimit, DefaultQueueSize)
+
+			for i := 0; i < 10; i++ {
+				path := filepath.Join(dir, "app.log."+strconv.Itoa(i))
+				appendLines(t, path, "line 1", "line 2", "line 3")
+				writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+			}
+			processingWg.Wait()
+
+			if consumer.count.Load() != 30 {
+				t.Errorf("all lines should be read, got %d", consumer.count.Load())
+			}
+			if consumer.maxRunning.Load() > 2 {
+				t.Errorf("at most 2 readers should run at the same time, got %d", consumer.maxRunning.Load())
+			}
+			if ActiveReaders() != 0 {
+				t.Errorf("no reader should be active after all reads, got %d", ActiveReaders())
+			}
+		})
+	}
+}
+
+func TestMaxConcurrentReadsValidation(t *testing.T) {
+	for _, item := range []struct {
+		watch    config.Watch
+		expected int
+	}{
+		{config.Watch{}, DefaultMaxConcurrency},
+		{config.Watch{MaxConcurrentReads: -1}, DefaultMaxConcurrency},
+		{config.Watch{MaxConcurrentReads: 8, MaxConcurrency: 4}, 8},
+		{config.Watch{MaxConcurrency: 4}, 4},
+		{config.Watch{MaxConcurrentReads: MaxConcurrentReadsLimit + 1}, MaxConcurrentReadsLimit},
+	} {
+		if got := maxConcurrentReads(item.watch); got != item.expected {
+			t.Errorf("%+v expected %d, got %d", item.watch, item.expected, got)
+		}
+	}
+}
+
+func TestBusyFileYields(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		busy     = filepath.Join(dir, "busy.log")
+		quiets   []string
+		lines    []string
+		release  = make(chan struct{})
+	)
+
+	config.GlobalConfig.Watch.MaxReadCount = 10
+	config.GlobalConfig.Watch.MaxReadPasses = 2
+	useScheduler(ConcurrencyPool, 1, DefaultQueueSize)
+
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, "busy "+strconv.Itoa(i))
+	}
+	appendLines(t, busy, lines...)
+
+	for i := 0; i < 3; i++ {
+		path := filepath.Join(dir, "quiet.log."+strconv.Itoa(i))
+		appendLines(t, path, "quiet "+strconv.Itoa(i))
+		quiets = append(quiets, path)
+	}
+
+	// 唯一的worker先被占用, 大文件的读取任务排在安静文件之前
+	GlobalScheduler.Submit(func() { <-release })
+	writeEvent("index_test", fsnotify.Event{Name: busy, Op: fsnotify.Write})
+	for _, path := range quiets {
+		writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	}
+	close(release)
+	processingWg.Wait()
+
+	// 大文件连续读取2次(20行)之后让出worker, 安静文件先读取, 之后大文件重新排队读完, 不需要新的写事件
+	var (
+		got      = consumer.lines()
+		expected = append(append([]string{}, lines[:20]...), "quiet 0", "quiet 1", "quiet 2")
+	)
+	expected = append(expected, lines[20:]...)
+
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d lines, got %d", len(expected), len(got))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("line %d expected %q, got %q", i, expected[i], got[i])
+		}
+	}
+
+	info, _ := os.Stat(busy)
+	if offset := GlobalFileStates[busy].Offset; offset != info.Size() {
+		t.Errorf("busy file should be read to the end %d, got %d", info.Size(), offset)
+	}
+}
+
+// benchmarkScheduler 每次迭代向每个文件追加lines行, 并读取到所有文件的最新位置
+func benchmarkScheduler(b *testing.B, model string, files, lines int) {
+	var (
+		consumer = &discardConsumer{}
+		dir      = b.TempDir()
+		paths    []string
+		content  []string
+	)
+
+	// 读取时的debug日志会输出日志内容, 影响测试结果
+	level := k3.CurrentLogLevel
+	k3.SetLogLevel(k3.K3LogLevelERROR)
+	b.Cleanup(func() { k3.SetLogLevel(level) })
+
+	initTestWatch(b)
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+	useScheduler(model, DefaultMaxConcurrency, DefaultQueueSize)
+
+	for i := 0; i < lines; i++ {
+		content = append(content, "benchmark log line "+strconv.Itoa(i))
+	}
+
+	for i := 0; i < files; i++ {
+		paths = append(paths, filepath.Join(dir, "app.log."+strconv.Itoa(i)))
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		for _, path := range paths {
+			appendLines(b, path, content...)
+		}
+		b.StartTimer()
+
+		// 每次写事件最多读取max_read_count行, 大文件需要多次写事件才能读完
+		for pending := paths; len(pending) > 0; {
+			for _, path := range pending {
+				writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+			}
+			processingWg.Wait()
+
+			var unfinished []string
+			for _, path := range pending {
+				info, err := os.Stat(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				GlobalFileStatesLock.Lock()
+				offset := GlobalFileStates[path].Offset
+				GlobalFileStatesLock.Unlock()
+				if offset < info.Size() {
+					unfinished = append(unfinished, path)
+				}
+			}
+			pending = unfinished
+		}
+	}
+	b.StopTimer()
+
+	if expected := int64(b.N * files * lines); consumer.count.Load() != expected {
+		b.Fatalf("expected %d lines, got %d", expected, consumer.count.Load())
+	}
+}
+
+func BenchmarkSchedulerManySmallFiles(b *testing.B) {
+	for _, model := range []string{ConcurrencySemaphore, ConcurrencyPool} {
+		b.Run(model, func(b *testing.B) {
+			benchmarkScheduler(b, model, 500, 10)
+		})
+	}
+}
+
+func BenchmarkSchedulerFewLargeFiles(b *testing.B) {
+	for _, model := range []string{ConcurrencySemaphore, ConcurrencyPool} {
+		b.Run(model, func(b *testing.B) {
+			benchmarkScheduler(b, model, 4, 5000)
+		})
+	}
+}
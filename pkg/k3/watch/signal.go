@@ -0,0 +1,77 @@
+package watch
+
+import (
+	"context"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"syscall"
+	"time"
+)
+
+var DefaultShutdownGrace = 5 * time.Second // shutdown_timeout之外, 留给关闭http等其他服务和保存文件状态的时间
+
+// WaitSignal 阻塞直到收到退出信号或者ctx结束, 返回进程的退出码
+// SIGINT/SIGTERM/SIGQUIT返回0, ctx结束(监听协程异常退出)返回1, SIGHUP调用reload重新加载配置后继续等待
+func WaitSignal(ctx context.Context, signals <-chan os.Signal, reload func()) int {
+	for {
+		select {
+		case sig, ok := <-signals:
+			if !ok {
+				k3.K3LogError("[WaitSignal] signal chan closed")
+				return 1
+			}
+
+			switch sig {
+			case syscall.SIGHUP:
+				k3.K3LogInfo("[WaitSignal] accept signal %s, reload config.", sig)
+				if reload != nil {
+					reload()
+				}
+			case syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT:
+				k3.K3LogInfo("[WaitSignal] accept signal %s, shutdown.", sig)
+				return 0
+			default:
+				k3.K3LogWarn("[WaitSignal] accept unexpected signal %s, shutdown.", sig)
+				return 1
+			}
+		case <-ctx.Done():
+			k3.K3LogError("[WaitSignal] context done")
+			return 1
+		}
+	}
+}
+
+// ShutdownGracePeriod 退出时最长的等待时间, watch.shutdown_timeout加上DefaultShutdownGrace
+func ShutdownGracePeriod() time.Duration {
+	var timeout = config.GlobalConfig.Watch.ShutdownTimeout
+
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+
+	return time.Duration(timeout)*time.Second + DefaultShutdownGrace
+}
+
+// Shutdown 依次调用cleans(Closed提交consumer中剩余的数据), 之后保存文件状态
+// 超过grace还没有结束时不再等待, 仍然保存已经提交的offset, 返回false由调用方强制退出
+func Shutdown(grace time.Duration, cleans ...func()) bool {
+	done := waitUntil(func() {
+		for _, clean := range cleans {
+			if clean != nil {
+				clean()
+			}
+		}
+	}, time.Now().Add(grace))
+
+	if !done {
+		k3.K3LogError("[Shutdown] shutdown timeout after %s, force exit, unsent data may be lost", grace)
+	}
+
+	// 清理之后保存, 记录的是consumer提交之后的offset
+	if err := SaveGlobalFileStatesToDiskFile(FileStateFilePath); err != nil {
+		k3.K3LogError("[Shutdown] save file states failed: %s", err.Error())
+	}
+
+	return done
+}
@@ -0,0 +1,62 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWaitSignal(t *testing.T) {
+	var (
+		signals = make(chan os.Signal, 3)
+		reloads int
+	)
+
+	// SIGHUP重新加载配置后继续等待, SIGTERM退出
+	signals <- syscall.SIGHUP
+	signals <- syscall.SIGHUP
+	signals <- syscall.SIGTERM
+	if state := WaitSignal(context.Background(), signals, func() { reloads++ }); state != 0 || reloads != 2 {
+		t.Errorf("expected exit 0 after 2 reloads, got exit %d after %d reloads", state, reloads)
+	}
+
+	// 监听协程异常退出
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if state := WaitSignal(ctx, make(chan os.Signal), nil); state != 1 {
+		t.Errorf("context done should exit with 1, got %d", state)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	var (
+		_       = initTestWatch(t)
+		flushed atomic.Bool
+		block   = make(chan struct{})
+	)
+	t.Cleanup(func() { close(block) })
+
+	// 等待Closed提交剩余数据之后再返回, 并保存文件状态
+	flush := func() {
+		time.Sleep(100 * time.Millisecond)
+		flushed.Store(true)
+	}
+	if !Shutdown(time.Second, nil, flush) || !flushed.Load() {
+		t.Error("shutdown should wait for the flush")
+	}
+	if _, err := os.Stat(FileStateFilePath); err != nil {
+		t.Errorf("file states should be saved on shutdown: %s", err.Error())
+	}
+
+	// 超过grace时不再等待
+	start := time.Now()
+	if Shutdown(100*time.Millisecond, func() { <-block }) {
+		t.Error("shutdown should time out when the flush hangs")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown should return after the grace period, took %s", elapsed)
+	}
+}
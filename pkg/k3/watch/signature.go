@@ -0,0 +1,52 @@
+package watch
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+var (
+	DefaultSignatureMaxBytes = 4096 // 检查文件签名时, 第一行最多读取的字节数
+)
+
+// checkSkipSignature 文件第一次读取时检查第一行是否匹配skip_signature, 每个文件只检查一次
+// 返回true表示文件需要跳过; 文件还没有写入完整的第一行时不做判断, 下次读取时再检查
+func checkSkipSignature(fd *os.File, fileState *FileState, rule *IndexRule) bool {
+	var (
+		buf       = make([]byte, DefaultSignatureMaxBytes)
+		n         int
+		firstLine []byte
+		err       error
+	)
+
+	GlobalFileStatesLock.Lock()
+	checked, skipped := fileState.SignatureChecked, fileState.Skipped
+	GlobalFileStatesLock.Unlock()
+
+	if rule.skipSignature == nil || checked {
+		return skipped
+	}
+
+	if n, err = fd.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return false
+	}
+
+	if i := bytes.Index(buf[:n], []byte(rule.lineDelimiter)); i >= 0 {
+		firstLine = buf[:i]
+	} else if n == len(buf) {
+		// 第一行超过最大长度, 只检查前DefaultSignatureMaxBytes个字节
+		firstLine = buf[:n]
+	} else {
+		return false
+	}
+
+	skipped = rule.skipSignature.Match(bytes.TrimRight(firstLine, "\r"))
+
+	GlobalFileStatesLock.Lock()
+	fileState.SignatureChecked = true
+	fileState.Skipped = skipped
+	GlobalFileStatesLock.Unlock()
+
+	return skipped
+}
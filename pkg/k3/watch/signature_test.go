@@ -0,0 +1,76 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"path/filepath"
+	"testing"
+)
+
+func TestSkipSignature(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		rotated  = filepath.Join(dir, "app.log-20241016")
+		active   = filepath.Join(dir, "app.log")
+	)
+
+	if err := InitIndexRules(map[string]config.Index{"index_test": {SkipSignature: `^#\s*ROTATED`}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, rotated, "# ROTATED by logrotate", "old line")
+	appendLines(t, active, "new line")
+
+	writeEvent("index_test", fsnotify.Event{Name: rotated, Op: fsnotify.Write})
+	writeEvent("index_test", fsnotify.Event{Name: active, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	// 只检查一次, 之后的写入也不会读取
+	appendLines(t, rotated, "another old line")
+	writeEvent("index_test", fsnotify.Event{Name: rotated, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	assertLines(t, consumer, "new line")
+
+	if !GlobalFileStates[rotated].Skipped || GlobalFileStates[rotated].Offset != 0 {
+		t.Errorf("rotated file should be skipped, got %s", GlobalFileStates[rotated])
+	}
+
+	if GlobalFileStates[active].Skipped {
+		t.Errorf("active file should not be skipped")
+	}
+}
+
+func TestSkipSignatureWaitFirstLine(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	if err := InitIndexRules(map[string]config.Index{"index_test": {SkipSignature: `^#ROTATED`}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 空文件还没有第一行, 不做判断
+	appendLines(t, path)
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	if GlobalFileStates[path].SignatureChecked {
+		t.Errorf("empty file should not be checked")
+	}
+
+	appendLines(t, path, "normal line")
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	assertLines(t, consumer, "normal line")
+}
+
+func TestInvalidSkipSignature(t *testing.T) {
+	if err := InitIndexRules(map[string]config.Index{"index_test": {SkipSignature: `(`}}); err == nil {
+		t.Errorf("invalid skip_signature should return error")
+	}
+}
@@ -0,0 +1,129 @@
+package watch
+
+import (
+	"encoding/json"
+	"fmt"
+	"log-engine-sdk/pkg/k3"
+	"os"
+)
+
+var (
+	StateFileVersion = 1 // 状态文件格式版本, 没有version的状态文件是旧的path -> FileState格式
+)
+
+// StateFile 状态文件(core.json)的结构, 正常读取的文件记录在online中, 长时间没有写入且已经读完的文件记录在obsolete中
+// 内存中统一使用GlobalFileStates, obsolete中的文件FileState.Obsolete为true
+type StateFile struct {
+	Version  int                   `json:"version"`
+	Online   map[string]*FileState `json:"online"`
+	Obsolete map[string]*FileState `json:"obsolete"`
+}
+
+// newStateFile 按照FileState.Obsolete将文件状态拆分到online和obsolete中, offset为sender已经确认的位置
+// 调用时需要持有GlobalFileStatesLock
+func newStateFile(fileStates map[string]*FileState) *StateFile {
+	var stateFile = &StateFile{
+		Version:  StateFileVersion,
+		Online:   make(map[string]*FileState),
+		Obsolete: make(map[string]*FileState),
+	}
+
+	for path, fileState := range fileStates {
+		if fileState.delivery != nil {
+			committed := *fileState
+			committed.Offset = fileState.committedOffset()
+			// 被截断的日志还没有确认时, 重启后从这条日志的开头重新读取, 不能丢弃
+			committed.SkipLine = committed.SkipLine && committed.Offset == fileState.Offset
+			// gzip文件已经发送的member(或者读取完成的文件)中还有没有确认的日志, 重启后从头重新读取
+			if (committed.CompressedOffset > 0 || committed.Completed) && committed.Offset != fileState.Offset {
+				committed.CompressedOffset, committed.Offset, committed.Completed = 0, 0, false
+			}
+			fileState = &committed
+		}
+
+		if fileState.Obsolete {
+			stateFile.Obsolete[path] = fileState
+		} else {
+			stateFile.Online[path] = fileState
+		}
+	}
+
+	return stateFile
+}
+
+// fileStates 合并online和obsolete, 返回path -> FileState
+func (s *StateFile) fileStates() map[string]*FileState {
+	var fileStates = make(map[string]*FileState, len(s.Online)+len(s.Obsolete))
+
+	for path, fileState := range s.Online {
+		fileState.Obsolete = false
+		fileStates[path] = fileState
+	}
+
+	for path, fileState := range s.Obsolete {
+		fileState.Obsolete = true
+		fileStates[path] = fileState
+	}
+
+	for path, fileState := range fileStates {
+		if len(fileState.Path) == 0 {
+			fileState.Path = path
+		}
+	}
+
+	return fileStates
+}
+
+// decodeStateFile 解析状态文件的内容, 兼容旧的path -> FileState格式, migrated表示内容是旧格式, 需要重写为新格式
+func decodeStateFile(content []byte) (stateFile *StateFile, migrated bool, err error) {
+	var (
+		fields map[string]json.RawMessage
+		flat   map[string]*FileState
+	)
+
+	if err = json.Unmarshal(content, &fields); err != nil {
+		return nil, false, fmt.Errorf("[decodeStateFile] json decode failed: %w", err)
+	}
+
+	if _, exists := fields["version"]; exists {
+		stateFile = &StateFile{}
+		if err = json.Unmarshal(content, stateFile); err != nil {
+			return nil, false, fmt.Errorf("[decodeStateFile] json decode state file failed: %w", err)
+		}
+		return stateFile, false, nil
+	}
+
+	// 旧格式, 所有文件都是online
+	if err = json.Unmarshal(content, &flat); err != nil {
+		return nil, false, fmt.Errorf("[decodeStateFile] json decode flat state file failed: %w", err)
+	}
+
+	return newStateFile(flat), true, nil
+}
+
+// recoverCorruptStateFile 状态文件无法解析时, 备份为<filePath>.corrupt.<ts>, 使用空的GlobalFileStates继续启动
+// 之后由ScanLogFileToGlobalFileStatesAndSaveToDiskFile重新扫描目录生成状态, 调用方需要持有GlobalFileStatesLock
+func recoverCorruptStateFile(filePath string, decodeErr error) error {
+	if err := backupCorruptStateFile(filePath, decodeErr); err != nil {
+		return fmt.Errorf("[recoverCorruptStateFile] %w", err)
+	}
+
+	GlobalFileStates = make(map[string]*FileState)
+
+	k3.K3LogWarn("[recoverCorruptStateFile] continue with empty state.")
+	return nil
+}
+
+// backupCorruptStateFile 将无法解析的状态文件(或分片)备份为<filePath>.corrupt.<ts>
+func backupCorruptStateFile(filePath string, decodeErr error) error {
+	var backupPath = filePath + ".corrupt." + nowFunc().Format("20060102150405")
+
+	k3.K3LogError("[backupCorruptStateFile] state file[%s] is corrupt: %s", filePath, decodeErr.Error())
+
+	if err := os.Rename(filePath, backupPath); err != nil {
+		return fmt.Errorf("backup corrupt state file failed: %w", err)
+	}
+
+	k3.K3LogWarn("[backupCorruptStateFile] corrupt state file backup to [%s].", backupPath)
+	return nil
+}
@@ -0,0 +1,352 @@
+package watch
+
+import (
+	"encoding/json"
+	"fmt"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadFlatStateFileMigrated(t *testing.T) {
+	var (
+		dir       = t.TempDir()
+		online    = filepath.Join(dir, "online.log")
+		obsolete  = filepath.Join(dir, "obsolete.log")
+		stateFile StateFile
+	)
+
+	initTestWatch(t)
+
+	// 旧格式: path -> FileState
+	flat := map[string]*FileState{
+		online:   {Path: online, Offset: 128, StartReadTime: 100, LastReadTime: 200, IndexName: "index_test", Dev: 1, Inode: 2},
+		obsolete: {Path: obsolete, Offset: 64, LastReadTime: 50, IndexName: "index_test", Obsolete: true},
+	}
+	content, _ := json.Marshal(flat)
+	if err := os.WriteFile(FileStateFilePath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadDiskFileToGlobalFileStates(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(GlobalFileStates) != 2 {
+		t.Fatalf("2 file states expected, got %d", len(GlobalFileStates))
+	}
+	if got := GlobalFileStates[online]; *got != *flat[online] {
+		t.Errorf("online state should be loaded without loss, got %v", got)
+	}
+	if got := GlobalFileStates[obsolete]; !got.Obsolete || got.Offset != 64 {
+		t.Errorf("obsolete state should be kept, got %v", got)
+	}
+
+	// 状态文件被重写为新格式
+	if content, _ = os.ReadFile(FileStateFilePath); json.Unmarshal(content, &stateFile) != nil || stateFile.Version != StateFileVersion {
+		t.Fatalf("state file should be migrated, got %s", content)
+	}
+	if stateFile.Online[online] == nil || stateFile.Online[online].Offset != 128 {
+		t.Errorf("online file should be saved in online section, got %v", stateFile.Online)
+	}
+	if stateFile.Obsolete[obsolete] == nil || len(stateFile.Online) != 1 {
+		t.Errorf("obsolete file should be saved in obsolete section, got %v", stateFile.Obsolete)
+	}
+
+	// 再次加载新格式
+	GlobalFileStates = make(map[string]*FileState)
+	if err := LoadDiskFileToGlobalFileStates(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if got := GlobalFileStates[online]; got == nil || *got != *flat[online] {
+		t.Errorf("state should survive the round trip, got %v", got)
+	}
+	if got := GlobalFileStates[obsolete]; got == nil || !got.Obsolete {
+		t.Errorf("obsolete state should survive the round trip, got %v", got)
+	}
+}
+
+func TestLoadEmptyStateFile(t *testing.T) {
+	initTestWatch(t)
+
+	if err := os.WriteFile(FileStateFilePath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadDiskFileToGlobalFileStates(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if len(GlobalFileStates) != 0 {
+		t.Errorf("empty state file should load nothing, got %d", len(GlobalFileStates))
+	}
+
+	if err := os.WriteFile(FileStateFilePath, []byte("{broken"), 0644); err == nil {
+		if err = LoadDiskFileToGlobalFileStates(FileStateFilePath); err == nil {
+			t.Errorf("broken state file should return error")
+		}
+	}
+}
+
+func TestLoadCorruptStateFileRecovered(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		path = filepath.Join(dir, "app.log")
+	)
+
+	initTestWatch(t)
+	appendLines(t, path, "line 1")
+
+	if err := os.WriteFile(FileStateFilePath, []byte(`{"online": garbage`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有开启recover_corrupt_state, 启动失败
+	if err := LoadDiskFileToGlobalFileStates(FileStateFilePath); err == nil {
+		t.Fatal("corrupt state file should return error without recover_corrupt_state")
+	}
+
+	config.GlobalConfig.Watch.RecoverCorruptState = true
+	GlobalFileStates[path] = &FileState{Path: path, Offset: 3}
+	if err := LoadDiskFileToGlobalFileStates(FileStateFilePath); err != nil {
+		t.Fatalf("corrupt state file should be recovered, got %s", err)
+	}
+	if len(GlobalFileStates) != 0 {
+		t.Errorf("file states should be reset to empty, got %d", len(GlobalFileStates))
+	}
+
+	// 损坏的文件被备份
+	backups, _ := filepath.Glob(FileStateFilePath + ".corrupt.*")
+	if len(backups) != 1 {
+		t.Fatalf("corrupt state file should be backed up, got %v", backups)
+	}
+	if content, _ := os.ReadFile(backups[0]); string(content) != `{"online": garbage` {
+		t.Errorf("backup should keep the corrupt content, got %s", content)
+	}
+
+	// 继续启动, 重新扫描目录生成状态
+	if err := ScanLogFileToGlobalFileStatesAndSaveToDiskFile(map[string][]string{"index_test": {dir}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if GlobalFileStates[path] == nil || GlobalFileStates[path].Offset != 0 {
+		t.Errorf("state should be rebuilt from disk, got %v", GlobalFileStates[path])
+	}
+	if !k3.FileExists(FileStateFilePath) {
+		t.Errorf("state file should be rewritten")
+	}
+}
+
+func TestScanPruneMissingFileStates(t *testing.T) {
+	var (
+		_        = initTestWatch(t)
+		dir      = t.TempDir()
+		existing = filepath.Join(dir, "app.log")
+		stale    = filepath.Join(dir, "stale.log")
+		fresh    = filepath.Join(dir, "fresh.log")
+		never    = filepath.Join(dir, "never.log")
+		now      = time.Now().Unix()
+	)
+
+	appendLines(t, existing, "line 1")
+
+	// stale超过state_retention没有读取, fresh刚刚还在读取, never是没有记录读取时间的旧状态
+	config.GlobalConfig.Watch.StateRetention = 3600
+	GlobalFileStates[existing] = &FileState{Path: existing, IndexName: "index_test", LastReadTime: now - 7200}
+	GlobalFileStates[stale] = &FileState{Path: stale, IndexName: "index_test", Offset: 10, LastReadTime: now - 7200}
+	GlobalFileStates[fresh] = &FileState{Path: fresh, IndexName: "index_test", Offset: 20, LastReadTime: now - 60}
+	GlobalFileStates[never] = &FileState{Path: never, IndexName: "index_test"}
+
+	if err := ScanLogFileToGlobalFileStatesAndSaveToDiskFile(map[string][]string{"index_test": {dir}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{stale, never} {
+		if _, ok := GlobalFileStates[path]; ok {
+			t.Errorf("stale missing file %s should be pruned", path)
+		}
+	}
+	if GlobalFileStates[fresh] == nil || GlobalFileStates[fresh].Offset != 20 {
+		t.Errorf("recently read missing file should be retained with its offset, got %v", GlobalFileStates[fresh])
+	}
+	if GlobalFileStates[existing] == nil {
+		t.Errorf("existing file should be kept regardless of last read time")
+	}
+
+	// 保留的文件状态同样保存到硬盘
+	var stateFile StateFile
+	content, _ := os.ReadFile(FileStateFilePath)
+	if err := json.Unmarshal(content, &stateFile); err != nil {
+		t.Fatal(err)
+	}
+	if stateFile.Online[fresh] == nil || stateFile.Online[stale] != nil {
+		t.Errorf("state file should keep fresh and drop stale entries, got %v", stateFile.Online)
+	}
+}
+
+func TestStateShardLoadMerge(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		nginx  = filepath.Join(dir, "nginx.log")
+		api    = filepath.Join(dir, "api.log")
+		legacy = map[string]*FileState{
+			nginx: {Path: nginx, Offset: 10, IndexName: "index_nginx"},
+			api:   {Path: api, Offset: 20, IndexName: "index_api"},
+		}
+	)
+
+	initTestWatch(t)
+	config.GlobalConfig.Watch.StateShard = true
+
+	// 还没有分片时从单个状态文件迁移, 保存时拆分到每个index_name的分片
+	content, _ := json.Marshal(newStateFile(legacy))
+	if err := os.WriteFile(FileStateFilePath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadDiskFileToGlobalFileStates(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveGlobalFileStatesToDiskFile(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	for _, indexName := range []string{"index_nginx", "index_api"} {
+		if !k3.FileExists(stateShardPath(FileStateFilePath, indexName)) {
+			t.Errorf("state shard of %s should be written", indexName)
+		}
+	}
+
+	// 分片存在时不再读取单个状态文件, 合并所有分片
+	if err := os.WriteFile(FileStateFilePath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	GlobalFileStates = make(map[string]*FileState)
+	if err := LoadDiskFileToGlobalFileStates(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if len(GlobalFileStates) != 2 || GlobalFileStates[nginx].Offset != 10 || GlobalFileStates[api].Offset != 20 {
+		t.Errorf("all shards should be merged, got %v", GlobalFileStates)
+	}
+
+	// 损坏的分片只影响自己的index_name
+	if err := os.WriteFile(stateShardPath(FileStateFilePath, "index_api"), []byte(`{"online": garbage`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	GlobalFileStates = make(map[string]*FileState)
+	if err := LoadDiskFileToGlobalFileStates(FileStateFilePath); err == nil {
+		t.Fatal("corrupt shard should return error without recover_corrupt_state")
+	}
+
+	config.GlobalConfig.Watch.RecoverCorruptState = true
+	GlobalFileStates = make(map[string]*FileState)
+	if err := LoadDiskFileToGlobalFileStates(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if len(GlobalFileStates) != 1 || GlobalFileStates[nginx] == nil {
+		t.Errorf("other shards should be loaded, got %v", GlobalFileStates)
+	}
+	if backups, _ := filepath.Glob(stateShardPath(FileStateFilePath, "index_api") + ".corrupt.*"); len(backups) != 1 {
+		t.Errorf("corrupt shard should be backed up, got %v", backups)
+	}
+}
+
+func TestStateShardIsolatedSave(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		nginx = filepath.Join(dir, "nginx.log")
+		api   = filepath.Join(dir, "api.log")
+		stale = []byte("not rewritten\n")
+	)
+
+	initTestWatch(t)
+	config.GlobalConfig.Watch.StateShard = true
+	GlobalFileStates[nginx] = &FileState{Path: nginx, Offset: 10, IndexName: "index_nginx"}
+	GlobalFileStates[api] = &FileState{Path: api, Offset: 20, IndexName: "index_api"}
+
+	if err := SaveGlobalFileStatesToDiskFile(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只有index_nginx变化, index_api的分片不重新写入
+	if err := os.WriteFile(stateShardPath(FileStateFilePath, "index_api"), stale, 0644); err != nil {
+		t.Fatal(err)
+	}
+	GlobalFileStates[nginx].Offset = 30
+	if err := SaveGlobalFileStatesToDiskFile(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := os.ReadFile(stateShardPath(FileStateFilePath, "index_api")); string(content) != string(stale) {
+		t.Errorf("unchanged shard should not be rewritten, got %s", content)
+	}
+	var stateFile StateFile
+	content, _ := os.ReadFile(stateShardPath(FileStateFilePath, "index_nginx"))
+	if err := json.Unmarshal(content, &stateFile); err != nil || stateFile.Online[nginx] == nil || stateFile.Online[nginx].Offset != 30 {
+		t.Errorf("changed shard should be rewritten, got %s", content)
+	}
+	if k3.FileExists(FileStateFilePath) {
+		t.Errorf("single state file should not be written in shard mode")
+	}
+
+	// index_name没有文件状态时删除分片
+	delete(GlobalFileStates, api)
+	if err := SaveGlobalFileStatesToDiskFile(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if k3.FileExists(stateShardPath(FileStateFilePath, "index_api")) {
+		t.Errorf("shard without file states should be removed")
+	}
+}
+
+func TestStateShardEscapedIndexName(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		indexes = []string{"../escape", "a/b", "..", ".hidden", `c\d`}
+	)
+
+	initTestWatch(t)
+	shardDir := stateShardDir(FileStateFilePath)
+	config.GlobalConfig.Watch.StateShard = true
+	for i, indexName := range indexes {
+		path := filepath.Join(dir, fmt.Sprintf("%d.log", i))
+		GlobalFileStates[path] = &FileState{Path: path, Offset: int64(i + 1), IndexName: indexName}
+	}
+
+	if err := SaveGlobalFileStatesToDiskFile(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// 所有分片都在分片目录下, 权限不超过0644(受umask影响), 不留下临时文件
+	entries, err := os.ReadDir(shardDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(indexes) {
+		t.Errorf("every index_name should have its own shard in %s, got %v", shardDir, entries)
+	}
+	for _, entry := range entries {
+		info, _ := entry.Info()
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || filepath.Ext(entry.Name()) != ".json" {
+			t.Errorf("unexpected entry in shard dir: %s", entry.Name())
+		}
+		if info != nil && info.Mode().Perm()&0133 != 0 {
+			t.Errorf("shard %s should be 0644 at most, got %v", entry.Name(), info.Mode().Perm())
+		}
+	}
+	if k3.FileExists(filepath.Join(filepath.Dir(FileStateFilePath), "escape.json")) {
+		t.Error("shard should not be written outside the shard dir")
+	}
+
+	// 重新加载时还原index_name
+	GlobalFileStates = make(map[string]*FileState)
+	resetStateShards()
+	if err = LoadDiskFileToGlobalFileStates(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	for i, indexName := range indexes {
+		fileState := GlobalFileStates[filepath.Join(dir, fmt.Sprintf("%d.log", i))]
+		if fileState == nil || fileState.IndexName != indexName || fileState.Offset != int64(i+1) {
+			t.Errorf("state of %q should be restored, got %+v", indexName, fileState)
+		}
+	}
+}
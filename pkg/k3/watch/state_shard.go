@@ -0,0 +1,197 @@
+package watch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	DefaultStateShardDir = "state" // 按index_name拆分的状态文件目录, 位于状态文件所在目录下
+)
+
+var (
+	stateShardContents map[string][]byte // index_name -> 最近一次写入分片的内容, 内容没有变化的分片不重新写入, 需要持有GlobalFileStatesLock
+)
+
+// resetStateShards 清空已经写入的分片记录, InitVars时调用
+func resetStateShards() {
+	stateShardContents = make(map[string][]byte)
+}
+
+// stateShardDir 分片所在的目录, 每个index_name一个文件, 如state/index_nginx.json
+func stateShardDir(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), DefaultStateShardDir)
+}
+
+// stateShardPath 分片的路径, index_name转义后作为文件名, 包含/或者..时也不会写到分片目录之外
+func stateShardPath(filePath, indexName string) string {
+	return filepath.Join(stateShardDir(filePath), escapeStateShardName(indexName)+".json")
+}
+
+// escapeStateShardName 转义index_name中的路径分隔符等字符, 开头的.也转义, 避免出现.、..和隐藏文件
+// 只包含字母、数字、-和_的index_name保持不变, 兼容之前写入的分片
+func escapeStateShardName(indexName string) string {
+	name := url.PathEscape(indexName)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+// unescapeStateShardName escapeStateShardName的逆操作, 无法解析时使用原始的文件名
+func unescapeStateShardName(name string) string {
+	if indexName, err := url.PathUnescape(name); err == nil {
+		return indexName
+	}
+	return name
+}
+
+// loadStateShards 加载并合并所有分片到GlobalFileStates, 没有任何分片时loaded为false, 由调用方加载单个状态文件(从单文件模式迁移)
+// 开启recover_corrupt_state时, 无法解析的分片备份后跳过, 不影响其他分片; 调用方需要持有GlobalFileStatesLock
+func loadStateShards(filePath string) (loaded bool, err error) {
+	var (
+		entries   []os.DirEntry
+		content   []byte
+		stateFile *StateFile
+	)
+
+	if entries, err = os.ReadDir(stateShardDir(filePath)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("read state shard dir failed: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		var (
+			path      = filepath.Join(stateShardDir(filePath), entry.Name())
+			indexName = unescapeStateShardName(strings.TrimSuffix(entry.Name(), ".json"))
+		)
+
+		if content, err = os.ReadFile(path); err != nil {
+			return false, fmt.Errorf("read state shard[%s] failed: %w", path, err)
+		}
+
+		// 记录磁盘上已经存在的分片, 之后没有文件状态时删除
+		stateShardContents[indexName] = nil
+		loaded = true
+
+		if len(bytes.TrimSpace(content)) == 0 {
+			continue
+		}
+
+		if stateFile, _, err = decodeStateFile(content); err != nil {
+			if !config.GlobalConfig.Watch.RecoverCorruptState {
+				return false, fmt.Errorf("state shard[%s]: %w", path, err)
+			}
+			if err = backupCorruptStateFile(path, err); err != nil {
+				return false, err
+			}
+			delete(stateShardContents, indexName)
+			continue
+		}
+
+		for path, fileState := range stateFile.fileStates() {
+			GlobalFileStates[path] = fileState
+		}
+	}
+
+	return loaded, nil
+}
+
+// writeStateShards 按照FileState.IndexName将GlobalFileStates写入各自的分片, 只写入内容有变化的分片
+// 一个分片写入失败不影响其他分片, 没有文件状态的index_name删除分片; 调用方需要持有GlobalFileStatesLock
+func writeStateShards(filePath string) error {
+	var (
+		shards = make(map[string]map[string]*FileState)
+		errs   []error
+	)
+
+	for path, fileState := range GlobalFileStates {
+		if _, exists := shards[fileState.IndexName]; !exists {
+			shards[fileState.IndexName] = make(map[string]*FileState)
+		}
+		shards[fileState.IndexName][path] = fileState
+	}
+
+	if err := os.MkdirAll(stateShardDir(filePath), os.ModePerm); err != nil {
+		return fmt.Errorf("create state shard dir failed: %w", err)
+	}
+
+	for indexName, fileStates := range shards {
+		var buffer bytes.Buffer
+
+		if err := json.NewEncoder(&buffer).Encode(newStateFile(fileStates)); err != nil {
+			errs = append(errs, fmt.Errorf("json encode state shard[%s] failed: %w", indexName, err))
+			continue
+		}
+
+		if last, exists := stateShardContents[indexName]; exists && bytes.Equal(last, buffer.Bytes()) {
+			continue
+		}
+
+		if err := writeStateShard(stateShardPath(filePath, indexName), buffer.Bytes()); err != nil {
+			errs = append(errs, fmt.Errorf("write state shard[%s] failed: %w", indexName, err))
+			continue
+		}
+		stateShardContents[indexName] = buffer.Bytes()
+	}
+
+	for indexName := range stateShardContents {
+		if _, exists := shards[indexName]; exists {
+			continue
+		}
+
+		if err := os.Remove(stateShardPath(filePath, indexName)); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("remove state shard[%s] failed: %w", indexName, err))
+			continue
+		}
+		k3.K3LogDebug("[writeStateShards] index_name[%s] has no file state, remove state shard.", indexName)
+		delete(stateShardContents, indexName)
+	}
+
+	return errors.Join(errs...)
+}
+
+// writeStateShard 先写入同一目录下的临时文件再重命名, 写入过程中退出时不会留下不完整的分片
+func writeStateShard(path string, content []byte) error {
+	var (
+		tmpPath = path + ".tmp"
+		tmp     *os.File
+		err     error
+	)
+
+	if tmp, err = os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
+		return err
+	}
+
+	if _, err = tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if err = errors.Join(tmp.Sync(), tmp.Close()); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
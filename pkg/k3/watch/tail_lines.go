@@ -0,0 +1,90 @@
+package watch
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+var (
+	TailLinesChunkSize int64 = 64 * 1024 // 从文件末尾向前查找分隔符时每次读取的字节数
+)
+
+// tailLinesOffset 从文件末尾向前查找lines个分隔符, 返回倒数第lines行的开始位置, 文件不足lines行时返回0
+// 文件以分隔符结尾时, 最后一个分隔符属于最后一行; 没有以分隔符结尾时, 没有写完的最后一行也算作一行
+func tailLinesOffset(path string, lines int, delimiter string) (int64, error) {
+	var (
+		fd    *os.File
+		info  os.FileInfo
+		delim = []byte(delimiter)
+		found int
+		err   error
+	)
+
+	if lines <= 0 {
+		return 0, nil
+	}
+
+	if len(delim) == 0 {
+		delim = []byte(DefaultLineDelimiter)
+	}
+
+	if fd, err = os.Open(path); err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	if info, err = fd.Stat(); err != nil {
+		return 0, err
+	}
+
+	var (
+		limit = info.Size() // 只查找结束位置不超过limit的分隔符
+		size  = int64(len(delim))
+		buf   = make([]byte, TailLinesChunkSize+size-1)
+	)
+
+	if limit >= size {
+		tail := make([]byte, size)
+		if _, err = fd.ReadAt(tail, limit-size); err != nil && err != io.EOF {
+			return 0, err
+		}
+		if bytes.Equal(tail, delim) {
+			limit -= size
+		}
+	}
+
+	// 每次读取[start, pos+len(delim)-1), 只统计开始位置在[start, pos)之间的分隔符, 跨越两次读取的分隔符不会重复统计
+	for pos := limit; pos > 0; {
+		start := pos - TailLinesChunkSize
+		if start < 0 {
+			start = 0
+		}
+
+		end := pos + size - 1
+		if end > limit {
+			end = limit
+		}
+
+		chunk := buf[:end-start]
+		if _, err = fd.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		for i := len(chunk); ; {
+			index := bytes.LastIndex(chunk[:i], delim)
+			if index < 0 {
+				break
+			}
+
+			if found++; found == lines {
+				return start + int64(index) + size, nil
+			}
+			i = index
+		}
+
+		pos = start
+	}
+
+	return 0, nil
+}
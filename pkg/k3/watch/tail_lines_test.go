@@ -0,0 +1,108 @@
+package watch
+
+import (
+	"fmt"
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTailLinesOffset(t *testing.T) {
+	var (
+		dir       = t.TempDir()
+		chunkSize = TailLinesChunkSize
+	)
+
+	// 分隔符跨越两次读取
+	TailLinesChunkSize = 3
+	defer func() { TailLinesChunkSize = chunkSize }()
+
+	for _, item := range []struct {
+		content   string
+		delimiter string
+		lines     int
+		expected  string // 从返回的offset开始读取的内容
+	}{
+		{"a\nbb\nccc\n", "\n", 1, "ccc\n"},
+		{"a\nbb\nccc\n", "\n", 2, "bb\nccc\n"},
+		{"a\nbb\nccc\n", "\n", 3, "a\nbb\nccc\n"},
+		{"a\nbb\nccc\n", "\n", 10, "a\nbb\nccc\n"},
+		{"a\nbb\nccc", "\n", 1, "ccc"},
+		{"a\nbb\nccc", "\n", 2, "bb\nccc"},
+		{"a||bb||ccc||", "||", 2, "bb||ccc||"},
+		{"\n\n\n", "\n", 2, "\n\n"},
+		{"", "\n", 1, ""},
+	} {
+		path := filepath.Join(dir, "app.log")
+		if err := os.WriteFile(path, []byte(item.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		offset, err := tailLinesOffset(path, item.lines, item.delimiter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := item.content[offset:]; actual != item.expected {
+			t.Errorf("last %d lines of %q expected %q, got %q", item.lines, item.content, item.expected, actual)
+		}
+	}
+}
+
+func TestInitialTailLines(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		path     = filepath.Join(dir, "app.log")
+		recorded = filepath.Join(dir, "recorded.log")
+		lines    []string
+	)
+
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	appendLines(t, path, lines...)
+	appendLines(t, recorded, lines...)
+
+	initTestWatch(t)
+	config.GlobalConfig.Watch.InitialTailLines = 10
+
+	// 已经记录了offset的文件不受影响
+	GlobalFileStates[recorded] = &FileState{Path: recorded, Offset: 7, IndexName: "index_test"}
+
+	if err := ScanLogFileToGlobalFileStatesAndSaveToDiskFile(map[string][]string{"index_test": {dir}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// 最后10行从line 90开始
+	expected := int64(len(strings.Join(lines[:90], "\n")) + 1)
+	if offset := GlobalFileStates[path].Offset; offset != expected {
+		t.Errorf("new file should start at the last 10 lines, expected offset %d, got %d", expected, offset)
+	}
+	if offset := GlobalFileStates[recorded].Offset; offset != 7 {
+		t.Errorf("recorded offset should be kept, got %d", offset)
+	}
+}
+
+func TestRescanIgnoresInitialTailLines(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		path = filepath.Join(dir, "app.log")
+	)
+
+	initTestWatch(t)
+	config.GlobalConfig.Watch.InitialTailLines = 1
+
+	if err := ScanLogFileToGlobalFileStatesAndSaveToDiskFile(map[string][]string{"index_test": {dir}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// 定时扫描时新发现的文件从头读取
+	appendLines(t, path, "line 1", "line 2")
+	if err := scanLogFiles(map[string][]string{"index_test": {dir}}, FileStateFilePath, false); err != nil {
+		t.Fatal(err)
+	}
+	if offset := GlobalFileStates[path].Offset; offset != 0 {
+		t.Errorf("file discovered by rescan should be read from beginning, got offset %d", offset)
+	}
+}
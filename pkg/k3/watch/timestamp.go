@@ -0,0 +1,119 @@
+package watch
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timestamp_layout支持的常用格式名称, 其它值作为go的时间layout使用
+var (
+	TimestampLayouts = map[string]string{
+		"RFC3339":     time.RFC3339Nano,
+		"nginx":       "02/Jan/2006:15:04:05 -0700",
+		"datetime":    "2006-01-02 15:04:05",
+		"datetime_ms": "2006-01-02 15:04:05.000",
+	}
+
+	// DefaultTimestampLayouts 没有配置timestamp_layout时依次尝试的格式
+	DefaultTimestampLayouts = []string{
+		time.RFC3339Nano,
+		"02/Jan/2006:15:04:05 -0700",
+		"2006-01-02 15:04:05.000",
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05",
+	}
+)
+
+// 时间戳格式, 数字类型的时间戳
+const (
+	TimestampUnix   = "unix"    // 秒
+	TimestampUnixMs = "unix_ms" // 毫秒
+)
+
+// compileTimestamp 编译timestamp_regexp, 正则中有分组时使用第一个分组
+func compileTimestamp(indexName, pattern string) (*regexp.Regexp, error) {
+	var (
+		re  *regexp.Regexp
+		err error
+	)
+
+	if len(pattern) == 0 {
+		return nil, nil
+	}
+
+	if re, err = regexp.Compile(pattern); err != nil {
+		return nil, fmt.Errorf("[NewIndexRule] index_name[%s] invalid timestamp_regexp: %w", indexName, err)
+	}
+
+	return re, nil
+}
+
+// extractTimestamp 从日志中解析日志时间, json/logfmt格式从timestamp_field中获取, 文本日志使用timestamp_regexp匹配
+// 没有配置或者解析失败时返回false, 使用读取时间
+func extractTimestamp(rule *IndexRule, line string, fields map[string]interface{}) (time.Time, bool) {
+	var value interface{}
+
+	if len(rule.TimestampField) > 0 && fields != nil {
+		value = fields[rule.TimestampField]
+	} else if rule.timestampRegexp != nil {
+		if match := rule.timestampRegexp.FindStringSubmatch(line); len(match) > 1 {
+			value = match[1]
+		} else if len(match) == 1 {
+			value = match[0]
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return parseTimestamp(strings.TrimSpace(v), rule.TimestampLayout)
+	case float64:
+		return parseUnixTimestamp(v, rule.TimestampLayout), true
+	}
+
+	return time.Time{}, false
+}
+
+// parseTimestamp 按照layout解析时间, 没有配置layout时依次尝试常用格式, 没有时区的时间使用本地时区
+func parseTimestamp(value, layout string) (time.Time, bool) {
+	var layouts = DefaultTimestampLayouts
+
+	if len(value) == 0 {
+		return time.Time{}, false
+	}
+
+	switch layout {
+	case "":
+	case TimestampUnix, TimestampUnixMs:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return parseUnixTimestamp(number, layout), true
+	default:
+		if named, exists := TimestampLayouts[layout]; exists {
+			layout = named
+		}
+		layouts = []string{layout}
+	}
+
+	for _, layout := range layouts {
+		if timestamp, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return timestamp, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// parseUnixTimestamp 数字类型的时间戳, 没有配置unix/unix_ms时, 超过1e12按照毫秒处理
+func parseUnixTimestamp(value float64, layout string) time.Time {
+	if layout == TimestampUnixMs || (layout != TimestampUnix && value > 1e12) {
+		return time.UnixMilli(int64(value))
+	}
+
+	seconds := int64(value)
+	return time.Unix(seconds, int64((value-float64(seconds))*1e9))
+}
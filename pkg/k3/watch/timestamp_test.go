@@ -0,0 +1,92 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExtractTimestamp(t *testing.T) {
+	var (
+		utc8     = time.FixedZone("", 8*3600)
+		expected = time.Date(2024, 10, 16, 8, 30, 15, 0, utc8)
+	)
+
+	for _, item := range []struct {
+		name     string
+		index    config.Index
+		line     string
+		expected time.Time
+	}{
+		{"nginx", config.Index{TimestampRegexp: `\[([^\]]+)\]`, TimestampLayout: "nginx"},
+			`10.0.0.1 - - [16/Oct/2024:08:30:15 +0800] "GET /api HTTP/1.1" 200 512`, expected},
+		{"nginx default layouts", config.Index{TimestampRegexp: `\[([^\]]+)\]`},
+			`10.0.0.1 - - [16/Oct/2024:08:30:15 +0800] "GET /api HTTP/1.1" 200 512`, expected},
+		{"json RFC3339", config.Index{Format: FormatJSON, TimestampField: "time"},
+			`{"time": "2024-10-16T08:30:15+08:00", "msg": "ok"}`, expected},
+		{"json unix_ms", config.Index{Format: FormatJSON, TimestampField: "ts"},
+			`{"ts": 1729038615000, "msg": "ok"}`, expected},
+		{"logfmt unix", config.Index{Format: FormatLogfmt, TimestampField: "ts", TimestampLayout: TimestampUnix},
+			`ts=1729038615 level=info`, expected},
+		{"java datetime_ms", config.Index{TimestampRegexp: `^\S+ \S+`, TimestampLayout: "datetime_ms"},
+			`2024-10-16 08:30:15.000 ERROR [main] c.e.App - failed`, time.Date(2024, 10, 16, 8, 30, 15, 0, time.Local)},
+		{"custom layout", config.Index{TimestampRegexp: `^(\w+ +\d+ [\d:]+)`, TimestampLayout: "Jan _2 15:04:05"},
+			`Oct 16 08:30:15 host sshd[1]: accepted`, time.Date(0, 10, 16, 8, 30, 15, 0, time.Local)},
+	} {
+		rule, err := NewIndexRule("index_test", item.index)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		timestamp, ok := extractTimestamp(rule, item.line, mergeParsedFields(rule, item.line, map[string]interface{}{}))
+		if !ok || !timestamp.Equal(item.expected) {
+			t.Errorf("%s: expected %v, got %v %v", item.name, item.expected, timestamp, ok)
+		}
+	}
+
+	// 没有匹配或者格式不对时解析失败
+	for _, item := range []struct {
+		index config.Index
+		line  string
+	}{
+		{config.Index{}, `2024-10-16 08:30:15 no rule`},
+		{config.Index{TimestampRegexp: `\[([^\]]+)\]`}, `no brackets`},
+		{config.Index{TimestampRegexp: `\[([^\]]+)\]`, TimestampLayout: "nginx"}, `[not a time]`},
+		{config.Index{Format: FormatJSON, TimestampField: "time"}, `{"msg": "no time"}`},
+	} {
+		rule, _ := NewIndexRule("index_test", item.index)
+		if timestamp, ok := extractTimestamp(rule, item.line, mergeParsedFields(rule, item.line, map[string]interface{}{})); ok {
+			t.Errorf("%q should not be parsed, got %v", item.line, timestamp)
+		}
+	}
+
+	if _, err := NewIndexRule("index_test", config.Index{TimestampRegexp: `[`}); err == nil {
+		t.Errorf("invalid timestamp_regexp should return error")
+	}
+}
+
+func TestTimestampFromLine(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+		start    = time.Now()
+	)
+
+	if err := InitIndexRules(map[string]config.Index{"index_test": {TimestampRegexp: `\[([^\]]+)\]`, TimestampLayout: "nginx"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, `10.0.0.1 - - [16/Oct/2024:08:30:15 +0800] "GET / HTTP/1.1" 200 1`, `no timestamp`)
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	// 解析出的时间作为日志时间, 解析失败时使用读取时间
+	if expected := time.Date(2024, 10, 16, 0, 30, 15, 0, time.UTC); !consumer.datas[0].Timestamp.Equal(expected) {
+		t.Errorf("timestamp should be parsed from line, got %v", consumer.datas[0].Timestamp)
+	}
+	if timestamp := consumer.datas[1].Timestamp; timestamp.Before(start) || timestamp.After(time.Now()) {
+		t.Errorf("timestamp should fall back to ingest time, got %v", timestamp)
+	}
+}
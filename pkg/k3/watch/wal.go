@@ -0,0 +1,444 @@
+package watch
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// wal 文件中记录的类型
+const (
+	walOpAppend = "append" // 数据交给consumer之前写入
+	walOpAck    = "ack"    // sender确认接收后写入
+)
+
+var (
+	DefaultWalDir              = "wal"    // wal文件目录, 位于状态文件所在目录下
+	DefaultWalCompactThreshold = 10000    // wal文件中已确认的记录超过该数量时重写wal文件
+	DefaultQueueWalName        = "_queue" // consumer.durable_queue开启时, 所有没有单独开启wal的index_name共用的wal
+)
+
+var (
+	walsLock   = &sync.RWMutex{}
+	GlobalWals = make(map[string]*Wal) // index_name -> wal, 只包含开启wal的index_name, 以及durable_queue共用的wal
+)
+
+// walRecord wal文件中的一行记录
+type walRecord struct {
+	Op   string         `json:"op"`
+	UUID string         `json:"uuid"`
+	Data *protocol.Data `json:"data,omitempty"`
+}
+
+// Wal 单个index_name的预写日志, 数据交给consumer之前先追加到wal文件, sender确认接收后写入ack记录
+// 重启时重放所有没有ack的数据, 保证进程崩溃时不丢数据(at-least-once, 可能重复发送)
+type Wal struct {
+	lock    *sync.Mutex
+	path    string
+	fd      *os.File
+	pending map[string]protocol.Data // uuid -> 待确认的数据
+	order   []string                 // 数据的写入顺序, 重放时保持顺序, 包含已确认的uuid
+}
+
+// OpenWal 打开wal文件, 加载没有确认的数据, 并重写wal文件只保留没有确认的数据
+func OpenWal(path string) (*Wal, error) {
+	var (
+		wal = &Wal{
+			lock:    &sync.Mutex{},
+			path:    path,
+			pending: make(map[string]protocol.Data),
+		}
+		err error
+	)
+
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("[OpenWal] create wal dir failed: %w", err)
+	}
+
+	if err = wal.load(); err != nil {
+		return nil, err
+	}
+
+	if err = wal.rewrite(); err != nil {
+		return nil, err
+	}
+
+	return wal, nil
+}
+
+// load 读取wal文件中的所有记录, 进程崩溃时最后一行可能没有写完, 无法解析的记录直接跳过
+func (w *Wal) load() error {
+	var (
+		fd     *os.File
+		reader *bufio.Reader
+		line   []byte
+		record walRecord
+		err    error
+	)
+
+	if fd, err = os.Open(w.path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("[Wal.load] open wal file failed: %w", err)
+	}
+	defer fd.Close()
+
+	reader = bufio.NewReader(fd)
+	for {
+		line, err = reader.ReadBytes('\n')
+		if len(line) > 0 {
+			record = walRecord{}
+			if jsonErr := json.Unmarshal(line, &record); jsonErr != nil {
+				k3.K3LogWarn("[Wal.load] skip broken record in %s: %s", w.path, jsonErr.Error())
+			} else {
+				w.apply(record)
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("[Wal.load] read wal file failed: %w", err)
+		}
+	}
+}
+
+// apply 将一条记录应用到内存中的待确认数据
+func (w *Wal) apply(record walRecord) {
+	switch record.Op {
+	case walOpAppend:
+		if record.Data == nil {
+			return
+		}
+		if _, exists := w.pending[record.UUID]; !exists {
+			w.order = append(w.order, record.UUID)
+		}
+		w.pending[record.UUID] = *record.Data
+	case walOpAck:
+		delete(w.pending, record.UUID)
+	}
+}
+
+// rewrite 重写wal文件, 只保留待确认的数据, 调用方需要持有锁(或者还没有并发访问)
+func (w *Wal) rewrite() error {
+	var (
+		tmpPath = w.path + ".tmp"
+		tmp     *os.File
+		writer  *bufio.Writer
+		order   []string
+		err     error
+	)
+
+	if tmp, err = os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666); err != nil {
+		return fmt.Errorf("[Wal.rewrite] create wal file failed: %w", err)
+	}
+
+	writer = bufio.NewWriter(tmp)
+	for _, uuid := range w.order {
+		data, ok := w.pending[uuid]
+		if !ok {
+			continue
+		}
+		if err = writeWalRecord(writer, walRecord{Op: walOpAppend, UUID: uuid, Data: &data}); err != nil {
+			_ = tmp.Close()
+			return fmt.Errorf("[Wal.rewrite] write wal file failed: %w", err)
+		}
+		order = append(order, uuid)
+	}
+
+	if err = errors.Join(writer.Flush(), tmp.Sync(), tmp.Close()); err != nil {
+		return fmt.Errorf("[Wal.rewrite] write wal file failed: %w", err)
+	}
+
+	if err = os.Rename(tmpPath, w.path); err != nil {
+		return fmt.Errorf("[Wal.rewrite] rename wal file failed: %w", err)
+	}
+
+	if w.fd != nil {
+		_ = w.fd.Close()
+	}
+
+	if w.fd, err = os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND, 0666); err != nil {
+		return fmt.Errorf("[Wal.rewrite] open wal file failed: %w", err)
+	}
+	w.order = order
+
+	return nil
+}
+
+// writeWalRecord 写入一行记录
+func writeWalRecord(writer io.Writer, record walRecord) error {
+	var (
+		content []byte
+		err     error
+	)
+
+	if content, err = json.Marshal(record); err != nil {
+		return err
+	}
+
+	_, err = writer.Write(append(content, '\n'))
+	return err
+}
+
+// Append 数据交给consumer之前写入wal
+func (w *Wal) Append(data protocol.Data) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := writeWalRecord(w.fd, walRecord{Op: walOpAppend, UUID: data.UUID, Data: &data}); err != nil {
+		return fmt.Errorf("[Wal.Append] write wal file failed: %w", err)
+	}
+
+	if _, exists := w.pending[data.UUID]; !exists {
+		w.order = append(w.order, data.UUID)
+	}
+	w.pending[data.UUID] = data
+
+	return nil
+}
+
+// Ack sender确认接收后移除数据, 没有待确认的数据时清空wal文件
+func (w *Wal) Ack(uuid string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if _, exists := w.pending[uuid]; !exists {
+		return nil
+	}
+	delete(w.pending, uuid)
+
+	if len(w.pending) == 0 {
+		if err := w.fd.Truncate(0); err != nil {
+			return fmt.Errorf("[Wal.Ack] truncate wal file failed: %w", err)
+		}
+		w.order = nil
+		return nil
+	}
+
+	// 已确认的记录过多时重写wal文件, 避免wal文件无限增长
+	if len(w.order)-len(w.pending) >= DefaultWalCompactThreshold {
+		return w.rewrite()
+	}
+
+	if err := writeWalRecord(w.fd, walRecord{Op: walOpAck, UUID: uuid}); err != nil {
+		return fmt.Errorf("[Wal.Ack] write wal file failed: %w", err)
+	}
+
+	return nil
+}
+
+// Pending 按写入顺序返回所有待确认的数据
+func (w *Wal) Pending() []protocol.Data {
+	var (
+		datas []protocol.Data
+	)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for _, uuid := range w.order {
+		if data, ok := w.pending[uuid]; ok {
+			datas = append(datas, data)
+		}
+	}
+
+	return datas
+}
+
+// Close 关闭wal文件, 待确认的数据保留在wal文件中, 下次启动时重放
+func (w *Wal) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.fd == nil {
+		return nil
+	}
+
+	err := w.fd.Close()
+	w.fd = nil
+	return err
+}
+
+// walPath index_name对应的wal文件路径
+func walPath(indexName string) string {
+	return filepath.Join(filepath.Dir(FileStateFilePath), DefaultWalDir, indexName+".wal")
+}
+
+// InitWals 打开所有开启wal的index_name的wal文件, 需要在InitIndexRules之后调用
+func InitWals() error {
+	var (
+		wals = make(map[string]*Wal)
+		wal  *Wal
+		err  error
+	)
+
+	indexRulesLock.RLock()
+	defer indexRulesLock.RUnlock()
+
+	for indexName, rule := range GlobalIndexRules {
+		if !rule.Wal {
+			continue
+		}
+
+		if wal, err = OpenWal(walPath(indexName)); err != nil {
+			_ = closeWals(wals)
+			return fmt.Errorf("[InitWals] index_name[%s] open wal failed: %w", indexName, err)
+		}
+		wals[indexName] = wal
+	}
+
+	// 开启durable_queue时, 交给consumer的所有数据都先写入wal, 进程崩溃时consumer缓存中的数据重启后重放
+	if config.GlobalConfig.Consumer.DurableQueue {
+		if wal, err = OpenWal(walPath(DefaultQueueWalName)); err != nil {
+			_ = closeWals(wals)
+			return fmt.Errorf("[InitWals] open durable queue wal failed: %w", err)
+		}
+		wals[DefaultQueueWalName] = wal
+	}
+
+	walsLock.Lock()
+	GlobalWals = wals
+	walsLock.Unlock()
+
+	return nil
+}
+
+// getWal 获取index_name对应的wal, 没有单独开启wal时使用durable_queue的wal, 都没有开启返回nil
+func getWal(indexName string) *Wal {
+	walsLock.RLock()
+	defer walsLock.RUnlock()
+
+	if wal, exists := GlobalWals[indexName]; exists {
+		return wal
+	}
+	return GlobalWals[DefaultQueueWalName]
+}
+
+// getQueueWal 获取durable_queue的wal, 没有开启返回nil
+func getQueueWal() *Wal {
+	walsLock.RLock()
+	defer walsLock.RUnlock()
+	return GlobalWals[DefaultQueueWalName]
+}
+
+// ReplayWals 将所有wal中没有确认的数据重新交给consumer, 数据已经在wal中, 直接交给不写wal的consumer
+func ReplayWals(consumer protocol.K3Consumer) error {
+	var (
+		errs []error
+		wals = make(map[string]*Wal)
+	)
+
+	// consumer.Add可能触发提交并回调ackWals, 重放时不能持有walsLock
+	walsLock.RLock()
+	for indexName, wal := range GlobalWals {
+		wals[indexName] = wal
+	}
+	walsLock.RUnlock()
+
+	for indexName, wal := range wals {
+		datas := wal.Pending()
+		if len(datas) > 0 {
+			k3.K3LogInfo("[ReplayWals] index_name[%s] replay %d unconfirmed records", indexName, len(datas))
+		}
+
+		for _, data := range datas {
+			if err := consumer.Add(data); err != nil {
+				errs = append(errs, fmt.Errorf("[ReplayWals] index_name[%s] replay failed: %w", indexName, err))
+				break
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ackWals consumer的OnSend回调, sender确认接收后从wal中移除, 发送失败的数据保留在wal中, 重启时重放
+// 返回BulkError时, 只有其中的Failed保留
+func ackWals(datas []protocol.Data, err error) {
+	for _, data := range deliveredDatas(datas, err) {
+		if wal := getWal(data.IndexName); wal != nil {
+			if err = wal.Ack(data.UUID); err != nil {
+				k3.K3LogError("[ackWals] %s", err.Error())
+			}
+		}
+
+		// 重启前后index_name的wal配置变化时, 重放的数据可能来自durable_queue的wal, 没有待确认的数据时Ack不做处理
+		if wal := getQueueWal(); wal != nil && wal != getWal(data.IndexName) {
+			if err = wal.Ack(data.UUID); err != nil {
+				k3.K3LogError("[ackWals] %s", err.Error())
+			}
+		}
+	}
+}
+
+// CloseWals 关闭所有wal文件
+func CloseWals() error {
+	walsLock.Lock()
+	defer walsLock.Unlock()
+
+	err := closeWals(GlobalWals)
+	GlobalWals = make(map[string]*Wal)
+	return err
+}
+
+func closeWals(wals map[string]*Wal) error {
+	var (
+		errs []error
+	)
+
+	for _, wal := range wals {
+		if err := wal.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// walConsumer 开启wal的index_name, 数据先写入wal再交给consumer
+type walConsumer struct {
+	consumer protocol.K3Consumer
+}
+
+func (w *walConsumer) Add(data protocol.Data) error {
+	if wal := getWal(data.IndexName); wal != nil {
+		if err := wal.Append(data); err != nil {
+			return err
+		}
+	}
+	return w.consumer.Add(data)
+}
+
+func (w *walConsumer) Flush() error {
+	return w.consumer.Flush()
+}
+
+func (w *walConsumer) Drain() error {
+	if drain, ok := w.consumer.(protocol.K3Drain); ok {
+		return drain.Drain()
+	}
+	return w.consumer.Flush()
+}
+
+func (w *walConsumer) QueueDepth() int {
+	if queue, ok := w.consumer.(protocol.K3QueueDepth); ok {
+		return queue.QueueDepth()
+	}
+	return 0
+}
+
+func (w *walConsumer) Close() error {
+	return w.consumer.Close()
+}
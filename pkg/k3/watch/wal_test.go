@@ -0,0 +1,254 @@
+package watch
+
+import (
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"log-engine-sdk/pkg/k3/sender"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// nopSender 测试用sender, 直接确认接收所有数据
+type nopSender struct{}
+
+func (s *nopSender) Send(data []protocol.Data) error {
+	return nil
+}
+
+func (s *nopSender) Close() error {
+	return nil
+}
+
+func TestWalReplayAfterCrash(t *testing.T) {
+	var (
+		path     = filepath.Join(t.TempDir(), "app.log")
+		consumer protocol.K3Consumer
+		err      error
+	)
+
+	initTestWatch(t)
+	if err = InitIndexRules(map[string]config.Index{"index_wal": {Wal: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = InitWals(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = CloseWals() })
+
+	if consumer, err = k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{
+		Sender:    &nopSender{},
+		BatchSize: 2,
+		OnSend:    ackWals,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(&walConsumer{consumer: consumer})
+
+	// 批量为2, 前两行发送并确认, 第三行还在consumer的缓存中
+	appendLines(t, path, "line 1", "line 2", "line 3")
+	writeEvent("index_wal", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	// 模拟进程崩溃: consumer没有关闭, 缓存中的数据丢失
+	if err = CloseWals(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后重放没有确认的数据
+	if err = InitWals(); err != nil {
+		t.Fatal(err)
+	}
+	replayed := &captureConsumer{}
+	if err = ReplayWals(replayed); err != nil {
+		t.Fatal(err)
+	}
+
+	assertLines(t, replayed, "line 3")
+	if replayed.datas[0].IndexName != "index_wal" {
+		t.Errorf("replayed data should keep index name, got %s", replayed.datas[0].IndexName)
+	}
+}
+
+func TestWalWithoutIndexOption(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+
+	if err := InitWals(); err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(&walConsumer{consumer: consumer})
+
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	assertLines(t, consumer, "line 1")
+	if _, err := os.Stat(walPath("index_test")); !os.IsNotExist(err) {
+		t.Errorf("wal file should not be created for index without wal, got %v", err)
+	}
+}
+
+func TestWalBrokenRecordAndAck(t *testing.T) {
+	var (
+		path = filepath.Join(t.TempDir(), "wal", "index_wal.wal")
+		wal  *Wal
+		err  error
+	)
+
+	if wal, err = OpenWal(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, uuid := range []string{"1", "2", "3"} {
+		if err = wal.Append(protocol.Data{UUID: uuid, IndexName: "index_wal"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = wal.Ack("1")
+	_ = wal.Close()
+
+	// 进程崩溃时最后一条记录只写了一半
+	appendLines(t, path, `{"op":"append","uuid":"4","da`)
+
+	if wal, err = OpenWal(path); err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	pending := wal.Pending()
+	if len(pending) != 2 || pending[0].UUID != "2" || pending[1].UUID != "3" {
+		t.Fatalf("pending should be 2, 3, got %v", pending)
+	}
+
+	// 发送失败的数据保留在wal中
+	walsLock.Lock()
+	GlobalWals = map[string]*Wal{"index_wal": wal}
+	walsLock.Unlock()
+	defer func() {
+		walsLock.Lock()
+		GlobalWals = make(map[string]*Wal)
+		walsLock.Unlock()
+	}()
+
+	ackWals(pending, errors.New("send failed"))
+	if len(wal.Pending()) != 2 {
+		t.Errorf("failed data should be kept in wal, got %v", wal.Pending())
+	}
+
+	// 全部确认后清空wal文件
+	ackWals(pending, nil)
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Errorf("wal file should be empty after all acked, got %v, %v", info, err)
+	}
+}
+
+func TestWalAckBulkPartialFailure(t *testing.T) {
+	var (
+		datas = []protocol.Data{{UUID: "1", IndexName: "index_wal"}, {UUID: "2", IndexName: "index_wal"}, {UUID: "3", IndexName: "index_wal"}}
+		err   error
+	)
+
+	initTestWatch(t)
+	if err = InitIndexRules(map[string]config.Index{"index_wal": {Wal: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = InitWals(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = CloseWals() })
+
+	for _, data := range datas {
+		if err = getWal("index_wal").Append(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 只有uuid为2的数据写入失败, 其余的数据确认
+	ackWals(datas, &sender.BulkError{Failed: datas[1:2]})
+
+	pending := getWal("index_wal").Pending()
+	if len(pending) != 1 || pending[0].UUID != "2" {
+		t.Errorf("only the failed data should stay in wal, got %v", pending)
+	}
+
+	// 整批失败时不确认
+	ackWals(datas[1:2], errors.New("elk unavailable"))
+	if pending = getWal("index_wal").Pending(); len(pending) != 1 {
+		t.Errorf("failed batch should stay in wal, got %v", pending)
+	}
+}
+
+func TestDurableQueueReplayAfterCrash(t *testing.T) {
+	var (
+		path     = filepath.Join(t.TempDir(), "app.log")
+		received = &recordSender{}
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	initTestWatch(t)
+	config.GlobalConfig.Consumer.DurableQueue = true
+	t.Cleanup(func() {
+		config.GlobalConfig.Consumer.DurableQueue = false
+		_ = CloseWals()
+	})
+
+	// start 模拟进程启动: 打开wal, 创建consumer并重放上次没有确认的数据
+	start := func() {
+		if err := InitWals(); err != nil {
+			t.Fatal(err)
+		}
+		consumer, err := k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{
+			Sender:    received,
+			BatchSize: 2,
+			OnSend:    ackWals,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ReplayWals(consumer); err != nil {
+			t.Fatal(err)
+		}
+		GlobalDataAnalytics = k3.NewDataAnalytics(&walConsumer{consumer: consumer})
+	}
+
+	// 没有单独开启wal的index_name也写入durable_queue, 批量为2, 第三行还在consumer的缓存中时进程崩溃
+	start()
+	appendLines(t, path, "line 1", "line 2", "line 3")
+	writeEvent("index_test", event)
+	processingWg.Wait()
+	if err := CloseWals(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后第三行重放, 与新的一行一起发送, 第五行在缓存中时再次崩溃
+	start()
+	appendLines(t, path, "line 4")
+	writeEvent("index_test", event)
+	processingWg.Wait()
+	appendLines(t, path, "line 5")
+	writeEvent("index_test", event)
+	processingWg.Wait()
+	if err := CloseWals(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertLines(t, &captureConsumer{datas: received.datas}, "line 1", "line 2", "line 3", "line 4")
+
+	// 再次重启时只重放没有确认的第五行, 已经确认的数据不会重复发送
+	if err := InitWals(); err != nil {
+		t.Fatal(err)
+	}
+	replayed := &captureConsumer{}
+	if err := ReplayWals(replayed); err != nil {
+		t.Fatal(err)
+	}
+	assertLines(t, replayed, "line 5")
+	if replayed.datas[0].IndexName != "index_test" {
+		t.Errorf("replayed data should keep index name, got %s", replayed.datas[0].IndexName)
+	}
+}
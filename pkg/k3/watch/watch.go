@@ -1,7 +1,6 @@
 package watch
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
@@ -13,21 +12,45 @@ import (
 	"log-engine-sdk/pkg/k3/protocol"
 	"log-engine-sdk/pkg/k3/sender"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
 type FileState struct {
-	Path          string
-	Offset        int64
-	StartReadTime int64
-	LastReadTime  int64
-	IndexName     string
+	Path             string
+	Offset           int64
+	StartReadTime    int64
+	LastReadTime     int64
+	IndexName        string
+	ContentHash      string `json:"ContentHash,omitempty"`      // whole_file模式下, 最近一次发送的文件内容hash
+	SignatureChecked bool   `json:"SignatureChecked,omitempty"` // 是否已经检查过skip_signature
+	Skipped          bool   `json:"Skipped,omitempty"`          // 第一行匹配skip_signature, 文件不再读取
+	Dev              uint64 `json:"Dev,omitempty"`              // 文件所在设备
+	Inode            uint64 `json:"Inode,omitempty"`            // 文件inode, 同一路径的inode变化表示文件被轮转
+	Completed        bool   `json:"Completed,omitempty"`        // gzip文件已经读取完成, 不再读取, 此时Offset为解压后的大小
+	CompressedOffset int64  `json:"CompressedOffset,omitempty"` // gzip文件已经发送完的member在压缩文件中的结束位置, 此时Offset为解压后的位置, 中断后从这里继续读取
+	Obsolete         bool   `json:"Obsolete,omitempty"`         // 长时间没有写入且已经读完, 句柄已关闭, 再次写入时恢复
+	SkipLine         bool   `json:"SkipLine,omitempty"`         // offset位于被截断的行中间, 下次读取时丢弃到换行符为止
+
+	delivery              *deliveryTracker // 已经交给consumer还没有确认的日志, 落盘的offset不超过其中最早的一条, 不落盘
+	multilinePendingSince time.Time        // 多行日志开始等待结束行的时间, 不落盘
+	multilineScheduled    bool             // 是否已经设置了多行日志超时后的读取
+	backpressureScheduled bool             // 是否已经设置了暂停读取后的再次读取
+	rateLimitScheduled    bool             // 是否已经设置了超过rate_limit后的再次读取
+	partialOffset         int64            // 文件末尾没有换行符的行的开始位置, 不落盘
+	partialSize           int64            // 文件末尾没有换行符的行已经写入的字节数, 不落盘
+	partialSince          time.Time        // 文件末尾没有换行符的行最近一次增长的时间, 不落盘
+	partialScheduled      bool             // 是否已经设置了没有换行符的行超时后的读取
+	openFailures          int              // 连续打开失败(如没有读权限)的次数, 不落盘
+	openRetryAt           time.Time        // 打开失败后, 在此之前不再打开, 不落盘
+	openRetryScheduled    bool             // 是否已经设置了打开失败后的再次读取
 }
 
 func (f *FileState) String() string {
-	return fmt.Sprintf("Path: %s, Offset: %d, StartReadTime: %d, LastReadTime: %d, IndexName: %s", f.Path, f.Offset, f.StartReadTime, f.LastReadTime, f.IndexName)
+	return fmt.Sprintf("Path: %s, Offset: %d, StartReadTime: %d, LastReadTime: %d, IndexName: %s, Inode: %d", f.Path, f.Offset, f.StartReadTime, f.LastReadTime, f.IndexName, f.Inode)
 }
 
 // 处理不同类型的协程回收工作
@@ -47,89 +70,404 @@ var (
 var (
 	WatcherContext       context.Context    // 控制watcher相关所有协程退出
 	WatcherContextCancel context.CancelFunc // 用于主动取消watcher相关的所有协程（含Clock协程）
+	senderContext        context.Context    // consumer发送使用的ctx, 不随WatcherContext取消, 退出时超过shutdown_timeout才取消, 保证剩余数据尽量发送
+	senderContextCancel  context.CancelFunc
 )
 
 var (
-	GlobalDataAnalytics k3.DataAnalytics // 日志接收器
-	DefaultSyncInterval = 60             // 单位秒, 默认为60s, 默认定时60秒将GlobalFileStates的状态同步到硬盘
-	DefaultMaxReadCount = 200            // 默认每次读取日志文件的最大次数
+	GlobalDataAnalytics     k3.DataAnalytics // 日志接收器
+	DefaultSyncInterval     = 60             // 单位秒, 默认为60s, 默认定时60秒将GlobalFileStates的状态同步到硬盘
+	DefaultMaxReadCount     = 200            // 默认每次读取日志文件的最大次数
+	DefaultDrainTimeout     = 5              // 单位秒, 文件删除后读取剩余数据的最长时间
+	DefaultMaxOpenFiles     = 1024           // 默认最多缓存的文件句柄数量
+	DefaultShutdownTimeout  = 30             // 单位秒, 退出时等待读取协程结束和consumer提交剩余数据的最长时间
+	DefaultObsoleteInterval = 1              // 单位小时, 定时检查长时间没有写入的文件
+	DefaultObsoleteDate     = 1              // 单位天, 超过该时间没有读取且已经读完的文件标记为obsolete
+	DefaultStateRetention   = 3600           // 单位秒, 启动扫描时已经不存在的文件, 超过该时间没有读取才删除文件状态
+)
+
+var (
+	customSenderLock = &sync.Mutex{}
+	customSender     protocol.Sender // SetSender设置的发送目标, 为nil时按照sender.type创建
+)
+
+var (
+	ResetToEnd bool // 启动时忽略状态文件中的offset, 所有文件从当前末尾开始读取, 由--reset-to-end开启
+)
+
+// watch.read_from, 扫描时新发现的文件从哪里开始读取
+const (
+	ReadFromBeginning = "beginning" // 默认, 从文件开头读取
+	ReadFromEnd       = "end"       // 从文件当前末尾读取, 只发送之后写入的数据
+)
+
+var (
+	DefaultDrainWaitInterval = 50 * time.Millisecond // 文件删除后等待读取协程结束的检查间隔
+	nowFunc                  = time.Now              // 当前时间, 测试时可以替换
+	openFileFunc             = os.OpenFile           // 打开文件, 测试时可以替换
 )
 
 // 用于处理读取文件的协程， 控制协程的数量即可，多个文件可以同时读取发送
 var (
-	processingSem chan struct{} // 可开启的最大协程数量
-	processingWg  *sync.WaitGroup
-	processingMap *sync.Map
+	GlobalScheduler Scheduler // 读取任务的调度模型, 由watch.concurrency配置
+	processingWg    *sync.WaitGroup
+	processingMap   *sync.Map
 )
 
 var (
 	ClockObsoleteWG *sync.WaitGroup
 )
 
+var (
+	GlobalFdCache *FdCache // 正在读取的文件句柄缓存
+)
+
+// 协程退出时关闭资源失败的错误, 由Stop统一返回
+var (
+	closeErrorsLock = &sync.Mutex{}
+	closeErrors     []error
+)
+
 func InitVars() {
-	ClockWG = &sync.WaitGroup{}                                                          // 定时器协程锁
-	WatcherWG = &sync.WaitGroup{}                                                        // Watcher协程锁
-	GlobalFileStatesLock = &sync.Mutex{}                                                 // 全局FileStates锁
-	FileStateFilePath = k3.GetRootPath() + "/" + config.GlobalConfig.Watch.StateFilePath // Watcher读写硬盘的状态文件记录地址
-	GlobalFileStates = make(map[string]*FileState)                                       // 初始化全局FileStates
+	InitVarsWithContext(context.Background())
+}
+
+// InitVarsWithContext 初始化全局变量, WatcherContext由ctx派生, ctx取消时所有协程退出
+func InitVarsWithContext(ctx context.Context) {
+	ClockWG = &sync.WaitGroup{}                                                                  // 定时器协程锁
+	WatcherWG = &sync.WaitGroup{}                                                                // Watcher协程锁
+	GlobalFileStatesLock = &sync.Mutex{}                                                         // 全局FileStates锁
+	FileStateFilePath = filepath.Join(k3.GetRootPath(), config.GlobalConfig.Watch.StateFilePath) // Watcher读写硬盘的状态文件记录地址
+	GlobalFileStates = make(map[string]*FileState)                                               // 初始化全局FileStates
+
+	WatcherContext, WatcherContextCancel = context.WithCancel(ctx) // Watcher取消上下文
+	senderContext, senderContextCancel = context.WithCancel(context.WithoutCancel(ctx))
 
-	WatcherContext, WatcherContextCancel = context.WithCancel(context.Background()) // Watcher取消上下文
+	// 重新初始化时, 之前的调度器和定时读取不再提交新的读取任务
+	if GlobalScheduler != nil {
+		GlobalScheduler.Close()
+	}
+	if readTimers != nil {
+		readTimers.stop()
+	}
 
 	processingMap = &sync.Map{}
 	processingWg = &sync.WaitGroup{}
-	processingSem = make(chan struct{}, 100) // 控制最大协程数量为100
+	readTimers = newReadTimerSet()
+	readersLimit = maxConcurrentReads(config.GlobalConfig.Watch)
+	GlobalScheduler = NewScheduler(config.GlobalConfig.Watch.Concurrency, readersLimit, config.GlobalConfig.Watch.QueueSize)
 
 	ClockObsoleteWG = &sync.WaitGroup{}
+
+	GlobalFdCache = NewFdCache(config.GlobalConfig.Watch.MaxOpenFiles)
+
+	indexWatchersLock.Lock()
+	indexWatchers = make(map[string]*indexWatcher) // 每个index_name的监听协程, 热加载时使用
+	indexWatchersLock.Unlock()
+
+	resetFailedDirectories()
+	resetFlushSync()
+	resetStateShards()
+	resetBackpressure()
+
+	// 抓取指标时获取当前的文件数量和读取协程数量
+	k3.MetricFilesWatched.SetFunc(countFileStates)
+	k3.MetricReaderGoroutines.SetFunc(ActiveReaders)
+}
+
+// countFileStates 当前GlobalFileStates中的文件数量
+func countFileStates() int64 {
+	GlobalFileStatesLock.Lock()
+	defer GlobalFileStatesLock.Unlock()
+
+	return int64(len(GlobalFileStates))
 }
 
 func InitConsumerBatchLog() error {
 	var (
-		elk      *sender.ElasticSearchClient
-		err      error
-		consumer protocol.K3Consumer
+		batchSender protocol.Sender
+		err         error
+		consumer    protocol.K3Consumer
 	)
-	if elk, err = sender.NewElasticsearch(config.GlobalConfig.ELK.Address,
-		config.GlobalConfig.ELK.Username,
-		config.GlobalConfig.ELK.Password); err != nil {
+
+	// dry_run时不管sender的配置, 日志交给Default sender打印, 不会连接elk
+	if config.GlobalConfig.System.DryRun {
+		k3.K3LogInfo("[InitConsumerBatchLog] dry run is enabled, events are printed instead of shipped.")
+		batchSender = &sender.Default{}
+	} else if batchSender = fetchCustomSender(); batchSender != nil {
+		k3.K3LogInfo("[InitConsumerBatchLog] use custom sender, sender config is ignored.")
+	} else if batchSender, err = newSender(); err != nil {
 		return err
 	}
 
+	// 发送失败时重试, 退出时取消等待中的重试
+	if config.GlobalConfig.Sender.MaxRetries > 0 {
+		batchSender = sender.NewRetrySenderWithContext(WatcherContext, batchSender,
+			config.GlobalConfig.Sender.MaxRetries, time.Duration(config.GlobalConfig.Sender.RetryDelay)*time.Second)
+	}
+
 	if consumer, err = k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{
-		Sender:        elk,
+		Sender:        batchSender,
 		BatchSize:     config.GlobalConfig.Consumer.ConsumerBatchSize,
 		AutoFlush:     config.GlobalConfig.Consumer.ConsumerBatchAutoFlush,
 		Interval:      config.GlobalConfig.Consumer.ConsumerBatchInterval,
 		CacheCapacity: config.GlobalConfig.Consumer.ConsumerBatchCapacity,
+		MaxBatchAge:   time.Duration(config.GlobalConfig.Consumer.ConsumerBatchMaxAge) * time.Second,
+		MaxBatchBytes: config.GlobalConfig.Consumer.ConsumerBatchMaxBytes,
+		OnSend:        onBatchSent,
+		Context:       senderContext,
 	}); err != nil {
 		return err
 	}
-	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+
+	// 重放wal中上次没有确认的数据, 数据已经在wal中, 不需要再次写入
+	if err = ReplayWals(consumer); err != nil {
+		return err
+	}
+
+	// 读取文件的协程写入异步队列后立即返回, 发送缓慢时不阻塞读取
+	if config.GlobalConfig.Consumer.AsyncChannelSize > 0 {
+		if consumer, err = k3.NewAsyncConsumerWithConfig(k3.K3AsyncConsumerConfig{
+			Consumer:    consumer,
+			ChannelSize: config.GlobalConfig.Consumer.AsyncChannelSize,
+			Overflow:    config.GlobalConfig.Consumer.AsyncOverflow,
+			OnDrop:      dropDeliveries,
+		}); err != nil {
+			return err
+		}
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(&walConsumer{consumer: consumer})
 
 	return nil
 }
 
-// LoadDiskFileToGlobalFileStates 从文件加载GlobalFileStates内存中
-func LoadDiskFileToGlobalFileStates(filePath string) error {
+// SetSender 设置自定义的发送目标(如内部的消息队列), 需要在Run之前调用, 之后不再按照sender.type创建sender
+// 重试等consumer配置仍然生效, Stop时由consumer关闭sender, 设置为nil时恢复使用配置创建
+// 实现protocol.ContextSender时, Stop超过shutdown_timeout会取消正在进行的发送
+func SetSender(s protocol.Sender) {
+	customSenderLock.Lock()
+	customSender = s
+	customSenderLock.Unlock()
+}
+
+func fetchCustomSender() protocol.Sender {
+	customSenderLock.Lock()
+	defer customSenderLock.Unlock()
+	return customSender
+}
+
+// newSender 按照sender.type创建批量日志的发送目标, index.sender绑定了sender.named中的目标时, 按照index_name分发
+func newSender() (protocol.Sender, error) {
 	var (
-		fd      *os.File
-		decoder *json.Decoder
+		fallback protocol.Sender
+		senders  = make(map[string]protocol.Sender) // 只创建被引用的目标, 多个index_name引用同一个目标时只创建一次
+		routes   = make(map[string]string)
+		target   protocol.Sender
+		err      error
+	)
+
+	if fallback, err = newGlobalSender(); err != nil {
+		return nil, err
+	}
+
+	for indexName, index := range config.GlobalConfig.Watch.Index {
+		if len(index.Sender) == 0 {
+			continue
+		}
+		routes[indexName] = index.Sender
+
+		if _, created := senders[index.Sender]; created {
+			continue
+		}
+
+		// 失败时已经创建的目标需要关闭
+		named, ok := config.GlobalConfig.Sender.Named[index.Sender]
+		if !ok {
+			_ = sender.NewRouteSender(fallback, senders, nil).Close()
+			return nil, fmt.Errorf("[newSender] index_name[%s] sender[%s] is not configured in sender.named", indexName, index.Sender)
+		}
+		if target, err = newTargetSender(named); err != nil {
+			_ = sender.NewRouteSender(fallback, senders, nil).Close()
+			return nil, err
+		}
+		senders[index.Sender] = target
+	}
+
+	if len(routes) == 0 {
+		return fallback, nil
+	}
+
+	return sender.NewRouteSender(fallback, senders, routes), nil
+}
+
+// newGlobalSender 按照sender.type创建没有绑定sender的index_name使用的发送目标
+func newGlobalSender() (protocol.Sender, error) {
+	var (
+		senders []protocol.Sender
+		target  protocol.Sender
 		err     error
 	)
 
+	if config.GlobalConfig.Sender.Type != sender.TypeMulti {
+		return newTargetSender(config.SenderTarget{
+			Type:       config.GlobalConfig.Sender.Type,
+			Kafka:      config.GlobalConfig.Sender.Kafka,
+			Http:       config.GlobalConfig.Sender.Http,
+			Syslog:     config.GlobalConfig.Sender.Syslog,
+			Loki:       config.GlobalConfig.Sender.Loki,
+			ClickHouse: config.GlobalConfig.Sender.ClickHouse,
+			File:       config.GlobalConfig.Sender.File,
+		})
+	}
+
+	if len(config.GlobalConfig.Sender.Multi) == 0 {
+		return nil, errors.New("[newGlobalSender] sender multi is empty")
+	}
+
+	for _, t := range config.GlobalConfig.Sender.Multi {
+		if target, err = newTargetSender(t); err != nil {
+			// 已经创建的目标需要关闭
+			_ = sender.NewMultiSender(senders...).Close()
+			return nil, err
+		}
+		senders = append(senders, target)
+	}
+
+	return sender.NewMultiSender(senders...), nil
+}
+
+// newTargetSender 创建单个发送目标
+func newTargetSender(target config.SenderTarget) (protocol.Sender, error) {
+	var (
+		elk   *sender.ElasticSearchClient
+		kafka *sender.Kafka
+		hs    *sender.HTTPSender
+		ss    *sender.SyslogSender
+		loki  *sender.LokiSender
+		ch    *sender.ClickHouseSender
+		fs    *sender.FileSender
+		err   error
+	)
+
+	switch target.Type {
+	case sender.TypeKafka:
+		if kafka, err = sender.NewKafka(target.Kafka); err != nil {
+			return nil, err
+		}
+		return kafka, nil
+	case sender.TypeHttp:
+		if hs, err = sender.NewHTTPSender(target.Http); err != nil {
+			return nil, err
+		}
+		return hs, nil
+	case sender.TypeSyslog:
+		if ss, err = sender.NewSyslogSender(target.Syslog); err != nil {
+			return nil, err
+		}
+		return ss, nil
+	case sender.TypeLoki:
+		if loki, err = sender.NewLokiSender(target.Loki); err != nil {
+			return nil, err
+		}
+		return loki, nil
+	case sender.TypeClickHouse:
+		if ch, err = sender.NewClickHouseSender(target.ClickHouse); err != nil {
+			return nil, err
+		}
+		return ch, nil
+	case sender.TypeFile:
+		if fs, err = sender.NewFileSender(target.File); err != nil {
+			return nil, err
+		}
+		return fs, nil
+	case sender.TypeStdout:
+		return &sender.Default{}, nil
+	case sender.TypeStdoutNDJSON:
+		return sender.NewStdoutNDJSON(nil), nil
+	case "", sender.TypeElk:
+		if elk, err = sender.NewElasticsearch(config.GlobalConfig.ELK.Address,
+			config.GlobalConfig.ELK.Username,
+			config.GlobalConfig.ELK.Password); err != nil {
+			return nil, err
+		}
+
+		// 检查目标索引的mapping与发送的字段类型是否兼容
+		if err = elk.VerifyMapping(fetchIndexNames(config.GlobalConfig.Watch.ReadPath)); err != nil {
+			_ = elk.Close()
+			return nil, err
+		}
+		return elk, nil
+	default:
+		return nil, errors.New("[newTargetSender] unsupported sender type: " + target.Type)
+	}
+}
+
+// fetchIndexNames read_path中配置的所有index_name
+func fetchIndexNames(directory map[string][]string) []string {
+	var (
+		indexNames = make([]string, 0, len(directory))
+	)
+
+	for indexName := range directory {
+		indexNames = append(indexNames, indexName)
+	}
+	sort.Strings(indexNames)
+
+	return indexNames
+}
+
+// LoadDiskFileToGlobalFileStates 从文件加载GlobalFileStates内存中, 开启state_shard时加载并合并所有index_name的分片
+func LoadDiskFileToGlobalFileStates(filePath string) error {
+	var (
+		content   []byte
+		stateFile *StateFile
+		migrated  bool
+		err       error
+	)
+
 	GlobalFileStatesLock.Lock()
 	defer GlobalFileStatesLock.Unlock()
 
-	// 打开文件
-	if fd, err = os.OpenFile(filePath, os.O_RDWR, os.ModePerm); err != nil {
-		return errors.New("[LoadDiskFileToGlobalFileStates] open state file failed: " + err.Error())
+	// 开启state_shard时加载所有分片, 还没有分片时加载单个状态文件, 保存时写入分片
+	if config.GlobalConfig.Watch.StateShard {
+		if loaded, err := loadStateShards(filePath); err != nil {
+			return fmt.Errorf("[LoadDiskFileToGlobalFileStates] %w: %w", ErrStateLoad, err)
+		} else if loaded {
+			return nil
+		}
+	}
+
+	// 读取文件
+	if content, err = os.ReadFile(filePath); err != nil {
+		return fmt.Errorf("[LoadDiskFileToGlobalFileStates] %w: open state file: %w", ErrStateLoad, err)
+	}
+
+	// 新创建的状态文件
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return nil
 	}
-	defer fd.Close()
 
 	// 将文件映射到FileState
-	decoder = json.NewDecoder(fd)
+	if stateFile, migrated, err = decodeStateFile(content); err != nil {
+		if !config.GlobalConfig.Watch.RecoverCorruptState {
+			return fmt.Errorf("[LoadDiskFileToGlobalFileStates] %w: %w", ErrStateLoad, err)
+		}
+		if err = recoverCorruptStateFile(filePath, err); err != nil {
+			return fmt.Errorf("[LoadDiskFileToGlobalFileStates] %w: %w", ErrStateLoad, err)
+		}
+		return nil
+	}
+
+	for path, fileState := range stateFile.fileStates() {
+		GlobalFileStates[path] = fileState
+	}
 
-	if err = decoder.Decode(&GlobalFileStates); err != nil && !errors.Is(err, io.EOF) {
-		return errors.New("[LoadDiskFileToGlobalFileStates] json decode failed: " + err.Error())
+	// 旧格式的状态文件, 直接重写为新格式
+	if migrated {
+		if err = writeStateFile(filePath); err != nil {
+			return fmt.Errorf("[LoadDiskFileToGlobalFileStates] %w: migrate state file: %w", ErrStateLoad, err)
+		}
+		k3.K3LogInfo("[LoadDiskFileToGlobalFileStates] migrate state file[%s] to version %d, files: %d.", filePath, StateFileVersion, len(GlobalFileStates))
 	}
 
 	return nil
@@ -137,89 +475,221 @@ func LoadDiskFileToGlobalFileStates(filePath string) error {
 
 // SaveGlobalFileStatesToDiskFile 保存GlobalFileState的数据到硬盘目录filePath
 func SaveGlobalFileStatesToDiskFile(filePath string) error {
+	var err error
+
+	GlobalFileStatesLock.Lock()
+	defer GlobalFileStatesLock.Unlock()
+
+	if err = writeStateFile(filePath); err != nil {
+		return fmt.Errorf("[SaveFileStateToDiskFile] %w: %w", ErrStateSave, err)
+	}
+
+	k3.K3LogDebug("[SaveFileStateToDiskFile] save file state to disk file success .")
+	return nil
+}
+
+// writeStateFile 将GlobalFileStates按照StateFile格式写入filePath, 开启state_shard时写入有变化的分片, 调用方需要持有GlobalFileStatesLock
+func writeStateFile(filePath string) error {
 	var (
 		fd      *os.File
 		encoder *json.Encoder
 		err     error
 	)
 
-	GlobalFileStatesLock.Lock()
-	defer GlobalFileStatesLock.Unlock()
+	if config.GlobalConfig.Watch.StateShard {
+		return writeStateShards(filePath)
+	}
 
 	// 打开文件, 并清空
 	if fd, err = os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm); err != nil {
-		return errors.New("[SaveFileStateToDiskFile] open state file failed: " + err.Error())
+		return fmt.Errorf("open state file failed: %w", err)
 	}
 	defer fd.Close()
 
 	encoder = json.NewEncoder(fd)
 
-	if err = encoder.Encode(&GlobalFileStates); err != nil {
-		return errors.New("[SaveFileStateToDiskFile] json encode failed: " + err.Error())
+	if err = encoder.Encode(newStateFile(GlobalFileStates)); err != nil {
+		return fmt.Errorf("json encode failed: %w", err)
 	}
 
-	k3.K3LogDebug("[SaveFileStateToDiskFile] save file state to disk file success .")
 	return nil
 }
 
 // ScanLogFileToGlobalFileStatesAndSaveToDiskFile  保证硬盘文件和FileState一致，并同步到硬盘状态文件, 项目启动的时候使用此函数
 func ScanLogFileToGlobalFileStatesAndSaveToDiskFile(directory map[string][]string, filePath string) error {
+	return scanLogFiles(directory, filePath, true)
+}
+
+// scanLogFiles startup为false时是定时重新扫描, read_from和initial_tail_lines只对启动扫描时新发现的文件生效, 之后发现的文件从头读取
+func scanLogFiles(directory map[string][]string, filePath string, startup bool) error {
 	var (
-		totalFiles           = make(map[string][]string)
+		fileIndexNames       = make(map[string]string) // 文件路径 -> index_name
 		err                  error
 		files                []string
 		globalFileStatesKeys []string
 		tempDiskFiles        []string
+		discoveredFiles      []*FileState // 新发现的文件
+		removedFiles         []*FileState // 硬盘上已经不存在的文件
+		prunedCount          int          // 已经不存在且超过state_retention没有读取的文件数量
+		retainedCount        int          // 已经不存在但是最近还在读取的文件数量
+		readFromEnd          = startup && config.GlobalConfig.Watch.ReadFrom == ReadFromEnd
+		initialTailLines     = config.GlobalConfig.Watch.InitialTailLines
+		startTime, _         = config.GlobalConfig.Watch.StartTime() // 启动时已经校验过格式
 	)
 
-	globalFileStatesInterface := make(map[string]interface{})
-	for k, fileState := range GlobalFileStates {
-		globalFileStatesInterface[k] = fileState
-	}
 	// 获取GlobalFileStates的key
-	globalFileStatesKeys = k3.GetMapKeys(globalFileStatesInterface)
-
-	for indexName, dirs := range directory {
+	globalFileStatesKeys = k3.GetMapKeys(GlobalFileStates)
+	sort.Strings(globalFileStatesKeys)
 
-		for _, dir := range dirs {
-			if files, err = k3.FetchDirectory(dir, -1); err != nil {
+	// index_name按照名称排序, 同一个文件属于多个index_name时结果是确定的
+	for _, indexName := range fetchIndexNames(directory) {
+		for _, dir := range directory[indexName] {
+			if files, err = k3.FetchDirectoryWithConfig(resolveReadPath(dir), fetchDirectoryConfig()); err != nil {
 				continue
 			}
-			totalFiles[indexName] = append(totalFiles[indexName], files...)
+			// 不匹配include_globs/exclude_globs的文件不读取, 已经记录的也从GlobalFileStates中移除
+			for _, diskFile := range filterWatchFiles(indexName, files) {
+				if _, ok := fileIndexNames[diskFile]; !ok {
+					tempDiskFiles = append(tempDiskFiles, diskFile)
+				}
+				fileIndexNames[diskFile] = indexName
+			}
 		}
 	}
+	sortScanFiles(tempDiskFiles)
 
 	GlobalFileStatesLock.Lock()
-	// 检查硬盘上的日志文件是否存在GlobalFileStates中，如果不存在就ADD
-	for indexName, diskFiles := range totalFiles {
-		tempDiskFiles = append(tempDiskFiles, diskFiles...)
-		for _, diskFile := range diskFiles {
-			if k3.InSlice(diskFile, globalFileStatesKeys) == false {
-				GlobalFileStates[diskFile] = &FileState{
-					Path:          diskFile,
-					Offset:        0,
-					StartReadTime: time.Now().Unix(),
-					LastReadTime:  time.Now().Unix(),
-					IndexName:     indexName,
+	// 检查硬盘上的日志文件是否存在GlobalFileStates中，如果不存在就ADD, 按照scan_order的顺序
+	for _, diskFile := range tempDiskFiles {
+		indexName := fileIndexNames[diskFile]
+		if k3.InSlice(diskFile, globalFileStatesKeys) == false {
+			// 修改时间早于start_date的文件不读取, 之后有写入时由writeEvent加入
+			if beforeStartDate(diskFile, startTime) {
+				continue
+			}
+			GlobalFileStates[diskFile] = &FileState{
+				Path:          diskFile,
+				Offset:        0,
+				StartReadTime: time.Now().Unix(),
+				LastReadTime:  nowFunc().Unix(),
+				IndexName:     indexName,
+			}
+			GlobalFileStates[diskFile].Dev, GlobalFileStates[diskFile].Inode, _ = k3.FileIdentity(diskFile)
+			// read_from: end 时, 启动扫描时新发现的文件只读取之后写入的数据, 已经记录了offset的文件不受影响
+			if readFromEnd {
+				if info, err := os.Stat(diskFile); err == nil {
+					GlobalFileStates[diskFile].Offset = info.Size()
 				}
-			} else { // 如果存在，就检查是否需要更新index_name
-				if GlobalFileStates[diskFile].IndexName != indexName {
-					GlobalFileStates[diskFile].IndexName = indexName
+			} else if startup && initialTailLines > 0 && !isGzipFile(diskFile) {
+				// initial_tail_lines: 只读取最后的initial_tail_lines行, gzip文件的offset是解压后的位置, 从头读取
+				if offset, err := tailLinesOffset(diskFile, initialTailLines, getIndexRule(indexName).lineDelimiter); err != nil {
+					k3.K3LogWarn("[ScanLogFileToGlobalFileStatesAndSaveToDiskFile] find last %d lines of file[%s] failed, read from beginning: %s", initialTailLines, diskFile, err.Error())
+				} else {
+					GlobalFileStates[diskFile].Offset = offset
 				}
 			}
+			discoveredFiles = append(discoveredFiles, GlobalFileStates[diskFile])
+		} else { // 如果存在，就检查是否需要更新index_name
+			if GlobalFileStates[diskFile].IndexName != indexName {
+				GlobalFileStates[diskFile].IndexName = indexName
+			}
 		}
 	}
 
 	// 检查GlobalFileStates中是否真实存在于硬盘上，如果不存在就DELETE
+	// 已经不存在的文件最近还在读取时保留(如轮转时短暂地改名), 超过state_retention没有读取才删除, 避免状态文件越来越大
 	for _, fileStateKey := range globalFileStatesKeys {
 		if k3.InSlice(fileStateKey, tempDiskFiles) == false {
+			if _, err := os.Stat(fileStateKey); os.IsNotExist(err) {
+				if !staleFileState(GlobalFileStates[fileStateKey]) {
+					retainedCount++
+					continue
+				}
+				prunedCount++
+			}
+			removedFiles = append(removedFiles, GlobalFileStates[fileStateKey])
 			delete(GlobalFileStates, fileStateKey)
 		}
 	}
 	GlobalFileStatesLock.Unlock()
 
+	if prunedCount > 0 || retainedCount > 0 {
+		k3.K3LogInfo("[ScanLogFileToGlobalFileStatesAndSaveToDiskFile] pruned %d missing file states not read for %ds, retained %d recently read.", prunedCount, stateRetention(), retainedCount)
+	}
+
+	for _, fileState := range discoveredFiles {
+		emitLifecycleEvent(LifecycleFileDiscovered, fileState)
+	}
+
+	for _, fileState := range removedFiles {
+		emitLifecycleEvent(LifecycleFileRemoved, fileState)
+	}
+
+	if err = SaveGlobalFileStatesToDiskFile(filePath); err != nil {
+		return fmt.Errorf("[ScanDiskLogAddFileState] %w: %w", ErrScan, err)
+	}
+
+	return nil
+}
+
+// stateRetention 已经不存在的文件保留文件状态的时间, 单位秒
+func stateRetention() int {
+	if retention := config.GlobalConfig.Watch.StateRetention; retention > 0 {
+		return retention
+	}
+	return DefaultStateRetention
+}
+
+// staleFileState 超过state_retention没有读取时返回true, 调用方需要持有GlobalFileStatesLock
+func staleFileState(fileState *FileState) bool {
+	return nowFunc().Unix()-fileState.LastReadTime > int64(stateRetention())
+}
+
+// beforeStartDate 文件的修改时间早于start_date时返回true, startTime为零值表示不限制
+func beforeStartDate(path string, startTime time.Time) bool {
+	if startTime.IsZero() {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return info.ModTime().Before(startTime)
+}
+
+// ResetFileStatesToEnd 将所有文件的offset设置为文件当前的大小并保存到硬盘, 之后只读取新写入的数据
+// 与删除状态文件不同, 删除状态文件会从0开始重新读取所有历史数据
+func ResetFileStatesToEnd(filePath string) error {
+	var (
+		resetFiles []*FileState
+		fileInfo   os.FileInfo
+		err        error
+	)
+
+	GlobalFileStatesLock.Lock()
+	for path, fileState := range GlobalFileStates {
+		if fileInfo, err = os.Stat(path); err != nil {
+			k3.K3LogWarn("[ResetFileStatesToEnd] stat file[%s] failed: %s", path, err.Error())
+			continue
+		}
+
+		if fileState.Offset != fileInfo.Size() {
+			fileState.Offset = fileInfo.Size()
+			resetFiles = append(resetFiles, fileState)
+		}
+		fileState.LastReadTime = nowFunc().Unix()
+	}
+	GlobalFileStatesLock.Unlock()
+
+	k3.K3LogInfo("[ResetFileStatesToEnd] reset %d files to end.", len(resetFiles))
+	for _, fileState := range resetFiles {
+		emitLifecycleEvent(LifecycleOffsetReset, fileState)
+	}
+
 	if err = SaveGlobalFileStatesToDiskFile(filePath); err != nil {
-		return errors.New("[ScanDiskLogAddFileState] save file state to disk failed: " + err.Error())
+		return fmt.Errorf("[ResetFileStatesToEnd] save file state to disk failed: %w", err)
 	}
 
 	return nil
@@ -232,7 +702,9 @@ func InitWatcher(directory map[string][]string, fileStatePath string) error {
 
 	//  这里要考虑2个问题，
 	//  1. watcher协程在初始化的时候, 并不是所有的协程都创建成功，这样就需要终止后面所有的协程创建，并让已经创建的协程回收，且终止主程序
+	//     单个目录加入监听失败时, 只有开启fail_on_partial_init才终止, 否则跳过该目录并记录到FailedDirectories
 	//  2. 如果所有的协程创建成功， 一旦某个协程出现异常，需要让所有的协程退出，并回收，且终止主程序
+	//     退出的原因通过OnFatal设置的回调通知调用方
 
 	var (
 		// 定义检查所有协程是否创建成功的chan
@@ -240,6 +712,18 @@ func InitWatcher(directory map[string][]string, fileStatePath string) error {
 		err       error
 	)
 
+	// 当前WatcherContext的监听协程已经启动, 再次调用时只处理新增和删除的目录, 已有的协程和offset不受影响
+	watcherStartedLock.Lock()
+	started := watcherStarted == WatcherContext
+	watcherStarted = WatcherContext
+	watcherStartedLock.Unlock()
+	if started {
+		return applyWatchDirectory(directory)
+	}
+
+	// 记录当前监听的目录, 热加载时与新的read_path对比
+	setWatchDirectory(directory)
+
 	// 每个index name 开一个协程来处理监听事件
 	for indexName, dirs := range directory {
 		WatcherWG.Add(1)
@@ -247,13 +731,14 @@ func InitWatcher(directory map[string][]string, fileStatePath string) error {
 	}
 
 	// 用于解决，主程序启动后，一旦有一个协程异常退出，用于回收协程，并让其他协程也退出
-	go func() {
-		WatcherWG.Wait() // 阻塞函数
+	// 使用启动时的WaitGroup和取消函数, 重新InitVars之后不会影响新的协程
+	go func(watcherWG, processingWg *sync.WaitGroup, cancel context.CancelFunc) {
+		watcherWG.Wait() // 阻塞函数
 		k3.K3LogInfo("[InitWatcher] All watcher goroutine exit.")
 		processingWg.Wait() // 阻塞函数, 回收每次读取文件时开的所有协程
 		k3.K3LogInfo("[InitWatcher] All processing goroutine exit.")
-		WatcherContextCancel() // 考虑到所有的Watcher的协程都退出了， 保险起见再次发一个退出信号
-	}()
+		cancel() // 考虑到所有的Watcher的协程都退出了， 保险起见再次发一个退出信号
+	}(WatcherWG, processingWg, WatcherContextCancel)
 
 	// 判断协程开启的协程是否都创建成功， 如果有一个不成功就直接 退出主程序
 	for i := 0; i < len(directory); i++ {
@@ -265,18 +750,33 @@ func InitWatcher(directory map[string][]string, fileStatePath string) error {
 	}
 	close(isSuccess)
 
-	return err
+	if err != nil {
+		return fmt.Errorf("[InitWatcher] %w: %w", ErrWatcherInit, err)
+	}
+
+	return nil
 }
 
 // forkWatcher 开单一协程来处理监听，每个indexName开一个协程
 func forkWatcher(indexName string, dirs []string, fileStatePath string, isSuccess chan error) {
 	var (
-		watcher *fsnotify.Watcher
-		err     error
+		watcher       *fsnotify.Watcher
+		err           error
+		watcherWG     = WatcherWG
+		watcherCtx    = WatcherContext
+		watcherCancel = WatcherContextCancel
+		ctx, cancel   = context.WithCancel(watcherCtx) // 热加载删除index_name时只取消当前协程
 	)
 
-	defer WatcherWG.Done()
-	defer WatcherContextCancel()
+	defer watcherWG.Done()
+	defer func() {
+		unregisterIndexWatcher(indexName, watcher)
+		// 热加载主动停止的协程不影响其他协程, 其他原因退出时让所有的Watcher协程退出
+		if ctx.Err() == nil || watcherCtx.Err() != nil {
+			watcherCancel()
+		}
+		cancel()
+	}()
 
 	// 每个indexName 创建一个Watcher
 	if watcher, err = fsnotify.NewWatcher(); err != nil {
@@ -286,20 +786,32 @@ func forkWatcher(indexName string, dirs []string, fileStatePath string, isSucces
 		isSuccess <- err
 		return
 	}
-	defer watcher.Close()
+	defer func() {
+		if err := watcher.Close(); err != nil {
+			recordCloseError(fmt.Errorf("close watcher of index_name[%s] failed: %w", indexName, err))
+		}
+	}()
 
 	// 将所有的目录都加入监听
 	for _, dir := range dirs {
-		if err = watcher.Add(dir); err != nil {
-			// 处理错误， 让所有的Watcher协程退出
-			k3.K3LogError("[forkWatcher] add dir to watcher failed: %s", err.Error())
-			WatcherContextCancel()
-			isSuccess <- err
-			return
+		if err = watcher.Add(watchTarget(dir)); err != nil {
+			// 开启fail_on_partial_init时, 让所有的Watcher协程退出
+			if config.GlobalConfig.Watch.FailOnPartialInit {
+				k3.K3LogError("[forkWatcher] add dir to watcher failed: %s", err.Error())
+				WatcherContextCancel()
+				isSuccess <- err
+				return
+			}
+
+			// 否则跳过该目录, 记录下来之后重试, 其他目录照常监听
+			k3.K3LogError("[forkWatcher] index_name[%s] add dir[%s] to watcher failed, skipped: %s", indexName, dir, err.Error())
+			recordFailedDirectory(indexName, dir, err)
+			err = nil
 		}
 	}
 
 	// 证明协程已经创建成功，将成功信号返回
+	registerIndexWatcher(indexName, &indexWatcher{watcher: watcher, cancel: cancel})
 	isSuccess <- nil
 
 EXIT:
@@ -308,7 +820,7 @@ EXIT:
 
 		case event, ok := <-watcher.Events:
 			if !ok {
-				k3.K3LogWarn("[forkWatcher] index_name[%s] watcher event channel closed.", indexName)
+				reportFatal(fmt.Errorf("index_name[%s] watcher event channel closed", indexName))
 				WatcherContextCancel()
 				break EXIT
 			}
@@ -317,16 +829,16 @@ EXIT:
 
 		case err, ok := <-watcher.Errors:
 			if !ok {
-				k3.K3LogWarn("[forkWatcher] index_name[%s] watcher error channel closed.", indexName)
+				reportFatal(fmt.Errorf("index_name[%s] watcher error channel closed", indexName))
 				WatcherContextCancel()
 				break EXIT
 			}
 
-			k3.K3LogError("[forkWatcher] index_name[%s] watcher error: %s", indexName, err)
+			reportFatal(fmt.Errorf("index_name[%s] watcher error: %w", indexName, err))
 			WatcherContextCancel()
 			break EXIT
 
-		case <-WatcherContext.Done():
+		case <-ctx.Done():
 			k3.K3LogWarn("[forkWatcher] index_name[%s] watcher exit with by globalWatchContext. ", indexName)
 			break EXIT
 		}
@@ -336,6 +848,11 @@ EXIT:
 }
 
 func handlerEvent(indexName string, event fsnotify.Event, fileStatePath string, watcher *fsnotify.Watcher) {
+	// read_path中的文件监听的是所在的目录, 目录中其他文件的事件忽略
+	if !shouldHandleEvent(indexName, event.Name) {
+		return
+	}
+
 	// 删除 -> 删除GlobalFileState的内容
 
 	// 新增 -> 目录就add监听
@@ -347,21 +864,18 @@ func handlerEvent(indexName string, event fsnotify.Event, fileStatePath string,
 	} else if event.Op&fsnotify.Create == fsnotify.Create {
 		// fmt.Println("收到新增", indexName, event.Name)
 		createEvent(indexName, event, watcher)
-	} else if event.Op&fsnotify.Remove == fsnotify.Remove || event.Op&fsnotify.Rename == fsnotify.Rename {
-		// fmt.Println("收到删除或修改文件名称", indexName, event.Name)
+	} else if event.Op&fsnotify.Remove == fsnotify.Remove {
+		// fmt.Println("收到删除", indexName, event.Name)
 		removeEvent(event, watcher)
+	} else if event.Op&fsnotify.Rename == fsnotify.Rename {
+		// 文件改名(轮转)时保留文件状态, 由inode判断轮转
+		renameEvent(indexName, event, watcher)
 	}
 }
 
-// processing 协程中处理
+// processing 读取任务, 由GlobalScheduler决定在哪个协程中执行
 func processing(indexName string, event fsnotify.Event) {
-	defer processingWg.Done()
-
-	// 1. 判断当前协程数量是否负载, 如果负载processingSem会阻塞，等待其他协程处理完, 队列如果一直是满状态的时候，这里会阻塞
-	processingSem <- struct{}{}
-	defer func() {
-		<-processingSem
-	}()
+	// 1. 并发数量由GlobalScheduler控制, 负载时任务会等待其他任务处理完
 
 	// 2. 判断当前文件是不是已经在协程中，如果,event.Name标记的协程已经存在，就直接返回, 协程结束
 	if _, loading := processingMap.LoadOrStore(event.Name, true); loading {
@@ -369,138 +883,519 @@ func processing(indexName string, event fsnotify.Event) {
 		return
 	}
 
-	// 4. 协程结束，将当前event.Name标记的协程，移除掉
-	defer processingMap.Delete(event.Name)
+	var yield bool
+	defer func() {
+		// 4. 协程结束，将当前event.Name标记的协程，移除掉
+		processingMap.Delete(event.Name)
+
+		// 5. 连续读取max_read_passes次之后还有数据, 移除标记之后再重新提交, 同一个文件始终只有一个协程在读取
+		if yield {
+			yieldRead(indexName, event.Name)
+		}
+	}()
 
 	// 3. 开始处理读取发送问题
-	readEventNameByOffset(indexName, event)
+	yield = readEventNameByOffset(indexName, event)
 }
 
 // readEventNameByOffset 读取文件，更新GlobalFileState, 并把数据发送给elk
-func readEventNameByOffset(indexName string, event fsnotify.Event) {
+// 连续读取max_read_passes次之后文件还有数据时返回true, 由processing重新提交读取任务
+func readEventNameByOffset(indexName string, event fsnotify.Event) bool {
 	var (
 		err              error
 		fd               *os.File
-		reader           *bufio.Reader
-		currentReadCount int
 		currentFileState *FileState
-		currentOffset    int64
-		content          string
+		exists           bool
 		maxReadCount     = config.GlobalConfig.Watch.MaxReadCount
+		readPasses       = maxReadPasses()
 	)
 
-	currentReadCount = 0                            // 当前文件被读取次数
-	currentFileState = GlobalFileStates[event.Name] // 当前文件信息
-	currentOffset = currentFileState.Offset         // 当前文件读取位置
+	GlobalFileStatesLock.Lock()
+	currentFileState, exists = GlobalFileStates[event.Name] // 当前文件信息
+	GlobalFileStatesLock.Unlock()
+
+	if !exists {
+		k3.K3LogWarn("[readEventNameByOffset] index_name[%s] event[%s] path[%s] file state not found.", indexName, event.Op, event.Name)
+		return false
+	}
+
+	// obsolete文件再次写入, 恢复为正常文件
+	GlobalFileStatesLock.Lock()
+	if currentFileState.Obsolete {
+		currentFileState.Obsolete = false
+		k3.K3LogInfo("[readEventNameByOffset] obsolete path[%s] written again, reactivated.", event.Name)
+	}
+	GlobalFileStatesLock.Unlock()
 
 	if maxReadCount < 0 || maxReadCount > DefaultMaxReadCount {
 		maxReadCount = DefaultMaxReadCount
 	}
-	// 3.1. 打开文件
-	if fd, err = os.OpenFile(event.Name, os.O_RDONLY, 0666); err != nil {
-		k3.K3LogError("[readEventNameByOffset] index_name[%s] event[%s] path[%s] open file failed: %s", indexName, event.Op, event.Name, err.Error())
-		return
+
+	// 3.0. 同一路径的inode发生变化, 文件已经被轮转, 先读完旧文件剩余的数据, 再从新文件的开头读取
+	checkRotatedFile(currentFileState)
+
+	// 3.1. consumer中等待发送的数据过多, 本次不读取, offset不移动, 之后再次读取
+	if checkBackpressure() {
+		k3.K3LogDebug("[readEventNameByOffset] index_name[%s] path[%s] consumer queue is full, skip reading.", indexName, event.Name)
+		scheduleBackpressureRead(currentFileState)
+		return false
 	}
-	defer fd.Close()
 
-	reader = bufio.NewReader(fd)
+	// 3.2. 从缓存中获取文件句柄, 缓存中不存在就打开文件, 打开失败的文件在退避时间内不再打开, 不影响其他文件
+	if fd, err = openWithBackoff(indexName, currentFileState); err != nil {
+		if errors.Is(err, errOpenBackoff) {
+			k3.K3LogDebug("[readEventNameByOffset] index_name[%s] path[%s] %s, skip reading.", indexName, event.Name, err.Error())
+		} else {
+			k3.K3LogError("[readEventNameByOffset] index_name[%s] event[%s] path[%s] open file failed: %s", indexName, event.Op, event.Name, err.Error())
+		}
+		return false
+	}
+	defer GlobalFdCache.Release(event.Name, fd)
+
+	// 3.3. 根据GlobalFileState的offset开始循环读取文件，每次读取次数为maxReadCount, 并将读取的数据发送给ELK
+	// 读完一次还有数据时继续读取, 连续读取readPasses次之后让出协程, 避免一个持续写入的大文件一直占用读取协程
+	for pass := 1; ; pass++ {
+		GlobalFileStatesLock.Lock()
+		offset := currentFileState.Offset
+		GlobalFileStatesLock.Unlock()
+
+		if err = readFileByOffset(fd, currentFileState, maxReadCount); err != nil {
+			k3.K3LogError("[readEventNameByOffset] index_name[%s] event[%s] path[%s] read file failed: %s", indexName, event.Op, event.Name, err.Error())
+			return false
+		}
 
-	// 3.2. 根据GlobalFileState的offset开始循环读取文件，读取次数为maxReadCount
-	for currentReadCount < maxReadCount {
-		currentReadCount++
-		if _, err = fd.Seek(currentOffset, 0); err != nil {
-			k3.K3LogError("[readEventNameByOffset] index_name[%s] event[%s] path[%s] seek file failed: %s", indexName, event.Op, event.Name, err.Error())
-			break
+		if !hasUnreadData(fd, currentFileState, offset) {
+			return false
 		}
 
-		line, err := reader.ReadString('\n')
+		if pass >= readPasses {
+			k3.K3LogDebug("[readEventNameByOffset] index_name[%s] path[%s] read %d passes, yield to other files.", indexName, event.Name, pass)
+			return true
+		}
 
-		if err != nil {
-			if err == io.EOF {
-				currentOffset += int64(len(line))
-				content += line
-				k3.K3LogDebug("[readEventNameByOffset] read file over.")
-			} else {
-				k3.K3LogError("[readEventNameByOffset] index_name[%s] event[%s] path[%s] read file failed: %s", indexName, event.Op, event.Name, err.Error())
-			}
-			break
+		// 继续读取之前也需要检查consumer中等待发送的数据
+		if checkBackpressure() {
+			scheduleBackpressureRead(currentFileState)
+			return false
 		}
+	}
+}
+
+// readFileByOffset 从fileState.Offset开始读取fd，最多读取maxReadCount次，将读取的数据发送给consumer并更新fileState
+func readFileByOffset(fd *os.File, fileState *FileState, maxReadCount int) error {
+	var (
+		err              error
+		scanner          *lineScanner
+		line             string
+		consumed         int64
+		truncated        bool
+		currentReadCount int
+		currentOffset    int64
+		skipLine         bool
+		idleFlush        bool // 没有换行符的最后一行超过partial_line_timeout没有写入, 作为完整的一行发送
+		events           []readEvent
+		multiline        *multilineBuffer
+		emitted          bool // 多行合并时, 本次读取是否已经有结束的日志
+		rateLimited      bool // 超过rate_limit, 剩余的行之后再读取
+		maxBytes         = maxLineBytes()
+	)
+
+	var rule = getIndexRule(fileState.IndexName)
 
-		currentOffset += int64(len(line))
-		content += line
+	// gzip压缩文件不能按照offset读取, 整个文件只读取一次
+	if isGzipFile(fileState.Path) {
+		return readGzipFile(fd, fileState)
 	}
 
-	// 3.3. 将读取的数据，发送给ELK
-	if len(content) > 0 {
-		k3.K3LogDebug("[readEventNameByOffset] send data to elk : ", content)
-		SendData2Consumer(content, currentFileState)
+	// 文件第一行匹配skip_signature, 整个文件不读取
+	if checkSkipSignature(fd, fileState, rule) {
+		k3.K3LogDebug("[readFileByOffset] path[%s] matches skip signature, skipping.", fileState.Path)
+		return nil
 	}
 
-	// 注意，每次读取完，GlobalFileState的数据已经得到了更新，并没有及时更新到硬盘，用定时器来处理即可
-	GlobalFileStatesLock.Lock()
-	GlobalFileStates[currentFileState.Path].Offset = currentOffset
-	if GlobalFileStates[currentFileState.Path].StartReadTime == 0 {
-		GlobalFileStates[currentFileState.Path].StartReadTime = time.Now().Unix()
+	// whole_file模式, 整个文件作为一条日志发送
+	if rule.WholeFile {
+		return readWholeFile(fd, fileState, rule.WholeFileOnChange)
 	}
-	GlobalFileStates[currentFileState.Path].LastReadTime = time.Now().Unix()
-	GlobalFileStatesLock.Unlock()
-}
 
-// SendData2Consumer  将数据发送给 consumer
-func SendData2Consumer(content string, fileState *FileState) {
+	// 文件被清空(如 : > app.log 或者 O_TRUNC 重新打开), 每次读取前都需要检查
+	if err = checkTruncatedFile(fd, fileState); err != nil {
+		return err
+	}
 
+	GlobalFileStatesLock.Lock()
+	currentOffset = fileState.Offset // 当前文件读取位置
+	skipLine = fileState.SkipLine
+	GlobalFileStatesLock.Unlock()
+
+	// 句柄是复用的, 每次读取前都需要重新定位到offset
+	if _, err = fd.Seek(currentOffset, io.SeekStart); err != nil {
+		return fmt.Errorf("seek file failed: %w", err)
+	}
+
+	scanner = newLineScanner(fd, rule.lineDelimiter, maxBytes)
+	scanner.raw = rule.rawBytes()
+	defer scanner.release()
+
+	if multilineRule := getMultiline(); multilineRule != nil {
+		multiline = &multilineBuffer{rule: multilineRule}
+	}
+
+	for {
+		// 达到最大读取次数后, 还需要读完正在合并的多行日志
+		if currentReadCount >= maxReadCount && (multiline == nil || emitted || !multiline.pending()) {
+			break
+		}
+		currentReadCount++
+
+		line, consumed, truncated, err = scanner.readLine()
+
+		// 上次读取时被截断的行还没有结束, 剩余的内容丢弃, 直到换行符为止
+		if skipLine {
+			currentOffset += consumed
+			if err == nil {
+				skipLine = false
+				continue
+			}
+			if err == io.EOF {
+				err = nil
+			} else {
+				err = fmt.Errorf("read file failed: %w", err)
+			}
+			break
+		}
+
+		if err != nil && !(truncated && err == io.EOF) {
+			if err != io.EOF {
+				err = fmt.Errorf("read file failed: %w", err)
+				break
+			}
+
+			// 最后一行还没有写完(没有换行符), 不读取也不移动offset, 下次从这一行的开头重新读取
+			// 超过partial_line_timeout没有继续写入时, 作为完整的一行发送
+			err = nil
+			if len(line) == 0 || !checkPartialLine(fileState, currentOffset, consumed) {
+				k3.K3LogDebug("[readFileByOffset] read file over.")
+				break
+			}
+			idleFlush = true
+		}
+
+		// 超过rate_limit, 这一行不读取也不移动offset, 之后从这一行重新读取
+		if !rule.allowRead() {
+			rateLimited = true
+			break
+		}
+
+		currentOffset += consumed
+		k3.MetricLinesReadTotal.Add(1)
+		k3.MetricBytesReadTotal.Add(consumed)
+
+		// 超过max_line_bytes的行截断后单独作为一条日志, 已经读到文件末尾时, 之后写入的剩余内容在下次读取时丢弃
+		if truncated {
+			lineStart := currentOffset - consumed
+			k3.K3LogWarn("[readFileByOffset] path[%s] line at offset %d exceeds max_line_bytes %d, truncated.", fileState.Path, lineStart, maxBytes)
+
+			if multiline != nil && multiline.pending() {
+				event, _ := multiline.flush()
+				events = append(events, newReadEvent(event, lineStart))
+			}
+			events = append(events, readEvent{content: line, offset: lineStart, truncated: true})
+			emitted = true
+
+			if err == io.EOF {
+				skipLine = true
+				err = nil
+				break
+			}
+			continue
+		}
+
+		if multiline == nil {
+			events = append(events, newReadEvent(line, currentOffset))
+			continue
+		}
+
+		// 还没有结束的日志在结束的日志之后, 结束的日志的末尾位于currentOffset - multiline.size
+		if event, ok := multiline.add(line); ok {
+			events = append(events, newReadEvent(event, currentOffset-multiline.size))
+			emitted = true
+		}
+
+		// 文件已经没有写入, 正在合并的日志也一起发送
+		if idleFlush && multiline.pending() {
+			event, _ := multiline.flush()
+			events = append(events, newReadEvent(event, currentOffset))
+			emitted = true
+		}
+	}
+
+	// 还没有结束的多行日志不提交offset, 下次从这条日志的开头重新读取, 避免重启后丢失或者重复发送
+	if multiline != nil {
+		if emitted || !multiline.pending() {
+			resetMultilineTimeout(fileState)
+		}
+
+		if multiline.pending() {
+			if err == nil && checkMultilineTimeout(multiline.rule, fileState) {
+				event, _ := multiline.flush()
+				events = append(events, newReadEvent(event, currentOffset))
+				resetMultilineTimeout(fileState)
+			} else {
+				currentOffset -= multiline.size
+			}
+		}
+	}
+
+	// 将读取的数据，发送给ELK
+	if len(events) > 0 {
+		k3.K3LogDebug("[readFileByOffset] send %d events to elk.", len(events))
+		// consumer已经关闭(退出中), 停止读取且不移动offset, 重启后从这次读取的位置重新发送
+		if sendErr := sendEvents(events, fileState); errors.Is(sendErr, k3.ErrConsumerClosed) {
+			return sendErr
+		}
+	}
+
+	// 注意，每次读取完，GlobalFileState的数据已经得到了更新，并没有及时更新到硬盘，用定时器来处理即可
+	GlobalFileStatesLock.Lock()
+	fileState.Offset = currentOffset
+	fileState.SkipLine = skipLine
+	if fileState.StartReadTime == 0 {
+		fileState.StartReadTime = time.Now().Unix()
+	}
+	fileState.LastReadTime = nowFunc().Unix()
+	GlobalFileStatesLock.Unlock()
+
+	// 超过rate_limit时没有读完, 等到有新的令牌之后再读取
+	if rateLimited {
+		k3.K3LogDebug("[readFileByOffset] path[%s] index_name[%s] exceeds rate_limit %d, pause reading.", fileState.Path, fileState.IndexName, rule.RateLimit)
+		scheduleRateLimitRead(fileState, rule.rateLimitRetryInterval())
+	}
+
+	return err
+}
+
+// readEvent 一条按行或者按多行规则拆分好的日志, offset为日志在文件中的开始位置
+type readEvent struct {
+	content   string
+	offset    int64
+	truncated bool // 超过max_line_bytes被截断
+}
+
+// newReadEvent end为日志在文件中的结束位置
+func newReadEvent(content string, end int64) readEvent {
+	return readEvent{content: content, offset: end - int64(len(content))}
+}
+
+// SendData2Consumer  将数据发送给 consumer
+func SendData2Consumer(content string, fileState *FileState) {
+	sendContent(content, 0, fileState)
+}
+
+// sendContent 将content按行发送给 consumer, offset为content在文件(gzip为解压后的内容)中的开始位置
+func sendContent(content string, offset int64, fileState *FileState) {
 	var (
-		ip    string
-		ips   []string
+		ip    = fetchLocalIP()
+		rule  = getIndexRule(fileState.IndexName)
 		datas []string
-		err   error
 	)
 
-	if ips, err = k3.GetLocalIPs(); err != nil {
-		k3.K3LogWarn("get local ips error: %s", err)
-		ip = "127.0.0.1"
-	} else {
-		ip = ips[0]
+	datas = strings.Split(content, rule.lineDelimiter)
+	for i, data := range datas {
+		if i > 0 {
+			offset += int64(len(datas[i-1]) + len(rule.lineDelimiter))
+		}
+
+		if !rule.rawBytes() {
+			data = strings.TrimSpace(data)
+			data = strings.Trim(data, "\n")
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		ship := rule.shouldShip(data)
+		logDryRunFilter(fileState, data, ship)
+		if !ship || !rule.sampled(fileState.Path, offset) {
+			continue
+		}
+
+		if err := trackData(ip, data, offset, false, fileState); errors.Is(err, k3.ErrConsumerClosed) {
+			return
+		}
 	}
+}
+
+// sendEvents 将已经按行或者按多行规则拆分好的日志逐条发送, 多行日志内部的换行保留
+// consumer已经关闭时停止发送, 返回k3.ErrConsumerClosed
+func sendEvents(events []readEvent, fileState *FileState) error {
+	var (
+		ip   = fetchLocalIP()
+		rule = getIndexRule(fileState.IndexName)
+	)
 
-	datas = strings.Split(content, "\n")
-	for _, data := range datas {
-		data = strings.TrimSpace(data)
-		data = strings.Trim(data, "\n")
+	for _, event := range events {
+		// 多行日志合并后整体判断是否需要发送
+		data := strings.TrimSuffix(event.content, rule.lineDelimiter)
+		if !rule.rawBytes() {
+			data = strings.TrimSpace(data)
+		}
 		if len(data) == 0 {
 			continue
 		}
 
-		if err = GlobalDataAnalytics.Track(config.GlobalConfig.Account.AccountId, config.GlobalConfig.Account.AppId, ip, fileState.IndexName,
-			map[string]interface{}{
-				"_data": data,
-				"_path": fileState.Path,
-			}); err != nil {
-			k3.K3LogError("Track: %s", err.Error())
+		ship := rule.shouldShip(data)
+		logDryRunFilter(fileState, data, ship)
+		if !ship || !rule.sampled(fileState.Path, event.offset) {
+			continue
+		}
+
+		if err := trackData(ip, data, event.offset, event.truncated, fileState); errors.Is(err, k3.ErrConsumerClosed) {
+			return err
 		}
 	}
+
+	return nil
+}
+
+// trackData 将一条日志发送给 consumer, offset为日志在文件中的开始位置, 用于计算content_hash, truncated为true时标记_truncated
+func trackData(ip, data string, offset int64, truncated bool, fileState *FileState) error {
+	var (
+		rule       = getIndexRule(fileState.IndexName)
+		properties = map[string]interface{}{
+			"_data":            data,
+			"_path":            fileState.Path,
+			sender.OffsetField: offset, // 同一个文件的日志按照读取顺序递增, 用于在存储中恢复文件内的顺序
+		}
+		fields    map[string]interface{}
+		timestamp time.Time
+		ok        bool
+	)
+
+	if truncated {
+		properties[TruncatedField] = true
+	}
+
+	// base64编码时_data为编码后的内容, 解析和过滤使用原始内容
+	if encoded, ok := rule.encodeData(data); ok {
+		properties["_data"] = encoded
+		properties[EncodingField] = rule.encoding
+	}
+
+	// 采样发送的日志附加采样率, 统计时用于还原数量
+	if rate := rule.sampleRate(); rate < 1 {
+		properties[SampleRateField] = rate
+	}
+
+	// 附加主机名、来源文件等字段
+	getEnrich().enrich(properties, fileState)
+
+	// 重新读取同一位置的同一条日志时hash相同, elk使用hash作为文档_id, 重复发送时覆盖而不是新增
+	if config.GlobalConfig.ELK.IDStrategy == sender.IDStrategyContentHash {
+		properties[sender.ContentHashField] = contentHash(fileState.Path, offset, data)
+	}
+
+	// json/logfmt格式的日志解析后合并字段
+	fields = mergeParsedFields(rule, data, properties)
+
+	// 日志中解析出的时间作为日志时间, 解析失败时使用读取时间
+	if timestamp, ok = extractTimestamp(rule, data, fields); !ok {
+		timestamp = time.Now()
+	}
+	logDryRunParse(rule, fileState, fields, timestamp, ok)
+
+	// 处理器丢弃的日志不等待确认, offset照常前进
+	event := &protocol.Data{
+		AccountId:  config.GlobalConfig.Account.AccountId,
+		AppId:      config.GlobalConfig.Account.AppId,
+		Ip:         ip,
+		Timestamp:  timestamp,
+		IndexName:  fileState.IndexName,
+		Properties: properties,
+	}
+	if !rule.process(event) {
+		return nil
+	}
+
+	// sender确认接收之前, 落盘的offset不会超过这条日志; consumer没有接收时不等待确认
+	var (
+		tracker      = deliveryTrackerOf(fileState)
+		pending, ack = tracker.newPending(offset)
+	)
+
+	if err := GlobalDataAnalytics.TrackWithAck(event.AccountId, event.AppId, event.Ip, event.IndexName, event.Timestamp, event.Properties, ack); err != nil {
+		k3.K3LogError("Track: %s", err.Error())
+		return err
+	}
+	tracker.add(pending)
+
+	return nil
+}
+
+// fetchLocalIP 获取本机IP, 获取失败使用127.0.0.1
+func fetchLocalIP() string {
+	var (
+		ips []string
+		err error
+	)
+
+	if ips, err = k3.GetLocalIPs(); err != nil {
+		k3.K3LogWarn("get local ips error: %s", err)
+		return "127.0.0.1"
+	}
+
+	if len(ips) == 0 {
+		return "127.0.0.1"
+	}
+
+	return ips[0]
 }
 
 // 日志写入的监听
 func writeEvent(indexName string, event fsnotify.Event) {
 	// 判断当前文件是否已经存在，不存在就创建
+	var (
+		fileState  *FileState
+		discovered bool
+	)
+
 	GlobalFileStatesLock.Lock()
 	if _, exists := GlobalFileStates[event.Name]; !exists {
+		// 隐藏文件、临时文件和不匹配include_globs/exclude_globs的文件不读取
+		if ignoredFile(indexName, event.Name) || !getIndexRule(indexName).shouldWatch(event.Name) {
+			GlobalFileStatesLock.Unlock()
+			return
+		}
 
-		GlobalFileStates[event.Name] = &FileState{
+		fileState = &FileState{
 			Path:          event.Name,
 			Offset:        0,
 			StartReadTime: time.Now().Unix(),
-			LastReadTime:  time.Now().Unix(),
+			LastReadTime:  nowFunc().Unix(),
 			IndexName:     indexName,
 		}
+		fileState.Dev, fileState.Inode, _ = k3.FileIdentity(event.Name)
+		GlobalFileStates[event.Name] = fileState
+		discovered = true
 	}
 	GlobalFileStatesLock.Unlock()
 
-	// 每次监听到文件变化，需要开一个协程
-	processingWg.Add(1)
-	// 监测到某个文件有写入，循环读取
-	go processing(indexName, event)
+	if discovered {
+		emitLifecycleEvent(LifecycleFileDiscovered, fileState)
+	}
+
+	// 高频写入时, 窗口内同一个文件的写入事件合并为一次读取
+	if debounceInterval := config.GlobalConfig.Watch.DebounceInterval; debounceInterval > 0 {
+		debounceWrite(event.Name, time.Duration(debounceInterval)*time.Millisecond, func() {
+			processing(indexName, event)
+		})
+		return
+	}
+
+	// 监测到某个文件有写入，提交读取任务，循环读取
+	GlobalScheduler.Submit(func() {
+		processing(indexName, event)
+	})
 }
 
 // 文件或目录创建
@@ -517,38 +1412,200 @@ func createEvent(indexName string, event fsnotify.Event, watcher *fsnotify.Watch
 	} else {
 		// fmt.Println("WRITE", "==>", event.Name)
 		if ok {
-			// 将目录加入到监听
-			if err = watcher.Add(event.Name); err != nil {
-				k3.K3LogError("[createEvent] index_name[%s] event[%s] path[%s] add watcher failed: %s", indexName, event.Op, event.Name, err.Error())
-				return
-			}
+			// 将目录及其所有子目录加入到监听, 目录中已经存在的文件(如整体移动进来的目录)加入到GlobalFileStates并读取
+			createDirectory(indexName, event, watcher)
 		} else {
 			// 将文件写入到GlobalFileStates中, 无需同步给硬盘，交给定时器处理同步工作
-			GlobalFileStatesLock.Lock()
-			GlobalFileStates[event.Name] = &FileState{
-				Path:          event.Name,
-				Offset:        0,
-				StartReadTime: 0,
-				LastReadTime:  0,
-				IndexName:     indexName,
+			// 已经记录的文件被重新创建(轮转: 改名后创建同名的新文件), 主动读取一次, 由checkRotatedFile读完旧文件剩余的数据后从新文件的开头读取
+			if !createFile(indexName, event.Name) && trackedFile(event.Name) {
+				writeEvent(indexName, fsnotify.Event{Name: event.Name, Op: fsnotify.Write})
 			}
-			GlobalFileStatesLock.Unlock()
 		}
 	}
 }
 
+// createDirectory 新建(或移动进来)的目录, 递归添加所有子目录的监听, 已经存在的文件从头开始读取
+func createDirectory(indexName string, event fsnotify.Event, watcher *fsnotify.Watcher) {
+	var (
+		paths     []string
+		files     []string
+		watchList = make(map[string]bool)
+		err       error
+	)
+
+	if paths, err = k3.FetchDirectoryPath(event.Name, -1); err != nil {
+		k3.K3LogError("[createEvent] index_name[%s] event[%s] path[%s] fetch directory failed: %s", indexName, event.Op, event.Name, err.Error())
+		return
+	}
+
+	for _, path := range watcher.WatchList() {
+		watchList[path] = true
+	}
+
+	// 先添加目录监听, 再遍历文件, 避免遍历之后新建的文件没有被监听到
+	for _, path := range paths {
+		if watchList[path] {
+			continue
+		}
+
+		if err = watcher.Add(path); err != nil {
+			k3.K3LogError("[createEvent] index_name[%s] event[%s] path[%s] add watcher failed: %s", indexName, event.Op, path, err.Error())
+			return
+		}
+	}
+
+	if files, err = k3.FetchDirectoryWithConfig(event.Name, fetchDirectoryConfig()); err != nil {
+		k3.K3LogError("[createEvent] index_name[%s] event[%s] path[%s] fetch directory file failed: %s", indexName, event.Op, event.Name, err.Error())
+		return
+	}
+
+	sortScanFiles(files)
+	for _, file := range files {
+		if createFile(indexName, file) {
+			// 目录中已经存在的文件不会再收到写入事件, 主动读取一次
+			writeEvent(indexName, fsnotify.Event{Name: file, Op: fsnotify.Write})
+		}
+	}
+}
+
+// createFile 将新文件加入到GlobalFileStates中, 已经存在的文件、隐藏文件和临时文件、不匹配include_globs/exclude_globs的文件和修改时间早于start_date的文件不添加, 返回是否新增
+func createFile(indexName string, path string) bool {
+	if ignoredFile(indexName, path) || !getIndexRule(indexName).shouldWatch(path) {
+		return false
+	}
+
+	if startTime, _ := config.GlobalConfig.Watch.StartTime(); beforeStartDate(path, startTime) {
+		return false
+	}
+
+	fileState := &FileState{
+		Path:          path,
+		Offset:        0,
+		StartReadTime: 0,
+		LastReadTime:  0,
+		IndexName:     indexName,
+	}
+	fileState.Dev, fileState.Inode, _ = k3.FileIdentity(path)
+
+	GlobalFileStatesLock.Lock()
+	if _, exists := GlobalFileStates[path]; exists {
+		GlobalFileStatesLock.Unlock()
+		return false
+	}
+	GlobalFileStates[path] = fileState
+	GlobalFileStatesLock.Unlock()
+
+	emitLifecycleEvent(LifecycleFileDiscovered, fileState)
+	return true
+}
+
 // 文件或目录删除
 func removeEvent(event fsnotify.Event, watcher *fsnotify.Watcher) {
+	var (
+		fileState *FileState
+		exists    bool
+		fd        *os.File
+		cached    bool
+	)
 	// 如果是目录，删除watcher的监听， 如果是文件，删除文件FileStates中的记录
 	// 注意， 当文件被删除或者改名，原来的文件其实已经被删除了, 那再去判断文件是什么类型已经没有意义了，所以需要直接处理
 	GlobalFileStatesLock.Lock()
+	fileState, exists = GlobalFileStates[event.Name]
 	delete(GlobalFileStates, event.Name)
 	GlobalFileStatesLock.Unlock()
+
+	// 将句柄从缓存中取出，防止同名的新文件复用旧文件的句柄
+	if fd, cached = GlobalFdCache.Take(event.Name); cached {
+		if exists && config.GlobalConfig.Watch.DrainOnRemove {
+			// 删除前写入的数据还可以通过已打开的句柄读取，读取完再关闭句柄
+			processingWg.Add(1)
+			go drainRemovedFile(fd, fileState)
+		} else {
+			GlobalFdCache.Release(event.Name, fd)
+		}
+	}
+
+	if exists {
+		emitLifecycleEvent(LifecycleFileRemoved, fileState)
+	}
+
 	// 这里没有判断是不是目录了， 无所谓，直接删了就行了
 	_ = watcher.Remove(event.Name)
 	// fmt.Println(event.Name, "------>", watcher.WatchList())
 }
 
+// drainRemovedFile 文件被删除后，通过缓存的句柄读取删除前还未读取的数据
+// 只使用已经打开的句柄，不会重新打开已经不存在的文件，读取时间受drain_timeout限制
+func drainRemovedFile(fd *os.File, fileState *FileState) {
+	var (
+		drainTimeout = config.GlobalConfig.Watch.DrainTimeout
+		deadline     time.Time
+		err          error
+	)
+
+	defer processingWg.Done()
+	defer GlobalFdCache.Release(fileState.Path, fd)
+
+	if drainTimeout <= 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+	deadline = time.Now().Add(time.Duration(drainTimeout) * time.Second)
+
+	// 等待正在读取该文件的协程结束，同一个句柄不能被并发读取
+	for {
+		if _, loading := processingMap.LoadOrStore(fileState.Path, true); !loading {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			k3.K3LogWarn("[drainRemovedFile] %s is still being processed, give up drain.", fileState.Path)
+			return
+		}
+		time.Sleep(DefaultDrainWaitInterval)
+	}
+	defer processingMap.Delete(fileState.Path)
+
+	if err = drainFd(fd, fileState, deadline); err != nil {
+		k3.K3LogError("[drainRemovedFile] path[%s] drain file failed: %s", fileState.Path, err.Error())
+		return
+	}
+
+	GlobalFileStatesLock.Lock()
+	offset := fileState.Offset
+	GlobalFileStatesLock.Unlock()
+
+	k3.K3LogDebug("[drainRemovedFile] path[%s] drain file over, offset: %d", fileState.Path, offset)
+}
+
+// drainFd 从fileState.Offset开始读取fd, 直到没有新数据或者超过deadline
+func drainFd(fd *os.File, fileState *FileState, deadline time.Time) error {
+	var (
+		before int64
+		offset int64
+		err    error
+	)
+
+	for time.Now().Before(deadline) {
+		GlobalFileStatesLock.Lock()
+		before = fileState.Offset
+		GlobalFileStatesLock.Unlock()
+
+		if err = readFileByOffset(fd, fileState, DefaultMaxReadCount); err != nil {
+			return err
+		}
+
+		GlobalFileStatesLock.Lock()
+		offset = fileState.Offset
+		GlobalFileStatesLock.Unlock()
+
+		if offset == before {
+			break
+		}
+	}
+
+	return nil
+}
+
 // ClockSyncGlobalFileStatesToDiskFile 定时将GlobalFileStates数据同步到硬盘
 func ClockSyncGlobalFileStatesToDiskFile(filePath string) {
 	// 创建定时器
@@ -564,47 +1621,120 @@ func ClockSyncGlobalFileStatesToDiskFile(filePath string) {
 
 	t = time.NewTicker(time.Duration(syncInterval) * time.Second)
 
+	// 使用启动时的WaitGroup和上下文, 重新InitVars之后不会影响新的协程
 	ClockWG.Add(1)
-	go func() {
-		defer ClockWG.Done()
+	go func(clockWG *sync.WaitGroup, ctx context.Context, cancel context.CancelFunc) {
+		defer clockWG.Done()
 		defer func() {
 			t.Stop()
 		}()
-		defer WatcherContextCancel()
+		defer cancel()
 
 		for {
 			select {
 			case <-t.C:
+				// 先提交缓存中的数据, 确认接收后offset才会前进
+				if config.GlobalConfig.Watch.FlushBeforeSync {
+					if err = GlobalDataAnalytics.Flush(); err != nil {
+						k3.K3LogError("[ClockSyncGlobalFileStatesToDiskFile] flush consumer before sync failed: %s", err.Error())
+					}
+				}
 				// 如果只是保持失败，没必要让整个程序退出
 				if err = SaveGlobalFileStatesToDiskFile(filePath); err != nil {
 					k3.K3LogError("[ClockSyncGlobalFileStatesToDiskFile] save file state to disk failed: %v\n", err)
 				}
 				k3.K3LogDebug("[ClockSyncGlobalFileStatesToDiskFile] save file state to disk success.")
-			case <-WatcherContext.Done(): // 退出协程，并退出ClockSyncGlobalFileStatesToDiskFile的定时器
+				logActiveReaders()
+			case <-ctx.Done(): // 退出协程，并退出ClockSyncGlobalFileStatesToDiskFile的定时器
 				k3.K3LogInfo("[ClockSyncGlobalFileStatesToDiskFile]  Accept clock goroutine exit singal.")
 				return
 			}
 		}
-	}()
+	}(ClockWG, WatcherContext, WatcherContextCancel)
 
-	go func() {
-		ClockWG.Wait() // 阻塞等待Clock定时器协程协程退出
+	go func(clockWG *sync.WaitGroup, cancel context.CancelFunc) {
+		clockWG.Wait() // 阻塞等待Clock定时器协程协程退出
 		k3.K3LogInfo("[ClockSyncGlobalFileStatesToDiskFile]  All clock goroutine  exit.")
-		WatcherContextCancel()
-	}()
+		cancel()
+	}(ClockWG, WatcherContextCancel)
+}
+
+// ClockIdleCloseFd 定时关闭长时间没有写入的文件句柄, 只关闭句柄, 文件状态(offset)保留, 再次写入时重新打开继续读取
+// 与obsolete不同, obsolete只处理超过obsolete_date天没有读取且已经读完的文件
+func ClockIdleCloseFd() {
+	var (
+		idleCloseTimeout = config.GlobalConfig.Watch.IdleCloseTimeout
+		interval         time.Duration
+		t                *time.Ticker
+	)
+
+	// 没有配置时不开启
+	if idleCloseTimeout <= 0 {
+		return
+	}
+
+	// 检查间隔为超时时间的一半, 关闭时间最多比超时时间晚一半
+	if interval = time.Duration(idleCloseTimeout) * time.Second / 2; interval < time.Second {
+		interval = time.Second
+	}
+	t = time.NewTicker(interval)
+
+	ClockWG.Add(1)
+	go func(clockWG *sync.WaitGroup, ctx context.Context) {
+		defer clockWG.Done()
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				if closed := GlobalFdCache.CloseIdle(time.Duration(idleCloseTimeout) * time.Second); closed > 0 {
+					k3.K3LogDebug("[ClockIdleCloseFd] close %d idle fds.", closed)
+				}
+			case <-ctx.Done():
+				k3.K3LogInfo("[ClockIdleCloseFd] Accept clock goroutine exit singal.")
+				return
+			}
+		}
+	}(ClockWG, WatcherContext)
 }
 
 // Run 启动监听, directory 是一个map，key是索引名称，value是索引对应的目录列表, 所有的子目录也包含
 func Run(directory map[string][]string) (func(), error) {
+	return RunWithContext(context.Background(), directory)
+}
+
+// RunWithContext 与Run相同, 内部的WatcherContext由ctx派生
+// ctx取消时所有协程退出, 并提交consumer中剩余的数据, 之后调用返回的关闭函数不会重复关闭
+func RunWithContext(ctx context.Context, directory map[string][]string) (func(), error) {
 	var (
 		err error
 	)
 	// 初始化用到的所有全局变量
-	InitVars()
+	InitVarsWithContext(ctx)
+
+	// 编译每个index_name的读取规则
+	if err = InitIndexRules(config.GlobalConfig.Watch.Index); err != nil {
+		return nil, fmt.Errorf("[Run] InitIndexRules failed: %w", err)
+	}
+
+	// 编译多行日志合并规则
+	if err = InitMultiline(config.GlobalConfig.Watch.Multiline); err != nil {
+		return nil, fmt.Errorf("[Run] InitMultiline failed: %w", err)
+	}
+
+	// 每条日志附加的字段, 主机名只获取一次
+	if err = InitEnrich(config.GlobalConfig.Watch.EnrichFields); err != nil {
+		return nil, fmt.Errorf("[Run] InitEnrich failed: %w", err)
+	}
+
+	// 打开开启wal的index_name的wal文件
+	if err = InitWals(); err != nil {
+		return nil, fmt.Errorf("[Run] InitWals failed: %w", err)
+	}
 
 	// 1. 初始化批量日志写入, 引入elk
 	if err = InitConsumerBatchLog(); err != nil {
-		return nil, errors.New("[Run] InitConsumerBatchLog failed: " + err.Error())
+		return nil, fmt.Errorf("[Run] InitConsumerBatchLog failed: %w", err)
 	}
 
 	// 2. 初始化FileState 文件, state file 文件是以工作根目录为基准的相对目录
@@ -612,20 +1742,27 @@ func Run(directory map[string][]string) (func(), error) {
 	if !k3.FileExists(FileStateFilePath) {
 		// 创建文件
 		if _, err = os.OpenFile(FileStateFilePath, os.O_CREATE, os.ModePerm); err != nil {
-			return nil, errors.New("[Run] create state file failed: " + err.Error())
+			return nil, fmt.Errorf("[Run] %w: create state file: %w", ErrStateLoad, err)
 		}
 	}
 
 	// 打开文件FileStateFilePath, 并将FileStateFilePath的数据load到GlobalFileStates变量中(内存)
 	if err = LoadDiskFileToGlobalFileStates(FileStateFilePath); err != nil {
-		return nil, errors.New("[Run] load file state failed : " + err.Error())
+		return nil, fmt.Errorf("[Run] %w", err)
 	}
 
 	// 2.2. 遍历硬盘上的所有文件，如果GlobalFileStates中没有，就add
 	// 2.3. 检查GlobalFileStates中的文件是否存在，不存在就delete掉
 	// 2.4. 将GlobalFileStates最新数据更新到FileStateFilePath
 	if err = ScanLogFileToGlobalFileStatesAndSaveToDiskFile(directory, FileStateFilePath); err != nil {
-		return nil, errors.New("[Run] scan log file state failed: " + err.Error())
+		return nil, fmt.Errorf("[Run] %w", err)
+	}
+
+	// 2.5. 开启--reset-to-end时, 忽略已经记录的offset, 所有文件从当前末尾开始读取
+	if ResetToEnd {
+		if err = ResetFileStatesToEnd(FileStateFilePath); err != nil {
+			return nil, fmt.Errorf("[Run] reset file state to end failed: %w", err)
+		}
 	}
 
 	// 3. 初始化watcher，每个index_name 创建一个协程来监听, 如果有协程创建不成功，或者意外退出，则程序终止
@@ -635,27 +1772,163 @@ func Run(directory map[string][]string) (func(), error) {
 
 	// 4. TODO 需要检查代码 -> 定时更新 FileState 数据到硬盘
 	ClockSyncGlobalFileStatesToDiskFile(FileStateFilePath)
-	ClockSyncObsoleteFile(directory, FileStateFilePath)
+	ClockSyncObsoleteFile(FileStateFilePath)
+	ClockIdleCloseFd()
+	ClockRetryFailedDirectories()
+	ClockRescanWatchDirectory()
+	ClockFlushSync(FileStateFilePath)
+
+	return closeOnDone(ctx), nil
+}
+
+// closeOnDone ctx取消时调用Closed, 返回的关闭函数与ctx取消只会执行一次Closed
+func closeOnDone(ctx context.Context) func() {
+	var (
+		once   sync.Once
+		closed = make(chan struct{})
+		closer = func() {
+			once.Do(func() {
+				Closed()
+				close(closed)
+			})
+		}
+	)
+
+	// context.Background()等永远不会取消的ctx不需要等待
+	if ctx.Done() == nil {
+		return closer
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			k3.K3LogInfo("[RunWithContext] parent context done: %s", ctx.Err().Error())
+			closer()
+		case <-closed:
+		}
+	}()
+
+	return closer
+}
+
+// waitUntil 执行fn, 在deadline之前完成返回true, 超时后不再等待fn返回
+func waitUntil(fn func(), deadline time.Time) bool {
+	var (
+		done  = make(chan struct{})
+		timer = time.NewTimer(time.Until(deadline))
+	)
+	defer timer.Stop()
+
+	go func() {
+		defer close(done)
+		fn()
+	}()
 
-	return Closed, nil
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
 }
 
-// Closed 清理协程，并关闭资源
+// DrainProcessing 停止接收新的读取任务(写事件、定时重新读取等), 等待正在读取的协程结束, 之后可以安全地关闭consumer
+// 超过timeout还没有结束时, 记录仍在读取的文件并返回ErrDrainTimeout, 避免卡住的读取导致无法退出
+func DrainProcessing(timeout time.Duration) error {
+	var files []string
+
+	GlobalScheduler.Close()
+	readTimers.stop()
+
+	if waitUntil(processingWg.Wait, time.Now().Add(timeout)) {
+		return nil
+	}
+
+	processingMap.Range(func(key, _ interface{}) bool {
+		files = append(files, key.(string))
+		return true
+	})
+	sort.Strings(files)
+
+	k3.K3LogError("[DrainProcessing] reading goroutines not finished after %s, still processing: %v", timeout, files)
+	return fmt.Errorf("[DrainProcessing] %w after %s, still processing: %v", ErrDrainTimeout, timeout, files)
+}
+
+// Closed 清理协程，并关闭资源, 关闭过程中的错误只记录日志
 func Closed() {
-	k3.K3LogDebug("[Closed] closed watch.")
+	if err := Stop(); err != nil {
+		k3.K3LogError("[Closed] closed watch with error: %s", err.Error())
+	}
+}
+
+// Stop 清理协程，并关闭所有资源，尽最大努力关闭每一个资源，返回所有关闭失败的错误
+// 先等待读取协程结束, 再提交consumer中剩余的数据并关闭sender, 超过shutdown_timeout时返回超时错误
+func Stop() error {
+	var (
+		errs     []error
+		timeout  = config.GlobalConfig.Watch.ShutdownTimeout
+		deadline time.Time
+		closeErr error
+	)
+
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	deadline = time.Now().Add(time.Duration(timeout) * time.Second)
+
+	k3.K3LogDebug("[Stop] closed watch.")
 	// 回收定时器协程和监听协程
 	WatcherContextCancel()
-	time.Sleep(time.Second * 1) // 留1s的时间给协程来回收资源
-	// 回收批量写入日志的协程
-	GlobalDataAnalytics.Close()
+
+	// 超过shutdown_timeout时取消consumer正在进行的发送, 没有响应的请求不会一直占用sender
+	cancelSend := time.AfterFunc(time.Until(deadline), senderContextCancel)
+	defer cancelSend.Stop()
+
+	// 不再接收新的读取任务, 等待所有读取文件的协程结束, 读取的数据都已经交给consumer
+	if err := DrainProcessing(time.Until(deadline)); err != nil {
+		errs = append(errs, err)
+	}
+
+	// 回收批量写入日志的协程, consumer提交剩余的数据后关闭sender
+	if !waitUntil(func() { closeErr = GlobalDataAnalytics.Close() }, deadline) {
+		errs = append(errs, fmt.Errorf("flush consumer timeout after %ds, unsent data may be lost", timeout))
+	} else if closeErr != nil {
+		errs = append(errs, fmt.Errorf("close consumer failed: %w", closeErr))
+	}
+
+	// consumer关闭时已经提交了剩余数据, 之后再关闭wal, 没有确认的数据保留在wal中
+	if err := CloseWals(); err != nil {
+		errs = append(errs, fmt.Errorf("close wal failed: %w", err))
+	}
+
+	// 关闭所有缓存的文件句柄
+	if err := GlobalFdCache.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close fd cache failed: %w", err))
+	}
+
+	// watcher协程退出时关闭watcher失败的错误
+	closeErrorsLock.Lock()
+	errs = append(errs, closeErrors...)
+	closeErrors = nil
+	closeErrorsLock.Unlock()
+
+	return errors.Join(errs...)
+}
+
+// recordCloseError 记录协程退出时关闭资源失败的错误, 由Stop统一返回
+func recordCloseError(err error) {
+	k3.K3LogError("[recordCloseError] %s", err.Error())
+	closeErrorsLock.Lock()
+	closeErrors = append(closeErrors, err)
+	closeErrorsLock.Unlock()
 }
 
-// obsolete_interval : 1
+// obsolete_interval : 1 # 单位小时, 默认1, 定时检查长时间没有写入的文件
 // obsolete_date : 1 	 # 单位天，  默认1， 表示如果文件一天都没有读写，表示已经没有写入了
-// obsolete_max_read_count : 1000  #
+// obsolete_max_read_count : 1000  # 对于长时间没有读写的文件， 一次最大读取次数
 
-// ClockSyncObsoleteFile  定时长时间未读取的文件
-func ClockSyncObsoleteFile(directory map[string][]string, filePath string) {
+// ClockSyncObsoleteFile  定时长时间未读取的文件, 使用当前监听的目录(热加载后会变化)扫描
+func ClockSyncObsoleteFile(filePath string) {
 	// 创建定时器
 	var (
 		obsoleteInterval     = config.GlobalConfig.Watch.ObsoleteInterval     // 单位小时, 默认1  定时1小时检查一下GlobalFileState中，是否文件是不是有已经读取完的
@@ -664,77 +1937,140 @@ func ClockSyncObsoleteFile(directory map[string][]string, filePath string) {
 
 		t *time.Ticker
 	)
-	t = time.NewTicker(time.Duration(obsoleteInterval) * time.Minute)
+
+	if obsoleteInterval <= 0 {
+		obsoleteInterval = DefaultObsoleteInterval
+	}
+
+	if obsoleteDate <= 0 {
+		obsoleteDate = DefaultObsoleteDate
+	}
+
+	if obsoleteMaxReadCount <= 0 {
+		obsoleteMaxReadCount = DefaultMaxReadCount
+	}
+
+	t = time.NewTicker(time.Duration(obsoleteInterval) * time.Hour)
 
 	ClockObsoleteWG.Add(1)
-	go func() {
-		defer ClockObsoleteWG.Done()
+	go func(clockObsoleteWG *sync.WaitGroup, ctx context.Context, cancel context.CancelFunc) {
+		defer clockObsoleteWG.Done()
 		defer t.Stop()
-		defer WatcherContextCancel()
+		defer cancel()
 
 		for {
 			select {
 			case <-t.C:
 				// 定时信号来了
 				// 1. 解决硬盘已经将文件删除了，但是GlobalFileState或硬盘还存在的问题
-				_ = ScanLogFileToGlobalFileStatesAndSaveToDiskFile(directory, filePath)
-				// 2. 解决长时间未读取的文件，读取完整的问题
+				_ = scanLogFiles(getWatchDirectory(), filePath, false)
+				// 2. 解决长时间未读取的文件，读取完整的问题, 已经读完的文件标记为obsolete
 				readObsoleteFiles(obsoleteDate, obsoleteMaxReadCount)
-			case <-WatcherContext.Done():
+			case <-ctx.Done():
 				k3.K3LogInfo("[ClockSyncObsoleteFile] Accept clock obsolete exit signal.")
 				return
 			}
 		}
-	}()
+	}(ClockObsoleteWG, WatcherContext, WatcherContextCancel)
 
-	go func() {
-		ClockObsoleteWG.Wait()
+	go func(clockObsoleteWG *sync.WaitGroup, cancel context.CancelFunc) {
+		clockObsoleteWG.Wait()
 		k3.K3LogInfo("[ClockSyncObsoleteFile]  All clock obsolete goroutine exit.")
-		WatcherContextCancel()
-	}()
+		cancel()
+	}(ClockObsoleteWG, WatcherContextCancel)
 }
 
-// readHistoryFiles 解决长时间未读取的文件，读取完整的问题
+// readObsoleteFiles 检查超过obsoleteDate天没有读取的文件
+// 1. 没有读完的文件, 读取剩余的数据
+// 2. 已经读完的文件(offset == size, gzip文件为Completed), 关闭句柄并标记为obsolete, 不再定时检查, 再次写入时恢复
+// 3. obsolete文件或者长时间没有读取的文件已经不存在时(启动扫描时保留的最近删除的文件), 删除文件状态
 func readObsoleteFiles(obsoleteDate, obsoleteMaxReadCount int) {
 	var (
-		// 满足需要读取的文件
-		readFilePath = make([]string, 0)
+		now       = nowFunc().Unix()
+		deadline  = int64(obsoleteDate * 24 * 60 * 60)
+		readFiles = make([]*FileState, 0)
+		obsoletes = make([]*FileState, 0)
+		fileInfo  os.FileInfo
+		err       error
 	)
 
-	// 1. 遍历GlobalFileStates中记录的文件，长时间未被操作
-	for fileName, fileState := range GlobalFileStates {
-		// 查看文件是否满足长时间未读取的条件
-		if duration := time.Now().Unix() - fileState.LastReadTime; duration > int64(obsoleteDate*24*60*60) {
-			readFilePath = append(readFilePath, fileName)
+	GlobalFileStatesLock.Lock()
+	for _, fileState := range GlobalFileStates {
+		if fileState.Obsolete {
+			obsoletes = append(obsoletes, fileState)
+		} else if now-fileState.LastReadTime > deadline {
+			// 查看文件是否满足长时间未读取的条件
+			readFiles = append(readFiles, fileState)
+		}
+	}
+	GlobalFileStatesLock.Unlock()
+
+	// 1. 已经不存在的obsolete文件, 删除文件状态
+	for _, fileState := range obsoletes {
+		if _, err = os.Stat(fileState.Path); os.IsNotExist(err) {
+			removeMissingFileState(fileState)
 		}
 	}
 
 	// 2. 开协程挨个读写
-	for _, readFile := range readFilePath {
-		// 如果文件已经读取完了，就不用再读取了
-		if fileInfo, err := os.Stat(readFile); err != nil {
+	for _, fileState := range readFiles {
+		if fileInfo, err = os.Stat(fileState.Path); os.IsNotExist(err) {
+			removeMissingFileState(fileState)
+			continue
+		} else if err != nil {
 			k3.K3LogError("[readObsoleteFiles] stat file error: %s", err.Error())
 			continue
-		} else {
-			if fileInfo.Size() == GlobalFileStates[readFile].Offset {
-				continue
-			}
 		}
 
-		processingWg.Add(1)
-		go processReadObsoleteFile(GlobalFileStates[readFile], obsoleteMaxReadCount)
+		// 如果文件已经读取完了，就不用再读取了; gzip文件的offset是解压后的位置, 不能与文件大小比较, 使用Completed
+		GlobalFileStatesLock.Lock()
+		completed := fileInfo.Size() == fileState.Offset
+		if isGzipFile(fileState.Path) {
+			completed = fileState.Completed
+		}
+		GlobalFileStatesLock.Unlock()
+
+		if completed {
+			markObsoleteFile(fileState)
+			continue
+		}
+
+		fileState := fileState
+		GlobalScheduler.Submit(func() {
+			processReadObsoleteFile(fileState, obsoleteMaxReadCount)
+		})
 	}
+}
 
-	go processingWg.Wait()
+// removeMissingFileState 删除已经不存在的文件的状态
+func removeMissingFileState(fileState *FileState) {
+	GlobalFileStatesLock.Lock()
+	delete(GlobalFileStates, fileState.Path)
+	GlobalFileStatesLock.Unlock()
+
+	k3.K3LogInfo("[readObsoleteFiles] path[%s] not exists, remove file state.", fileState.Path)
+	emitLifecycleEvent(LifecycleFileRemoved, fileState)
 }
 
-func processReadObsoleteFile(fileState *FileState, maxReadCount int) {
-	defer processingWg.Done()
-	processingSem <- struct{}{}
-	defer func() {
-		<-processingSem
-	}()
+// markObsoleteFile 长时间未写入且已经读取完的文件, 主动关闭缓存的句柄并标记为obsolete
+func markObsoleteFile(fileState *FileState) {
+	// 正在读取的文件不处理, 下次检查时再标记
+	if _, loading := processingMap.LoadOrStore(fileState.Path, true); loading {
+		return
+	}
+	defer processingMap.Delete(fileState.Path)
+
+	GlobalFdCache.Remove(fileState.Path)
+
+	GlobalFileStatesLock.Lock()
+	fileState.Obsolete = true
+	GlobalFileStatesLock.Unlock()
+
+	k3.K3LogInfo("[markObsoleteFile] path[%s] is obsolete, file descriptor closed.", fileState.Path)
+	emitLifecycleEvent(LifecycleFileObsoleted, fileState)
+}
 
+func processReadObsoleteFile(fileState *FileState, maxReadCount int) {
 	// 已经有协程在处理这个文件，跳过
 	if _, ok := processingMap.LoadOrStore(fileState.Path, true); ok {
 		k3.K3LogWarn("[processReadFile] %s is already being processed, skipping .", fileState.Path)
@@ -743,63 +2079,18 @@ func processReadObsoleteFile(fileState *FileState, maxReadCount int) {
 	defer processingMap.Delete(fileState.Path)
 
 	var (
-		fd     *os.File
-		err    error
-		reader *bufio.Reader
+		fd  *os.File
+		err error
 	)
 
-	// 打开待读取的文件
-	if fd, err = os.OpenFile(fileState.Path, os.O_RDONLY, os.ModePerm); err != nil {
+	// 从缓存中获取待读取的文件句柄
+	if fd, err = openWithBackoff(fileState.IndexName, fileState); err != nil {
 		k3.K3LogWarn("[processReadFile] open file error: %s", err.Error())
 		return
 	}
-	defer fd.Close()
-
-	reader = bufio.NewReader(fd)
-	// 证明文件没有被处理，开始读取
-	var (
-		currentReadCount = 0
-		currentOffset    = fileState.Offset
-		content          = ""
-	)
-
-	// 循环读取
-	for currentReadCount < maxReadCount {
-		currentReadCount++
-
-		if _, err = fd.Seek(currentOffset, 0); err != nil {
-			k3.K3LogError("[processReadObsoleteFile] seek file error: %s", err.Error())
-			break
-		}
-
-		line, err := reader.ReadString('\n')
-
-		if err != nil {
-			if err == io.EOF {
-				k3.K3LogDebug("[processReadObsoleteFile] read file over.")
-				currentOffset += int64(len(line))
-				content += line
-			} else {
-				k3.K3LogError("[processReadObsoleteFile] read file error: %s", err.Error())
-			}
-			break
-		}
-		currentOffset += int64(len(line))
-		content += line
-	}
+	defer GlobalFdCache.Release(fileState.Path, fd)
 
-	if len(content) > 0 {
-		k3.K3LogDebug("[processReadObsoleteFile] send data to elk : ", content)
-		SendData2Consumer(content, fileState)
+	if err = readFileByOffset(fd, fileState, maxReadCount); err != nil {
+		k3.K3LogError("[processReadObsoleteFile] path[%s] read file error: %s", fileState.Path, err.Error())
 	}
-
-	// 注意，每次读取完，GlobalFileState的数据已经得到了更新，并没有及时更新到硬盘，用定时器来处理即可
-	GlobalFileStatesLock.Lock()
-	GlobalFileStates[fileState.Path].Offset = currentOffset
-	if GlobalFileStates[fileState.Path].StartReadTime == 0 {
-		GlobalFileStates[fileState.Path].StartReadTime = time.Now().Unix()
-	}
-	GlobalFileStates[fileState.Path].LastReadTime = time.Now().Unix()
-	GlobalFileStatesLock.Unlock()
-
 }
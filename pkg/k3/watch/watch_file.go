@@ -0,0 +1,90 @@
+package watch
+
+import (
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// read_path中除了目录, 也可以直接配置文件(如/var/log/syslog)
+// fsnotify监听文件所在的目录而不是文件本身, 文件被轮转(改名后重新创建)之后仍然可以收到新文件的事件
+// 与目录中的文件相同, 改名时由renameEvent保留文件状态, 读完旧文件剩余的数据后由inode判断轮转, 从新文件的开头读取
+// 为了监听文件而加入的目录中, 其他文件的事件忽略
+
+// resolveReadPath 清理read_path中的路径(多余的/、末尾的/和.), 相对路径以工作根目录为基准, 与state_file_path一致
+// 同一个目录的不同写法(如 logs/nginx, ./logs/nginx/)解析为同一个路径, 避免重复监听和文件状态的key不一致
+func resolveReadPath(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(k3.GetRootPath(), path)
+}
+
+// isWatchFile path存在且不是目录
+func isWatchFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// watchTarget 加入fsnotify监听的路径, 文件监听所在的目录
+func watchTarget(path string) string {
+	if isWatchFile(path) {
+		return filepath.Dir(path)
+	}
+	return path
+}
+
+// expandWatchPath 目录返回目录及其所有的子目录, 文件只返回文件本身
+func expandWatchPath(path string) ([]string, error) {
+	if isWatchFile(path) {
+		return []string{path}, nil
+	}
+	return k3.FetchDirectoryPath(path, -1)
+}
+
+// shouldHandleEvent 事件的路径是read_path中的文件, 或者在read_path的目录中时返回true
+// 还没有记录监听目录的index_name(如热加载时正在创建的协程)不过滤
+func shouldHandleEvent(indexName, path string) bool {
+	var entries = getWatchDirectory()[indexName]
+
+	if len(entries) == 0 {
+		return true
+	}
+
+	path = filepath.Clean(path)
+	for _, entry := range entries {
+		entry = filepath.Clean(entry)
+		if path == entry || strings.HasPrefix(path, strings.TrimSuffix(entry, string(os.PathSeparator))+string(os.PathSeparator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fetchDirectoryConfig 遍历read_path目录的配置, 根据include_all_files和ignore_suffixes跳过隐藏文件和临时文件
+func fetchDirectoryConfig() k3.FetchDirectoryConfig {
+	return k3.FetchDirectoryConfig{
+		MaxDepth:       -1,
+		IncludeAll:     config.GlobalConfig.Watch.IncludeAllFiles,
+		IgnoreSuffixes: config.GlobalConfig.Watch.IgnoreSuffixes,
+	}
+}
+
+// ignoredFile 目录中的隐藏文件和临时文件不读取, read_path中直接配置的文件除外
+func ignoredFile(indexName, path string) bool {
+	if !fetchDirectoryConfig().Ignored(path) {
+		return false
+	}
+
+	path = filepath.Clean(path)
+	for _, entry := range getWatchDirectory()[indexName] {
+		if filepath.Clean(entry) == path {
+			return false
+		}
+	}
+
+	return true
+}
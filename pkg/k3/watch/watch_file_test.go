@@ -0,0 +1,170 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchFile(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		syslog   = filepath.Join(dir, "syslog")
+		other    = filepath.Join(dir, "other.log")
+	)
+
+	if err := os.WriteFile(syslog, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// read_path中的文件不展开, 监听所在的目录
+	directory := ExpandWatchDirectory(map[string][]string{"index_test": {syslog}})
+	if files := directory["index_test"]; len(files) != 1 || files[0] != syslog {
+		t.Fatalf("file in read_path should be kept as is, got %v", directory)
+	}
+	if err := ScanLogFileToGlobalFileStatesAndSaveToDiskFile(directory, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitWatcher(directory, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一个目录中的其他文件不读取
+	appendLines(t, other, "other 1")
+	appendLines(t, syslog, "line 1")
+	waitFor(t, func() bool { return len(consumer.lines()) == 1 })
+	assertLines(t, consumer, "line 1")
+
+	// 轮转: 改名后重新创建, 继续读取新文件, 改名后的文件不再读取
+	if err := os.Rename(syslog, syslog+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, syslog, "line 2")
+	waitFor(t, func() bool { return len(consumer.lines()) == 2 })
+	appendLines(t, syslog+".1", "rotated 1")
+
+	time.Sleep(100 * time.Millisecond)
+	assertLines(t, consumer, "line 1", "line 2")
+
+	GlobalFileStatesLock.Lock()
+	defer GlobalFileStatesLock.Unlock()
+	for _, path := range []string{other, syslog + ".1"} {
+		if _, exists := GlobalFileStates[path]; exists {
+			t.Errorf("%s is not in read_path and should not be tracked", path)
+		}
+	}
+}
+
+func TestWatchFileRotated(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		syslog   = filepath.Join(dir, "syslog")
+	)
+	setWatchDirectory(map[string][]string{"index_test": {syslog}})
+
+	appendLines(t, syslog, "line 1")
+	handlerEvent("index_test", fsnotify.Event{Name: syslog, Op: fsnotify.Write}, FileStateFilePath, nil)
+	processingWg.Wait()
+
+	// 直接配置的文件轮转时, 改名前还没有读取的数据通过旧句柄读完, 再从新文件的开头读取
+	appendLines(t, syslog, "line 2")
+	if err := os.Rename(syslog, syslog+".1"); err != nil {
+		t.Fatal(err)
+	}
+	handlerEvent("index_test", fsnotify.Event{Name: syslog, Op: fsnotify.Rename}, FileStateFilePath, nil)
+	handlerEvent("index_test", fsnotify.Event{Name: syslog + ".1", Op: fsnotify.Create}, FileStateFilePath, nil)
+	processingWg.Wait()
+
+	appendLines(t, syslog, "line 3")
+	handlerEvent("index_test", fsnotify.Event{Name: syslog, Op: fsnotify.Create}, FileStateFilePath, nil)
+	processingWg.Wait()
+
+	assertLines(t, consumer, "line 1", "line 2", "line 3")
+
+	GlobalFileStatesLock.Lock()
+	defer GlobalFileStatesLock.Unlock()
+	if _, exists := GlobalFileStates[syslog+".1"]; exists {
+		t.Errorf("rotated file is not in read_path and should not be tracked")
+	}
+	if offset := GlobalFileStates[syslog].Offset; offset != int64(len("line 3\n")) {
+		t.Errorf("new file should be read from beginning, got offset %d", offset)
+	}
+}
+
+func TestIgnoredFile(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		syslog   = filepath.Join(dir, ".syslog")
+	)
+
+	setWatchDirectory(map[string][]string{"index_test": {dir}, "index_file": {syslog}})
+
+	// 目录中的隐藏文件和临时文件不读取
+	for _, name := range []string{".app.log", "app.log.swp", "app.log~"} {
+		path := filepath.Join(dir, name)
+		appendLines(t, path, name)
+
+		createEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Create}, nil)
+		writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	}
+	processingWg.Wait()
+	assertLines(t, consumer)
+
+	// read_path中直接配置的文件不受影响
+	appendLines(t, syslog, "syslog")
+	createEvent("index_file", fsnotify.Event{Name: syslog, Op: fsnotify.Create}, nil)
+	writeEvent("index_file", fsnotify.Event{Name: syslog, Op: fsnotify.Write})
+	processingWg.Wait()
+	assertLines(t, consumer, "syslog")
+
+	// include_all_files时读取所有文件
+	config.GlobalConfig.Watch.IncludeAllFiles = true
+	path := filepath.Join(dir, ".app.log")
+	createEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Create}, nil)
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+	assertLines(t, consumer, "syslog", ".app.log")
+}
+
+func TestResolveReadPath(t *testing.T) {
+	var (
+		root, _ = filepath.EvalSymlinks(t.TempDir())
+		dir     = filepath.Join(root, "logs", "nginx")
+		cwd, _  = os.Getwd()
+	)
+
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// 相对路径以工作根目录为基准
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(cwd) })
+
+	// 不同写法的同一个目录解析结果相同
+	directory := ExpandWatchDirectory(map[string][]string{
+		"relative":       {"logs/nginx"},
+		"dot":            {"./logs/nginx/"},
+		"double_slash":   {"logs//nginx"},
+		"absolute":       {dir + string(os.PathSeparator)},
+		"absolute_clean": {filepath.Join(root, "logs", ".", "nginx")},
+	})
+
+	expected := []string{dir, filepath.Join(dir, "sub")}
+	if len(directory) != 5 {
+		t.Fatalf("all read paths should be resolved, got %v", directory)
+	}
+	for indexName, dirs := range directory {
+		if !equalLines(dirs, expected) {
+			t.Errorf("index_name[%s] expected %v, got %v", indexName, expected, dirs)
+		}
+	}
+}
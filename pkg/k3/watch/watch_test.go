@@ -0,0 +1,997 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3"
+	"log-engine-sdk/pkg/k3/config"
+	"log-engine-sdk/pkg/k3/protocol"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// captureConsumer 测试用consumer, 记录所有Add进来的数据, Add时立即确认接收
+type captureConsumer struct {
+	lock     sync.Mutex
+	datas    []protocol.Data
+	closeErr error // Close 返回的错误
+	closed   bool
+}
+
+func (c *captureConsumer) Add(data protocol.Data) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.datas = append(c.datas, data)
+	if data.Ack != nil {
+		data.Ack()
+	}
+	return nil
+}
+
+func (c *captureConsumer) Flush() error {
+	return nil
+}
+
+func (c *captureConsumer) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	return c.closeErr
+}
+
+// lines 返回所有收到的日志内容
+func (c *captureConsumer) lines() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var lines []string
+	for _, data := range c.datas {
+		lines = append(lines, data.Properties["_data"].(string))
+	}
+	return lines
+}
+
+// initTestWatch 初始化watch包的全局变量, 使用captureConsumer接收数据
+func initTestWatch(t testing.TB) *captureConsumer {
+	var consumer = &captureConsumer{}
+
+	config.GlobalConfig.Account = config.Account{AccountId: "1001", AppId: "1001-001"}
+	config.GlobalConfig.Watch = config.Watch{
+		StateFilePath: "core.json",
+		MaxReadCount:  DefaultMaxReadCount,
+	}
+
+	InitVars()
+	_ = InitIndexRules(nil)
+	_ = InitMultiline(config.Multiline{})
+	_ = InitEnrich([]string{EnrichNone})
+	FileStateFilePath = filepath.Join(t.TempDir(), "core.json")
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+
+	// 等待读取任务和定时读取结束, 避免在之后的测试重新初始化全局变量时仍在执行
+	t.Cleanup(func() {
+		WatcherContextCancel()
+		GlobalScheduler.Close()
+		readTimers.stop()
+		processingWg.Wait()
+		WatcherWG.Wait()
+		_ = GlobalFdCache.Close()
+	})
+
+	return consumer
+}
+
+// appendLines 向文件追加日志
+func appendLines(t testing.TB, path string, lines ...string) {
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	for _, line := range lines {
+		if _, err = fd.WriteString(line + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// assertLines 断言收到的日志内容和顺序
+func assertLines(t *testing.T, consumer *captureConsumer, expected ...string) {
+	t.Helper()
+
+	lines := consumer.lines()
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines %v, got %d lines %v", len(expected), expected, len(lines), lines)
+	}
+
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("line %d expected %q, got %q", i, expected[i], lines[i])
+		}
+	}
+}
+
+func TestDrainOnRemove(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	config.GlobalConfig.Watch.DrainOnRemove = true
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	// 写入后立即删除, 删除前写入的数据还没有被读取
+	appendLines(t, path, "line 2", "line 3")
+	if err = os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	removeEvent(fsnotify.Event{Name: path, Op: fsnotify.Remove}, watcher)
+	processingWg.Wait()
+
+	assertLines(t, consumer, "line 1", "line 2", "line 3")
+
+	if _, exists := GlobalFileStates[path]; exists {
+		t.Errorf("file state of %s should be removed", path)
+	}
+
+	if _, cached := GlobalFdCache.Take(path); cached {
+		t.Errorf("fd of %s should be removed from cache", path)
+	}
+}
+
+func TestRemoveWithoutDrain(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	appendLines(t, path, "line 2")
+	_ = os.Remove(path)
+	removeEvent(fsnotify.Event{Name: path, Op: fsnotify.Remove}, watcher)
+
+	// 未开启drain_on_remove, 删除前未读取的数据直接丢弃
+	time.Sleep(100 * time.Millisecond)
+	assertLines(t, consumer, "line 1")
+}
+
+func TestStopAggregatesCloseErrors(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		closeErr = errors.New("sender unavailable")
+		path     = filepath.Join(t.TempDir(), "app.log")
+	)
+
+	consumer.closeErr = closeErr
+	appendLines(t, path, "line 1")
+	fd, err := GlobalFdCache.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	GlobalFdCache.Release(path, fd)
+
+	if err = Stop(); !errors.Is(err, closeErr) {
+		t.Fatalf("Stop should return consumer close error, got %v", err)
+	}
+
+	// consumer 关闭失败, 其他资源依然需要关闭
+	if _, err = fd.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("cached fd should be closed, got %v", err)
+	}
+
+	if GlobalFdCache.Len() != 0 {
+		t.Errorf("fd cache should be empty, got %d", GlobalFdCache.Len())
+	}
+}
+
+func TestStopClean(t *testing.T) {
+	var consumer = initTestWatch(t)
+
+	if err := Stop(); err != nil {
+		t.Errorf("Stop should return nil, got %v", err)
+	}
+
+	if !consumer.closed {
+		t.Errorf("consumer should be closed")
+	}
+}
+
+func TestResetFileStatesToEnd(t *testing.T) {
+	var (
+		consumer  = initTestWatch(t)
+		dir       = t.TempDir()
+		tracked   = filepath.Join(dir, "tracked.log")
+		untracked = filepath.Join(dir, "untracked.log")
+		stateFile StateFile
+	)
+
+	appendLines(t, tracked, "history 1", "history 2")
+	appendLines(t, untracked, "history 3")
+
+	// 状态文件中记录的offset落后于文件末尾
+	GlobalFileStates[tracked] = &FileState{Path: tracked, Offset: 0, IndexName: "index_test"}
+	if err := ScanLogFileToGlobalFileStatesAndSaveToDiskFile(map[string][]string{"index_test": {dir}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ResetFileStatesToEnd(FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重置后的offset需要保存到硬盘
+	content, err := os.ReadFile(FileStateFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(content, &stateFile); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{tracked, untracked} {
+		info, _ := os.Stat(path)
+		if stateFile.Online[path] == nil || stateFile.Online[path].Offset != info.Size() {
+			t.Errorf("offset of %s should be reset to %d, got %v", path, info.Size(), stateFile.Online[path])
+		}
+	}
+
+	appendLines(t, tracked, "new 1")
+	appendLines(t, untracked, "new 2")
+	writeEvent("index_test", fsnotify.Event{Name: tracked, Op: fsnotify.Write})
+	processingWg.Wait()
+	writeEvent("index_test", fsnotify.Event{Name: untracked, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	// 历史数据不会被发送
+	assertLines(t, consumer, "new 1", "new 2")
+}
+
+func TestScanReadFrom(t *testing.T) {
+	for _, readFrom := range []string{"", ReadFromBeginning, ReadFromEnd} {
+		t.Run("read_from="+readFrom, func(t *testing.T) {
+			var (
+				consumer  = initTestWatch(t)
+				dir       = t.TempDir()
+				persisted = filepath.Join(dir, "persisted.log")
+				fresh     = filepath.Join(dir, "fresh.log")
+			)
+			config.GlobalConfig.Watch.ReadFrom = readFrom
+
+			appendLines(t, persisted, "history 1", "history 2")
+			appendLines(t, fresh, "history 3")
+
+			// 状态文件中已经记录了offset的文件, 始终从记录的offset继续读取
+			GlobalFileStates[persisted] = &FileState{Path: persisted, Offset: int64(len("history 1\n")), IndexName: "index_test"}
+			if err := ScanLogFileToGlobalFileStatesAndSaveToDiskFile(map[string][]string{"index_test": {dir}}, FileStateFilePath); err != nil {
+				t.Fatal(err)
+			}
+
+			appendLines(t, persisted, "new 1")
+			appendLines(t, fresh, "new 2")
+			writeEvent("index_test", fsnotify.Event{Name: persisted, Op: fsnotify.Write})
+			processingWg.Wait()
+			writeEvent("index_test", fsnotify.Event{Name: fresh, Op: fsnotify.Write})
+			processingWg.Wait()
+
+			if readFrom == ReadFromEnd {
+				assertLines(t, consumer, "history 2", "new 1", "new 2")
+			} else {
+				assertLines(t, consumer, "history 2", "new 1", "history 3", "new 2")
+			}
+		})
+	}
+}
+
+func TestRescanReadFromBeginning(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		startup  = filepath.Join(dir, "startup.log")
+		later    = filepath.Join(dir, "later.log")
+	)
+	config.GlobalConfig.Watch.ReadFrom = ReadFromEnd
+
+	appendLines(t, startup, "history 1")
+	if err := ScanLogFileToGlobalFileStatesAndSaveToDiskFile(map[string][]string{"index_test": {dir}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// 启动之后才出现的文件由定时扫描发现, 其中的数据都是启动之后写入的, 从头读取
+	appendLines(t, later, "later 1", "later 2")
+	if err := scanLogFiles(map[string][]string{"index_test": {dir}}, FileStateFilePath, false); err != nil {
+		t.Fatal(err)
+	}
+	if offset := GlobalFileStates[later].Offset; offset != 0 {
+		t.Errorf("file discovered by rescan should be read from beginning, got offset %d", offset)
+	}
+
+	writeEvent("index_test", fsnotify.Event{Name: startup, Op: fsnotify.Write})
+	processingWg.Wait()
+	writeEvent("index_test", fsnotify.Event{Name: later, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	assertLines(t, consumer, "later 1", "later 2")
+}
+
+func TestScanStartDate(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		oldFile  = filepath.Join(dir, "old.log")
+		newFile  = filepath.Join(dir, "new.log")
+		cutoff   = time.Now().Add(-24 * time.Hour)
+	)
+	config.GlobalConfig.Watch.StartDate = cutoff.Format("2006-01-02 15:04:05")
+
+	appendLines(t, oldFile, "old 1")
+	appendLines(t, newFile, "new 1")
+	if err := os.Chtimes(oldFile, cutoff.Add(-time.Hour), cutoff.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ScanLogFileToGlobalFileStatesAndSaveToDiskFile(map[string][]string{"index_test": {dir}}, FileStateFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	GlobalFileStatesLock.Lock()
+	_, oldTracked := GlobalFileStates[oldFile]
+	_, newTracked := GlobalFileStates[newFile]
+	GlobalFileStatesLock.Unlock()
+	if oldTracked || !newTracked {
+		t.Fatalf("only files modified after start_date should be tracked, old %v new %v", oldTracked, newTracked)
+	}
+
+	// 旧文件再次写入后修改时间晚于start_date, 通过写入事件开始读取
+	appendLines(t, oldFile, "old 2")
+	writeEvent("index_test", fsnotify.Event{Name: oldFile, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	assertLines(t, consumer, "old 1", "old 2")
+
+	// 移动进来的旧文件同样不读取
+	moved := filepath.Join(dir, "moved.log")
+	appendLines(t, moved, "moved 1")
+	if err := os.Chtimes(moved, cutoff.Add(-time.Hour), cutoff.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if createFile("index_test", moved) {
+		t.Errorf("file modified before start_date should not be created")
+	}
+}
+
+func TestReadFileByOffset(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	appendLines(t, path, "line 1", "line 2", "line 3")
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	appendLines(t, path, "line 4", "line 5")
+	writeEvent("index_test", event)
+	processingWg.Wait()
+
+	assertLines(t, consumer, "line 1", "line 2", "line 3", "line 4", "line 5")
+
+	info, _ := os.Stat(path)
+	if fileState := GlobalFileStates[path]; fileState.Offset != info.Size() || fileState.StartReadTime == 0 || fileState.LastReadTime == 0 {
+		t.Errorf("file state should be updated, got %s", fileState.String())
+	}
+}
+
+func TestReadFileByOffsetPartialLine(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "app.log")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	// 最后一行还没有写完
+	appendLines(t, path, "line 1")
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	_, _ = fd.WriteString("line")
+
+	writeEvent("index_test", event)
+	processingWg.Wait()
+	assertLines(t, consumer, "line 1")
+
+	if offset := GlobalFileStates[path].Offset; offset != int64(len("line 1\n")) {
+		t.Errorf("offset should only advance for complete lines, got %d", offset)
+	}
+
+	// 写完后从这一行的开头重新读取
+	_, _ = fd.WriteString(" 2\n")
+	writeEvent("index_test", event)
+	processingWg.Wait()
+	assertLines(t, consumer, "line 1", "line 2")
+}
+
+func TestIntraFileOrder(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		paths    = []string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")}
+		wg       sync.WaitGroup
+		count    = 300
+	)
+
+	config.GlobalConfig.Watch.MaxReadCount = 7
+
+	for _, path := range paths {
+		appendLines(t, path)
+		createFile("index_order", path)
+	}
+
+	// 两个文件同时写入, 每次写入都触发读取, 同一个文件的读取任务可能同时提交
+	for _, path := range paths {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				appendLines(t, path, fmt.Sprintf("%s %d", filepath.Base(path), i))
+				writeEvent("index_order", fsnotify.Event{Name: path, Op: fsnotify.Write})
+			}
+		}(path)
+	}
+	wg.Wait()
+
+	// 每次最多读取7行, 继续触发读取直到读完
+	waitFor(t, func() bool {
+		for _, path := range paths {
+			writeEvent("index_order", fsnotify.Event{Name: path, Op: fsnotify.Write})
+		}
+		processingWg.Wait()
+		return len(consumer.lines()) == 2*count
+	})
+
+	// 每个文件的日志按照写入的顺序进入consumer, _offset递增
+	var (
+		next   = make(map[string]int)
+		offset = make(map[string]int64)
+	)
+	consumer.lock.Lock()
+	defer consumer.lock.Unlock()
+	for _, data := range consumer.datas {
+		path := data.Properties["_path"].(string)
+		if line := data.Properties["_data"]; line != fmt.Sprintf("%s %d", filepath.Base(path), next[path]) {
+			t.Fatalf("%s line %d expected, got %v", path, next[path], line)
+		}
+		if current := data.Properties["_offset"].(int64); next[path] > 0 && current <= offset[path] {
+			t.Fatalf("%s _offset should increase, got %d after %d", path, current, offset[path])
+		} else {
+			offset[path] = current
+		}
+		next[path]++
+	}
+}
+
+// recordSender 测试用sender, 记录所有发送的数据, block不为nil时发送阻塞直到block关闭
+type recordSender struct {
+	lock  sync.Mutex
+	datas []protocol.Data
+	block chan struct{}
+}
+
+func (s *recordSender) Send(data []protocol.Data) error {
+	if s.block != nil {
+		<-s.block
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.datas = append(s.datas, data...)
+	return nil
+}
+
+func (s *recordSender) Close() error {
+	return nil
+}
+
+func TestStopFlushesFinalBatch(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		sender   = &recordSender{}
+		expected = 0
+	)
+
+	initTestWatch(t)
+	consumer, err := k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{Sender: sender, BatchSize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(dir, "app_"+string(rune('a'+i))+".log")
+		for j := 0; j < 50; j++ {
+			appendLines(t, path, "line")
+			expected++
+		}
+		writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	}
+
+	// 读取还在进行中时退出, 所有读取的数据都需要发送
+	if err = Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sender.datas) != expected {
+		t.Errorf("all %d events should be delivered, got %d", expected, len(sender.datas))
+	}
+}
+
+func TestStopFlushTimeout(t *testing.T) {
+	var (
+		path   = filepath.Join(t.TempDir(), "app.log")
+		sender = &recordSender{block: make(chan struct{})}
+	)
+
+	initTestWatch(t)
+	t.Cleanup(func() { close(sender.block) })
+	config.GlobalConfig.Watch.ShutdownTimeout = 1
+
+	consumer, err := k3.NewBatchConsumerWithConfig(k3.K3BatchConsumerConfig{Sender: sender, BatchSize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	GlobalDataAnalytics = k3.NewDataAnalytics(consumer)
+
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+
+	// sender一直没有返回, 超时后返回错误
+	start := time.Now()
+	if err = Stop(); err == nil {
+		t.Fatal("Stop should return error when flush times out")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Stop should return after shutdown timeout, took %v", elapsed)
+	}
+}
+
+// hungSender 测试用sender, 发送一直阻塞到ctx取消, 记录发送是否已经返回
+type hungSender struct {
+	returned chan struct{}
+}
+
+func (s *hungSender) Send([]protocol.Data) error {
+	return errors.New("SendContext should be used")
+}
+
+func (s *hungSender) SendContext(ctx context.Context, _ []protocol.Data) error {
+	<-ctx.Done()
+	close(s.returned)
+	return ctx.Err()
+}
+
+func (s *hungSender) Close() error {
+	return nil
+}
+
+func TestStopCancelsHungSend(t *testing.T) {
+	var (
+		path   = filepath.Join(t.TempDir(), "app.log")
+		sender = &hungSender{returned: make(chan struct{})}
+	)
+
+	initTestWatch(t)
+	config.GlobalConfig.Watch.ShutdownTimeout = 1
+	SetSender(sender)
+	t.Cleanup(func() { SetSender(nil) })
+
+	if err := InitConsumerBatchLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+
+	// 超过shutdown_timeout时取消正在进行的发送, 不再一直阻塞
+	if err := Stop(); err == nil {
+		t.Fatal("Stop should return error when flush times out")
+	}
+	select {
+	case <-sender.returned:
+	case <-time.After(time.Second):
+		t.Error("hung send should be cancelled after shutdown timeout")
+	}
+}
+
+// funcProcessor 测试用的处理器, 读取时调用函数
+type funcProcessor func(data *protocol.Data) (bool, error)
+
+func (f funcProcessor) Process(data *protocol.Data) (bool, error) {
+	return f(data)
+}
+
+func TestStopDrainsSlowRead(t *testing.T) {
+	var (
+		consumer    = initTestWatch(t)
+		path        = filepath.Join(t.TempDir(), "app.log")
+		started     = make(chan struct{})
+		startOnce   sync.Once
+		closedEarly bool
+		closedLock  sync.Mutex
+	)
+
+	// 每条日志处理100ms, 处理完时consumer不能已经关闭
+	RegisterProcessor("slow", func(config.Processor) (Processor, error) {
+		return funcProcessor(func(*protocol.Data) (bool, error) {
+			startOnce.Do(func() { close(started) })
+			time.Sleep(100 * time.Millisecond)
+
+			consumer.lock.Lock()
+			defer consumer.lock.Unlock()
+			if consumer.closed {
+				closedLock.Lock()
+				closedEarly = true
+				closedLock.Unlock()
+			}
+			return true, nil
+		}), nil
+	})
+	t.Cleanup(func() { RegisterProcessor("slow", nil) })
+	if err := InitIndexRules(map[string]config.Index{"index_test": {Processors: []config.Processor{{Type: "slow"}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, "line 1", "line 2", "line 3")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	<-started
+
+	if err := Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	closedLock.Lock()
+	defer closedLock.Unlock()
+	if closedEarly {
+		t.Error("consumer should be closed after the slow read finished")
+	}
+	assertLines(t, consumer, "line 1", "line 2", "line 3")
+
+	// 退出之后的写事件不再读取
+	appendLines(t, path, "line 4")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+	assertLines(t, consumer, "line 1", "line 2", "line 3")
+}
+
+func TestDrainProcessingTimeout(t *testing.T) {
+	var (
+		path    = filepath.Join(t.TempDir(), "app.log")
+		started = make(chan struct{})
+		block   = make(chan struct{})
+	)
+
+	initTestWatch(t)
+	RegisterProcessor("block", func(config.Processor) (Processor, error) {
+		return funcProcessor(func(*protocol.Data) (bool, error) {
+			close(started)
+			<-block
+			return true, nil
+		}), nil
+	})
+	t.Cleanup(func() {
+		close(block)
+		processingWg.Wait()
+		RegisterProcessor("block", nil)
+	})
+	if err := InitIndexRules(map[string]config.Index{"index_test": {Processors: []config.Processor{{Type: "block"}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	<-started
+
+	// 卡住的读取不会导致无法退出, 错误中包含仍在读取的文件
+	err := DrainProcessing(100 * time.Millisecond)
+	if !errors.Is(err, ErrDrainTimeout) {
+		t.Fatalf("DrainProcessing should return ErrDrainTimeout, got %v", err)
+	}
+	if !strings.Contains(err.Error(), path) {
+		t.Errorf("error should contain the file still processing, got %v", err)
+	}
+}
+
+func TestObsoleteFile(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		dir      = t.TempDir()
+		path     = filepath.Join(dir, "app.log")
+		unread   = filepath.Join(dir, "unread.log")
+		now      = time.Now()
+	)
+
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	// 读取时缓存的句柄
+	fd, _ := GlobalFdCache.Open(path)
+	GlobalFdCache.Release(path, fd)
+
+	// 没有读完的文件
+	appendLines(t, unread, "unread 1")
+	GlobalFileStates[unread] = &FileState{Path: unread, IndexName: "index_test", LastReadTime: now.Unix()}
+
+	// 没有超过obsolete_date
+	now = now.Add(23 * time.Hour)
+	readObsoleteFiles(1, DefaultMaxReadCount)
+	processingWg.Wait()
+	if GlobalFileStates[path].Obsolete || GlobalFdCache.Len() != 1 {
+		t.Fatalf("file should not be obsolete before obsolete_date")
+	}
+
+	// 超过obsolete_date, 读完的文件标记为obsolete并关闭句柄, 没有读完的文件读取剩余数据
+	now = now.Add(2 * time.Hour)
+	readObsoleteFiles(1, DefaultMaxReadCount)
+	processingWg.Wait()
+
+	if !GlobalFileStates[path].Obsolete {
+		t.Errorf("fully read file should be obsolete")
+	}
+	if _, err := fd.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("fd of obsolete file should be closed, got %v", err)
+	}
+	if GlobalFileStates[unread].Obsolete {
+		t.Errorf("unread file should not be obsolete")
+	}
+	assertLines(t, consumer, "line 1", "unread 1")
+
+	// 再次写入时恢复
+	appendLines(t, path, "line 2")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	if GlobalFileStates[path].Obsolete {
+		t.Errorf("obsolete file should be reactivated after write")
+	}
+	assertLines(t, consumer, "line 1", "unread 1", "line 2")
+}
+
+func TestObsoleteFileRemoved(t *testing.T) {
+	var (
+		path = filepath.Join(t.TempDir(), "app.log")
+		now  = time.Now()
+	)
+
+	initTestWatch(t)
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	appendLines(t, path, "line 1")
+	writeEvent("index_test", fsnotify.Event{Name: path, Op: fsnotify.Write})
+	processingWg.Wait()
+
+	now = now.Add(25 * time.Hour)
+	readObsoleteFiles(1, DefaultMaxReadCount)
+	if !GlobalFileStates[path].Obsolete {
+		t.Fatalf("fully read file should be obsolete")
+	}
+
+	// obsolete文件已经不存在, 删除文件状态
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	readObsoleteFiles(1, DefaultMaxReadCount)
+
+	if _, exists := GlobalFileStates[path]; exists {
+		t.Errorf("file state of removed obsolete file should be pruned")
+	}
+}
+
+func TestCreateDirectoryRecursive(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		root     = t.TempDir()
+		watched  = filepath.Join(root, "watched")
+		staging  = filepath.Join(root, "staging")
+		moved    = filepath.Join(watched, "app")
+	)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	// 在监听目录之外准备好目录树, 再整体移动进来
+	if err = os.MkdirAll(filepath.Join(staging, "nested", "deep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(watched, 0755); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, filepath.Join(staging, "top.log"), "top 1")
+	appendLines(t, filepath.Join(staging, "nested", "nested.log"), "nested 1")
+	appendLines(t, filepath.Join(staging, "nested", "deep", "deep.log"), "deep 1")
+
+	if err = watcher.Add(watched); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(staging, moved); err != nil {
+		t.Fatal(err)
+	}
+
+	createEvent("index_test", fsnotify.Event{Name: moved, Op: fsnotify.Create}, watcher)
+	processingWg.Wait()
+
+	// 所有子目录都加入监听
+	watchList := make(map[string]bool)
+	for _, path := range watcher.WatchList() {
+		watchList[path] = true
+	}
+	for _, dir := range []string{moved, filepath.Join(moved, "nested"), filepath.Join(moved, "nested", "deep")} {
+		if !watchList[dir] {
+			t.Errorf("%s should be watched, watch list %v", dir, watcher.WatchList())
+		}
+	}
+
+	// 目录中已经存在的文件都被读取
+	lines := consumer.lines()
+	sort.Strings(lines)
+	if strings.Join(lines, ",") != "deep 1,nested 1,top 1" {
+		t.Errorf("all nested files should be read, got %v", lines)
+	}
+
+	// 重复的create事件不会重复添加和读取
+	createEvent("index_test", fsnotify.Event{Name: moved, Op: fsnotify.Create}, watcher)
+	processingWg.Wait()
+	if len(consumer.lines()) != 3 || len(GlobalFileStates) != 3 {
+		t.Errorf("files should not be added twice, got %d lines %d states", len(consumer.lines()), len(GlobalFileStates))
+	}
+
+	// 之后的写入正常读取
+	appendLines(t, filepath.Join(moved, "nested", "deep", "deep.log"), "deep 2")
+	writeEvent("index_test", fsnotify.Event{Name: filepath.Join(moved, "nested", "deep", "deep.log"), Op: fsnotify.Write})
+	processingWg.Wait()
+	if lines = consumer.lines(); len(lines) != 4 || lines[3] != "deep 2" {
+		t.Errorf("nested file should continue to be read, got %v", lines)
+	}
+}
+
+func TestRunWithContextCancel(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		received []string
+		dir      = t.TempDir()
+		path     = filepath.Join(dir, "app.log")
+		server   = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var datas []protocol.Data
+			if err := json.NewDecoder(r.Body).Decode(&datas); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			lock.Lock()
+			for _, data := range datas {
+				received = append(received, data.Properties["_data"].(string))
+			}
+			lock.Unlock()
+		}))
+		baseline = runtime.NumGoroutine()
+	)
+	defer server.Close()
+
+	cwd, _ := os.Getwd()
+	stateFilePath, err := filepath.Rel(cwd, filepath.Join(t.TempDir(), "core.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.GlobalConfig.Account = config.Account{AccountId: "1001", AppId: "1001-001"}
+	config.GlobalConfig.Sender = config.Sender{Type: "http", Http: config.HttpSender{URL: server.URL}}
+	// 批量足够大, 只有退出时才会提交
+	config.GlobalConfig.Consumer = config.Consumer{ConsumerBatchSize: 1000, ConsumerBatchInterval: 3600}
+	config.GlobalConfig.Watch = config.Watch{
+		StateFilePath: stateFilePath,
+		MaxReadCount:  DefaultMaxReadCount,
+		SyncInterval:  DefaultSyncInterval,
+		EnrichFields:  []string{EnrichNone},
+	}
+	t.Cleanup(func() {
+		config.GlobalConfig.Sender = config.Sender{}
+		config.GlobalConfig.Consumer = config.Consumer{}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	closer, err := RunWithContext(ctx, map[string][]string{"index_test": {dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, "line 1", "line 2")
+	waitFor(t, func() bool {
+		GlobalFileStatesLock.Lock()
+		defer GlobalFileStatesLock.Unlock()
+		fileState, ok := GlobalFileStates[path]
+		return ok && fileState.Offset == 14
+	})
+
+	lock.Lock()
+	if len(received) != 0 {
+		t.Fatalf("nothing should be sent before cancel, got %v", received)
+	}
+	lock.Unlock()
+
+	// 取消外部ctx, 剩余的数据在退出时提交
+	cancel()
+	waitFor(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(received) == 2
+	})
+	if received[0] != "line 1" || received[1] != "line 2" {
+		t.Errorf("final flush should send all lines, got %v", received)
+	}
+
+	if WatcherContext.Err() == nil {
+		t.Errorf("watcher context should be cancelled with parent context")
+	}
+
+	// 之后调用关闭函数不会重复关闭
+	closer()
+	WatcherWG.Wait()
+	ClockWG.Wait()
+	ClockObsoleteWG.Wait()
+
+	server.CloseClientConnections()
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+	waitFor(t, func() bool {
+		return runtime.NumGoroutine() <= baseline
+	})
+}
+
+// waitFor 等待cond成立, 超时后测试失败
+func waitFor(t *testing.T, cond func() bool) {
+	var deadline = time.Now().Add(5 * time.Second)
+
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("wait condition timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,64 @@
+package watch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log-engine-sdk/pkg/k3"
+	"os"
+	"time"
+)
+
+var (
+	DefaultMaxWholeFileSize int64 = 10 * 1024 * 1024 // whole_file模式下, 单个文件最大读取字节数
+)
+
+// readWholeFile whole_file模式下读取整个文件, 作为一条日志发送给consumer
+// onlyChanged 为true时, 文件内容hash和上一次发送的一致就不再发送
+func readWholeFile(fd *os.File, fileState *FileState, onlyChanged bool) error {
+	var (
+		content   []byte
+		hash      string
+		unchanged bool
+		err       error
+	)
+
+	if _, err = fd.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek file failed: %w", err)
+	}
+
+	if content, err = io.ReadAll(io.LimitReader(fd, DefaultMaxWholeFileSize+1)); err != nil {
+		return fmt.Errorf("read whole file failed: %w", err)
+	}
+
+	if int64(len(content)) > DefaultMaxWholeFileSize {
+		return fmt.Errorf("file size exceeds max whole file size(%d)", DefaultMaxWholeFileSize)
+	}
+
+	sum := sha256.Sum256(content)
+	hash = hex.EncodeToString(sum[:])
+
+	GlobalFileStatesLock.Lock()
+	unchanged = fileState.ContentHash == hash
+	GlobalFileStatesLock.Unlock()
+
+	if len(content) > 0 && !(onlyChanged && unchanged) {
+		// consumer已经关闭时不记录hash, 重启后重新发送
+		if err = trackData(fetchLocalIP(), string(content), 0, false, fileState); errors.Is(err, k3.ErrConsumerClosed) {
+			return err
+		}
+	}
+
+	GlobalFileStatesLock.Lock()
+	fileState.Offset = int64(len(content))
+	fileState.ContentHash = hash
+	if fileState.StartReadTime == 0 {
+		fileState.StartReadTime = time.Now().Unix()
+	}
+	fileState.LastReadTime = nowFunc().Unix()
+	GlobalFileStatesLock.Unlock()
+
+	return nil
+}
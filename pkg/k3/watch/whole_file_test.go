@@ -0,0 +1,64 @@
+package watch
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log-engine-sdk/pkg/k3/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWholeFile(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "manifest.yaml")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	if err := InitIndexRules(map[string]config.Index{"index_manifest": {WholeFile: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, "name: app", "version: 1")
+	writeEvent("index_manifest", event)
+	processingWg.Wait()
+
+	// 没有开启whole_file_on_change, 每次写入都发送整个文件
+	writeEvent("index_manifest", event)
+	processingWg.Wait()
+
+	assertLines(t, consumer, "name: app\nversion: 1\n", "name: app\nversion: 1\n")
+
+	if offset := GlobalFileStates[path].Offset; offset != 21 {
+		t.Errorf("offset should be file size 21, got %d", offset)
+	}
+}
+
+func TestWholeFileOnChange(t *testing.T) {
+	var (
+		consumer = initTestWatch(t)
+		path     = filepath.Join(t.TempDir(), "manifest.yaml")
+		event    = fsnotify.Event{Name: path, Op: fsnotify.Write}
+	)
+
+	if err := InitIndexRules(map[string]config.Index{"index_manifest": {WholeFile: true, WholeFileOnChange: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLines(t, path, "version: 1")
+	writeEvent("index_manifest", event)
+	processingWg.Wait()
+
+	// 内容没有变化, 不发送
+	writeEvent("index_manifest", event)
+	processingWg.Wait()
+
+	// 内容变化后重新发送整个文件
+	if err := os.WriteFile(path, []byte("version: 2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	writeEvent("index_manifest", event)
+	processingWg.Wait()
+
+	assertLines(t, consumer, "version: 1\n", "version: 2\n")
+}